@@ -0,0 +1,155 @@
+package adminctl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"group-ssh-chat/chat"
+	"group-ssh-chat/graceful"
+	"io"
+	"log"
+	"net"
+	"os"
+	"runtime/pprof"
+	"strings"
+	"time"
+)
+
+// Used for serving the admin console on a Unix domain socket. Each connection
+// sends a single command line and receives "OK" or "ERR <reason>" followed by
+// the command output, after which the server closes the connection.
+type Server struct {
+	socketPath string
+	hub        *chat.Hub
+	reload     func() error
+}
+
+// Returns an admin console listening on ADMIN_SOCKET_PATH, or nil when the
+// variable is not set. reload is invoked by the "reload" command.
+func New(hub *chat.Hub, reload func() error) *Server {
+	socketPath := os.Getenv("ADMIN_SOCKET_PATH")
+	if socketPath == "" {
+		return nil
+	}
+
+	return &Server{socketPath: socketPath, hub: hub, reload: reload}
+}
+
+// Listens on the socket and serves admin commands until the listener fails
+func (as *Server) ListenAndServe() error {
+	listener, err := graceful.Listen("admin", "unix", as.socketPath)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	if err := os.Chmod(as.socketPath, 0600); err != nil {
+		return err
+	}
+
+	log.Println("Admin console is listening on", as.socketPath)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go as.handleConnection(conn)
+	}
+}
+
+// Reads one command from the connection and writes its result
+func (as *Server) handleConnection(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		fmt.Fprintln(conn, "ERR empty command")
+		return
+	}
+
+	var out strings.Builder
+	if err := as.execute(&out, fields[0], fields[1:]); err != nil {
+		fmt.Fprintln(conn, "ERR", err)
+		return
+	}
+	log.Printf("Admin console: %s", strings.TrimSpace(line))
+	fmt.Fprintln(conn, "OK")
+	io.WriteString(conn, out.String())
+}
+
+// Runs a single admin command, writing its output to w
+func (as *Server) execute(w io.Writer, command string, args []string) error {
+	switch command {
+	case "help":
+		fmt.Fprintln(w, "sessions                  list open sessions")
+		fmt.Fprintln(w, "kick <user> [reason]      disconnect all sessions of a user")
+		fmt.Fprintln(w, "broadcast <text>          send an announcement to everyone")
+		fmt.Fprintln(w, "reload                    reload the configuration")
+		fmt.Fprintln(w, "profile goroutine|heap    dump a runtime profile")
+		fmt.Fprintln(w, "upgrade                   hand over to a new server process")
+		fmt.Fprintln(w, "userdata export <user>    dump everything stored about a user as JSON")
+		fmt.Fprintln(w, "userdata delete <user>    delete everything stored about a user")
+		return nil
+
+	case "sessions":
+		for _, s := range as.hub.Sessions() {
+			fmt.Fprintf(w, "%-16s %-20s #%-15s %-22s %s\n", s.User, s.ConnectedAt.Format(time.DateTime), s.Room, s.RemoteAddr, s.ID)
+		}
+		return nil
+
+	case "kick":
+		if len(args) == 0 {
+			return errors.New("usage: kick <user> [reason]")
+		}
+		n := as.hub.Disconnect(args[0], strings.Join(args[1:], " "))
+		if n == 0 {
+			return fmt.Errorf("%s is not online", args[0])
+		}
+		fmt.Fprintf(w, "closed %d sessions of %s\n", n, args[0])
+		return nil
+
+	case "broadcast":
+		if len(args) == 0 {
+			return errors.New("usage: broadcast <text>")
+		}
+		as.hub.Announce(strings.Join(args, " "))
+		return nil
+
+	case "reload":
+		return as.reload()
+
+	case "upgrade":
+		return graceful.Upgrade()
+
+	case "userdata":
+		if len(args) != 2 || (args[0] != "export" && args[0] != "delete") {
+			return errors.New("usage: userdata export|delete <user>")
+		}
+		if args[0] == "delete" {
+			if err := as.hub.DeleteUserData(args[1]); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "deleted the data of %s\n", args[1])
+			return nil
+		}
+		data, err := as.hub.ExportUserData(args[1])
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(data)
+
+	case "profile":
+		if len(args) != 1 || (args[0] != "goroutine" && args[0] != "heap") {
+			return errors.New("usage: profile goroutine|heap")
+		}
+		return pprof.Lookup(args[0]).WriteTo(w, 1)
+	}
+	return fmt.Errorf("unknown command %q, try help", command)
+}
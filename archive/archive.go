@@ -0,0 +1,239 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"group-ssh-chat/retention"
+	"group-ssh-chat/storage"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Used for moving old messages out of the history into compressed JSON
+// lines files in an S3-compatible bucket, one per room and day, and for
+// reading them back
+type Archiver struct {
+	history storage.History
+	client  *s3Client
+	prefix  string
+	after   time.Duration
+	runAt   time.Duration
+
+	// Held during a run so a manual run cannot overlap the nightly one
+	mu sync.Mutex
+}
+
+// Returns an archiver writing to ARCHIVE_S3_BUCKET, or nil when the variable
+// is not set. ARCHIVE_S3_ENDPOINT (default https://s3.amazonaws.com),
+// ARCHIVE_S3_REGION (default us-east-1), ARCHIVE_S3_ACCESS_KEY and
+// ARCHIVE_S3_SECRET_KEY select the service and ARCHIVE_PREFIX is prepended
+// to object names. Messages older than ARCHIVE_AFTER (default 30d) are
+// archived every night at ARCHIVE_AT (local time, default 03:00).
+func New(history storage.History) *Archiver {
+	bucket := os.Getenv("ARCHIVE_S3_BUCKET")
+	if bucket == "" {
+		return nil
+	}
+
+	rawEndpoint := os.Getenv("ARCHIVE_S3_ENDPOINT")
+	if rawEndpoint == "" {
+		rawEndpoint = "https://s3.amazonaws.com"
+	}
+	endpoint, err := url.Parse(rawEndpoint)
+	if err != nil || endpoint.Host == "" {
+		log.Fatalf("Invalid ARCHIVE_S3_ENDPOINT %q", rawEndpoint)
+	}
+	a := &Archiver{
+		history: history,
+		client: &s3Client{
+			endpoint:  endpoint,
+			bucket:    bucket,
+			region:    os.Getenv("ARCHIVE_S3_REGION"),
+			accessKey: os.Getenv("ARCHIVE_S3_ACCESS_KEY"),
+			secretKey: os.Getenv("ARCHIVE_S3_SECRET_KEY"),
+			http:      &http.Client{Timeout: time.Minute},
+		},
+		prefix: strings.Trim(os.Getenv("ARCHIVE_PREFIX"), "/"),
+		after:  30 * 24 * time.Hour,
+		runAt:  3 * time.Hour,
+	}
+	if a.client.region == "" {
+		a.client.region = "us-east-1"
+	}
+	if d, err := retention.ParseAge(os.Getenv("ARCHIVE_AFTER")); err == nil && d > 0 {
+		a.after = d
+	}
+	if at, err := time.Parse("15:04", os.Getenv("ARCHIVE_AT")); err == nil {
+		a.runAt = time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute
+	}
+	return a
+}
+
+// Runs the archival every night
+func (a *Archiver) Start() {
+	go func() {
+		for {
+			time.Sleep(time.Until(a.nextRun(time.Now())))
+			archived, err := a.Run()
+			if err != nil {
+				log.Printf("Archive run failed: %v", err)
+			}
+			if archived > 0 {
+				log.Printf("Archived %d messages", archived)
+			}
+		}
+	}()
+}
+
+// Returns the next time the nightly run is due after now
+func (a *Archiver) nextRun(now time.Time) time.Time {
+	next := startOfDay(now).Add(a.runAt)
+	if !next.After(now) {
+		next = startOfDay(now.AddDate(0, 0, 1)).Add(a.runAt)
+	}
+	return next
+}
+
+// Uploads the messages of every day older than the archive age and removes
+// them from the history. A room's messages are only removed once all of its
+// days were uploaded. Returns the number of messages archived.
+func (a *Archiver) Run() (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	cutoff := startOfDay(time.Now().Add(-a.after))
+	days := map[string]map[string][]storage.StoredMessage{}
+	a.history.Scan(func(msg storage.StoredMessage) {
+		if !msg.Time.Before(cutoff) {
+			return
+		}
+		if days[msg.Room] == nil {
+			days[msg.Room] = map[string][]storage.StoredMessage{}
+		}
+		day := msg.Time.Format(time.DateOnly)
+		days[msg.Room][day] = append(days[msg.Room][day], msg)
+	})
+
+	rooms := make([]string, 0, len(days))
+	for room := range days {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+
+	archived := 0
+	var errs []error
+	for _, room := range rooms {
+		uploaded := true
+		for day, msgs := range days[room] {
+			if err := a.upload(room, day, msgs); err != nil {
+				errs = append(errs, fmt.Errorf("#%s %s: %w", room, day, err))
+				uploaded = false
+			}
+		}
+		if !uploaded {
+			continue
+		}
+		removed, err := a.history.Purge(room, cutoff)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("#%s: %w", room, err))
+			continue
+		}
+		archived += removed
+	}
+	return archived, errors.Join(errs...)
+}
+
+// Stores the messages of a room's day, merged with what an earlier run
+// already stored for it
+func (a *Archiver) upload(room string, day string, msgs []storage.StoredMessage) error {
+	key := a.key(room, day)
+	existing, err := a.download(key)
+	if err != nil && !errors.Is(err, errNoSuchObject) {
+		return err
+	}
+	byID := map[int64]storage.StoredMessage{}
+	for _, msg := range existing {
+		byID[msg.ID] = msg
+	}
+	for _, msg := range msgs {
+		byID[msg.ID] = msg
+	}
+	merged := make([]storage.StoredMessage, 0, len(byID))
+	for _, msg := range byID {
+		merged = append(merged, msg)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].ID < merged[j].ID })
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, msg := range merged {
+		if err := enc.Encode(msg); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return a.client.put(key, buf.Bytes(), "application/gzip")
+}
+
+// Returns the archived messages of a room sent on the given day, oldest
+// first
+func (a *Archiver) Fetch(room string, day time.Time) ([]storage.StoredMessage, error) {
+	msgs, err := a.download(a.key(room, day.Format(time.DateOnly)))
+	if errors.Is(err, errNoSuchObject) {
+		return nil, fmt.Errorf("nothing archived for #%s on %s", room, day.Format(time.DateOnly))
+	}
+	return msgs, err
+}
+
+// Downloads and decodes an archived day
+func (a *Archiver) download(key string) ([]storage.StoredMessage, error) {
+	data, err := a.client.get(key)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var msgs []storage.StoredMessage
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var msg storage.StoredMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, scanner.Err()
+}
+
+// Returns the object name of a room's day
+func (a *Archiver) key(room string, day string) string {
+	key := url.PathEscape(room) + "/" + day + ".jsonl.gz"
+	if a.prefix != "" {
+		key = a.prefix + "/" + key
+	}
+	return key
+}
+
+// Returns midnight of the day t falls on, in local time
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,344 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Types of events recorded in the audit log
+const (
+	EventConnect     = "connect"
+	EventHandshake   = "handshake_failed"
+	EventAuthFailure = "auth_failure"
+	EventAuthSuccess = "auth_success"
+	EventJoin        = "join"
+	EventLeave       = "leave"
+	EventCommand     = "command"
+	EventMessage     = "message"
+)
+
+// A single audit log entry, written as one JSON line
+type Event struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	User        string    `json:"user,omitempty"`
+	RemoteAddr  string    `json:"remote_addr,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	SessionID   string    `json:"session_id,omitempty"`
+	Command     string    `json:"command,omitempty"`
+	Message     string    `json:"message,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Number of the latest events kept in memory for Recent
+const recentCapacity = 200
+
+// Used for writing an append-only audit trail with size based rotation
+type Logger struct {
+	mu          sync.Mutex
+	path        string
+	file        *os.File
+	size        int64
+	maxSize     int64
+	maxBackups  int
+	logMessages bool
+	recent      []Event // ring of the latest events, oldest at next once full
+	next        int
+}
+
+// Returns a new audit logger, or nil when AUDIT_LOG_PATH is not set.
+// All methods are safe to call on a nil logger.
+func New() *Logger {
+	path := os.Getenv("AUDIT_LOG_PATH")
+	if path == "" {
+		return nil
+	}
+
+	al := &Logger{
+		path:        path,
+		maxSize:     int64(envInt("AUDIT_LOG_MAX_SIZE_MB", 100)) * 1024 * 1024,
+		maxBackups:  envInt("AUDIT_LOG_MAX_BACKUPS", 5),
+		logMessages: os.Getenv("AUDIT_LOG_MESSAGES") == "true",
+	}
+	if err := al.open(); err != nil {
+		log.Fatal("Failed to open audit log: ", err)
+	}
+	al.loadRecent()
+
+	return al
+}
+
+// Reports whether message contents should be recorded
+func (al *Logger) LogsMessages() bool {
+	return al != nil && al.logMessages
+}
+
+// Appends an event to the audit log, rotating the file when it grows too large
+func (al *Logger) Log(e Event) {
+	if al == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Println("Audit marshal error:", err)
+		return
+	}
+	line = append(line, '\n')
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if al.maxSize > 0 && al.size+int64(len(line)) > al.maxSize {
+		if err := al.rotate(); err != nil {
+			log.Println("Audit rotate error:", err)
+		}
+	}
+
+	n, err := al.file.Write(line)
+	al.size += int64(n)
+	if err != nil {
+		log.Println("Audit write error:", err)
+	}
+	al.remember(e)
+}
+
+// Fills the ring of recent events from the log, reading files newest first
+// only until it is full
+func (al *Logger) loadRecent() {
+	files := al.files()
+	var latest [][]Event
+	count := 0
+	for i := len(files) - 1; i >= 0 && count < recentCapacity; i-- {
+		var events []Event
+		err := scanFile(files[i], func(e *Event) bool {
+			events = append(events, *e)
+			return false
+		})
+		if err != nil {
+			log.Println("Audit read error:", err)
+			return
+		}
+		latest = append(latest, events)
+		count += len(events)
+	}
+	for i := len(latest) - 1; i >= 0; i-- {
+		for _, e := range latest[i] {
+			al.remember(e)
+		}
+	}
+}
+
+// Adds an event to the ring of recent events, replacing the oldest once
+// it is full
+func (al *Logger) remember(e Event) {
+	if len(al.recent) < recentCapacity {
+		al.recent = append(al.recent, e)
+		return
+	}
+	al.recent[al.next] = e
+	al.next = (al.next + 1) % recentCapacity
+}
+
+// Closes the underlying audit log file
+func (al *Logger) Close() error {
+	if al == nil {
+		return nil
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	return al.file.Close()
+}
+
+// Opens the audit log file for appending and records its current size
+func (al *Logger) open() error {
+	f, err := os.OpenFile(al.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	al.file = f
+	al.size = info.Size()
+	return nil
+}
+
+// Shifts the existing backups (path.1 -> path.2 ...) and starts a fresh file
+func (al *Logger) rotate() error {
+	if err := al.file.Close(); err != nil {
+		return err
+	}
+
+	if al.maxBackups > 0 {
+		os.Remove(backupName(al.path, al.maxBackups))
+		for i := al.maxBackups - 1; i >= 1; i-- {
+			os.Rename(backupName(al.path, i), backupName(al.path, i+1))
+		}
+		if err := os.Rename(al.path, backupName(al.path, 1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(al.path); err != nil {
+		return err
+	}
+
+	return al.open()
+}
+
+func backupName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+func envInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// Returns the events of the user in the current log file and its backups,
+// oldest first
+func (al *Logger) Events(user string) ([]Event, error) {
+	if al == nil {
+		return nil, nil
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	var events []Event
+	for _, path := range al.files() {
+		err := scanFile(path, func(e *Event) bool {
+			if e.User == user {
+				events = append(events, *e)
+			}
+			return false
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// Returns the last n events, oldest first. Served from memory, so at most
+// the latest recentCapacity events are available.
+func (al *Logger) Recent(n int) []Event {
+	if al == nil || n <= 0 {
+		return nil
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	ordered := append(append([]Event(nil), al.recent[al.next:]...), al.recent[:al.next]...)
+	if len(ordered) > n {
+		ordered = ordered[len(ordered)-n:]
+	}
+	return ordered
+}
+
+// Replaces the user's name and anything identifying in their events with
+// placeholders, keeping the events themselves so the trail stays complete.
+// Returns the number of events redacted.
+func (al *Logger) Redact(user string) (int, error) {
+	if al == nil {
+		return 0, nil
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	for i := range al.recent {
+		if al.recent[i].User == user {
+			redactEvent(&al.recent[i])
+		}
+	}
+	redacted := 0
+	for _, path := range al.files() {
+		err := scanFile(path, func(e *Event) bool {
+			if e.User != user {
+				return false
+			}
+			redactEvent(e)
+			redacted++
+			return true
+		})
+		if err != nil {
+			return redacted, err
+		}
+	}
+	// The current file was replaced, so writes must go to the new one.
+	if err := al.file.Close(); err != nil {
+		return redacted, err
+	}
+	return redacted, al.open()
+}
+
+// Name put in place of a redacted user
+const redactedUser = "[redacted]"
+
+// Replaces the user and anything identifying in the event
+func redactEvent(e *Event) {
+	e.User = redactedUser
+	e.RemoteAddr, e.Fingerprint, e.Command, e.Message = "", "", "", ""
+}
+
+// Returns the paths of the current log file and its backups, oldest first
+func (al *Logger) files() []string {
+	var paths []string
+	for i := al.maxBackups; i >= 1; i-- {
+		if _, err := os.Stat(backupName(al.path, i)); err == nil {
+			paths = append(paths, backupName(al.path, i))
+		}
+	}
+	return append(paths, al.path)
+}
+
+// Calls fn for every event in the file. When fn reports a change the file
+// is rewritten with the changes.
+func scanFile(path string, fn func(e *Event) bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	var out []byte
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		var e Event
+		if len(bytes.TrimSpace(line)) == 0 || json.Unmarshal(line, &e) != nil {
+			out = append(out, line...)
+			continue
+		}
+		if !fn(&e) {
+			out = append(out, line...)
+			continue
+		}
+		changed = true
+		updated, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		out = append(append(out, updated...), '\n')
+	}
+	if !changed {
+		return nil
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -1,87 +1,307 @@
 package auth
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"group-ssh-chat/storage"
+	"group-ssh-chat/totp"
+	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
 )
 
-// Used for managing SSH authentication
+// Set on the permissions of users whose self-registration awaits approval
+const (
+	RegistrationExtension = "registration"
+	RegistrationPending   = "pending"
+)
+
+// Set on the permissions when the login name was replaced with the username
+// bound to the key
+const UserExtension = "user"
+
+// Set on the permissions of a key admitted by open registration, holding the
+// key in authorized_keys format. The public key callback also runs for keys
+// a client merely queries, so the key is saved by CompleteRegistration once
+// the handshake succeeded.
+const NewKeyExtension = "new-key"
+
+// Usernames accepted by open registration
+var validUsername = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+// Used for managing SSH authentication. Credentials are checked by the
+// configured providers, then users who enabled two-factor authentication are
+// challenged for a TOTP code.
 type SSHAuth struct {
-	authorizedKeysMap map[string]string
-	HostSSHPrivateKey ssh.Signer
+	HostSSHPrivateKeys []ssh.Signer
+	providers          []AuthProvider
+	totpSecrets        *storage.SecretStore
+	registeredKeys     *storage.KeyStore
+	totpMutex          sync.Mutex
+	lastTOTPStep       map[string]int64
 }
 
 // Returns new ssh auth manager struct reference
-func New() *SSHAuth {
+func New(totpSecrets *storage.SecretStore, registeredKeys *storage.KeyStore) *SSHAuth {
 	sam := &SSHAuth{
-		authorizedKeysMap: map[string]string{},
+		totpSecrets:    totpSecrets,
+		registeredKeys: registeredKeys,
+		lastTOTPStep:   map[string]int64{},
 	}
-	sam.initHostSSHPrivateKey()
-	sam.initAuthorizedKeys()
+	if os.Getenv("HOST_SSH_KEYS_DIR") != "" {
+		sam.initHostSSHKeysDir()
+	} else {
+		sam.initHostSSHPrivateKey()
+	}
+	sam.providers = loadProviders(Stores{RegisteredKeys: registeredKeys})
 
 	return sam
 }
 
-// Handles the public authorized key login for a user
-func (sam SSHAuth) HandlePublicKeyLogin(c ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
-	if sam.authorizedKeysMap[c.User()] == string(pubKey.Marshal()) {
-		return &ssh.Permissions{
-			// Record the public key used for authentication.
-			Extensions: map[string]string{
-				"pubkey-fp": ssh.FingerprintSHA256(pubKey),
+// Handles the public key login for a user
+func (sam *SSHAuth) HandlePublicKeyLogin(c ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+	perms, err := sam.authenticate(func(p AuthProvider) (*ssh.Permissions, error) {
+		return p.PublicKey(c, pubKey)
+	})
+	return sam.secondFactor(c, perms, err)
+}
+
+// Handles the password login for a user
+func (sam *SSHAuth) HandlePasswordLogin(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+	perms, err := sam.authenticate(func(p AuthProvider) (*ssh.Permissions, error) {
+		return p.Password(c, pass)
+	})
+	return sam.secondFactor(c, perms, err)
+}
+
+// Handles the keyboard-interactive login for a user
+func (sam *SSHAuth) HandleKeyboardInteractiveLogin(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	perms, err := sam.authenticate(func(p AuthProvider) (*ssh.Permissions, error) {
+		return p.KeyboardInteractive(c, client)
+	})
+	return sam.secondFactor(c, perms, err)
+}
+
+// Saves the key of a connection admitted by open registration. Fails when
+// someone else registered the username since the key was offered.
+func (sam *SSHAuth) CompleteRegistration(user string, perms *ssh.Permissions) error {
+	key, ok := perms.Extensions[NewKeyExtension]
+	if !ok {
+		return nil
+	}
+	registered := storage.RegisteredKey{
+		User:         user,
+		Key:          key,
+		Fingerprint:  perms.Extensions["pubkey-fp"],
+		RegisteredAt: time.Now(),
+		Pending:      perms.Extensions[RegistrationExtension] == RegistrationPending,
+	}
+	if err := sam.registeredKeys.Register(registered); err != nil {
+		return err
+	}
+	log.Printf("Registered %s for %q (pending approval: %v)", registered.Fingerprint, user, registered.Pending)
+	return nil
+}
+
+// Challenges users who passed a provider and enabled two-factor
+// authentication for a TOTP code. Keys awaiting approval are let through
+// without one, the session only tells them to wait.
+func (sam *SSHAuth) secondFactor(c ssh.ConnMetadata, perms *ssh.Permissions, err error) (*ssh.Permissions, error) {
+	if err != nil {
+		return nil, err
+	}
+	if perms == nil {
+		perms = &ssh.Permissions{}
+	}
+	if perms.Extensions[RegistrationExtension] == RegistrationPending {
+		return perms, nil
+	}
+	user := c.User()
+	if canonical, ok := perms.Extensions[UserExtension]; ok {
+		user = canonical
+	}
+	if secret, ok := sam.totpSecrets.Get(user); ok && !secret.Pending {
+		return nil, &ssh.PartialSuccessError{
+			Next: ssh.ServerAuthCallbacks{
+				KeyboardInteractiveCallback: sam.totpChallenge(user, perms),
 			},
-		}, nil
+		}
+	}
+	return perms, nil
+}
+
+// Returns a keyboard-interactive callback asking for the user's current TOTP
+// code, granting perms when it is valid and has not been used before
+func (sam *SSHAuth) totpChallenge(user string, perms *ssh.Permissions) func(ssh.ConnMetadata, ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	return func(_ ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		answers, err := client("", "Two-factor authentication", []string{"Verification code: "}, []bool{true})
+		if err != nil {
+			return nil, err
+		}
+		secret, ok := sam.totpSecrets.Get(user)
+		if !ok || len(answers) != 1 {
+			return nil, fmt.Errorf("two-factor authentication failed for %q", user)
+		}
+
+		step, ok := totp.Validate(secret.Secret, answers[0], time.Now())
+		if !ok {
+			return nil, fmt.Errorf("invalid verification code for %q", user)
+		}
+
+		sam.totpMutex.Lock()
+		defer sam.totpMutex.Unlock()
+		if step <= sam.lastTOTPStep[user] {
+			return nil, fmt.Errorf("verification code for %q was already used", user)
+		}
+		sam.lastTOTPStep[user] = step
+		return perms, nil
 	}
-	return nil, fmt.Errorf("unknown public key for %q", c.User())
 }
 
-// handles password based login
-// func (sam SSHAuth) HandlePasswordLogin(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
-// 	// Should use constant-time compare (or better, salt+hash) in
-// 	// a production setting.
-// 	if c.User() == "testuser" && string(pass) == "tiger" {
-// 		return &ssh.Permissions{
-// 			// Record the public key used for authentication.
-// 			Extensions: map[string]string{
-// 				"pubkey-fp": c.User(),
-// 			},
-// 		}, nil
-// 	}
-// 	return nil, fmt.Errorf("password rejected for %q", c.User())
-// }
-
-// Reads the host ssh server private key and parses it
+// Reads the host ssh server private key and parses it, generating a new key
+// on first run when the file does not exist yet
 func (sam *SSHAuth) initHostSSHPrivateKey() {
-	pkBytes, err := os.ReadFile(os.Getenv("HOST_SSH_PRIVATE_KEY_PATH"))
+	path := os.Getenv("HOST_SSH_PRIVATE_KEY_PATH")
+	pkBytes, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && path != "" {
+		log.Printf("Host key %s not found, generating a new ed25519 key", path)
+		signer, err := GenerateHostKey(path)
+		if err != nil {
+			log.Fatal("Failed to generate host key: ", err)
+		}
+		sam.HostSSHPrivateKeys = []ssh.Signer{signer}
+		return
+	}
 	if err != nil {
 		log.Fatal("Failed to load private key: ", err)
 	}
 
-	pk, err := ssh.ParsePrivateKey(pkBytes)
+	pk, err := parseHostKey(path, pkBytes)
 	if err != nil {
 		log.Fatal("Failed to parse private key: ", err)
 	}
 
-	sam.HostSSHPrivateKey = pk
+	sam.HostSSHPrivateKeys = []ssh.Signer{pk}
 }
 
-// Public key authentication is done by comparing the public key of a received connection
-func (sam *SSHAuth) initAuthorizedKeys() {
-	authorizedKeysBytes, err := os.ReadFile(os.Getenv("AUTHORIZED_KEYS_PATH"))
-	if err != nil {
-		log.Fatalf("Failed to load authorized_keys, err: %v", err)
+// Loads every private key in HOST_SSH_KEYS_DIR (e.g. RSA, ed25519 and ECDSA
+// keys side by side) so clients can negotiate any of their algorithms.
+// Public key files are skipped. An empty directory gets a new ed25519 key.
+func (sam *SSHAuth) initHostSSHKeysDir() {
+	dir := os.Getenv("HOST_SSH_KEYS_DIR")
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatal("Failed to read host keys directory: ", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".pub") || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		pkBytes, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatal("Failed to load private key: ", err)
+		}
+		pk, err := parseHostKey(path, pkBytes)
+		if err != nil {
+			log.Fatalf("Failed to parse private key %s: %v", path, err)
+		}
+
+		log.Printf("Loaded %s host key %s", pk.PublicKey().Type(), path)
+		sam.HostSSHPrivateKeys = append(sam.HostSSHPrivateKeys, pk)
 	}
 
-	for len(authorizedKeysBytes) > 0 {
-		pubKey, comment, _, rest, err := ssh.ParseAuthorizedKey(authorizedKeysBytes)
+	if len(sam.HostSSHPrivateKeys) == 0 {
+		log.Printf("No host keys found in %s, generating a new ed25519 key", dir)
+		signer, err := GenerateHostKey(filepath.Join(dir, "ssh_host_ed25519_key"))
 		if err != nil {
-			log.Fatal(err)
+			log.Fatal("Failed to generate host key: ", err)
 		}
+		sam.HostSSHPrivateKeys = []ssh.Signer{signer}
+	}
+}
+
+// Parses a host private key, decrypting it when it is passphrase protected
+func parseHostKey(path string, pkBytes []byte) (ssh.Signer, error) {
+	pk, err := ssh.ParsePrivateKey(pkBytes)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return pk, err
+	}
+
+	passphrase, err := hostKeyPassphrase(path)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKeyWithPassphrase(pkBytes, passphrase)
+}
 
-		sam.authorizedKeysMap[comment] = string(pubKey.Marshal())
-		authorizedKeysBytes = rest
+// Returns the host key passphrase from HOST_SSH_KEY_PASSPHRASE, or prompts
+// for it when the server was started from an interactive terminal
+func hostKeyPassphrase(path string) ([]byte, error) {
+	if passphrase := os.Getenv("HOST_SSH_KEY_PASSPHRASE"); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("%s is encrypted, set HOST_SSH_KEY_PASSPHRASE or start the server from a terminal", path)
+	}
+	fmt.Fprintf(os.Stderr, "Enter passphrase for host key %s: ", path)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return passphrase, err
+}
+
+// Generates an ed25519 host key, writes it to path in OpenSSH format with
+// 0600 permissions and logs its fingerprint. The key is encrypted when
+// HOST_SSH_KEY_PASSPHRASE is set. An existing file is never overwritten.
+func GenerateHostKey(path string) (ssh.Signer, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	var block *pem.Block
+	if passphrase := os.Getenv("HOST_SSH_KEY_PASSPHRASE"); passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "group-ssh-chat host key", []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(priv, "group-ssh-chat host key")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := pem.Encode(f, block); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, err
 	}
+	log.Printf("Generated host key %s with fingerprint %s", path, ssh.FingerprintSHA256(signer.PublicKey()))
+	return signer, nil
 }
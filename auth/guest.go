@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Set on the permissions of guests
+const GuestExtension = "guest"
+
+// Prefix of the temporary names given to guests, which other providers do
+// not hand out
+const GuestPrefix = "guest-"
+
+// Number of distinct guest names, guest-0000 to guest-9999
+const guestNames = 10000
+
+func init() {
+	Register("guest", func(Stores) AuthProvider {
+		return &guestProvider{owners: map[int]string{}}
+	})
+}
+
+// Lets in anyone, under a temporary guest name. Listed last in
+// AUTH_PROVIDERS it admits whoever the other providers do not know.
+type guestProvider struct {
+	mu     sync.Mutex
+	owners map[int]string // guest number -> key fingerprint, "" for keyless guests
+}
+
+func (gp *guestProvider) Methods() []string {
+	return []string{MethodPublicKey, MethodKeyboardInteractive}
+}
+
+// Admits a guest with a key. The same key always gets the same name as long
+// as it is free.
+func (gp *guestProvider) PublicKey(_ ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+	fingerprint := ssh.FingerprintSHA256(pubKey)
+	sum := sha256.Sum256(pubKey.Marshal())
+	perms := gp.admit(int(binary.BigEndian.Uint32(sum[:])%guestNames), fingerprint)
+	perms.Extensions["pubkey-fp"] = fingerprint
+	return perms, nil
+}
+
+func (gp *guestProvider) Password(c ssh.ConnMetadata, _ []byte) (*ssh.Permissions, error) {
+	return nil, notHandled("password rejected for %q", c.User())
+}
+
+// Admits a guest without a key. No questions are asked.
+func (gp *guestProvider) KeyboardInteractive(_ ssh.ConnMetadata, _ ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	var b [4]byte
+	rand.Read(b[:])
+	return gp.admit(int(binary.BigEndian.Uint32(b[:])%guestNames), ""), nil
+}
+
+// Picks the first name from number on that is free or already belongs to
+// the key
+func (gp *guestProvider) admit(number int, fingerprint string) *ssh.Permissions {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	if len(gp.owners) >= guestNames {
+		// Every name was handed out, start over
+		gp.owners = map[int]string{}
+	}
+	for {
+		owner, taken := gp.owners[number]
+		if !taken || fingerprint != "" && owner == fingerprint {
+			break
+		}
+		number = (number + 1) % guestNames
+	}
+	gp.owners[number] = fingerprint
+
+	return &ssh.Permissions{
+		Extensions: map[string]string{
+			UserExtension:  fmt.Sprintf("%s%04d", GuestPrefix, number),
+			GuestExtension: "true",
+		},
+	}
+}
+
+// Reports whether the name is reserved for guests
+func isGuestName(user string) bool {
+	return strings.HasPrefix(strings.ToLower(user), GuestPrefix)
+}
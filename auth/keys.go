@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"fmt"
+	"group-ssh-chat/confusable"
+	"group-ssh-chat/storage"
+	"log"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func init() {
+	Register("authorized_keys", func(stores Stores) AuthProvider {
+		return newKeyProvider(stores.RegisteredKeys)
+	})
+}
+
+// Accepts the keys in AUTHORIZED_KEYS_PATH and self-registered keys
+type keyProvider struct {
+	authorizedKeysMap map[string]string // marshaled key -> username
+	authorizedUsers   map[string]bool
+	overrideUsername  bool
+	registeredKeys    *storage.KeyStore
+	openRegistration  bool
+	requireApproval   bool
+}
+
+// Returns a provider for the authorized_keys file and registered keys
+func newKeyProvider(registeredKeys *storage.KeyStore) *keyProvider {
+	kp := &keyProvider{
+		authorizedKeysMap: map[string]string{},
+		authorizedUsers:   map[string]bool{},
+		overrideUsername:  os.Getenv("STRICT_USERNAMES") == "override",
+		registeredKeys:    registeredKeys,
+		openRegistration:  os.Getenv("OPEN_REGISTRATION") == "true",
+		requireApproval:   os.Getenv("REGISTRATION_REQUIRES_APPROVAL") == "true",
+	}
+	kp.initAuthorizedKeys()
+	return kp
+}
+
+func (kp *keyProvider) Methods() []string {
+	return []string{MethodPublicKey}
+}
+
+// Handles the public authorized key login for a user. A key from
+// authorized_keys may only log in as the name in its comment; with
+// STRICT_USERNAMES=override a mismatching login name is replaced instead of
+// rejected.
+func (kp *keyProvider) PublicKey(c ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+	perms := &ssh.Permissions{
+		// Record the public key used for authentication.
+		Extensions: map[string]string{
+			"pubkey-fp": ssh.FingerprintSHA256(pubKey),
+		},
+	}
+
+	user := c.User()
+	if canonical, ok := kp.authorizedKeysMap[string(pubKey.Marshal())]; ok {
+		// The authorized_keys comment is the only name a key may use.
+		if canonical != user {
+			if !kp.overrideUsername {
+				return nil, fmt.Errorf("key of %q used to log in as %q", canonical, user)
+			}
+			perms.Extensions[UserExtension] = canonical
+		}
+		return perms, nil
+	}
+
+	if kp.authorizedUsers[user] {
+		return nil, fmt.Errorf("unknown public key for %q", user)
+	}
+	registered, isNew, err := kp.registeredKey(user, pubKey)
+	if err != nil {
+		return nil, err
+	}
+	if isNew {
+		perms.Extensions[NewKeyExtension] = registered.Key
+	}
+	if registered.Pending {
+		perms.Extensions[RegistrationExtension] = RegistrationPending
+	}
+	return perms, nil
+}
+
+func (kp *keyProvider) Password(c ssh.ConnMetadata, _ []byte) (*ssh.Permissions, error) {
+	return nil, notHandled("password rejected for %q", c.User())
+}
+
+func (kp *keyProvider) KeyboardInteractive(c ssh.ConnMetadata, _ ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	return nil, notHandled("keyboard-interactive rejected for %q", c.User())
+}
+
+// Returns the self-registered key for the user. When open registration is
+// enabled and the username is free the presented key is returned as a new
+// registration, which is only saved once the handshake completes.
+func (kp *keyProvider) registeredKey(user string, pubKey ssh.PublicKey) (registered storage.RegisteredKey, isNew bool, err error) {
+	if registered, ok := kp.registeredKeys.Get(user); ok {
+		if registered.Key != string(ssh.MarshalAuthorizedKey(pubKey)) {
+			return storage.RegisteredKey{}, false, fmt.Errorf("unknown public key for %q", user)
+		}
+		return registered, false, nil
+	}
+	if !kp.openRegistration {
+		return storage.RegisteredKey{}, false, notHandled("unknown user %q", user)
+	}
+	if !validUsername.MatchString(user) || isGuestName(user) {
+		return storage.RegisteredKey{}, false, notHandled("invalid username %q", user)
+	}
+	if existing, ok := confusable.Find(user, kp.knownUsers()); ok {
+		log.Printf("Refused to register %q, which looks like %q", user, existing)
+		return storage.RegisteredKey{}, false, fmt.Errorf("username %q looks too much like %q", user, existing)
+	}
+
+	return storage.RegisteredKey{
+		User:        user,
+		Key:         string(ssh.MarshalAuthorizedKey(pubKey)),
+		Fingerprint: ssh.FingerprintSHA256(pubKey),
+		Pending:     kp.requireApproval,
+	}, true, nil
+}
+
+// Returns the users of authorized_keys and of registered keys
+func (kp *keyProvider) knownUsers() []string {
+	users := make([]string, 0, len(kp.authorizedUsers))
+	for user := range kp.authorizedUsers {
+		users = append(users, user)
+	}
+	for _, key := range kp.registeredKeys.List() {
+		users = append(users, key.User)
+	}
+	return users
+}
+
+// Public key authentication is done by comparing the public key of a received connection
+func (kp *keyProvider) initAuthorizedKeys() {
+	authorizedKeysBytes, err := os.ReadFile(os.Getenv("AUTHORIZED_KEYS_PATH"))
+	if err != nil {
+		log.Fatalf("Failed to load authorized_keys, err: %v", err)
+	}
+
+	for len(authorizedKeysBytes) > 0 {
+		pubKey, comment, _, rest, err := ssh.ParseAuthorizedKey(authorizedKeysBytes)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		kp.authorizedKeysMap[string(pubKey.Marshal())] = comment
+		kp.authorizedUsers[comment] = true
+		authorizedKeysBytes = rest
+	}
+}
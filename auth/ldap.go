@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"golang.org/x/crypto/ssh"
+)
+
+func init() {
+	Register("ldap", func(Stores) AuthProvider {
+		l := NewLDAP()
+		if l == nil {
+			log.Fatal("The ldap auth provider needs LDAP_URL")
+		}
+		return l
+	})
+}
+
+// Set on the permissions of users who logged in through the directory,
+// holding their chat role
+const RoleExtension = "role"
+
+// Chat roles granted by directory group membership
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// Used for checking password logins against an LDAP directory such as
+// Active Directory. Users are looked up with a service account, then their
+// password is checked by binding as them.
+type LDAP struct {
+	url            string
+	startTLS       bool
+	bindDN         string
+	bindPassword   string
+	baseDN         string
+	userFilter     string
+	groupAttribute string
+	memberGroup    string
+	adminGroup     string
+}
+
+// Returns a directory backend for the server at LDAP_URL (ldap:// or
+// ldaps://), or nil when the variable is not set. LDAP_BIND_DN and
+// LDAP_BIND_PASSWORD are the service account, LDAP_BASE_DN where users are
+// searched with LDAP_USER_FILTER (default "(uid=%s)", for Active Directory
+// use "(sAMAccountName=%s)"). LDAP_START_TLS=true upgrades plain
+// connections. Users must be in LDAP_MEMBER_GROUP when it is set and get
+// the admin role when in LDAP_ADMIN_GROUP, both read from the
+// LDAP_GROUP_ATTRIBUTE attribute (default memberOf).
+func NewLDAP() *LDAP {
+	url := os.Getenv("LDAP_URL")
+	if url == "" {
+		return nil
+	}
+
+	l := &LDAP{
+		url:            url,
+		startTLS:       os.Getenv("LDAP_START_TLS") == "true",
+		bindDN:         os.Getenv("LDAP_BIND_DN"),
+		bindPassword:   os.Getenv("LDAP_BIND_PASSWORD"),
+		baseDN:         os.Getenv("LDAP_BASE_DN"),
+		userFilter:     os.Getenv("LDAP_USER_FILTER"),
+		groupAttribute: os.Getenv("LDAP_GROUP_ATTRIBUTE"),
+		memberGroup:    os.Getenv("LDAP_MEMBER_GROUP"),
+		adminGroup:     os.Getenv("LDAP_ADMIN_GROUP"),
+	}
+	if l.userFilter == "" {
+		l.userFilter = "(uid=%s)"
+	}
+	if l.groupAttribute == "" {
+		l.groupAttribute = "memberOf"
+	}
+	return l
+}
+
+// Checks the user's password against the directory and returns the user's
+// chat role
+func (l *LDAP) Authenticate(user string, password string) (string, error) {
+	// An empty password would be an unauthenticated bind, which many
+	// servers accept for any DN.
+	if password == "" || !validUsername.MatchString(user) {
+		return "", fmt.Errorf("password rejected for %q", user)
+	}
+
+	conn, err := l.dial()
+	if err != nil {
+		return "", fmt.Errorf("directory unavailable: %w", err)
+	}
+	defer conn.Close()
+
+	if l.bindDN != "" {
+		if err := conn.Bind(l.bindDN, l.bindPassword); err != nil {
+			return "", fmt.Errorf("directory service account bind failed: %w", err)
+		}
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		l.baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 10, false,
+		fmt.Sprintf(l.userFilter, ldap.EscapeFilter(user)),
+		[]string{"dn", l.groupAttribute},
+		nil,
+	))
+	if err != nil {
+		return "", fmt.Errorf("directory search for %q failed: %w", user, err)
+	}
+	if len(result.Entries) != 1 {
+		return "", fmt.Errorf("password rejected for %q", user)
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		return "", fmt.Errorf("password rejected for %q", user)
+	}
+
+	groups := map[string]bool{}
+	for _, group := range entry.GetAttributeValues(l.groupAttribute) {
+		groups[strings.ToLower(group)] = true
+	}
+	if l.adminGroup != "" && groups[strings.ToLower(l.adminGroup)] {
+		return RoleAdmin, nil
+	}
+	if l.memberGroup != "" && !groups[strings.ToLower(l.memberGroup)] {
+		return "", fmt.Errorf("%q is not in the chat members group", user)
+	}
+	return RoleMember, nil
+}
+
+func (l *LDAP) Methods() []string {
+	return []string{MethodPassword, MethodKeyboardInteractive}
+}
+
+func (l *LDAP) PublicKey(c ssh.ConnMetadata, _ ssh.PublicKey) (*ssh.Permissions, error) {
+	return nil, notHandled("unknown public key for %q", c.User())
+}
+
+// Checks the password against the directory and records the user's role in
+// the permissions
+func (l *LDAP) Password(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	role, err := l.Authenticate(c.User(), string(password))
+	if err != nil {
+		return nil, err
+	}
+	return &ssh.Permissions{
+		Extensions: map[string]string{
+			RoleExtension: role,
+		},
+	}, nil
+}
+
+// Asks for the password, for clients that prefer keyboard-interactive over
+// plain password authentication
+func (l *LDAP) KeyboardInteractive(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	answers, err := client("", "", []string{"Password: "}, []bool{false})
+	if err != nil {
+		return nil, err
+	}
+	if len(answers) != 1 {
+		return nil, fmt.Errorf("password rejected for %q", c.User())
+	}
+	return l.Password(c, []byte(answers[0]))
+}
+
+// Connects to the directory, upgrading to TLS when configured
+func (l *LDAP) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(l.url, ldap.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(10 * time.Second)
+	if l.startTLS {
+		host := strings.TrimPrefix(strings.TrimPrefix(l.url, "ldap://"), "ldaps://")
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			conn.Close()
+			return nil, errors.Join(errors.New("StartTLS failed"), err)
+		}
+	}
+	return conn, nil
+}
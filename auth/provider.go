@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/storage"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSH authentication methods a provider can handle
+const (
+	MethodPublicKey           = "publickey"
+	MethodPassword            = "password"
+	MethodKeyboardInteractive = "keyboard-interactive"
+)
+
+// A source of credentials accepted by the SSH server, e.g. authorized_keys or
+// an LDAP directory. Providers are asked in the configured order and return a
+// NotHandledError for credentials they know nothing about, so the next one
+// gets a chance. Any other error rejects the login.
+type AuthProvider interface {
+	// Returns the methods the provider handles
+	Methods() []string
+	PublicKey(c ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error)
+	Password(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error)
+	KeyboardInteractive(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error)
+}
+
+// Returned by providers for credentials they do not know. The reason is
+// reported when no provider accepts the credentials.
+type NotHandledError struct {
+	Reason string
+}
+
+func (e *NotHandledError) Error() string {
+	return e.Reason
+}
+
+// Returns a NotHandledError with a formatted reason
+func notHandled(format string, args ...any) error {
+	return &NotHandledError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// Stores providers may use, e.g. for self-registered keys
+type Stores struct {
+	RegisteredKeys *storage.KeyStore
+}
+
+// Creates a provider from its environment variables
+type ProviderFactory func(stores Stores) AuthProvider
+
+var (
+	registryMutex sync.Mutex
+	registry      = map[string]ProviderFactory{}
+)
+
+// Makes a provider available under name for AUTH_PROVIDERS. Deployments can
+// register their own providers before calling New.
+func Register(name string, factory ProviderFactory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if _, ok := registry[name]; ok {
+		log.Fatalf("Auth provider %q registered twice", name)
+	}
+	registry[name] = factory
+}
+
+// Creates the providers listed in the comma separated AUTH_PROVIDERS, e.g.
+// "authorized_keys,ldap". Defaults to authorized_keys, followed by ldap when
+// LDAP_URL is set.
+func loadProviders(stores Stores) []AuthProvider {
+	names := os.Getenv("AUTH_PROVIDERS")
+	if names == "" {
+		names = "authorized_keys"
+		if os.Getenv("LDAP_URL") != "" {
+			names += ",ldap"
+		}
+	}
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	var providers []AuthProvider
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		factory, ok := registry[name]
+		if !ok {
+			known := make([]string, 0, len(registry))
+			for n := range registry {
+				known = append(known, n)
+			}
+			sort.Strings(known)
+			log.Fatalf("Unknown auth provider %q in AUTH_PROVIDERS, available: %s", name, strings.Join(known, ", "))
+		}
+		providers = append(providers, factory(stores))
+	}
+	return providers
+}
+
+// Reports whether any provider handles the method
+func (sam *SSHAuth) Supports(method string) bool {
+	for _, p := range sam.providers {
+		for _, m := range p.Methods() {
+			if m == method {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Implemented by providers that can list the users they know, e.g. the
+// names in authorized_keys
+type userLister interface {
+	knownUsers() []string
+}
+
+// Reports whether any provider knows the user, so unauthenticated frontends
+// do not hand out its name
+func (sam *SSHAuth) IsKnownUser(user string) bool {
+	for _, p := range sam.providers {
+		lister, ok := p.(userLister)
+		if !ok {
+			continue
+		}
+		for _, known := range lister.knownUsers() {
+			if strings.EqualFold(known, user) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Asks the providers in order until one handles the credentials. Returns
+// the reason of the last provider when none does.
+func (sam *SSHAuth) authenticate(try func(p AuthProvider) (*ssh.Permissions, error)) (*ssh.Permissions, error) {
+	err := errors.New("no auth providers configured")
+	for _, p := range sam.providers {
+		var perms *ssh.Permissions
+		perms, err = try(p)
+		var nh *NotHandledError
+		if !errors.As(err, &nh) {
+			return perms, err
+		}
+	}
+	return nil, err
+}
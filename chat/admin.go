@@ -0,0 +1,181 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/commands"
+	"os"
+	"strings"
+	"time"
+)
+
+// Returned by admin-only commands when invoked by a regular user
+var errNotAdmin = errors.New("This command is only available to admins")
+
+// Parses the comma separated ADMIN_USERS list
+func loadAdmins() map[string]bool {
+	admins := map[string]bool{}
+	for _, name := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			admins[name] = true
+		}
+	}
+	return admins
+}
+
+// Reports whether the user is a server admin
+func (h *Hub) isAdmin(user string) bool {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	return h.isAdminLocked(user)
+}
+
+// Like isAdmin, with the lock held. Admins are listed in ADMIN_USERS or were
+// granted the role by their directory groups at their last login.
+func (h *Hub) isAdminLocked(user string) bool {
+	return h.config.admins[user] || h.directoryAdmins[user]
+}
+
+// Returns the role the command manager checks commands against: admin,
+// op of the user's current room or everyone
+func (h *Hub) roleOf(user string) commands.Permission {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	if h.isAdminLocked(user) {
+		return commands.Admin
+	}
+	if room := h.rooms[h.userRooms[user]]; room != nil && room.isOp(user, false) {
+		return commands.RoomOp
+	}
+	return commands.Everyone
+}
+
+// Records the role the directory granted a user logging in with a password,
+// so group changes take effect at the next login
+func (h *Hub) SetDirectoryRole(user string, admin bool) {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	if admin {
+		h.directoryAdmins[user] = true
+	} else {
+		delete(h.directoryAdmins, user)
+	}
+}
+
+// Lists IPs blocked for failed logins, or clears one or all blocks
+func (h *Hub) blocked(ctx *commands.CommandContext, args []string) error {
+	if !h.isAdmin(ctx.User) {
+		return errNotAdmin
+	}
+
+	if len(args) == 2 && args[0] == "clear" {
+		if args[1] == "all" {
+			h.policy.UnblockAll()
+			return ctx.Reply("All blocks cleared")
+		}
+		if !h.policy.Unblock(args[1]) {
+			return fmt.Errorf("%s is not blocked", args[1])
+		}
+		return ctx.Reply(args[1] + " unblocked")
+	}
+	if len(args) != 0 {
+		return errors.New("Usage: /blocked [clear <ip>|all]")
+	}
+
+	blocks := h.policy.Blocked()
+	if len(blocks) == 0 {
+		return ctx.Reply("No IPs are blocked")
+	}
+	var sb strings.Builder
+	sb.WriteString("Blocked IPs:")
+	for _, b := range blocks {
+		sb.WriteString(fmt.Sprintf("\n  %-40s %d failures, %s left", b.IP, b.Failures, time.Until(b.Until).Round(time.Second)))
+	}
+	return ctx.Reply(sb.String())
+}
+
+// Sends a system notice to all of the user's sessions
+func (h *Hub) replySystem(user string, text string) error {
+	h.Deliver(ToUser(user), newSystemMessage("", text))
+	return nil
+}
+
+// Handles /purge <room> [before-date], removing the room's history or the
+// part of it sent before the date
+func (h *Hub) purge(ctx *commands.CommandContext, args []string) error {
+	if !h.isAdmin(ctx.User) {
+		return errNotAdmin
+	}
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("Usage: /purge <room> [before-date], e.g. /purge #dev 2024-01-31")
+	}
+	room := normalizeRoomName(args[0])
+	var before time.Time
+	if len(args) == 2 {
+		var err error
+		if before, err = time.ParseInLocation(time.DateOnly, args[1], time.Local); err != nil {
+			if before, err = time.Parse(time.RFC3339, args[1]); err != nil {
+				return fmt.Errorf("Invalid date %q, expected e.g. 2024-01-31 or 2024-01-31T12:00:00Z", args[1])
+			}
+		}
+	}
+
+	removed, err := h.history.Purge(room, before)
+	if err != nil {
+		return fmt.Errorf("Failed to purge #%s: %v", room, err)
+	}
+	if removed > 0 {
+		notice := fmt.Sprintf("%s purged the history of #%s", ctx.User, room)
+		if !before.IsZero() {
+			notice += " before " + args[1]
+		}
+		h.broadcastSystemMessage(room, notice)
+	}
+	return ctx.Reply(fmt.Sprintf("Removed %d messages from #%s", removed, room))
+}
+
+// Lists self-registered keys, or approves or rejects a registration
+func (h *Hub) registrations(ctx *commands.CommandContext, args []string) error {
+	if !h.isAdmin(ctx.User) {
+		return errNotAdmin
+	}
+
+	if len(args) == 2 && args[0] == "approve" {
+		ok, err := h.registeredKeys.Approve(args[1])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%s has no pending registration", args[1])
+		}
+		return ctx.Reply(args[1] + " approved")
+	}
+	if len(args) == 2 && args[0] == "reject" {
+		ok, err := h.registeredKeys.Remove(args[1])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%s is not registered", args[1])
+		}
+		return ctx.Reply("Registration of " + args[1] + " removed")
+	}
+	if len(args) != 0 {
+		return errors.New("Usage: /registrations [approve|reject <user>]")
+	}
+
+	keys := h.registeredKeys.List()
+	if len(keys) == 0 {
+		return ctx.Reply("No self-registered keys")
+	}
+	var sb strings.Builder
+	sb.WriteString("Registered keys:")
+	for _, k := range keys {
+		status := "approved"
+		if k.Pending {
+			status = "pending"
+		}
+		sb.WriteString(fmt.Sprintf("\n  %-16s %-8s %s %s", k.User, status, k.Fingerprint, k.RegisteredAt.Format(time.DateTime)))
+	}
+	return ctx.Reply(sb.String())
+}
@@ -0,0 +1,144 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"group-ssh-chat/commands"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Preference key holding the user's aliases as a JSON object mapping each
+// name to the command line it stands for
+const aliasPreference = "aliases"
+
+// Most aliases a user can define
+const maxAliases = 50
+
+// Longest alias name
+const maxAliasNameLength = 16
+
+// Parses the aliases stored in the user's preferences
+func (p Preferences) aliases() map[string]string {
+	aliases := map[string]string{}
+	if p[aliasPreference] == "" {
+		return aliases
+	}
+	if err := json.Unmarshal([]byte(p[aliasPreference]), &aliases); err != nil {
+		log.Printf("Failed to parse aliases: %v", err)
+	}
+	return aliases
+}
+
+// Replaces a user alias at the start of a command line with the command it
+// stands for, keeping the rest of the line as further arguments. Built-in
+// commands and their aliases always take precedence.
+func (h *Hub) expandAlias(user string, line string) string {
+	rest := strings.TrimPrefix(line, "/")
+	name := rest
+	if i := strings.IndexFunc(rest, unicode.IsSpace); i >= 0 {
+		name, rest = rest[:i], rest[i:]
+	} else {
+		rest = ""
+	}
+	if _, ok := h.commands.Lookup(name); ok || name == "" {
+		return line
+	}
+	expansion, ok := h.preferencesOf(user).aliases()[strings.ToLower(name)]
+	if !ok {
+		return line
+	}
+	return "/" + expansion + rest
+}
+
+// Handles /alias [<name> <command> [args]], listing the sender's aliases or
+// adding one
+func (h *Hub) alias(ctx *commands.CommandContext, args []string) error {
+	aliases := h.preferencesOf(ctx.User).aliases()
+	if len(args) == 0 {
+		if len(aliases) == 0 {
+			return ctx.Reply("You have no aliases, add one with /alias <name> <command> [args]")
+		}
+		names := make([]string, 0, len(aliases))
+		for name := range aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var sb strings.Builder
+		sb.WriteString("Your aliases:")
+		for _, name := range names {
+			sb.WriteString(fmt.Sprintf("\n  /%-16s /%s", name, aliases[name]))
+		}
+		return ctx.Reply(sb.String())
+	}
+	if len(args) < 2 {
+		return errors.New("Usage: /alias [<name> <command> [args]]")
+	}
+
+	name := strings.ToLower(strings.TrimPrefix(args[0], "/"))
+	if !validAliasName(name) {
+		return fmt.Errorf("Alias names are letters and digits, at most %d of them", maxAliasNameLength)
+	}
+	if _, ok := h.commands.Lookup(name); ok {
+		return fmt.Errorf("/%s is already a command", name)
+	}
+	target := strings.TrimPrefix(args[1], "/")
+	if _, ok := h.commands.Lookup(target); !ok {
+		return fmt.Errorf("There is no command /%s", target)
+	}
+	if _, ok := aliases[name]; !ok && len(aliases) >= maxAliases {
+		return fmt.Errorf("You can have at most %d aliases", maxAliases)
+	}
+
+	aliases[name] = strings.Join(append([]string{target}, args[2:]...), " ")
+	if err := h.saveAliases(ctx.User, aliases); err != nil {
+		return err
+	}
+	return ctx.ReplyAll("/" + name + " now runs /" + aliases[name])
+}
+
+// Handles /unalias <name>
+func (h *Hub) unalias(ctx *commands.CommandContext, args []string) error {
+	name := strings.ToLower(strings.TrimPrefix(args[0], "/"))
+	aliases := h.preferencesOf(ctx.User).aliases()
+	if _, ok := aliases[name]; !ok {
+		return fmt.Errorf("You have no alias /%s", name)
+	}
+	delete(aliases, name)
+	if err := h.saveAliases(ctx.User, aliases); err != nil {
+		return err
+	}
+	return ctx.ReplyAll("Removed alias /" + name)
+}
+
+// Persists the user's aliases
+func (h *Hub) saveAliases(user string, aliases map[string]string) error {
+	value := ""
+	if len(aliases) > 0 {
+		data, err := json.Marshal(aliases)
+		if err != nil {
+			return fmt.Errorf("Failed to save aliases: %v", err)
+		}
+		value = string(data)
+	}
+	if err := h.prefsStore.Set(user, aliasPreference, value); err != nil {
+		return fmt.Errorf("Failed to save aliases: %v", err)
+	}
+	return nil
+}
+
+// Reports whether the name can be used for an alias
+func validAliasName(name string) bool {
+	if name == "" || len(name) > maxAliasNameLength {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/archive"
+	"group-ssh-chat/commands"
+	"group-ssh-chat/storage"
+	"sort"
+	"time"
+)
+
+// Lets admins run the archival by hand and bring archived days back into
+// /search
+func (h *Hub) SetArchiver(archiver *archive.Archiver) {
+	h.archiver = archiver
+}
+
+// Handles /archive run and /archive fetch <room> <date>
+func (h *Hub) archiveCommand(ctx *commands.CommandContext, args []string) error {
+	if !h.isAdmin(ctx.User) {
+		return errNotAdmin
+	}
+	if h.archiver == nil {
+		return errors.New("Archiving is not configured")
+	}
+
+	switch {
+	case len(args) == 1 && args[0] == "run":
+		go func() {
+			archived, err := h.archiver.Run()
+			if err != nil {
+				ctx.Reply(fmt.Sprintf("Archive run finished with errors after archiving %d messages: %v", archived, err))
+				return
+			}
+			ctx.Reply(fmt.Sprintf("Archive run finished, %d messages archived", archived))
+		}()
+		return ctx.Reply("Archive run started")
+	case len(args) == 3 && args[0] == "fetch":
+		room := normalizeRoomName(args[1])
+		day, err := time.ParseInLocation(time.DateOnly, args[2], time.Local)
+		if err != nil {
+			return fmt.Errorf("Invalid date %q, expected e.g. 2024-01-31", args[2])
+		}
+		msgs, err := h.archiver.Fetch(room, day)
+		if err != nil {
+			return fmt.Errorf("Failed to fetch the archive: %v", err)
+		}
+		h.restoreArchived(room, msgs)
+		return ctx.Reply(fmt.Sprintf("Loaded %d archived messages of #%s from %s, /search now includes them", len(msgs), room, args[2]))
+	}
+	return errors.New("Usage: /archive run|fetch <room> <date>")
+}
+
+// Keeps fetched archived messages of a room for /search until the server
+// restarts
+func (h *Hub) restoreArchived(room string, msgs []storage.StoredMessage) {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	seen := map[int64]bool{}
+	for _, msg := range h.archived[room] {
+		seen[msg.ID] = true
+	}
+	for _, msg := range msgs {
+		if !seen[msg.ID] {
+			h.archived[room] = append(h.archived[room], msg)
+			seen[msg.ID] = true
+		}
+	}
+	sort.Slice(h.archived[room], func(i, j int) bool { return h.archived[room][i].ID < h.archived[room][j].ID })
+}
+
+// Returns the fetched archived messages of the room whose text matches,
+// oldest first
+func (h *Hub) searchArchived(room string, match func(text string) bool) []storage.StoredMessage {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	var msgs []storage.StoredMessage
+	for _, msg := range h.archived[room] {
+		if match(msg.Text) {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
@@ -0,0 +1,170 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/commands"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A built-in participant such as the trivia bot. Bots see every chat message
+// and act through the hub's bot methods: they can register commands, talk in
+// rooms and send notices.
+type Bot interface {
+	// Called after a chat message was sent to a room, including messages of
+	// bots. Must not block.
+	OnMessage(msg Message)
+}
+
+// Implemented by bots that also want to know when users join or leave rooms
+type PresenceWatcher interface {
+	// Called after a user joined or left a room. Must not block.
+	OnPresence(room string, user string, joined bool)
+}
+
+// Inspects a chat message before it is sent to a room. The message has no
+// ID yet. Returns the text to send, possibly rewritten, or an error telling
+// the sender why the message was blocked.
+type MessageFilter func(msg Message) (string, error)
+
+// Adds a filter that every chat message typed or posted to a room passes
+// through from now on, after the built-in checks
+func (h *Hub) AddMessageFilter(filter MessageFilter) {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	h.filters = append(h.filters, filter)
+}
+
+// Runs the message through the filters and returns the text to send
+func (h *Hub) filterMessage(room string, from string, text string) (string, error) {
+	h.activeClientsMutex.Lock()
+	filters := h.filters
+	h.activeClientsMutex.Unlock()
+
+	msg := Message{Type: ChatMessage, From: from, Room: room, Time: time.Now(), Text: text}
+	for _, filter := range filters {
+		var err error
+		if msg.Text, err = filter(msg); err != nil {
+			return "", err
+		}
+	}
+	text = msg.Text
+	if strings.TrimSpace(text) == "" {
+		return "", errors.New("Your message was removed by a filter")
+	}
+	return text, nil
+}
+
+// Adds a bot that is told about every chat message from now on
+func (h *Hub) AddBot(bot Bot) {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	h.bots = append(h.bots, bot)
+}
+
+// Registers a command for bots and other extensions. Must be called before
+// the server accepts connections.
+func (h *Hub) RegisterCommand(cmd commands.Command) {
+	h.commands.Register(cmd)
+}
+
+// Returns the room the user is currently in
+func (h *Hub) RoomOf(user string) string {
+	return h.roomOf(user)
+}
+
+// Returns the users currently in the room on any node, sorted by name
+func (h *Hub) UsersIn(room string) []string {
+	users := h.sessions.usersIn(room)
+	online := map[string]bool{}
+	for _, user := range h.sessions.users() {
+		online[user] = true
+	}
+	h.activeClientsMutex.Lock()
+	for _, user := range h.remoteUsersInLocked(room) {
+		if !online[user] {
+			users = append(users, user)
+		}
+	}
+	h.activeClientsMutex.Unlock()
+	sort.Strings(users)
+	return users
+}
+
+// Posts a chat message from a bot to a room. The message is stored in the
+// history like any other.
+func (h *Hub) Say(from string, room string, text string) error {
+	h.activeClientsMutex.Lock()
+	err := h.checkRoomOpenLocked(room)
+	h.activeClientsMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	h.broadcastMessage(room, from, text, nil)
+	return nil
+}
+
+// Sends a system notice to everyone in the room
+func (h *Hub) Notice(room string, text string) {
+	h.broadcastSystemMessage(room, text)
+}
+
+// Sends a system notice to every session of the user
+func (h *Hub) Notify(user string, text string) {
+	h.replySystem(user, sanitizeInput(text))
+}
+
+// Mutes a user in a room on behalf of a bot, for the duration or until
+// unmuted when it is zero. Room ops cannot be muted.
+func (h *Hub) Mute(by string, name string, user string, d time.Duration) error {
+	h.activeClientsMutex.Lock()
+	room, exists := h.rooms[name]
+	if !exists {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("#%s does not exist", name)
+	}
+	if room.isOp(user, h.isAdminLocked(user)) {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("%s is an op of #%s and cannot be muted", user, name)
+	}
+	var until time.Time
+	if d > 0 {
+		until = time.Now().Add(d)
+	}
+	room.Muted[user] = until
+	h.saveRoomLocked(room)
+	h.activeClientsMutex.Unlock()
+
+	notice := fmt.Sprintf("%s was muted in #%s by %s", user, name, by)
+	if d > 0 {
+		notice += " for " + d.String()
+	}
+	h.broadcastSystemMessage(name, notice)
+	return nil
+}
+
+// Tells the bots about a chat message
+func (h *Hub) notifyBots(msg Message) {
+	h.activeClientsMutex.Lock()
+	bots := h.bots
+	h.activeClientsMutex.Unlock()
+
+	for _, bot := range bots {
+		bot.OnMessage(msg)
+	}
+}
+
+// Tells the bots watching presence about a user joining or leaving a room
+func (h *Hub) notifyBotsPresence(room string, user string, joined bool) {
+	h.activeClientsMutex.Lock()
+	bots := h.bots
+	h.activeClientsMutex.Unlock()
+
+	for _, bot := range bots {
+		if watcher, ok := bot.(PresenceWatcher); ok {
+			watcher.OnPresence(room, user, joined)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package chat
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// How often a node sends the list of its users to the others
+	rosterInterval = 10 * time.Second
+
+	// Nodes not heard from for this long are considered gone
+	rosterExpiry = 3 * rosterInterval
+)
+
+// Carries events between the hubs of a cluster so users connected to
+// different nodes can talk to each other. Implementations deliver every
+// published payload to the subscribers of all nodes, including the
+// publishing one.
+type Backplane interface {
+	Publish(payload []byte) error
+
+	// Calls handle for every payload published by any node until the
+	// backplane is closed
+	Subscribe(handle func(payload []byte)) error
+}
+
+// Types of events sent over the backplane
+const (
+	clusterMessage  = "message"
+	clusterNotice   = "notice"
+	clusterPresence = "presence"
+	clusterWhisper  = "whisper"
+	clusterRoster   = "roster"
+)
+
+// An event sent over the backplane
+type clusterEvent struct {
+	Node    string            `json:"node"`
+	Type    string            `json:"type"`
+	Room    string            `json:"room,omitempty"`
+	User    string            `json:"user,omitempty"`
+	Joined  bool              `json:"joined,omitempty"`
+	Message *Message          `json:"message,omitempty"`
+	Roster  map[string]string `json:"roster,omitempty"`
+}
+
+// The users connected to another node, mapped to their current room
+type remoteRoster struct {
+	users map[string]string
+	seen  time.Time
+}
+
+// Connects the hub to the other nodes of a cluster. Messages, notices,
+// presence and whispers are shared through the backplane from now on. Must
+// be called before the server accepts connections.
+func (h *Hub) JoinCluster(bp Backplane) {
+	h.backplane = bp
+	h.nodeID = uuid.New().String()
+	go func() {
+		if err := bp.Subscribe(h.handleClusterEvent); err != nil {
+			log.Printf("Cluster subscription ended: %v", err)
+		}
+	}()
+	go func() {
+		for {
+			h.publishRoster()
+			time.Sleep(rosterInterval)
+		}
+	}()
+}
+
+// Sends an event to the other nodes when the hub is part of a cluster
+func (h *Hub) publish(ev clusterEvent) {
+	if h.backplane == nil {
+		return
+	}
+	ev.Node = h.nodeID
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("Failed to encode cluster event: %v", err)
+		return
+	}
+	if err := h.backplane.Publish(payload); err != nil {
+		log.Printf("Failed to publish cluster event: %v", err)
+	}
+}
+
+// Sends the users of this node and their rooms to the other nodes
+func (h *Hub) publishRoster() {
+	h.activeClientsMutex.Lock()
+	roster := make(map[string]string, len(h.userRooms))
+	for user, room := range h.userRooms {
+		roster[user] = room
+	}
+	h.activeClientsMutex.Unlock()
+
+	h.publish(clusterEvent{Type: clusterRoster, Roster: roster})
+}
+
+// Applies an event published by another node to the local sessions
+func (h *Hub) handleClusterEvent(payload []byte) {
+	var ev clusterEvent
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		log.Printf("Ignoring malformed cluster event: %v", err)
+		return
+	}
+	if ev.Node == h.nodeID {
+		return
+	}
+
+	switch ev.Type {
+	case clusterMessage:
+		if ev.Message != nil {
+			h.deliverMessage(*ev.Message)
+		}
+	case clusterNotice:
+		if ev.Message != nil {
+			h.deliverNotice(*ev.Message)
+		}
+	case clusterPresence:
+		h.updateRemoteRoster(ev.Node, func(users map[string]string) {
+			if ev.Joined {
+				users[ev.User] = ev.Room
+			} else if users[ev.User] == ev.Room {
+				delete(users, ev.User)
+			}
+		})
+		h.deliverPresence(ev.Room, ev.User, ev.Joined)
+	case clusterWhisper:
+		if ev.Message != nil {
+			h.deliverWhisper(*ev.Message)
+		}
+	case clusterRoster:
+		h.updateRemoteRoster(ev.Node, func(users map[string]string) {
+			for user := range users {
+				delete(users, user)
+			}
+			for user, room := range ev.Roster {
+				users[user] = room
+			}
+		})
+	}
+}
+
+// Changes the known users of another node and forgets nodes that went quiet
+func (h *Hub) updateRemoteRoster(node string, update func(users map[string]string)) {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	now := time.Now()
+	roster, ok := h.remoteRosters[node]
+	if !ok {
+		roster = &remoteRoster{users: map[string]string{}}
+		h.remoteRosters[node] = roster
+	}
+	roster.seen = now
+	update(roster.users)
+
+	for id, r := range h.remoteRosters {
+		if now.Sub(r.seen) > rosterExpiry {
+			delete(h.remoteRosters, id)
+		}
+	}
+}
+
+// Returns the users in the room connected to other nodes. Must be called
+// with activeClientsMutex held.
+func (h *Hub) remoteUsersInLocked(room string) []string {
+	var users []string
+	for _, roster := range h.remoteRosters {
+		if time.Since(roster.seen) > rosterExpiry {
+			continue
+		}
+		for user, in := range roster.users {
+			if in == room {
+				users = append(users, user)
+			}
+		}
+	}
+	return users
+}
+
+// Reports whether the user is connected to another node. Must be called
+// with activeClientsMutex held.
+func (h *Hub) onlineRemotelyLocked(user string) bool {
+	for _, roster := range h.remoteRosters {
+		if _, ok := roster.users[user]; ok && time.Since(roster.seen) <= rosterExpiry {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,738 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/commands"
+	"group-ssh-chat/i18n"
+	"group-ssh-chat/storage"
+	"group-ssh-chat/ui"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Height assumed for clients that do not report theirs
+const defaultTerminalHeight = 24
+
+// Returns the context of a command typed in the session
+func (h *Hub) commandContext(sess *Session) *commands.CommandContext {
+	ctx := &commands.CommandContext{
+		User:      sess.User,
+		SessionID: sess.ID,
+		Room:      h.roomOf(sess.User),
+		Caps:      ui.DefaultCapabilities,
+		Height:    defaultTerminalHeight,
+		Write:     sess.client.WriteSystem,
+		WriteAll:  func(text string) { h.replySystem(sess.User, text) },
+	}
+	if reporter, ok := sess.client.(CapabilityReporter); ok {
+		ctx.Caps = reporter.Capabilities()
+	}
+	if reporter, ok := sess.client.(HeightReporter); ok {
+		ctx.Height = reporter.Height()
+	}
+	return ctx
+}
+
+// Returns the session a command was typed in, or nil if it has closed since
+func (h *Hub) invokingSession(ctx *commands.CommandContext) *Session {
+	for _, s := range h.userSessions(ctx.User) {
+		if s.ID == ctx.SessionID {
+			return s
+		}
+	}
+	return nil
+}
+
+// Registers the built-in slash commands with the hub's command manager
+func (h *Hub) registerCommands() {
+	h.commands.SetRoleResolver(h.roleOf)
+
+	h.commands.Register(commands.Command{
+		Name:        "help",
+		Description: "List the commands you can run, or explain one",
+		Category:    commands.Account,
+		Args:        []commands.Arg{{Name: "command|page", Optional: true}},
+		Examples:    []string{"/help whisper", "/help 2"},
+		Handler:     h.help,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "whisper",
+		Description: "Send a private message to a user, queued if they are offline",
+		Category:    commands.Chat,
+		Examples:    []string{`/whisper bob see you at five`, `/w bob "quoted" text is sent as typed`},
+		Args:        []commands.Arg{{Name: "user"}, {Name: "message", Type: commands.Text}},
+		Aliases:     []string{"w"},
+		Handler: func(ctx *commands.CommandContext, args []string) error {
+			to, text := args[0], args[1]
+			if err := h.checkMessageLength(text); err != nil {
+				return err
+			}
+			if err := h.checkBannedWords(text); err != nil {
+				return err
+			}
+			h.activeClientsMutex.Lock()
+			local := h.sessions.has(to)
+			remote := h.onlineRemotelyLocked(to)
+			h.activeClientsMutex.Unlock()
+			if !local && !remote {
+				return h.queueWhisper(ctx, to, text)
+			}
+
+			msg := Message{Type: WhisperMessage, From: ctx.User, To: to, Time: time.Now(), Text: text}
+			h.deliverWhisper(msg)
+			if remote {
+				h.publish(clusterEvent{Type: clusterWhisper, Message: &msg})
+			}
+			if to != ctx.User {
+				h.Deliver(ToUser(ctx.User), msg)
+			}
+			return nil
+		},
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "join",
+		Description: "Switch to a room, creating it if needed",
+		Category:    commands.Rooms,
+		Examples:    []string{`/join dev`},
+		Args:        []commands.Arg{{Name: "room"}},
+		Aliases:     []string{"j"},
+		Handler: func(ctx *commands.CommandContext, args []string) error {
+			room := normalizeRoomName(args[0])
+			if room == "" {
+				return errors.New("Room name cannot be empty")
+			}
+
+			h.activeClientsMutex.Lock()
+			previous := h.userRooms[ctx.User]
+			err := h.enterRoomLocked(ctx.User, room)
+			h.activeClientsMutex.Unlock()
+			if err != nil {
+				return err
+			}
+
+			if previous == room {
+				return i18n.Errorf("You are already in #%s", room)
+			}
+			h.broadcastPresence(previous, ctx.User, false)
+			h.broadcastPresence(room, ctx.User, true)
+			sessions := h.userSessions(ctx.User)
+			for _, s := range sessions {
+				s.client.WriteSystem(h.tr(s, "You joined #%s", room))
+			}
+			h.introduceRoom(sessions, room)
+			h.showUnread(ctx.User, room, sessions)
+			return nil
+		},
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "rooms",
+		Usage:       "/rooms",
+		Description: "List public rooms, most active first",
+		Category:    commands.Rooms,
+		Handler:     h.listRooms,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "users",
+		Usage:       "/users",
+		Description: "List users in your current room",
+		Category:    commands.Rooms,
+		Handler: func(ctx *commands.CommandContext, args []string) error {
+			room := ctx.Room
+			users := h.UsersIn(room)
+			if s := h.invokingSession(ctx); s != nil {
+				s.client.WriteUserList(room, users)
+			}
+			return nil
+		},
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "clear",
+		Usage:       "/clear",
+		Description: "Clear your screen",
+		Category:    commands.Account,
+		Handler: func(ctx *commands.CommandContext, args []string) error {
+			if s := h.invokingSession(ctx); s != nil {
+				s.client.Clear()
+			}
+			return nil
+		},
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "set",
+		Usage:       "/set [<setting> <value>]",
+		Description: "Show or change your preferences",
+		Category:    commands.Account,
+		Examples:    []string{`/set timestamps off`, `/set tz Europe/Berlin`},
+		Handler: func(ctx *commands.CommandContext, args []string) error {
+			if len(args) == 0 {
+				prefs := h.preferencesOf(ctx.User)
+				var sb strings.Builder
+				sb.WriteString("Your settings:")
+				for _, s := range settings {
+					sb.WriteString(fmt.Sprintf("\n  %-12s %-12s %s (%s)", s.name, prefs.Get(s.name), s.description, s.choices()))
+				}
+				return ctx.Reply(sb.String())
+			}
+			if len(args) != 2 {
+				return errors.New("Usage: /set [<setting> <value>]")
+			}
+
+			key := strings.ToLower(args[0])
+			return h.setPreference(ctx.User, key, normalizeSetting(key, args[1]))
+		},
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "theme",
+		Description: "Show or change your color theme",
+		Category:    commands.Account,
+		Args:        []commands.Arg{{Name: "name", Optional: true}},
+		Handler: func(ctx *commands.CommandContext, args []string) error {
+			if len(args) == 0 {
+				current := h.preferencesOf(ctx.User).Get("theme")
+				return ctx.Reply(fmt.Sprintf("Current theme: %s. Available: %s", current, strings.Join(ui.ThemeNames(), ", ")))
+			}
+			return h.setPreference(ctx.User, "theme", strings.ToLower(args[0]))
+		},
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "edit",
+		Description: "Correct one of your recent messages",
+		Category:    commands.Chat,
+		Examples:    []string{`/edit 42 fixed the typo`},
+		Args:        []commands.Arg{{Name: "id"}, {Name: "new text", Type: commands.Text}},
+		Handler: func(ctx *commands.CommandContext, args []string) error {
+			msg, err := h.ownRecentMessage(ctx.User, args[0])
+			if err != nil {
+				return err
+			}
+			// An edit is held to the same rules as a new message in the room.
+			if err := h.checkNotMuted(ctx.User, msg.Room); err != nil {
+				return err
+			}
+			if err := h.checkMessageLength(args[1]); err != nil {
+				return err
+			}
+			if err := h.checkBannedWords(args[1]); err != nil {
+				return err
+			}
+			if err := h.checkCanPost(ctx.User, msg.Room); err != nil {
+				return err
+			}
+			text, err := h.filterMessage(msg.Room, ctx.User, args[1])
+			if err != nil {
+				return err
+			}
+
+			msg, err = h.history.Edit(msg.ID, text)
+			if err != nil {
+				return err
+			}
+			h.broadcastSystemMessage(msg.Room, fmt.Sprintf("%s edited [%d]: %s", ctx.User, msg.ID, msg.Text))
+			return nil
+		},
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "delete",
+		Description: "Delete one of your recent messages",
+		Category:    commands.Chat,
+		Args:        []commands.Arg{{Name: "id"}},
+		Handler: func(ctx *commands.CommandContext, args []string) error {
+			msg, err := h.ownRecentMessage(ctx.User, args[0])
+			if err != nil {
+				return err
+			}
+
+			if err := h.history.Delete(msg.ID); err != nil {
+				return err
+			}
+			h.broadcastSystemMessage(msg.Room, fmt.Sprintf("%s deleted [%d]", ctx.User, msg.ID))
+			return nil
+		},
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "reply",
+		Description: "Reply to a message in your current room",
+		Category:    commands.Chat,
+		Examples:    []string{`/reply 42 sounds good`},
+		Args:        []commands.Arg{{Name: "id"}, {Name: "message", Type: commands.Text}},
+		Handler: func(ctx *commands.CommandContext, args []string) error {
+			id, err := parseMessageID(args[0])
+			if err != nil {
+				return err
+			}
+			parent, err := h.history.Get(id)
+			room := ctx.Room
+			if err != nil || parent.Room != room {
+				return fmt.Errorf("Message [%d] not found in #%s", id, room)
+			}
+			if err := h.checkPost(ctx.User, room, args[1]); err != nil {
+				return err
+			}
+			text, err := h.filterMessage(room, ctx.User, args[1])
+			if err != nil {
+				return err
+			}
+
+			h.broadcastMessage(room, ctx.User, text, &Quote{ID: parent.ID, From: parent.From, Text: parent.Text})
+			return nil
+		},
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "thread",
+		Description: "Show a message together with all replies to it",
+		Category:    commands.Chat,
+		Args:        []commands.Arg{{Name: "id"}},
+		Handler: func(ctx *commands.CommandContext, args []string) error {
+			id, err := parseMessageID(args[0])
+			if err != nil {
+				return err
+			}
+			thread, err := h.history.Thread(id)
+			if err != nil || len(thread) == 0 || thread[0].Room != ctx.Room {
+				return fmt.Errorf("Message [%d] not found in your current room", id)
+			}
+
+			if s := h.invokingSession(ctx); s != nil {
+				s.client.WriteHistory(fmt.Sprintf("Thread [%d] (%d messages)", thread[0].ID, len(thread)), thread)
+			}
+			return nil
+		},
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "markread",
+		Usage:       "/markread",
+		Description: "Mark all messages in every room as read",
+		Category:    commands.Chat,
+		Handler: func(ctx *commands.CommandContext, args []string) error {
+			h.markAllRead(ctx.User)
+			return ctx.ReplyAll("All rooms marked as read")
+		},
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "search",
+		Usage:       "/search [-r] <query> [#room]",
+		Description: "Search the room history, -r for a regular expression",
+		Category:    commands.Chat,
+		Examples:    []string{`/search deploy`, `/search -r "fail(ed|ure)" #ops`},
+		Handler:     h.search,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "more",
+		Usage:       "/more",
+		Description: "Show the next page of search results",
+		Category:    commands.Chat,
+		Handler: func(ctx *commands.CommandContext, args []string) error {
+			return h.showSearchPage(ctx)
+		},
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "remind",
+		Usage:       "/remind [@user] <duration> <message>",
+		Description: "Schedule a reminder for yourself or someone else",
+		Category:    commands.Chat,
+		Examples:    []string{`/remind 10m stand-up`, `/remind @bob 1h review the PR`},
+		MaxArgs:     3,
+		Handler:     h.remind,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "blocked",
+		Usage:       "/blocked [clear <ip>|all]",
+		Description: "Admin: list or clear IPs blocked for failed logins",
+		Category:    commands.Server,
+		Permission:  commands.Admin,
+		Handler:     h.blocked,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "2fa",
+		Usage:       "/2fa setup|confirm|disable|status",
+		Description: "Manage two-factor authentication for your logins",
+		Category:    commands.Account,
+		Handler:     h.twoFactor,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "registrations",
+		Usage:       "/registrations [approve|reject <user>]",
+		Description: "Admin: list, approve or reject self-registered keys",
+		Category:    commands.Server,
+		Permission:  commands.Admin,
+		Handler:     h.registrations,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "private",
+		Usage:       "/private on|off",
+		Description: "Make your current room invite-only (room op)",
+		Category:    commands.Moderation,
+		Permission:  commands.RoomOp,
+		Handler:     h.setPrivate,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "readonly",
+		Usage:       "/readonly [on|off]",
+		Description: "Show or set whether only ops can post in your current room (room op)",
+		Category:    commands.Moderation,
+		Handler:     h.setReadOnly,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "slowmode",
+		Usage:       "/slowmode [<seconds>|off]",
+		Description: "Show or set how often members can post in your current room (room op)",
+		Category:    commands.Moderation,
+		Handler:     h.setSlowMode,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "invite",
+		Description: "Invite a user to your current private room",
+		Category:    commands.Moderation,
+		Args:        []commands.Arg{{Name: "user"}},
+		Permission:  commands.RoomOp,
+		Handler:     h.invite,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "uninvite",
+		Description: "Revoke a user's access to your current private room",
+		Category:    commands.Moderation,
+		Args:        []commands.Arg{{Name: "user"}},
+		Permission:  commands.RoomOp,
+		Handler:     h.uninvite,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "op",
+		Description: "Make a user an op of your current room (room op)",
+		Category:    commands.Moderation,
+		Args:        []commands.Arg{{Name: "user"}},
+		Permission:  commands.RoomOp,
+		Handler:     h.setOp(true),
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "deop",
+		Description: "Remove a user's op status in your current room (room op)",
+		Category:    commands.Moderation,
+		Args:        []commands.Arg{{Name: "user"}},
+		Permission:  commands.RoomOp,
+		Handler:     h.setOp(false),
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "kick",
+		Description: "Move a user out of your current room (room op)",
+		Category:    commands.Moderation,
+		Examples:    []string{`/kick bob please stay on topic`},
+		Args:        []commands.Arg{{Name: "user"}, {Name: "reason", Type: commands.Text, Optional: true}},
+		Permission:  commands.RoomOp,
+		Handler:     h.kick,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "mute",
+		Description: "Stop a user from talking in your current room (room op)",
+		Category:    commands.Moderation,
+		Examples:    []string{`/mute bob 10m`},
+		Args:        []commands.Arg{{Name: "user"}, {Name: "duration", Optional: true}},
+		Permission:  commands.RoomOp,
+		Handler:     h.mute,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "unmute",
+		Description: "Let a muted user talk again (room op)",
+		Category:    commands.Moderation,
+		Args:        []commands.Arg{{Name: "user"}},
+		Permission:  commands.RoomOp,
+		Handler:     h.unmute,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "topic",
+		Usage:       "/topic [<text>|-]",
+		Description: "Show the room topic, or set or clear it (room op)",
+		Category:    commands.Rooms,
+		Examples:    []string{`/topic Release on Friday`, `/topic -`},
+		MaxArgs:     1,
+		Handler:     h.topic,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "room",
+		Usage:       "/room restore [<name>]",
+		Description: "Bring back an archived room, or list those you can restore (room op)",
+		Category:    commands.Rooms,
+		Handler:     h.room,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "welcome",
+		Usage:       "/welcome [<text>|-]",
+		Description: "Show the message users see when joining the room, or set or clear it (room op)",
+		Category:    commands.Rooms,
+		MaxArgs:     1,
+		Handler:     h.welcome,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "ignore",
+		Usage:       "/ignore [list|<user>]",
+		Description: "Hide a user's messages, whispers and join/leave notices",
+		Category:    commands.Account,
+		Handler:     h.ignore,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "unignore",
+		Description: "Stop ignoring a user",
+		Category:    commands.Account,
+		Args:        []commands.Arg{{Name: "user"}},
+		Handler:     h.unignore,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "alias",
+		Usage:       "/alias [<name> <command> [args]]",
+		Description: "List your aliases or add one, e.g. /alias b whisper bob",
+		Category:    commands.Account,
+		Examples:    []string{`/alias b whisper bob`, `/b lunch?`},
+		MaxArgs:     3,
+		Handler:     h.alias,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "unalias",
+		Description: "Remove one of your aliases",
+		Category:    commands.Account,
+		Args:        []commands.Arg{{Name: "name"}},
+		Handler:     h.unalias,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "inbox",
+		Usage:       "/inbox [clear]",
+		Description: "Review or clear whispers sent while you were away",
+		Category:    commands.Chat,
+		Handler:     h.showInbox,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "stats",
+		Usage:       "/stats",
+		Description: "Show server uptime, users and message counts",
+		Category:    commands.Server,
+		Handler:     h.showStats,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "debug",
+		Usage:       "/debug",
+		Description: "Show runtime statistics and internal sizes (admin only)",
+		Category:    commands.Server,
+		Permission:  commands.Admin,
+		Handler:     h.debug,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "resync",
+		Usage:       "/resync",
+		Description: "Replay messages your client missed while it was lagging",
+		Category:    commands.Account,
+		Handler:     h.resync,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "paste",
+		Usage:       "/paste",
+		Description: "Write a multi-line message, ended by a line with only \".\"",
+		Category:    commands.Chat,
+		Handler:     h.paste,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "ping",
+		Usage:       "/ping",
+		Description: "Measure the network round trip and server delay",
+		Category:    commands.Account,
+		Handler:     h.pingUsage,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "links",
+		Usage:       "/links",
+		Description: "List links recently shared in your current room",
+		Category:    commands.Chat,
+		Handler:     h.links,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "gif",
+		Description: "Post a GIF matching the query",
+		Category:    commands.Fun,
+		Args:        []commands.Arg{{Name: "query", Type: commands.Text}},
+		Handler:     h.gif,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "poll",
+		Usage:       "/poll [\"question\" option1 option2 ...|close]",
+		Description: "Start, show or close a poll in your current room",
+		Category:    commands.Fun,
+		Examples:    []string{`/poll "Lunch today?" pizza "thai food" sushi`},
+		Handler:     h.startPoll,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "vote",
+		Description: "Vote in the open poll of your current room",
+		Category:    commands.Fun,
+		Args:        []commands.Arg{{Name: "number", Type: commands.Number}},
+		Handler:     h.vote,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "roll",
+		Usage:       "/roll [dice]",
+		Description: "Roll dice such as 2d6 or 1d20+3 for the room",
+		Category:    commands.Fun,
+		Examples:    []string{`/roll 2d6`},
+		Handler:     h.roll,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "flip",
+		Usage:       "/flip",
+		Description: "Flip a coin for the room",
+		Category:    commands.Fun,
+		Handler:     h.flip,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "choose",
+		Usage:       "/choose a|b|c",
+		Description: "Let the server pick one of the choices",
+		Category:    commands.Fun,
+		Examples:    []string{`/choose tea|coffee`},
+		MaxArgs:     1,
+		Handler:     h.choose,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "react",
+		Description: "React to a message, again to take the reaction back",
+		Category:    commands.Chat,
+		Examples:    []string{`/react 42 👍`},
+		Args:        []commands.Arg{{Name: "id"}, {Name: "emoji"}},
+		Handler:     h.react,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "karma",
+		Description: "Show the karma leaderboard or a user's karma",
+		Category:    commands.Fun,
+		Args:        []commands.Arg{{Name: "user", Optional: true}},
+		Handler:     h.karma,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "presence",
+		Usage:       "/presence [all|batch|off]",
+		Description: "Show or change how join and leave notices are sent in the room",
+		Category:    commands.Rooms,
+		Handler:     h.setRoomPresence,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "dnd",
+		Usage:       "/dnd [duration|off]",
+		Description: "Only receive mentions and whispers for a while (default 1h)",
+		Category:    commands.Account,
+		Examples:    []string{`/dnd 30m`},
+		Handler:     h.setDND,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "purge",
+		Usage:       "/purge <room> [before-date]",
+		Description: "Remove a room's history, or the part before a date (admin only)",
+		Category:    commands.Moderation,
+		Examples:    []string{`/purge #dev 2024-01-31`},
+		Permission:  commands.Admin,
+		Handler:     h.purge,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "archive",
+		Usage:       "/archive run|fetch <room> <date>",
+		Description: "Archive old messages now, or load an archived day into /search (admin only)",
+		Category:    commands.Server,
+		Permission:  commands.Admin,
+		Handler:     h.archiveCommand,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "userdata",
+		Usage:       "/userdata export|delete <user>",
+		Description: "Export or delete everything stored about a user (admin only)",
+		Category:    commands.Server,
+		Permission:  commands.Admin,
+		Handler:     h.userData,
+	})
+
+	h.commands.Register(commands.Command{
+		Name:        "link",
+		Usage:       "/link [provider]|status|cancel|remove",
+		Description: "Link your key to a GitHub or Google account to show your name",
+		Category:    commands.Account,
+		Handler:     h.link,
+	})
+}
+
+// Parses a message ID as shown in the chat, e.g. "42" or "[42]"
+func parseMessageID(arg string) (int64, error) {
+	id, err := strconv.ParseInt(strings.Trim(arg, "[]#"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("Invalid message id %q", arg)
+	}
+	return id, nil
+}
+
+// Looks up a message the sender may still edit or delete
+func (h *Hub) ownRecentMessage(sender string, arg string) (storage.StoredMessage, error) {
+	id, err := parseMessageID(arg)
+	if err != nil {
+		return storage.StoredMessage{}, err
+	}
+	msg, err := h.history.Get(id)
+	if err != nil {
+		return storage.StoredMessage{}, fmt.Errorf("Message [%d] not found", id)
+	}
+	if msg.From != sender {
+		return storage.StoredMessage{}, errors.New("You can only change your own messages")
+	}
+	if window := h.editWindow(); time.Since(msg.Time) > window {
+		return storage.StoredMessage{}, fmt.Errorf("Messages can only be changed within %s of sending", window)
+	}
+	return msg, nil
+}
@@ -0,0 +1,149 @@
+package chat
+
+import (
+	"group-ssh-chat/i18n"
+	"group-ssh-chat/retention"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Hub settings read from the environment that can change on a config reload.
+// Guarded by the hub's activeClientsMutex.
+type hubConfig struct {
+	admins             map[string]bool
+	motd               string
+	bannedWords        []string
+	editWindow         time.Duration
+	maxSessionsPerUser int
+	presenceWindow     time.Duration
+	resumeGrace        time.Duration
+	guestPosting       string
+	guestSlowmode      time.Duration
+	maxMessageLength   int
+	defaultLanguage    string
+	defaultRoom        string
+	autoRooms          []string
+	roomArchiveAfter   time.Duration
+	keepaliveInterval  time.Duration
+}
+
+// Reads the hub settings from ADMIN_USERS, MOTD, BANNED_WORDS, EDIT_WINDOW,
+// MAX_SESSIONS_PER_USER, PRESENCE_BATCH_WINDOW, RESUME_GRACE, GUEST_POSTING,
+// GUEST_SLOWMODE, MAX_MESSAGE_LENGTH, DEFAULT_LANGUAGE, DEFAULT_ROOM,
+// AUTO_ROOMS, ROOM_ARCHIVE_AFTER and SESSION_KEEPALIVE
+func loadHubConfig() hubConfig {
+	cfg := hubConfig{
+		admins:            loadAdmins(),
+		motd:              os.Getenv("MOTD"),
+		editWindow:        5 * time.Minute,
+		presenceWindow:    time.Minute,
+		resumeGrace:       2 * time.Minute,
+		guestPosting:      guestReadOnly,
+		guestSlowmode:     30 * time.Second,
+		maxMessageLength:  defaultMaxMessageLength,
+		defaultLanguage:   i18n.English,
+		defaultRoom:       DefaultRoom,
+		keepaliveInterval: defaultKeepaliveInterval,
+	}
+	for _, word := range strings.Split(os.Getenv("BANNED_WORDS"), ",") {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			cfg.bannedWords = append(cfg.bannedWords, word)
+		}
+	}
+	if d, err := time.ParseDuration(os.Getenv("EDIT_WINDOW")); err == nil {
+		cfg.editWindow = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("MAX_SESSIONS_PER_USER")); err == nil {
+		cfg.maxSessionsPerUser = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("PRESENCE_BATCH_WINDOW")); err == nil && d > 0 {
+		cfg.presenceWindow = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("RESUME_GRACE")); err == nil && d >= 0 {
+		cfg.resumeGrace = d
+	}
+	if os.Getenv("GUEST_POSTING") == guestSlow {
+		cfg.guestPosting = guestSlow
+	}
+	if d, err := time.ParseDuration(os.Getenv("GUEST_SLOWMODE")); err == nil && d > 0 {
+		cfg.guestSlowmode = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("MAX_MESSAGE_LENGTH")); err == nil && n > 0 {
+		cfg.maxMessageLength = n
+	}
+	if lang := strings.ToLower(os.Getenv("DEFAULT_LANGUAGE")); lang != "" {
+		if i18n.Supported(lang) {
+			cfg.defaultLanguage = lang
+		} else {
+			log.Printf("Unknown DEFAULT_LANGUAGE %q, using %s", lang, i18n.English)
+		}
+	}
+	if room := normalizeRoomName(os.Getenv("DEFAULT_ROOM")); room != "" {
+		cfg.defaultRoom = room
+	}
+	for _, room := range strings.Split(os.Getenv("AUTO_ROOMS"), ",") {
+		if room = normalizeRoomName(room); room != "" {
+			cfg.autoRooms = append(cfg.autoRooms, room)
+		}
+	}
+	if d, err := retention.ParseAge(os.Getenv("ROOM_ARCHIVE_AFTER")); err == nil && d > 0 {
+		cfg.roomArchiveAfter = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("SESSION_KEEPALIVE")); err == nil && d >= 0 {
+		cfg.keepaliveInterval = d
+	}
+	return cfg
+}
+
+// Returns the most characters a message may have
+func (h *Hub) MaxMessageLength() int {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	return h.config.maxMessageLength
+}
+
+// Returns an error when the text is longer than MAX_MESSAGE_LENGTH
+func (h *Hub) checkMessageLength(text string) error {
+	limit := h.MaxMessageLength()
+	if n := utf8.RuneCountInString(text); n > limit {
+		return i18n.Errorf("Your message was not sent because it is %d characters long, the limit is %d", n, limit)
+	}
+	return nil
+}
+
+// Returns an error when the text contains a banned word
+func (h *Hub) checkBannedWords(text string) error {
+	h.activeClientsMutex.Lock()
+	banned := h.config.bannedWords
+	h.activeClientsMutex.Unlock()
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r == '\'' || r == '-' || r == '_' || 'a' <= r && r <= 'z' || '0' <= r && r <= '9' || r > 127)
+	})
+	for _, word := range words {
+		for _, b := range banned {
+			if word == b {
+				return i18n.Errorf("Your message was not sent because it contains a banned word (%s)", b)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the message of the day
+func (h *Hub) motd() string {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	return h.config.motd
+}
+
+// Returns how long after sending a message it may still be edited or deleted
+func (h *Hub) editWindow() time.Duration {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	return h.config.editWindow
+}
@@ -0,0 +1,135 @@
+package chat
+
+import (
+	"fmt"
+	"group-ssh-chat/storage"
+	"log"
+	"sort"
+	"time"
+)
+
+// Describes an open session for the admin console
+type SessionInfo struct {
+	ID          string
+	User        string
+	RemoteAddr  string
+	Room        string
+	ConnectedAt time.Time
+}
+
+// Returns all open sessions sorted by user and connection time
+func (h *Hub) Sessions() []SessionInfo {
+	sessions := h.sessions.all()
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		infos = append(infos, SessionInfo{
+			ID:          s.ID,
+			User:        s.User,
+			RemoteAddr:  s.RemoteAddr,
+			Room:        h.userRooms[s.User],
+			ConnectedAt: s.ConnectedAt,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].User != infos[j].User {
+			return infos[i].User < infos[j].User
+		}
+		return infos[i].ConnectedAt.Before(infos[j].ConnectedAt)
+	})
+	return infos
+}
+
+// Describes a room for the admin dashboard
+type RoomInfo struct {
+	Name     string
+	Topic    string
+	Private  bool
+	ReadOnly bool
+	Online   int
+	Members  int
+	Archived bool
+
+	LastActivity time.Time
+}
+
+// Returns all rooms sorted by name, with the number of users currently in
+// each. Private rooms count their invited members.
+func (h *Hub) Rooms() []RoomInfo {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	online := map[string]int{}
+	for _, room := range h.userRooms {
+		online[room]++
+	}
+	infos := make([]RoomInfo, 0, len(h.rooms))
+	for name, room := range h.rooms {
+		infos = append(infos, RoomInfo{
+			Name:     name,
+			Topic:    room.Topic,
+			Private:  room.Private,
+			ReadOnly: room.ReadOnly,
+			Online:   online[name],
+			Members:  len(room.Members),
+			Archived: room.Archived,
+
+			LastActivity: room.LastActivity,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// Returns up to limit messages of the room, oldest first: the most recent
+// ones, or those after afterID when it is set
+func (h *Hub) RoomHistory(room string, afterID int64, limit int) ([]storage.StoredMessage, error) {
+	room = normalizeRoomName(room)
+	h.activeClientsMutex.Lock()
+	_, exists := h.rooms[room]
+	h.activeClientsMutex.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("#%s does not exist", room)
+	}
+
+	if afterID > 0 {
+		return h.history.Since(room, afterID, limit), nil
+	}
+	return h.history.Recent(room, limit), nil
+}
+
+// Closes all sessions and tails of the user and returns how many were closed
+func (h *Hub) Disconnect(user string, reason string) int {
+	sessions := append(h.userSessions(user), h.userTails(user)...)
+	for _, s := range sessions {
+		if reason != "" {
+			s.client.WriteSystem("Disconnected by an admin: " + reason)
+		}
+		s.client.Close()
+	}
+	if len(sessions) > 0 {
+		log.Printf("Disconnected %d sessions of %s", len(sessions), user)
+	}
+	return len(sessions)
+}
+
+// Sends a server-wide announcement to every session
+func (h *Hub) Announce(text string) {
+	msg := newSystemMessage("", text)
+	msg.Metadata = map[string]string{MetaAnnouncement: "true"}
+	h.Deliver(Everyone(), msg)
+}
+
+// Re-reads the settings that can change while the server is running
+func (h *Hub) Reload() {
+	cfg := loadHubConfig()
+
+	h.activeClientsMutex.Lock()
+	h.config = cfg
+	h.createConfiguredRoomsLocked()
+	h.activeClientsMutex.Unlock()
+}
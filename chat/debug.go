@@ -0,0 +1,126 @@
+package chat
+
+import (
+	"fmt"
+	"group-ssh-chat/commands"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Implemented by clients that buffer output, to report how much of it is
+// waiting to be written
+type OutputQueue interface {
+	QueuedOutput() int
+}
+
+// Handles /debug, an admin dump of runtime statistics and the sizes of the
+// hub's maps and queues for tracking down leaks
+func (h *Hub) debug(ctx *commands.CommandContext, args []string) error {
+	if !h.isAdmin(ctx.User) {
+		return errNotAdmin
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	var sb strings.Builder
+	sb.WriteString("Runtime:")
+	sb.WriteString(fmt.Sprintf("\n  Goroutines          %d", runtime.NumGoroutine()))
+	sb.WriteString(fmt.Sprintf("\n  Heap in use         %s (%d objects)", formatBytes(mem.HeapInuse), mem.HeapObjects))
+	sb.WriteString(fmt.Sprintf("\n  Heap allocated      %s", formatBytes(mem.HeapAlloc)))
+	sb.WriteString(fmt.Sprintf("\n  From the OS         %s", formatBytes(mem.Sys)))
+	gc := "never"
+	if mem.NumGC > 0 {
+		gc = fmt.Sprintf("%d times, last %s ago, paused %s", mem.NumGC,
+			time.Since(time.Unix(0, int64(mem.LastGC))).Round(time.Second),
+			time.Duration(mem.PauseNs[(mem.NumGC+255)%256]))
+	}
+	sb.WriteString("\n  GC                  " + gc)
+
+	h.presence.mu.Lock()
+	presence := 0
+	for _, events := range h.presence.pending {
+		presence += len(events)
+	}
+	h.presence.mu.Unlock()
+
+	all := h.sessions.all()
+	queued := 0
+	for _, s := range all {
+		if q, ok := s.client.(OutputQueue); ok {
+			queued += q.QueuedOutput()
+		}
+	}
+	online := map[string]bool{}
+	for _, user := range h.sessions.users() {
+		online[user] = true
+	}
+
+	h.activeClientsMutex.Lock()
+	// Users placed in a room should always have a session here
+	empty := 0
+	for user := range h.userRooms {
+		if !online[user] {
+			empty++
+		}
+	}
+	tailing := 0
+	for _, list := range h.tails {
+		tailing += len(list)
+	}
+	backlog := 0
+	for sub := range h.subscriptions {
+		backlog += len(sub.messages)
+	}
+	sizes := []struct {
+		name string
+		size int
+	}{
+		{"Users", len(online)},
+		{"  without sessions", empty},
+		{"Sessions", len(all)},
+		{"  queued output", queued},
+		{"User rooms", len(h.userRooms)},
+		{"Rooms", len(h.rooms)},
+		{"Read cursors", len(h.readCursors)},
+		{"Searches", len(h.searches)},
+		{"Ignore lists", len(h.ignores)},
+		{"Tailing sessions", tailing},
+		{"Polls", len(h.polls)},
+		{"Do not disturb", len(h.dnd)},
+		{"Resume tokens", len(h.resumeTokens)},
+		{"Detached users", len(h.detached)},
+		{"Remote rosters", len(h.remoteRosters)},
+		{"Archived rooms", len(h.archived)},
+		{"Login keys", len(h.loginKeys)},
+		{"Pending links", len(h.linking)},
+		{"Guests", len(h.guests)},
+		{"Subscriptions", len(h.subscriptions)},
+		{"  queued messages", backlog},
+		{"Bots", len(h.bots)},
+		{"Message filters", len(h.filters)},
+		{"Pending presence", presence},
+	}
+	h.activeClientsMutex.Unlock()
+
+	sb.WriteString("\nHub:")
+	for _, s := range sizes {
+		sb.WriteString(fmt.Sprintf("\n  %-20s%d", s.name, s.size))
+	}
+	return ctx.Reply(sb.String())
+}
+
+// Formats a byte count, e.g. "12.3 MiB"
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,138 @@
+package chat
+
+// Picks the sessions of this node a message is delivered to. Selectors
+// compose, so Except(Available(InRoom("lobby")), "alice") reaches everyone
+// in #lobby except alice and users in do-not-disturb mode.
+type Selector interface {
+	// Returns the selected sessions. Called without activeClientsMutex
+	// held: sessions come from the registry and selectors needing other hub
+	// state take the mutex themselves.
+	sessions(h *Hub) []*Session
+}
+
+type selectorFunc func(h *Hub) []*Session
+
+func (f selectorFunc) sessions(h *Hub) []*Session {
+	return f(h)
+}
+
+// Selects the sessions of users in the room
+func InRoom(room string) Selector {
+	return selectorFunc(func(h *Hub) []*Session {
+		return h.sessions.inRoom(room)
+	})
+}
+
+// Selects the tails following the room of users still allowed in it
+func Tailing(room string) Selector {
+	return selectorFunc(func(h *Hub) []*Session {
+		h.activeClientsMutex.Lock()
+		defer h.activeClientsMutex.Unlock()
+		var sessions []*Session
+		for _, s := range h.tails[room] {
+			if h.canAccessLocked(s.User, room) {
+				sessions = append(sessions, s)
+			}
+		}
+		return sessions
+	})
+}
+
+// Selects the sessions of a single user
+func ToUser(user string) Selector {
+	return selectorFunc(func(h *Hub) []*Session {
+		return h.sessions.of(user)
+	})
+}
+
+// Selects every session
+func Everyone() Selector {
+	return selectorFunc(func(h *Hub) []*Session {
+		return h.sessions.all()
+	})
+}
+
+// Selects the sessions picked by any of the selectors, each once
+func Any(selectors ...Selector) Selector {
+	return selectorFunc(func(h *Hub) []*Session {
+		seen := map[*Session]bool{}
+		var sessions []*Session
+		for _, sel := range selectors {
+			for _, s := range sel.sessions(h) {
+				if !seen[s] {
+					seen[s] = true
+					sessions = append(sessions, s)
+				}
+			}
+		}
+		return sessions
+	})
+}
+
+// Selects the sessions picked by sel, except those of the given users
+func Except(sel Selector, users ...string) Selector {
+	excluded := map[string]bool{}
+	for _, user := range users {
+		excluded[user] = true
+	}
+	return filter(sel, func(h *Hub, s *Session) bool {
+		return !excluded[s.User]
+	})
+}
+
+// Selects the sessions picked by sel whose users are admins
+func OnlyAdmins(sel Selector) Selector {
+	return filter(sel, func(h *Hub, s *Session) bool {
+		return h.isAdminLocked(s.User)
+	})
+}
+
+// Selects the sessions picked by sel whose users are not in do-not-disturb
+// mode
+func Available(sel Selector) Selector {
+	return filter(sel, func(h *Hub, s *Session) bool {
+		return h.dnd[s.User] == nil
+	})
+}
+
+// Selects the sessions picked by sel whose users do not ignore from
+func NotIgnoring(sel Selector, from string) Selector {
+	return filter(sel, func(h *Hub, s *Session) bool {
+		return !h.ignoresLocked(s.User, from)
+	})
+}
+
+// Returns a selector keeping the sessions picked by sel that keep accepts.
+// keep is called with activeClientsMutex held.
+func filter(sel Selector, keep func(h *Hub, s *Session) bool) Selector {
+	return selectorFunc(func(h *Hub) []*Session {
+		picked := sel.sessions(h)
+		h.activeClientsMutex.Lock()
+		defer h.activeClientsMutex.Unlock()
+		var sessions []*Session
+		for _, s := range picked {
+			if keep(h, s) {
+				sessions = append(sessions, s)
+			}
+		}
+		return sessions
+	})
+}
+
+// Returns a snapshot of the sessions the selector picks. Must be called
+// without activeClientsMutex held.
+func (h *Hub) sessionsOf(target Selector) []*Session {
+	return target.sessions(h)
+}
+
+// Sends a message to the sessions of this node the selector picks
+func (h *Hub) Deliver(target Selector, msg Message) {
+	cache := &RenderCache{}
+	h.fanout.each(h.sessionsOf(target), func(s *Session) {
+		if msg.Type == ChatMessage {
+			h.deliverChat(s, msg, cache)
+		} else {
+			s.client.WriteMessage(msg, cache)
+		}
+	})
+}
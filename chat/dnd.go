@@ -0,0 +1,141 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/commands"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// How long /dnd without a duration lasts
+	defaultDNDDuration = time.Hour
+
+	// Longest do-not-disturb period
+	maxDNDDuration = 7 * 24 * time.Hour
+)
+
+// A user's do-not-disturb period. While it lasts the user only receives
+// messages mentioning them and whispers, and the rest is counted per room.
+type doNotDisturb struct {
+	until  time.Time
+	missed map[string]int
+	timer  *time.Timer
+}
+
+// Handles /dnd [duration|off]
+func (h *Hub) setDND(ctx *commands.CommandContext, args []string) error {
+	if len(args) > 1 {
+		return errors.New("Usage: /dnd [duration|off]")
+	}
+	if len(args) == 1 && args[0] == "off" {
+		if !h.endDND(ctx.User, nil) {
+			return errors.New("Do not disturb is not on")
+		}
+		return nil
+	}
+
+	duration := defaultDNDDuration
+	if len(args) == 1 {
+		d, err := time.ParseDuration(args[0])
+		if err != nil || d <= 0 {
+			return errors.New("Usage: /dnd [duration|off], e.g. /dnd 30m")
+		}
+		if d > maxDNDDuration {
+			return fmt.Errorf("Do not disturb lasts at most %s", maxDNDDuration)
+		}
+		duration = d
+	}
+
+	h.activeClientsMutex.Lock()
+	dnd, ok := h.dnd[ctx.User]
+	if ok {
+		dnd.timer.Stop()
+	} else {
+		dnd = &doNotDisturb{missed: map[string]int{}}
+		h.dnd[ctx.User] = dnd
+	}
+	dnd.until = time.Now().Add(duration)
+	dnd.timer = time.AfterFunc(duration, func() { h.endDND(ctx.User, dnd) })
+	h.activeClientsMutex.Unlock()
+
+	for _, s := range h.userSessions(ctx.User) {
+		s.client.WriteSystem(fmt.Sprintf("Do not disturb is on until %s. You will only see mentions and whispers. Use /dnd off to end it early.", h.sessionTime(s, dnd.until).Format("15:04")))
+	}
+	return nil
+}
+
+// Ends the user's do-not-disturb period, when given only if it is still the
+// current one, and tells the user what they missed. Reports whether a period
+// was ended.
+func (h *Hub) endDND(user string, expected *doNotDisturb) bool {
+	h.activeClientsMutex.Lock()
+	dnd, ok := h.dnd[user]
+	if !ok || (expected != nil && dnd != expected) {
+		h.activeClientsMutex.Unlock()
+		return false
+	}
+	dnd.timer.Stop()
+	delete(h.dnd, user)
+	summary := dnd.summary()
+	h.activeClientsMutex.Unlock()
+
+	h.replySystem(user, "Do not disturb is off. "+summary)
+	return true
+}
+
+// Returns the users in the room who are in do-not-disturb mode and should
+// not receive a message from the sender, counting it as missed for them.
+// Returns nil when nobody needs to be skipped.
+func (h *Hub) holdForDND(room string, from string, text string) []string {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	var held []string
+	for user, dnd := range h.dnd {
+		if user == from || h.userRooms[user] != room || Mentions(text, user) {
+			continue
+		}
+		held = append(held, user)
+		dnd.missed[room]++
+	}
+	return held
+}
+
+// Reports whether the user is in do-not-disturb mode
+func (h *Hub) inDND(user string) bool {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	_, ok := h.dnd[user]
+	return ok
+}
+
+// Describes the messages missed during the period
+func (dnd *doNotDisturb) summary() string {
+	if len(dnd.missed) == 0 {
+		return "You did not miss any messages."
+	}
+	rooms := make([]string, 0, len(dnd.missed))
+	total := 0
+	for room, n := range dnd.missed {
+		rooms = append(rooms, room)
+		total += n
+	}
+	sort.Strings(rooms)
+	parts := make([]string, len(rooms))
+	for i, room := range rooms {
+		parts[i] = fmt.Sprintf("%d in #%s", dnd.missed[room], room)
+	}
+	noun := "messages"
+	if total == 1 {
+		noun = "message"
+	}
+	return fmt.Sprintf("You missed %d %s: %s.", total, noun, strings.Join(parts, ", "))
+}
+
+// Reports whether text mentions the user as @user
+func Mentions(text string, user string) bool {
+	return strings.Contains(strings.ToLower(text), "@"+strings.ToLower(user))
+}
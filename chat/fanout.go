@@ -0,0 +1,116 @@
+package chat
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Number of sessions a fan-out worker handles at a time. Audiences no larger
+// than this are delivered to on the calling goroutine.
+const fanoutBatchSize = 64
+
+// A fixed pool of goroutines that delivers a broadcast to large audiences in
+// parallel
+type fanout struct {
+	batches chan fanoutBatch
+}
+
+// A slice of a broadcast's audience handed to a single worker
+type fanoutBatch struct {
+	sessions []*Session
+	deliver  func(*Session)
+	wg       *sync.WaitGroup
+}
+
+// Returns a fan-out pool with one worker per available CPU
+func newFanout() *fanout {
+	f := &fanout{batches: make(chan fanoutBatch)}
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		go f.work()
+	}
+	return f
+}
+
+// Delivers batches until the process exits
+func (f *fanout) work() {
+	for batch := range f.batches {
+		f.run(batch)
+	}
+}
+
+// Calls deliver for every session and returns once all calls have returned.
+// The calling goroutine handles the first batch itself, so a broadcast makes
+// progress even when every worker is busy.
+func (f *fanout) each(sessions []*Session, deliver func(*Session)) {
+	if len(sessions) <= fanoutBatchSize {
+		for _, s := range sessions {
+			deliver(s)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	for start := fanoutBatchSize; start < len(sessions); start += fanoutBatchSize {
+		end := start + fanoutBatchSize
+		if end > len(sessions) {
+			end = len(sessions)
+		}
+		batch := fanoutBatch{sessions: sessions[start:end], deliver: deliver, wg: &wg}
+		wg.Add(1)
+		select {
+		case f.batches <- batch:
+		default:
+			// All workers are busy, deliver the batch here instead of waiting.
+			f.run(batch)
+		}
+	}
+	for _, s := range sessions[:fanoutBatchSize] {
+		deliver(s)
+	}
+	wg.Wait()
+}
+
+// Delivers every session of a batch
+func (f *fanout) run(batch fanoutBatch) {
+	for _, s := range batch.sessions {
+		batch.deliver(s)
+	}
+	batch.wg.Done()
+}
+
+// Output of a single broadcast shared between the recipients that render it
+// the same way, so a message is formatted once per distinct style instead of
+// once per session. Clients pick the key describing their style. A nil cache
+// renders every time.
+type RenderCache struct {
+	mutex   sync.Mutex
+	entries map[any]*renderEntry
+}
+
+// Output rendered for one style
+type renderEntry struct {
+	once sync.Once
+	out  []byte
+}
+
+// Returns the output rendered for key, calling render the first time the key
+// is seen. The returned bytes are shared and must not be modified.
+func (rc *RenderCache) Render(key any, render func() []byte) []byte {
+	if rc == nil {
+		return render()
+	}
+
+	rc.mutex.Lock()
+	if rc.entries == nil {
+		rc.entries = make(map[any]*renderEntry)
+	}
+	entry, ok := rc.entries[key]
+	if !ok {
+		entry = &renderEntry{}
+		rc.entries[key] = entry
+	}
+	rc.mutex.Unlock()
+
+	entry.once.Do(func() { entry.out = render() })
+	return entry.out
+}
@@ -0,0 +1,55 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/commands"
+	"group-ssh-chat/fun"
+	"strings"
+)
+
+// Default dice rolled by /roll without arguments
+const defaultDice = "1d6"
+
+// Handles /roll [dice] by rolling dice for everyone in the room to see
+func (h *Hub) roll(ctx *commands.CommandContext, args []string) error {
+	spec := defaultDice
+	if len(args) > 1 {
+		return errors.New("Usage: /roll [dice], e.g. /roll 2d6")
+	}
+	if len(args) == 1 {
+		spec = args[0]
+	}
+	result, err := fun.Roll(spec)
+	if err != nil {
+		return fmt.Errorf("Usage: /roll [dice], %v", err)
+	}
+	if err := h.checkPost(ctx.User, ctx.Room, spec); err != nil {
+		return err
+	}
+	h.broadcastAction(ctx.Room, ctx.User, fmt.Sprintf("rolled %s: %s", strings.ToLower(spec), result))
+	return nil
+}
+
+// Handles /flip by flipping a coin for everyone in the room to see
+func (h *Hub) flip(ctx *commands.CommandContext, args []string) error {
+	if err := h.checkPost(ctx.User, ctx.Room, ""); err != nil {
+		return err
+	}
+	h.broadcastAction(ctx.Room, ctx.User, "flipped a coin: "+fun.Flip())
+	return nil
+}
+
+// Handles /choose a|b|c by picking one of the choices for the room
+func (h *Hub) choose(ctx *commands.CommandContext, args []string) error {
+	choices := strings.Join(args, " ")
+	choice, err := fun.Choose(choices)
+	if err != nil {
+		return fmt.Errorf("Usage: /choose a|b|c, %v", err)
+	}
+	if err := h.checkPost(ctx.User, ctx.Room, choices); err != nil {
+		return err
+	}
+	h.broadcastAction(ctx.Room, ctx.User, fmt.Sprintf("asked to choose between %s: %s", choices, choice))
+	return nil
+}
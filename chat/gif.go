@@ -0,0 +1,28 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/commands"
+	"group-ssh-chat/giphy"
+	"log"
+)
+
+// Handles /gif <query> by posting a matching GIF to the sender's room
+func (h *Hub) gif(ctx *commands.CommandContext, args []string) error {
+	if h.gifs == nil {
+		return errors.New("GIF search is not configured on this server")
+	}
+	query := args[0]
+	url, err := h.gifs.Search(ctx.User, query)
+	switch {
+	case errors.Is(err, giphy.ErrRateLimited):
+		return err
+	case errors.Is(err, giphy.ErrNoResults):
+		return fmt.Errorf("No GIFs found for %q", query)
+	case err != nil:
+		log.Printf("GIF search for %q by %s failed: %v", query, ctx.User, err)
+		return errors.New("GIF search failed, try again later")
+	}
+	return h.Post(ctx.User, ctx.Room, fmt.Sprintf("[gif: %s] %s", query, url))
+}
@@ -0,0 +1,126 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/i18n"
+	"log"
+	"strings"
+	"time"
+)
+
+// Values of GUEST_POSTING: guests may only read, or post once per
+// GUEST_SLOWMODE
+const (
+	guestReadOnly = "readonly"
+	guestSlow     = "slow"
+)
+
+// Commands guests may use. Everything else, e.g. whispers, is reserved for
+// known users.
+var guestCommands = map[string]bool{
+	"help":     true,
+	"join":     true,
+	"users":    true,
+	"clear":    true,
+	"set":      true,
+	"theme":    true,
+	"search":   true,
+	"more":     true,
+	"thread":   true,
+	"markread": true,
+	"links":    true,
+	"resync":   true,
+}
+
+// Marks the user as a guest with restricted permissions, or as a regular
+// user. Called on every login since guest names are reused.
+func (h *Hub) SetGuest(user string, guest bool) {
+	h.activeClientsMutex.Lock()
+	if !guest {
+		delete(h.guests, user)
+	} else if _, ok := h.guests[user]; !ok {
+		h.guests[user] = time.Time{}
+	}
+	_, detached := h.detached[user]
+	newGuest := guest && !h.sessions.has(user) && !detached
+	h.activeClientsMutex.Unlock()
+
+	if newGuest {
+		// Whatever is stored was left by an earlier guest, e.g. before a
+		// restart.
+		h.forgetGuest(user)
+	}
+}
+
+// Removes the settings, aliases and ignores a guest saved, once the guest
+// is gone. Guest names are handed out again, so nothing may carry over to
+// the next guest with the same name.
+func (h *Hub) forgetGuest(user string) {
+	if !h.isGuest(user) {
+		return
+	}
+	if err := h.prefsStore.Delete(user); err != nil {
+		log.Printf("Failed to remove preferences of guest %s: %v", user, err)
+	}
+}
+
+// Reports whether the user is a guest
+func (h *Hub) isGuest(user string) bool {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	_, ok := h.guests[user]
+	return ok
+}
+
+// Returns what a guest may do, or "" for regular users
+func (h *Hub) guestNotice(user string) string {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	if _, ok := h.guests[user]; !ok {
+		return ""
+	}
+	if h.config.guestPosting == guestSlow {
+		return fmt.Sprintf("You are a guest and can post once every %s.", h.config.guestSlowmode)
+	}
+	return "You are a guest and can only read."
+}
+
+// Returns an error when the user is a guest and the command is not open to
+// guests. Expects user aliases to be expanded already; built-in aliases
+// such as /j are resolved here.
+func (h *Hub) checkGuestCommand(user string, line string) error {
+	fields := strings.Fields(strings.TrimPrefix(line, "/"))
+	if len(fields) == 0 || !h.isGuest(user) {
+		return nil
+	}
+	name := strings.ToLower(fields[0])
+	if cmd, ok := h.commands.Lookup(name); ok {
+		name = cmd.Name
+	}
+	if guestCommands[name] {
+		return nil
+	}
+	return i18n.Errorf("Guests cannot use /%s", fields[0])
+}
+
+// Returns an error when the user is a guest who may not post right now, and
+// otherwise counts the post towards the guest's slow mode
+func (h *Hub) checkGuestPost(user string) error {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	last, ok := h.guests[user]
+	if !ok {
+		return nil
+	}
+	if h.config.guestPosting != guestSlow {
+		return errors.New("Guests can only read on this server")
+	}
+	if wait := h.config.guestSlowmode - time.Since(last); wait > 0 {
+		return i18n.Errorf("Guests can post once every %s, wait another %s", h.config.guestSlowmode, wait.Round(time.Second))
+	}
+	h.guests[user] = time.Now()
+	return nil
+}
@@ -0,0 +1,115 @@
+package chat
+
+import (
+	"fmt"
+	"group-ssh-chat/commands"
+	"strconv"
+	"strings"
+)
+
+// Lines of the terminal /help leaves free for the page header and the prompt
+const helpReservedLines = 3
+
+// Fewest lines of help shown per page, however small the terminal
+const minHelpPageSize = 5
+
+// Handles /help [<command>|<page>], listing the commands the sender can run
+// by category a page at a time, or explaining a single command
+func (h *Hub) help(ctx *commands.CommandContext, args []string) error {
+	page := 1
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return h.commandHelp(ctx, args[0])
+		}
+		page = n
+	}
+
+	return ctx.Reply(helpPage(h.helpLines(ctx.User), page, helpPageSize(ctx.Height)))
+}
+
+// Returns the /help listing of the commands the sender can run, grouped by
+// category
+func (h *Hub) helpLines(sender string) []string {
+	byCategory := map[commands.Category][]commands.Command{}
+	for _, cmd := range h.commands.CommandsFor(sender) {
+		byCategory[cmd.Category] = append(byCategory[cmd.Category], cmd)
+	}
+
+	var lines []string
+	for _, category := range commands.Categories {
+		cmds := byCategory[category]
+		if len(cmds) == 0 {
+			continue
+		}
+		lines = append(lines, strings.ToUpper(string(category[:1]))+string(category[1:])+":")
+		for _, cmd := range cmds {
+			description := cmd.Description
+			if len(cmd.Aliases) > 0 {
+				description += " (also /" + strings.Join(cmd.Aliases, ", /") + ")"
+			}
+			lines = append(lines, fmt.Sprintf("  %-28s %s", cmd.Usage, description))
+		}
+	}
+	return lines
+}
+
+// Returns how many lines of help fit a terminal of the height, or 0 to show
+// them all at once
+func helpPageSize(height int) int {
+	if height <= 0 {
+		return 0
+	}
+	if height-helpReservedLines < minHelpPageSize {
+		return minHelpPageSize
+	}
+	return height - helpReservedLines
+}
+
+// Returns a page of the help listing, the last one when page is past the end
+func helpPage(lines []string, page int, size int) string {
+	if size == 0 || len(lines) <= size {
+		return "Available commands:\n" + strings.Join(lines, "\n")
+	}
+	pages := (len(lines) + size - 1) / size
+	if page < 1 {
+		page = 1
+	}
+	if page > pages {
+		page = pages
+	}
+	start := (page - 1) * size
+	end := start + size
+	if end > len(lines) {
+		end = len(lines)
+	}
+
+	header := fmt.Sprintf("Available commands, page %d of %d", page, pages)
+	if page < pages {
+		header += fmt.Sprintf(", type /help %d for the next", page+1)
+	}
+	return header + ":\n" + strings.Join(lines[start:end], "\n")
+}
+
+// Shows the usage, description and examples of a command
+func (h *Hub) commandHelp(ctx *commands.CommandContext, name string) error {
+	cmd, ok := h.commands.Lookup(strings.TrimPrefix(name, "/"))
+	if !ok {
+		return fmt.Errorf("There is no command /%s, type /help for a list of commands", name)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(cmd.Usage)
+	sb.WriteString("\n  " + cmd.Description)
+	if len(cmd.Aliases) > 0 {
+		sb.WriteString("\n  Also: /" + strings.Join(cmd.Aliases, ", /"))
+	}
+	sb.WriteString(fmt.Sprintf("\n  Category: %s, available to %s", cmd.Category, cmd.Permission))
+	if len(cmd.Examples) > 0 {
+		sb.WriteString("\n  Examples:")
+		for _, example := range cmd.Examples {
+			sb.WriteString("\n    " + example)
+		}
+	}
+	return ctx.Reply(sb.String())
+}
@@ -0,0 +1,432 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"group-ssh-chat/archive"
+	"group-ssh-chat/audit"
+	"group-ssh-chat/commands"
+	"group-ssh-chat/giphy"
+	"group-ssh-chat/oauth"
+	"group-ssh-chat/preview"
+	"group-ssh-chat/scheduler"
+	"group-ssh-chat/securitypolicy"
+	"group-ssh-chat/stats"
+	"group-ssh-chat/storage"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A transport-agnostic chat hub that tracks users, rooms and sessions and
+// routes messages and commands between them
+type Hub struct {
+	sessions           *sessionRegistry
+	userRooms          map[string]string
+	readCursors        map[string]map[string]int64
+	searches           map[string]*searchResults
+	ignores            map[string]map[string]bool
+	rooms              map[string]*Room
+	tails              map[string][]*Session
+	polls              map[string]*poll
+	roomStore          *storage.RoomStore
+	config             hubConfig
+	activeClientsMutex sync.Mutex
+	commands           *commands.CommandManager
+	prefsStore         storage.Preferences
+	totpSecrets        *storage.SecretStore
+	registeredKeys     *storage.KeyStore
+	history            storage.History
+	inbox              *storage.InboxStore
+	reminders          *scheduler.Scheduler
+	policy             *securitypolicy.Policy
+	auditLog           *audit.Logger
+	stats              *stats.Collector
+	fanout             *fanout
+	previews           *preview.Fetcher
+	gifs               *giphy.Client
+	bots               []Bot
+	dnd                map[string]*doNotDisturb
+	resumeTokens       map[string]string
+	detached           map[string]*detachedUser
+	presence           *presencePolicy
+	backplane          Backplane
+	nodeID             string
+	remoteRosters      map[string]*remoteRoster
+	archiver           *archive.Archiver
+	archived           map[string][]storage.StoredMessage
+	directoryAdmins    map[string]bool
+	linker             *oauth.Linker
+	identities         *storage.IdentityStore
+	loginKeys          map[string]string
+	linking            map[string]context.CancelFunc
+	guests             map[string]time.Time
+	subscriptions      map[*Subscription]bool
+	filters            []MessageFilter
+}
+
+// Returns new instance of the chat hub
+func New(prefsStore storage.Preferences, history storage.History, roomStore *storage.RoomStore, reminderStore *storage.ReminderStore, inbox *storage.InboxStore, totpSecrets *storage.SecretStore, registeredKeys *storage.KeyStore, policy *securitypolicy.Policy, auditLog *audit.Logger, collector *stats.Collector) *Hub {
+	h := &Hub{
+		sessions:        newSessionRegistry(),
+		userRooms:       make(map[string]string),
+		readCursors:     make(map[string]map[string]int64),
+		searches:        make(map[string]*searchResults),
+		ignores:         make(map[string]map[string]bool),
+		tails:           make(map[string][]*Session),
+		polls:           make(map[string]*poll),
+		dnd:             make(map[string]*doNotDisturb),
+		resumeTokens:    make(map[string]string),
+		detached:        make(map[string]*detachedUser),
+		remoteRosters:   make(map[string]*remoteRoster),
+		archived:        make(map[string][]storage.StoredMessage),
+		directoryAdmins: make(map[string]bool),
+		loginKeys:       make(map[string]string),
+		linking:         make(map[string]context.CancelFunc),
+		guests:          make(map[string]time.Time),
+		subscriptions:   make(map[*Subscription]bool),
+		rooms:           make(map[string]*Room),
+		roomStore:       roomStore,
+		config:          loadHubConfig(),
+		commands:        commands.NewCommandManager(),
+		prefsStore:      prefsStore,
+		totpSecrets:     totpSecrets,
+		registeredKeys:  registeredKeys,
+		history:         history,
+		inbox:           inbox,
+		policy:          policy,
+		auditLog:        auditLog,
+		stats:           collector,
+		fanout:          newFanout(),
+		previews:        preview.New(),
+		gifs:            giphy.New(),
+	}
+	h.presence = newPresencePolicy(h.flushPresence)
+	h.loadRooms()
+	h.reminders = scheduler.New(reminderStore, h.deliverReminder)
+	h.reminders.Start()
+	go h.archiveIdleRoomsPeriodically()
+	go h.reapStaleSessionsPeriodically()
+	h.registerCommands()
+
+	return h
+}
+
+// Stops the goroutine owning the session registry. The hub must not be used
+// afterwards.
+func (h *Hub) Close() {
+	h.sessions.stop()
+}
+
+// Returned by Join when the user already has the maximum number of sessions open
+var ErrTooManySessions = errors.New("too many open sessions for this user")
+
+// Registers a new session for the user and announces the user if it is their first session
+func (h *Hub) Join(user string, remoteAddr string, client Client) (*Session, error) {
+	return h.JoinResumable(user, remoteAddr, "", client)
+}
+
+// Like Join, for clients able to resume. With a resume token from an
+// earlier session the user gets back the room and state they had, without
+// being announced, as long as they reconnect within RESUME_GRACE. Clients
+// pass NewResumeToken to only ask for a token. A fresh token is sent to the
+// client as a ResumeTokenNotice.
+func (h *Hub) JoinResumable(user string, remoteAddr string, resumeToken string, client Client) (*Session, error) {
+	sess := &Session{
+		ID:          uuid.New().String(),
+		User:        user,
+		RemoteAddr:  remoteAddr,
+		ConnectedAt: time.Now(),
+		client:      client,
+	}
+	if source, ok := client.(EnvironmentSource); ok {
+		sess.Env = source.Environment()
+	}
+
+	h.activeClientsMutex.Lock()
+	firstSession, err := h.sessions.add(sess, h.config.maxSessionsPerUser)
+	if err != nil {
+		h.activeClientsMutex.Unlock()
+		return nil, err
+	}
+	prefs := h.preferencesOf(user)
+	var resumed *detachedUser
+	var abandoned string
+	var requestedRoom string
+	var roomErr error
+	if firstSession {
+		resumed, abandoned = h.takeDetachedLocked(user, resumeToken)
+		h.setRoomLocked(user, h.defaultRoomLocked())
+		h.ignores[user] = prefs.ignored()
+		if resumed != nil {
+			h.setRoomLocked(user, resumed.room)
+			if resumed.search != nil {
+				h.searches[user] = resumed.search
+			}
+		} else if requestedRoom = normalizeRoomName(sess.Env["CHAT_ROOM"]); requestedRoom != "" {
+			// A room asked for with CHAT_ROOM is joined as with /join.
+			roomErr = h.enterRoomLocked(user, requestedRoom)
+		}
+	}
+	room := h.userRooms[user]
+	newToken := ""
+	if resumeToken != "" {
+		newToken = newResumeToken()
+		h.resumeTokens[user] = newToken
+	}
+	h.activeClientsMutex.Unlock()
+	if abandoned != "" {
+		h.broadcastPresence(abandoned, user, false)
+	}
+	h.stats.SessionOpened(user)
+
+	h.auditLog.Log(audit.Event{
+		Type:       audit.EventJoin,
+		User:       user,
+		RemoteAddr: remoteAddr,
+		SessionID:  sess.ID,
+	})
+
+	client.SetPreferences(prefs)
+	if resumed != nil {
+		client.WriteSystem(h.tr(sess, "Welcome back %s! Your session in #%s was resumed.", user, room))
+	} else {
+		client.WriteSystem(h.tr(sess, "Welcome %s! You are in #%s. Type /help for commands.", user, room))
+		if roomErr != nil {
+			client.WriteSystem(h.localize(sess, roomErr))
+		}
+		h.introduceRoom([]*Session{sess}, room)
+		if motd := h.motd(); motd != "" {
+			client.WriteSystem(motd)
+		}
+		if notice := h.guestNotice(user); notice != "" {
+			client.WriteSystem(notice)
+		}
+	}
+	if newToken != "" {
+		client.WriteSystem(ResumeTokenNotice + newToken)
+	}
+	if resumed != nil {
+		h.showUnread(user, room, []*Session{sess})
+		h.deliverInbox(user, []*Session{sess})
+	} else if firstSession {
+		h.broadcastPresence(room, user, true)
+		h.showUnread(user, room, []*Session{sess})
+		h.showUnreadSummary(user, []*Session{sess})
+		h.deliverInbox(user, []*Session{sess})
+	}
+	h.deliverOverdueReminders(user, sess)
+
+	return sess, nil
+}
+
+// Removes the session and announces the user leaving when it was their last session
+func (h *Hub) Leave(sess *Session) {
+	h.activeClientsMutex.Lock()
+	room := h.userRooms[sess.User]
+	lastSession := h.sessions.remove(sess) == 0
+	detached := false
+	if lastSession {
+		detached = h.detachLocked(sess.User)
+		h.setRoomLocked(sess.User, "")
+		delete(h.searches, sess.User)
+		delete(h.ignores, sess.User)
+		log.Println("Removed all sessions for:", sess.User)
+	}
+	h.activeClientsMutex.Unlock()
+
+	log.Println("Removed Session:", sess.ID)
+	h.rewindReadCursor(sess)
+	h.stats.SessionClosed(sess.User)
+	h.auditLog.Log(audit.Event{Type: audit.EventLeave, User: sess.User, SessionID: sess.ID})
+
+	if lastSession && !detached {
+		h.broadcastPresence(room, sess.User, false)
+		h.forgetGuest(sess.User)
+	}
+}
+
+// Handles input from a session, either a command or a chat message. Input
+// may span several lines, e.g. pasted text, which is always sent as a
+// message.
+func (h *Hub) HandleInput(sess *Session, line string) {
+	received := time.Now()
+	line = sanitizeInput(line)
+	if h.collectPaste(sess, line) || line == "" {
+		return
+	}
+
+	multiline := strings.Contains(line, "\n")
+	if commands.IsCommand(line) && !multiline {
+		h.auditLog.Log(audit.Event{Type: audit.EventCommand, User: sess.User, SessionID: sess.ID, Command: line})
+		line = h.expandAlias(sess.User, line)
+		if err := h.checkGuestCommand(sess.User, line); err != nil {
+			sess.client.WriteSystem(h.localize(sess, err))
+			return
+		}
+		if isPasteCommand(line) {
+			h.startPaste(sess)
+			return
+		}
+		if isPingCommand(line) {
+			h.ping(sess, received)
+			return
+		}
+		if err := h.commands.HandleCommand(h.commandContext(sess), line); err != nil {
+			if errors.Is(err, commands.ErrUnknownCommand) {
+				sess.client.WriteSystem(h.tr(sess, "%s, type /help for a list of commands", h.localize(sess, err)))
+			} else {
+				sess.client.WriteSystem(h.localize(sess, err))
+			}
+		}
+		return
+	}
+	if multiline && strings.Count(line, "\n") >= maxMessageLines {
+		sess.client.WriteSystem(h.tr(sess, "Messages are limited to %d lines", maxMessageLines))
+		return
+	}
+	h.sendMessage(sess, line)
+}
+
+// Returns an error when the user may not post the text in the room because
+// they are muted, the text is too long or contains a banned word, or the
+// room's or guest posting rules forbid it. Otherwise the post counts towards
+// slow mode. Commands posting to the room on the user's behalf run the same
+// checks as typed messages.
+func (h *Hub) checkPost(user string, room string, text string) error {
+	if err := h.checkNotMuted(user, room); err != nil {
+		return err
+	}
+	if err := h.checkMessageLength(text); err != nil {
+		return err
+	}
+	if err := h.checkBannedWords(text); err != nil {
+		return err
+	}
+	if err := h.checkCanPost(user, room); err != nil {
+		return err
+	}
+	return h.checkGuestPost(user)
+}
+
+// Sends a chat message typed in a session to the user's current room
+func (h *Hub) sendMessage(sess *Session, text string) {
+	room := h.roomOf(sess.User)
+	if err := h.checkPost(sess.User, room, text); err != nil {
+		sess.client.WriteSystem(h.localize(sess, err))
+		return
+	}
+	text, err := h.filterMessage(room, sess.User, text)
+	if err != nil {
+		sess.client.WriteSystem(h.localize(sess, err))
+		return
+	}
+	if h.auditLog.LogsMessages() {
+		h.auditLog.Log(audit.Event{Type: audit.EventMessage, User: sess.User, SessionID: sess.ID, Message: text})
+	}
+	h.broadcastMessage(room, sess.User, text, nil)
+	h.announceKarma(room, sess.User, text)
+}
+
+// Returns the room the user is currently in
+func (h *Hub) roomOf(user string) string {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	return h.userRooms[user]
+}
+
+// Puts the user in the room, or in none when it is empty, keeping the
+// registry's room index in step
+func (h *Hub) setRoomLocked(user string, room string) {
+	h.sessions.setRoom(user, room)
+	if room == "" {
+		delete(h.userRooms, user)
+	} else {
+		h.userRooms[user] = room
+	}
+}
+
+// Returns a snapshot of the sessions of a single user
+func (h *Hub) userSessions(user string) []*Session {
+	return h.sessionsOf(ToUser(user))
+}
+
+// Stores a chat message from a user and sends it to everyone in the room.
+// A non-nil quote marks the message as a reply.
+func (h *Hub) broadcastMessage(room string, from string, text string, quote *Quote) {
+	// Bots and integrations post here without going through HandleInput.
+	stored := storage.StoredMessage{Room: room, From: from, Text: sanitizeInput(text)}
+	if quote != nil {
+		stored.ReplyTo = quote.ID
+	}
+	stored, err := h.history.Append(stored)
+	if err != nil {
+		log.Println("Failed to store message:", err)
+	}
+	h.stats.MessageSent(room, from)
+	msg := newChatMessage(stored, quote)
+	h.deliverMessage(msg)
+	h.publish(clusterEvent{Type: clusterMessage, Message: &msg})
+}
+
+// Sends a stored chat message to the sessions of this node in its room
+func (h *Hub) deliverMessage(msg Message) {
+	h.touchRoom(msg.Room, msg.Time)
+	h.advanceReadCursors(msg.Room, msg.ID)
+	held := h.holdForDND(msg.Room, msg.From, msg.Text)
+	h.Deliver(Except(h.audience(msg.Room, msg.From), held...), msg)
+	h.previewLinks(msg)
+	h.notifyBots(msg)
+	h.notifySubscriptions(msg.stored())
+}
+
+// Sends a system notice to everyone in the room who is not in
+// do-not-disturb mode
+func (h *Hub) broadcastSystemMessage(room string, text string) {
+	h.broadcastNotice(newSystemMessage(room, text))
+}
+
+// Tells everyone in the room who is not in do-not-disturb mode what a user
+// did, e.g. "alice rolled 2d6: 7"
+func (h *Hub) broadcastAction(room string, from string, text string) {
+	h.broadcastNotice(Message{Type: ActionMessage, From: from, Room: room, Time: time.Now(), Text: text})
+}
+
+// Sends a system or action message to everyone in its room who is not in
+// do-not-disturb mode
+func (h *Hub) broadcastNotice(msg Message) {
+	msg.Text = sanitizeInput(msg.Text)
+	h.deliverNotice(msg)
+	h.publish(clusterEvent{Type: clusterNotice, Message: &msg})
+}
+
+// Sends a system or action message to the sessions of this node in its room
+func (h *Hub) deliverNotice(msg Message) {
+	h.Deliver(Available(InRoom(msg.Room)), msg)
+}
+
+// Reports whether the user has at least one active session on this or
+// another node of the cluster
+func (h *Hub) IsOnline(user string) bool {
+	if h.sessions.has(user) {
+		return true
+	}
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	return h.onlineRemotelyLocked(user)
+}
+
+// Reports whether the user is an admin, has a registered key or logged in
+// with a key, i.e. owns the name beyond a single session
+func (h *Hub) IsKnownUser(user string) bool {
+	if _, ok := h.registeredKeys.Get(user); ok {
+		return true
+	}
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	_, hasKey := h.loginKeys[user]
+	return hasKey || h.isAdminLocked(user)
+}
@@ -0,0 +1,103 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/commands"
+	"sort"
+	"strings"
+)
+
+// Preference key holding the comma separated list of ignored users
+const ignorePreference = "ignore"
+
+// Parses the ignore list stored in the user's preferences
+func (p Preferences) ignored() map[string]bool {
+	ignored := map[string]bool{}
+	for _, name := range strings.Split(p[ignorePreference], ",") {
+		if name != "" {
+			ignored[name] = true
+		}
+	}
+	return ignored
+}
+
+// Reports whether the user ignores other. Must be called with
+// activeClientsMutex held.
+func (h *Hub) ignoresLocked(user string, other string) bool {
+	return h.ignores[user][other]
+}
+
+// Selects the sessions and tails in the room of users not ignoring from
+func (h *Hub) audience(room string, from string) Selector {
+	return NotIgnoring(Any(InRoom(room), Tailing(room)), from)
+}
+
+// Handles /ignore [list|<user>]
+func (h *Hub) ignore(ctx *commands.CommandContext, args []string) error {
+	if len(args) == 0 || (len(args) == 1 && args[0] == "list") {
+		h.activeClientsMutex.Lock()
+		var names []string
+		for name := range h.ignores[ctx.User] {
+			names = append(names, name)
+		}
+		h.activeClientsMutex.Unlock()
+
+		if len(names) == 0 {
+			return ctx.Reply("You are not ignoring anyone")
+		}
+		sort.Strings(names)
+		return ctx.Reply("Ignoring: " + strings.Join(names, ", "))
+	}
+	if len(args) != 1 {
+		return errors.New("Usage: /ignore [list|<user>]")
+	}
+	if args[0] == ctx.User {
+		return errors.New("You cannot ignore yourself")
+	}
+	if strings.Contains(args[0], ",") {
+		return fmt.Errorf("Invalid username %q", args[0])
+	}
+	return h.updateIgnores(ctx, args[0], true)
+}
+
+// Handles /unignore <user>
+func (h *Hub) unignore(ctx *commands.CommandContext, args []string) error {
+	return h.updateIgnores(ctx, args[0], false)
+}
+
+// Adds or removes a user from the sender's ignore list and persists it
+func (h *Hub) updateIgnores(ctx *commands.CommandContext, user string, ignore bool) error {
+	h.activeClientsMutex.Lock()
+	ignored := h.ignores[ctx.User]
+	if ignored == nil {
+		ignored = map[string]bool{}
+		h.ignores[ctx.User] = ignored
+	}
+	if ignored[user] == ignore {
+		h.activeClientsMutex.Unlock()
+		if ignore {
+			return fmt.Errorf("You are already ignoring %s", user)
+		}
+		return fmt.Errorf("You are not ignoring %s", user)
+	}
+	if ignore {
+		ignored[user] = true
+	} else {
+		delete(ignored, user)
+	}
+	names := make([]string, 0, len(ignored))
+	for name := range ignored {
+		names = append(names, name)
+	}
+	h.activeClientsMutex.Unlock()
+
+	sort.Strings(names)
+	if err := h.prefsStore.Set(ctx.User, ignorePreference, strings.Join(names, ",")); err != nil {
+		return fmt.Errorf("Failed to save ignore list: %v", err)
+	}
+	if ignore {
+		return ctx.ReplyAll("Ignoring " + user + ". Use /unignore " + user + " to undo.")
+	}
+	return ctx.ReplyAll("No longer ignoring " + user)
+}
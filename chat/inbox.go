@@ -0,0 +1,92 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/commands"
+	"group-ssh-chat/storage"
+	"log"
+	"time"
+)
+
+// Queues a whisper for an offline user, to be delivered at their next login
+func (h *Hub) queueWhisper(ctx *commands.CommandContext, to string, text string) error {
+	_, err := h.inbox.Add(storage.QueuedWhisper{From: ctx.User, To: to, Text: text, Time: time.Now()})
+	if err != nil {
+		return fmt.Errorf("Failed to queue message: %v", err)
+	}
+	return ctx.Reply(fmt.Sprintf("%s is offline, your message will be delivered when they next log in", to))
+}
+
+// Shows a whisper to the recipient's sessions on this node unless they
+// ignore the sender
+func (h *Hub) deliverWhisper(msg Message) {
+	h.Deliver(NotIgnoring(ToUser(msg.To), msg.From), msg)
+}
+
+// Delivers whispers that were sent while the user was away and lets the
+// senders know they arrived
+func (h *Hub) deliverInbox(user string, sessions []*Session) {
+	msgs, err := h.inbox.TakeUndelivered(user)
+	if err != nil {
+		log.Printf("Failed to update inbox of %s: %v", user, err)
+	}
+
+	h.activeClientsMutex.Lock()
+	var visible []storage.QueuedWhisper
+	for _, msg := range msgs {
+		if !h.ignoresLocked(user, msg.From) {
+			visible = append(visible, msg)
+		}
+	}
+	h.activeClientsMutex.Unlock()
+
+	senders := map[string]int{}
+	for _, queued := range visible {
+		msg := Message{
+			Type:     WhisperMessage,
+			From:     queued.From,
+			To:       user,
+			Time:     queued.Time,
+			Text:     queued.Text,
+			Metadata: map[string]string{MetaQueued: "true"},
+		}
+		for _, s := range sessions {
+			s.client.WriteMessage(msg, nil)
+		}
+		senders[queued.From]++
+	}
+	for sender, n := range senders {
+		if n == 1 {
+			h.replySystem(sender, fmt.Sprintf("Your message to %s was delivered", user))
+		} else {
+			h.replySystem(sender, fmt.Sprintf("Your %d messages to %s were delivered", n, user))
+		}
+	}
+}
+
+// Handles /inbox [clear]
+func (h *Hub) showInbox(ctx *commands.CommandContext, args []string) error {
+	if len(args) == 1 && args[0] == "clear" {
+		if err := h.inbox.Clear(ctx.User); err != nil {
+			return fmt.Errorf("Failed to clear inbox: %v", err)
+		}
+		return ctx.Reply("Inbox cleared")
+	}
+	if len(args) != 0 {
+		return errors.New("Usage: /inbox [clear]")
+	}
+
+	queued := h.inbox.List(ctx.User)
+	if len(queued) == 0 {
+		return ctx.Reply("Your inbox is empty")
+	}
+	msgs := make([]storage.StoredMessage, len(queued))
+	for i, q := range queued {
+		msgs[i] = storage.StoredMessage{ID: q.ID, From: q.From, Text: q.Text, Time: q.Time}
+	}
+	if s := h.invokingSession(ctx); s != nil {
+		s.client.WriteHistory(fmt.Sprintf("Messages sent while you were away (%d)", len(msgs)), msgs)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package chat
+
+import (
+	"group-ssh-chat/i18n"
+	"strings"
+)
+
+// Returns the language the session's system messages are shown in: the
+// lang setting, or with "auto" the locale sent by the client, or else
+// DEFAULT_LANGUAGE
+func (h *Hub) languageOf(sess *Session) string {
+	if lang := h.preferencesOf(sess.User).Get("lang"); lang != "auto" && i18n.Supported(lang) {
+		return lang
+	}
+	for _, name := range []string{"LC_ALL", "LANG"} {
+		if lang := i18n.Match(sess.Env[name]); lang != "" {
+			return lang
+		}
+	}
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	return h.config.defaultLanguage
+}
+
+// Formats a system message in the session's language
+func (h *Hub) tr(sess *Session, format string, args ...any) string {
+	return i18n.Translate(h.languageOf(sess), format, args...)
+}
+
+// Formats a system message about a count of n in the session's language
+func (h *Hub) trPlural(sess *Session, n int, one string, other string, args ...any) string {
+	return i18n.Plural(h.languageOf(sess), n, one, other, args...)
+}
+
+// Returns the message of an error in the session's language
+func (h *Hub) localize(sess *Session, err error) string {
+	return i18n.Localize(h.languageOf(sess), err)
+}
+
+// Validates a value for the lang setting
+func validateLanguage(value string) error {
+	if value == "auto" || i18n.Supported(value) {
+		return nil
+	}
+	return i18n.Errorf("Unknown language %s, expected auto or one of: %s", value, strings.Join(i18n.Languages(), ", "))
+}
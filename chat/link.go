@@ -0,0 +1,198 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"group-ssh-chat/commands"
+	"group-ssh-chat/confusable"
+	"group-ssh-chat/oauth"
+	"group-ssh-chat/storage"
+	"group-ssh-chat/ui"
+	"log"
+	"strings"
+	"time"
+)
+
+// Lets users link their SSH key to an account at an identity provider with
+// /link. Identities linked earlier are shown even when linker is nil.
+func (h *Hub) SetAccountLinking(linker *oauth.Linker, identities *storage.IdentityStore) {
+	h.linker = linker
+	h.identities = identities
+}
+
+// Records the fingerprint of the key the user logged in with, which /link
+// binds the identity to
+func (h *Hub) SetLoginKey(user string, fingerprint string) {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	h.loginKeys[user] = fingerprint
+}
+
+// Returns the identity linked to the user, used by clients to show display
+// names and initials
+func (h *Hub) Identity(user string) (storage.LinkedIdentity, bool) {
+	if h.identities == nil {
+		return storage.LinkedIdentity{}, false
+	}
+	return h.identities.Get(user)
+}
+
+// Returns the usernames and display names of everyone but the user, for
+// spotting display names that could pass for someone else
+func (h *Hub) namesOtherThan(user string) []string {
+	names := h.sessions.users()
+	h.activeClientsMutex.Lock()
+	for admin := range h.config.admins {
+		names = append(names, admin)
+	}
+	h.activeClientsMutex.Unlock()
+	for _, key := range h.registeredKeys.List() {
+		names = append(names, key.User)
+	}
+	for other, identity := range h.identities.All() {
+		if other != user && identity.DisplayName() != "" {
+			names = append(names, other, identity.DisplayName())
+		}
+	}
+
+	others := names[:0]
+	for _, name := range names {
+		if name != user {
+			others = append(others, name)
+		}
+	}
+	return others
+}
+
+// Handles /link [provider], /link status, /link cancel and /link remove
+func (h *Hub) link(ctx *commands.CommandContext, args []string) error {
+	if len(args) == 1 {
+		switch args[0] {
+		case "status":
+			identity, ok := h.Identity(ctx.User)
+			if !ok {
+				return ctx.Reply("Your account is not linked")
+			}
+			return ctx.Reply(fmt.Sprintf("Linked to %s account %s (%s) since %s", identity.Provider, identity.Login, identity.DisplayName(), identity.LinkedAt.Format(time.DateOnly)))
+		case "remove":
+			if _, ok := h.Identity(ctx.User); !ok {
+				return errors.New("Your account is not linked")
+			}
+			if err := h.identities.Delete(ctx.User); err != nil {
+				return fmt.Errorf("Failed to unlink: %v", err)
+			}
+			return ctx.Reply("Your account was unlinked")
+		case "cancel":
+			h.activeClientsMutex.Lock()
+			cancel, ok := h.linking[ctx.User]
+			h.activeClientsMutex.Unlock()
+			if !ok {
+				return errors.New("No link is in progress")
+			}
+			cancel()
+			return nil
+		}
+	}
+
+	if h.linker == nil {
+		return errors.New("Account linking is not configured")
+	}
+	providers := h.linker.Providers()
+	usage := fmt.Errorf("Usage: /link %s|status|cancel|remove", strings.Join(providers, "|"))
+	var provider string
+	switch {
+	case len(args) == 1:
+		provider = strings.ToLower(args[0])
+	case len(args) == 0 && len(providers) == 1:
+		provider = providers[0]
+	default:
+		return usage
+	}
+
+	h.activeClientsMutex.Lock()
+	fingerprint := h.loginKeys[ctx.User]
+	_, pending := h.linking[ctx.User]
+	h.activeClientsMutex.Unlock()
+	if fingerprint == "" {
+		return errors.New("Linking needs a session logged in with an SSH key")
+	}
+	if pending {
+		return errors.New("A link is already in progress, finish it or run /link cancel")
+	}
+
+	dc, err := h.linker.Start(provider)
+	if err != nil {
+		log.Printf("Failed to start linking %s: %v", ctx.User, err)
+		return usage
+	}
+
+	linkCtx, cancel := context.WithDeadline(context.Background(), dc.ExpiresAt)
+	h.activeClientsMutex.Lock()
+	h.linking[ctx.User] = cancel
+	h.activeClientsMutex.Unlock()
+	go h.finishLink(linkCtx, cancel, ctx.User, fingerprint, dc)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("To link your key to %s, open %s and enter the code %s", dc.Provider, dc.URL, dc.Code))
+	if lines, err := ui.QRCode(dc.URL); err == nil && ctx.Caps.Unicode {
+		sb.WriteString("\n" + strings.Join(lines, "\n"))
+	}
+	sb.WriteString(fmt.Sprintf("\nThe code expires in %s.", time.Until(dc.ExpiresAt).Round(time.Minute)))
+	return ctx.Reply(sb.String())
+}
+
+// Waits for the user to enter the code and stores the identity they
+// authorized
+func (h *Hub) finishLink(ctx context.Context, cancel context.CancelFunc, user string, fingerprint string, dc *oauth.DeviceCode) {
+	defer func() {
+		cancel()
+		h.activeClientsMutex.Lock()
+		delete(h.linking, user)
+		h.activeClientsMutex.Unlock()
+	}()
+
+	identity, err := h.linker.Wait(ctx, dc)
+	switch {
+	case errors.Is(err, context.Canceled):
+		h.replySystem(user, "Linking cancelled")
+		return
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, oauth.ErrExpired):
+		h.replySystem(user, "The code expired, run /link to get a new one")
+		return
+	case errors.Is(err, oauth.ErrDenied):
+		h.replySystem(user, "Linking was denied at "+dc.Provider)
+		return
+	case err != nil:
+		log.Printf("Failed to link %s: %v", user, err)
+		h.replySystem(user, "Linking failed, try again later")
+		return
+	}
+
+	if other, ok := h.identities.UserOf(identity.Provider, identity.Subject); ok && other != user {
+		h.replySystem(user, fmt.Sprintf("That %s account is already linked to another user", dc.Provider))
+		return
+	}
+	linked := storage.LinkedIdentity{
+		Provider:    identity.Provider,
+		Subject:     identity.Subject,
+		Login:       identity.Login,
+		Name:        identity.Name,
+		Fingerprint: fingerprint,
+		LinkedAt:    time.Now().UTC(),
+	}
+	lookalike, flagged := confusable.Find(linked.DisplayName(), h.namesOtherThan(user))
+	linked.NameFlagged = flagged
+	if err := h.identities.Set(user, linked); err != nil {
+		log.Printf("Failed to save the identity of %s: %v", user, err)
+		h.replySystem(user, "Linking failed, try again later")
+		return
+	}
+	log.Printf("Linked %s (%s) to %s account %s", user, fingerprint, identity.Provider, identity.Subject)
+	if flagged {
+		log.Printf("Not showing the name %q of %s, which looks like %q", identity.Name, user, lookalike)
+		h.replySystem(user, fmt.Sprintf("Your key is now linked to %s account %s. Your name there looks too much like %s, so you keep appearing as %s.", dc.Provider, identity.Login, lookalike, user))
+		return
+	}
+	h.replySystem(user, fmt.Sprintf("Your key is now linked to %s account %s, you appear as %s", dc.Provider, identity.Login, linked.DisplayName()))
+}
@@ -0,0 +1,35 @@
+package chat
+
+import (
+	"fmt"
+	"group-ssh-chat/commands"
+	"group-ssh-chat/ui"
+	"strings"
+)
+
+// Most links listed by /links
+const maxListedLinks = 10
+
+// Handles /links by listing the URLs most recently shared in the sender's room
+func (h *Hub) links(ctx *commands.CommandContext, args []string) error {
+	room := ctx.Room
+	msgs := h.history.Search(room, func(text string) bool {
+		return strings.Contains(text, "://") && len(ui.FindURLs(text)) > 0
+	})
+
+	// Walk back from the newest message, then list the links oldest first.
+	var links []string
+	for i := len(msgs) - 1; i >= 0 && len(links) < maxListedLinks; i-- {
+		urls := ui.FindURLs(msgs[i].Text)
+		for j := len(urls) - 1; j >= 0 && len(links) < maxListedLinks; j-- {
+			links = append(links, fmt.Sprintf("[%d] %s: %s", msgs[i].ID, msgs[i].From, urls[j]))
+		}
+	}
+	if len(links) == 0 {
+		return ctx.Reply("No links have been shared in #" + room)
+	}
+	for i, j := 0, len(links)-1; i < j; i, j = i+1, j-1 {
+		links[i], links[j] = links[j], links[i]
+	}
+	return ctx.Reply(fmt.Sprintf("Recent links in #%s:\n  %s", room, strings.Join(links, "\n  ")))
+}
@@ -0,0 +1,80 @@
+package chat
+
+import (
+	"group-ssh-chat/storage"
+	"time"
+)
+
+// Kinds of messages
+const (
+	// A message a user sent to a room, stored in its history
+	ChatMessage = "chat"
+	// A notice from the server
+	SystemMessage = "system"
+	// A private message between two users
+	WhisperMessage = "whisper"
+	// Something a user did in a room, such as rolling dice
+	ActionMessage = "action"
+)
+
+// Keys of Message.Metadata
+const (
+	// Set to "true" on whispers that were queued while the recipient was
+	// offline. The message's time is when it was sent.
+	MetaQueued = "queued"
+	// Set to "true" on notices announced to every user
+	MetaAnnouncement = "announcement"
+)
+
+// A message as it travels from the hub to bots, filters, other nodes and
+// clients
+type Message struct {
+	// History ID and position in the room of chat messages, zero otherwise
+	ID  int64 `json:"id,omitempty"`
+	Seq int64 `json:"seq,omitempty"`
+
+	Type string    `json:"type"`
+	From string    `json:"from,omitempty"`
+	To   string    `json:"to,omitempty"` // recipient of a whisper
+	Room string    `json:"room,omitempty"`
+	Time time.Time `json:"time"`
+	Text string    `json:"text"`
+
+	// The message a chat message replies to
+	Quote *Quote `json:"quote,omitempty"`
+
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Returns a chat message for a message from the history
+func newChatMessage(stored storage.StoredMessage, quote *Quote) Message {
+	return Message{
+		ID:    stored.ID,
+		Seq:   stored.Seq,
+		Type:  ChatMessage,
+		From:  stored.From,
+		Room:  stored.Room,
+		Time:  stored.Time,
+		Text:  stored.Text,
+		Quote: quote,
+	}
+}
+
+// Returns a notice from the server
+func newSystemMessage(room string, text string) Message {
+	return Message{Type: SystemMessage, Room: room, Time: time.Now(), Text: text}
+}
+
+// Returns the form a chat message is stored in
+func (m Message) stored() storage.StoredMessage {
+	stored := storage.StoredMessage{ID: m.ID, Seq: m.Seq, Room: m.Room, From: m.From, Text: m.Text, Time: m.Time}
+	if m.Quote != nil {
+		stored.ReplyTo = m.Quote.ID
+	}
+	return stored
+}
+
+// Reports whether the metadata key is set to "true"
+func (m Message) Flag(key string) bool {
+	return m.Metadata[key] == "true"
+}
@@ -0,0 +1,63 @@
+package chat
+
+import (
+	"fmt"
+	"group-ssh-chat/commands"
+	"strings"
+)
+
+// Most lines a single multi-line message may have
+const maxMessageLines = 100
+
+// Most characters a message may have unless MAX_MESSAGE_LENGTH says otherwise
+const defaultMaxMessageLength = 4000
+
+// Line that ends /paste mode
+const pasteTerminator = "."
+
+// Reports whether the input line starts /paste mode. The mode belongs to
+// the session the line was typed in, so it is handled before commands are
+// dispatched by user.
+func isPasteCommand(line string) bool {
+	return strings.EqualFold(strings.TrimSpace(line), "/paste")
+}
+
+// Handles /paste with arguments; /paste alone is intercepted in HandleInput
+func (h *Hub) paste(ctx *commands.CommandContext, args []string) error {
+	return fmt.Errorf("Usage: /paste, then type the message and end it with a line containing only %q", pasteTerminator)
+}
+
+// Puts the session into /paste mode
+func (h *Hub) startPaste(sess *Session) {
+	sess.pasting = true
+	sess.pasted = nil
+	sess.client.WriteSystem(fmt.Sprintf("Paste mode: type or paste your message, then send it with a line containing only %q", pasteTerminator))
+}
+
+// Collects input while the session is in /paste mode and sends the
+// collected lines as one message once the terminator line arrives. Reports
+// whether the input was consumed.
+func (h *Hub) collectPaste(sess *Session, input string) bool {
+	if !sess.pasting {
+		return false
+	}
+	for _, line := range strings.Split(input, "\n") {
+		if line == pasteTerminator {
+			text := strings.Trim(strings.Join(sess.pasted, "\n"), "\n")
+			sess.pasting = false
+			sess.pasted = nil
+			if strings.TrimSpace(text) == "" {
+				sess.client.WriteSystem("Nothing was pasted")
+				return true
+			}
+			h.sendMessage(sess, text)
+			return true
+		}
+		if len(sess.pasted) >= maxMessageLines {
+			sess.client.WriteSystem(fmt.Sprintf("Messages are limited to %d lines, end the paste with a line containing only %q", maxMessageLines, pasteTerminator))
+			return true
+		}
+		sess.pasted = append(sess.pasted, line)
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/commands"
+	"strings"
+	"time"
+)
+
+// Returned by Ping when the connection has no way to reach the client
+var ErrPingUnsupported = errors.New("not supported by this connection")
+
+// Implemented by clients that can measure the network round trip to the
+// user, such as SSH terminals
+type LatencyProber interface {
+	// Sends a request the user's client must answer and returns how long
+	// the answer took
+	Ping() (time.Duration, error)
+}
+
+// Reports whether the input is a bare /ping, which is answered by the
+// session that typed it
+func isPingCommand(line string) bool {
+	return strings.EqualFold(strings.TrimSpace(line), "/ping")
+}
+
+// Handles /ping with arguments; /ping alone is intercepted in HandleInput
+func (h *Hub) pingUsage(ctx *commands.CommandContext, args []string) error {
+	return errors.New("Usage: /ping")
+}
+
+// Measures the round trip to the session's client and how long the server
+// took to get to the command, so users can tell network lag from a busy
+// server. Runs in the background as the answer may take a while.
+func (h *Hub) ping(sess *Session, received time.Time) {
+	prober, ok := sess.client.(LatencyProber)
+	if !ok {
+		sess.client.WriteSystem("Pong! This connection cannot measure the network round trip")
+		return
+	}
+	// Waiting for the hub shows how busy the server is.
+	h.activeClientsMutex.Lock()
+	h.activeClientsMutex.Unlock()
+	server := time.Since(received)
+
+	go func() {
+		network, err := prober.Ping()
+		if err != nil {
+			sess.client.WriteSystem(fmt.Sprintf("Pong! Server %s, the network round trip failed: %v", FormatLatency(server), err))
+			return
+		}
+		sess.client.WriteSystem(fmt.Sprintf("Pong! Network round trip %s, server %s", FormatLatency(network), FormatLatency(server)))
+	}()
+}
+
+// Formats a latency with a precision that suits its size, e.g. "42ms" or
+// "0.3ms"
+func FormatLatency(d time.Duration) string {
+	if d < 10*time.Millisecond {
+		return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+	}
+	return d.Round(time.Millisecond).String()
+}
@@ -0,0 +1,181 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/commands"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	minPollOptions = 2
+	maxPollOptions = 10
+
+	// Most characters of the question and of each option
+	maxPollQuestionLength = 200
+	maxPollOptionLength   = 80
+
+	// Width in cells of the bar of the option with the most votes
+	pollBarWidth = 20
+)
+
+// An open poll in a room. Every user may vote once.
+type poll struct {
+	Question  string
+	Options   []string
+	Votes     map[string]int
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// Handles /poll "question" option1 option2 ..., /poll to show the open poll
+// and /poll close to close it
+func (h *Hub) startPoll(ctx *commands.CommandContext, args []string) error {
+	if len(args) == 0 {
+		return h.showPoll(ctx)
+	}
+	if len(args) == 1 && args[0] == "close" {
+		return h.closePoll(ctx.User)
+	}
+
+	if len(args) < 1+minPollOptions || len(args) > 1+maxPollOptions {
+		return fmt.Errorf("Usage: /poll \"question\" option1 option2 ... (%d to %d options)", minPollOptions, maxPollOptions)
+	}
+	for _, arg := range args {
+		if strings.TrimSpace(arg) == "" {
+			return errors.New("The question and options cannot be empty")
+		}
+	}
+	if utf8.RuneCountInString(args[0]) > maxPollQuestionLength {
+		return fmt.Errorf("Poll questions are limited to %d characters", maxPollQuestionLength)
+	}
+	for _, option := range args[1:] {
+		if utf8.RuneCountInString(option) > maxPollOptionLength {
+			return fmt.Errorf("Poll options are limited to %d characters", maxPollOptionLength)
+		}
+	}
+	room := h.roomOf(ctx.User)
+	if err := h.checkPost(ctx.User, room, strings.Join(args, " ")); err != nil {
+		return err
+	}
+
+	h.activeClientsMutex.Lock()
+	if _, ok := h.polls[room]; ok {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("#%s already has an open poll, close it with /poll close first", room)
+	}
+	p := &poll{
+		Question:  args[0],
+		Options:   args[1:],
+		Votes:     map[string]int{},
+		CreatedBy: ctx.User,
+		CreatedAt: time.Now(),
+	}
+	h.polls[room] = p
+	text := p.render(ctx.User + " started a poll")
+	h.activeClientsMutex.Unlock()
+
+	h.broadcastSystemMessage(room, text+"\nVote with /vote <number>")
+	return nil
+}
+
+// Handles /vote <n> for the open poll in the sender's room. The tally goes
+// to the voter only, so votes do not flood the room or get around slow mode;
+// everyone can see it with /poll.
+func (h *Hub) vote(ctx *commands.CommandContext, args []string) error {
+	choice, _ := strconv.Atoi(args[0])
+
+	h.activeClientsMutex.Lock()
+	room := h.userRooms[ctx.User]
+	p, ok := h.polls[room]
+	if !ok {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("There is no open poll in #%s", room)
+	}
+	if choice < 1 || choice > len(p.Options) {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("Pick an option from 1 to %d", len(p.Options))
+	}
+	if _, voted := p.Votes[ctx.User]; voted {
+		h.activeClientsMutex.Unlock()
+		return errors.New("You have already voted in this poll")
+	}
+	p.Votes[ctx.User] = choice - 1
+	text := p.render(fmt.Sprintf("You voted (%d in total)", len(p.Votes)))
+	h.activeClientsMutex.Unlock()
+
+	return ctx.Reply(text)
+}
+
+// Shows the open poll of the sender's room to the sender
+func (h *Hub) showPoll(ctx *commands.CommandContext) error {
+	h.activeClientsMutex.Lock()
+	room := h.userRooms[ctx.User]
+	p, ok := h.polls[room]
+	var text string
+	if ok {
+		text = p.render(p.CreatedBy+"'s poll") + "\nVote with /vote <number>"
+	}
+	h.activeClientsMutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("There is no open poll in #%s", room)
+	}
+	return ctx.Reply(text)
+}
+
+// Closes the open poll of the sender's room and posts the final tally. Only
+// the poll's creator and room ops may close it.
+func (h *Hub) closePoll(sender string) error {
+	h.activeClientsMutex.Lock()
+	name := h.userRooms[sender]
+	p, ok := h.polls[name]
+	if !ok {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("There is no open poll in #%s", name)
+	}
+	room := h.rooms[name]
+	if p.CreatedBy != sender && (room == nil || !room.isOp(sender, h.isAdminLocked(sender))) {
+		h.activeClientsMutex.Unlock()
+		return errors.New("Only the poll's creator and room ops can close it")
+	}
+	delete(h.polls, name)
+	text := p.render(fmt.Sprintf("Poll closed by %s, final results", sender))
+	h.activeClientsMutex.Unlock()
+
+	h.broadcastSystemMessage(name, text)
+	return nil
+}
+
+// Renders the question and the tally of every option with a bar
+// proportional to its votes
+func (p *poll) render(heading string) string {
+	counts := make([]int, len(p.Options))
+	most := 0
+	for _, choice := range p.Votes {
+		counts[choice]++
+		if counts[choice] > most {
+			most = counts[choice]
+		}
+	}
+	width := 0
+	for _, option := range p.Options {
+		if n := utf8.RuneCountInString(option); n > width {
+			width = n
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(heading + ": " + p.Question)
+	for i, option := range p.Options {
+		bar := ""
+		if most > 0 {
+			bar = strings.Repeat("█", counts[i]*pollBarWidth/most)
+		}
+		fmt.Fprintf(&sb, "\n  %2d. %-*s %s %d", i+1, width, option, bar, counts[i])
+	}
+	return sb.String()
+}
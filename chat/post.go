@@ -0,0 +1,48 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/audit"
+	"strings"
+)
+
+// Posts a message to an existing room on behalf of a user who does not need
+// to be connected, e.g. a script using a one-shot SSH exec request. The same
+// rules apply as when typing in the room.
+func (h *Hub) Post(user string, room string, text string) error {
+	room = normalizeRoomName(room)
+	if room == "" {
+		return errors.New("Room name cannot be empty")
+	}
+	text = strings.TrimSpace(sanitizeInput(text))
+	if text == "" {
+		return errors.New("Message cannot be empty")
+	}
+	if strings.Count(text, "\n") >= maxMessageLines {
+		return fmt.Errorf("Messages are limited to %d lines", maxMessageLines)
+	}
+
+	h.activeClientsMutex.Lock()
+	openErr := h.checkRoomOpenLocked(room)
+	allowed := h.canAccessLocked(user, room)
+	h.activeClientsMutex.Unlock()
+	if openErr != nil {
+		return openErr
+	}
+	if !allowed {
+		return fmt.Errorf("#%s is private and you have not been invited", room)
+	}
+	if err := h.checkPost(user, room, text); err != nil {
+		return err
+	}
+	text, err := h.filterMessage(room, user, text)
+	if err != nil {
+		return err
+	}
+	if h.auditLog.LogsMessages() {
+		h.auditLog.Log(audit.Event{Type: audit.EventMessage, User: user, Message: text})
+	}
+	h.broadcastMessage(room, user, text, nil)
+	return nil
+}
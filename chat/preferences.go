@@ -0,0 +1,121 @@
+package chat
+
+import (
+	"fmt"
+	"group-ssh-chat/i18n"
+	"group-ssh-chat/ui"
+	"strings"
+)
+
+// A user's display settings keyed by setting name. Missing keys fall back to
+// the setting's default value.
+type Preferences map[string]string
+
+// A user configurable setting editable with /set
+type setting struct {
+	name        string
+	description string
+	def         string
+	values      []string
+
+	// For free-form settings, what the value looks like, how it is checked
+	// and whether it is case-sensitive
+	hint     string
+	validate func(value string) error
+	keepCase bool
+}
+
+// Settings available to /set, in the order they are listed
+var settings = []setting{
+	{name: "theme", description: "Color theme", def: ui.DefaultTheme, values: ui.ThemeNames()},
+	{name: "timestamps", description: "Show message timestamps", def: "on", values: []string{"on", "off"}},
+	{name: "clock", description: "Timestamp clock format", def: "24h", values: []string{"24h", "12h"}},
+	{name: "timeformat", description: "Timestamp format", def: "seconds", values: []string{"seconds", "minutes", "date", "iso"}},
+	{name: "tz", description: "Time zone, auto follows your client's TZ", def: "auto", hint: "auto|Area/City", validate: validateTimeZone, keepCase: true},
+	{name: "lang", description: "Language of system messages, auto follows your client's LANG", def: "auto", hint: "auto|en|de|...", validate: validateLanguage},
+	{name: "bell", description: "Ring the terminal bell on mentions and whispers", def: "off", values: []string{"on", "off"}},
+	{name: "emoji", description: "Expand :shortcodes: into emoji", def: "on", values: []string{"on", "off"}},
+	{name: "previews", description: "Show previews of shared images", def: "off", values: []string{"on", "off"}},
+	{name: "latency", description: "Show the network round trip in the prompt", def: "on", values: []string{"on", "off"}},
+	{name: "accessibility", description: "Screen reader friendly output", def: "off", values: []string{"on", "off"}},
+	{name: "output", description: "Output format, json writes a JSON object per line for bots", def: "text", values: []string{"text", "json"}},
+	{name: "presence", description: "Join and leave notices", def: presenceAll, values: presenceModes},
+}
+
+// Returns the value of the setting, or its default when unset
+func (p Preferences) Get(key string) string {
+	if v, ok := p[key]; ok {
+		return v
+	}
+	for _, s := range settings {
+		if s.name == key {
+			return s.def
+		}
+	}
+	return ""
+}
+
+// Reports whether an on/off setting is turned on
+func (p Preferences) Enabled(key string) bool {
+	return p.Get(key) == "on"
+}
+
+// Returns a /set value in canonical form. Values are case-insensitive
+// except for free-form ones like time zone names.
+func normalizeSetting(key string, value string) string {
+	for _, s := range settings {
+		if s.name == key && s.keepCase {
+			return value
+		}
+	}
+	return strings.ToLower(value)
+}
+
+// Validates a /set key and value pair
+func validateSetting(key string, value string) error {
+	for _, s := range settings {
+		if s.name != key {
+			continue
+		}
+		if s.validate != nil {
+			return s.validate(value)
+		}
+		for _, v := range s.values {
+			if v == value {
+				return nil
+			}
+		}
+		return i18n.Errorf("Invalid value for %s, expected one of: %s", key, strings.Join(s.values, ", "))
+	}
+	return i18n.Errorf("Unknown setting %s, type /set to list settings", key)
+}
+
+// Validates, stores and applies a preference to all of the user's sessions
+func (h *Hub) setPreference(user string, key string, value string) error {
+	if err := validateSetting(key, value); err != nil {
+		return err
+	}
+	if err := h.prefsStore.Set(user, key, value); err != nil {
+		return fmt.Errorf("Failed to save setting: %v", err)
+	}
+
+	prefs := h.preferencesOf(user)
+	for _, s := range h.userSessions(user) {
+		s.client.SetPreferences(prefs)
+		s.client.WriteSystem(h.tr(s, "%s set to %s", key, value))
+	}
+	return nil
+}
+
+// Returns what values the setting takes, for the /set listing
+func (s setting) choices() string {
+	if s.hint != "" {
+		return s.hint
+	}
+	return strings.Join(s.values, "|")
+}
+
+// Loads the user's stored preferences
+func (h *Hub) preferencesOf(user string) Preferences {
+	return Preferences(h.prefsStore.Get(user))
+}
@@ -0,0 +1,197 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/commands"
+	"group-ssh-chat/i18n"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How join and leave notices reach a user, from loudest to quietest. Users
+// pick theirs with /set presence and room ops set one for the room with
+// /presence. The quieter of the two wins.
+const (
+	presenceAll   = "all"
+	presenceBatch = "batch"
+	presenceOff   = "off"
+)
+
+var presenceModes = []string{presenceAll, presenceBatch, presenceOff}
+
+// A user joining or leaving a room
+type presenceEvent struct {
+	user   string
+	joined bool
+}
+
+// Collects the join and leave notices of each room for users who want them
+// batched and hands them to flush once per window
+type presencePolicy struct {
+	mu      sync.Mutex
+	pending map[string][]presenceEvent
+	flush   func(room string, events []presenceEvent, window time.Duration)
+}
+
+// Returns a policy handing the batched events of a room to flush
+func newPresencePolicy(flush func(room string, events []presenceEvent, window time.Duration)) *presencePolicy {
+	return &presencePolicy{
+		pending: map[string][]presenceEvent{},
+		flush:   flush,
+	}
+}
+
+// Queues an event, starting the room's window when it is the first one
+func (p *presencePolicy) add(room string, ev presenceEvent, window time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.pending[room]) == 0 {
+		time.AfterFunc(window, func() {
+			p.mu.Lock()
+			events := p.pending[room]
+			delete(p.pending, room)
+			p.mu.Unlock()
+			p.flush(room, events, window)
+		})
+	}
+	p.pending[room] = append(p.pending[room], ev)
+}
+
+// Announces a user joining or leaving the room to everyone else in it who
+// does not ignore them, right away or batched into a summary depending on
+// the room's and each recipient's presence setting
+func (h *Hub) broadcastPresence(room string, user string, joined bool) {
+	h.deliverPresence(room, user, joined)
+	h.publish(clusterEvent{Type: clusterPresence, Room: room, User: user, Joined: joined})
+}
+
+// Announces a user joining or leaving the room to the sessions of this node
+func (h *Hub) deliverPresence(room string, user string, joined bool) {
+	roomMode, window := h.roomPresence(room)
+	batched := false
+	for _, s := range h.sessionsOf(Except(h.audience(room, user), user)) {
+		switch h.presenceModeOf(s.User, roomMode) {
+		case presenceAll:
+			if joined {
+				s.client.WriteSystem(h.tr(s, "%s joined #%s", user, room))
+			} else {
+				s.client.WriteSystem(h.tr(s, "%s left #%s", user, room))
+			}
+		case presenceBatch:
+			batched = true
+		}
+	}
+	if batched {
+		h.presence.add(room, presenceEvent{user: user, joined: joined}, window)
+	}
+	h.notifyBotsPresence(room, user, joined)
+}
+
+// Sends the summary of a room's batched events to the users in it who get
+// batched notices
+func (h *Hub) flushPresence(room string, events []presenceEvent, window time.Duration) {
+	roomMode, _ := h.roomPresence(room)
+	for _, s := range h.sessionsOf(InRoom(room)) {
+		if h.presenceModeOf(s.User, roomMode) != presenceBatch {
+			continue
+		}
+		if summary := presenceSummary(h.languageOf(s), room, events, window); summary != "" {
+			s.client.WriteSystem(summary)
+		}
+	}
+}
+
+// Returns the presence mode of the room and the batching window
+func (h *Hub) roomPresence(name string) (string, time.Duration) {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	mode := presenceAll
+	if room, ok := h.rooms[name]; ok && room.Presence != "" {
+		mode = room.Presence
+	}
+	return mode, h.config.presenceWindow
+}
+
+// Returns how the user gets presence notices in a room with the given mode
+func (h *Hub) presenceModeOf(user string, roomMode string) string {
+	if h.inDND(user) {
+		return presenceOff
+	}
+	userMode := h.preferencesOf(user).Get("presence")
+	if presenceRank(userMode) > presenceRank(roomMode) {
+		return userMode
+	}
+	return roomMode
+}
+
+// Returns the position of the mode from loudest to quietest
+func presenceRank(mode string) int {
+	for i, m := range presenceModes {
+		if m == mode {
+			return i
+		}
+	}
+	return 0
+}
+
+// Summarizes batched events in the language, e.g. "3 users joined #lobby
+// in the last minute: alice, bob, carol"
+func presenceSummary(lang string, room string, events []presenceEvent, window time.Duration) string {
+	var joined, left []string
+	seenJoined, seenLeft := map[string]bool{}, map[string]bool{}
+	for _, ev := range events {
+		if ev.joined && !seenJoined[ev.user] {
+			seenJoined[ev.user] = true
+			joined = append(joined, ev.user)
+		} else if !ev.joined && !seenLeft[ev.user] {
+			seenLeft[ev.user] = true
+			left = append(left, ev.user)
+		}
+	}
+
+	period := i18n.Translate(lang, "in the last %s", window)
+	if window == time.Minute {
+		period = i18n.Translate(lang, "in the last minute")
+	}
+	var lines []string
+	if n := len(joined); n > 0 {
+		lines = append(lines, i18n.Plural(lang, n, "%d user joined #%s %s: %s", "%d users joined #%s %s: %s", n, room, period, strings.Join(joined, ", ")))
+	}
+	if n := len(left); n > 0 {
+		lines = append(lines, i18n.Plural(lang, n, "%d user left #%s %s: %s", "%d users left #%s %s: %s", n, room, period, strings.Join(left, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Handles /presence [all|batch|off], showing or changing how join and leave
+// notices are sent in the sender's current room
+func (h *Hub) setRoomPresence(ctx *commands.CommandContext, args []string) error {
+	if len(args) == 0 {
+		roomMode, _ := h.roomPresence(ctx.Room)
+		return ctx.Reply(fmt.Sprintf("Join and leave notices in #%s: %s. Yours: %s (change with /set presence).", ctx.Room, roomMode, h.preferencesOf(ctx.User).Get("presence")))
+	}
+	if len(args) != 1 || validateSetting("presence", args[0]) != nil {
+		return errors.New("Usage: /presence [all|batch|off]")
+	}
+
+	h.activeClientsMutex.Lock()
+	name := h.userRooms[ctx.User]
+	room := h.rooms[name]
+	if room == nil || !room.isOp(ctx.User, h.isAdminLocked(ctx.User)) {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("Only ops of #%s can change its join and leave notices", name)
+	}
+	room.Presence = args[0]
+	if room.Presence == presenceAll {
+		room.Presence = ""
+	}
+	h.saveRoomLocked(room)
+	h.activeClientsMutex.Unlock()
+
+	h.broadcastSystemMessage(name, fmt.Sprintf("%s set join and leave notices in #%s to %s", ctx.User, name, args[0]))
+	return nil
+}
@@ -0,0 +1,54 @@
+package chat
+
+import (
+	"group-ssh-chat/ui"
+	"log"
+)
+
+// Most images previewed per message
+const maxPreviewsPerMessage = 3
+
+// Shows previews of the allowlisted image links in a new message to the
+// sessions in its room that turned previews on. Images are fetched in the
+// background, so previews appear after the message.
+func (h *Hub) previewLinks(msg Message) {
+	if h.previews == nil {
+		return
+	}
+	previewed := 0
+	for _, url := range ui.FindURLs(msg.Text) {
+		if previewed == maxPreviewsPerMessage {
+			break
+		}
+		if h.previews.Allowed(url) {
+			previewed++
+			go h.showPreview(msg.Room, msg.From, url)
+		}
+	}
+}
+
+// Fetches an image and writes it to everyone in the room who wants previews
+func (h *Hub) showPreview(room string, from string, url string) {
+	if len(h.previewAudience(room, from)) == 0 {
+		return
+	}
+	img, err := h.previews.Fetch(url)
+	if err != nil {
+		log.Printf("Failed to preview %s: %v", url, err)
+		return
+	}
+	for _, s := range h.previewAudience(room, from) {
+		s.client.WriteImage(url, img)
+	}
+}
+
+// Returns the sessions in the room whose users turned previews on
+func (h *Hub) previewAudience(room string, from string) []*Session {
+	var sessions []*Session
+	for _, s := range h.sessionsOf(h.audience(room, from)) {
+		if h.preferencesOf(s.User).Enabled("previews") {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions
+}
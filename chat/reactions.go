@@ -0,0 +1,154 @@
+package chat
+
+import (
+	"fmt"
+	"group-ssh-chat/commands"
+	"group-ssh-chat/storage"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Longest emoji or shortcode accepted as a reaction, in characters
+	maxReactionLength = 32
+
+	// Number of different reactions a single message may collect
+	maxReactionsPerMessage = 20
+
+	// Number of users shown by /karma
+	karmaLeaderboardSize = 10
+)
+
+// Matches a message giving karma to a user, e.g. "alice++"
+var karmaPattern = regexp.MustCompile(`^@?([a-zA-Z0-9_-]{1,32})\+\+$`)
+
+// Handles /react <id> <emoji>. Reacting again with the same emoji takes the
+// reaction back.
+func (h *Hub) react(ctx *commands.CommandContext, args []string) error {
+	id, err := parseMessageID(args[0])
+	if err != nil {
+		return err
+	}
+	emoji := args[1]
+	if utf8.RuneCountInString(emoji) > maxReactionLength {
+		return fmt.Errorf("Reactions are limited to %d characters", maxReactionLength)
+	}
+
+	room := ctx.Room
+	msg, err := h.history.Get(id)
+	if err != nil || msg.Room != room {
+		return fmt.Errorf("Message [%d] not found in #%s", id, room)
+	}
+	if !hasReaction(msg, emoji) && len(msg.Reactions) >= maxReactionsPerMessage {
+		return fmt.Errorf("Message [%d] already has %d different reactions", id, maxReactionsPerMessage)
+	}
+	// Reactions are announced in the room, so the rules for posting apply
+	if err := h.checkPost(ctx.User, room, emoji); err != nil {
+		return err
+	}
+
+	msg, added, err := h.history.React(id, ctx.User, emoji)
+	if err != nil {
+		return err
+	}
+	verb := "reacted " + emoji + " to"
+	if !added {
+		verb = "took back " + emoji + " from"
+	}
+	text := fmt.Sprintf("%s [%d] %s", verb, msg.ID, msg.From)
+	if tally := ReactionTally(msg.Reactions); tally != "" {
+		text += ": " + tally
+	}
+	h.broadcastAction(room, ctx.User, text)
+	return nil
+}
+
+// Handles /karma [user], showing the leaderboard or a single user's karma
+func (h *Hub) karma(ctx *commands.CommandContext, args []string) error {
+	karma := h.karmaByUser()
+	if len(args) == 1 {
+		return ctx.Reply(fmt.Sprintf("%s has %d karma", args[0], karma[args[0]]))
+	}
+	if len(karma) == 0 {
+		return ctx.Reply("Nobody has any karma yet")
+	}
+
+	users := make([]string, 0, len(karma))
+	for user := range karma {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if karma[users[i]] != karma[users[j]] {
+			return karma[users[i]] > karma[users[j]]
+		}
+		return users[i] < users[j]
+	})
+	if len(users) > karmaLeaderboardSize {
+		users = users[:karmaLeaderboardSize]
+	}
+	var sb strings.Builder
+	sb.WriteString("Karma leaderboard:")
+	for i, user := range users {
+		fmt.Fprintf(&sb, "\n  %2d. %s %d", i+1, user, karma[user])
+	}
+	return ctx.Reply(sb.String())
+}
+
+// Announces the new karma of the user a "user++" message was about
+func (h *Hub) announceKarma(room string, from string, text string) {
+	target := karmaTarget(from, text)
+	if target == "" {
+		return
+	}
+	h.broadcastSystemMessage(room, fmt.Sprintf("%s now has %d karma", target, h.karmaByUser()[target]))
+}
+
+// Tallies karma from the history: every reaction by another user to a
+// user's message and every "user++" by someone else is worth a point
+func (h *Hub) karmaByUser() map[string]int {
+	karma := map[string]int{}
+	h.history.Scan(func(msg storage.StoredMessage) {
+		for _, r := range msg.Reactions {
+			for _, user := range r.Users {
+				if user != msg.From {
+					karma[msg.From]++
+				}
+			}
+		}
+		if target := karmaTarget(msg.From, msg.Text); target != "" {
+			karma[target]++
+		}
+	})
+	return karma
+}
+
+// Returns the user a "user++" message gives karma to, or "" when the text is
+// not one or the sender tries to give karma to themselves
+func karmaTarget(from string, text string) string {
+	match := karmaPattern.FindStringSubmatch(strings.TrimSpace(text))
+	if match == nil || match[1] == from {
+		return ""
+	}
+	return match[1]
+}
+
+// Reports whether the message already has a reaction with the emoji
+func hasReaction(msg storage.StoredMessage, emoji string) bool {
+	for _, r := range msg.Reactions {
+		if r.Emoji == emoji {
+			return true
+		}
+	}
+	return false
+}
+
+// Renders the reactions of a message, e.g. "👍 2  🎉 1"
+func ReactionTally(reactions []storage.Reaction) string {
+	parts := make([]string, len(reactions))
+	for i, r := range reactions {
+		parts[i] = fmt.Sprintf("%s %d", r.Emoji, len(r.Users))
+	}
+	return strings.Join(parts, "  ")
+}
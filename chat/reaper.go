@@ -0,0 +1,56 @@
+package chat
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// How often sessions are pinged unless SESSION_KEEPALIVE says otherwise
+const defaultKeepaliveInterval = time.Minute
+
+// How long to wait before checking again while SESSION_KEEPALIVE is 0
+const keepaliveDisabledRecheck = time.Minute
+
+// Pings the sessions every SESSION_KEEPALIVE and closes those whose client
+// stopped answering. A value of 0 turns the pings off.
+func (h *Hub) reapStaleSessionsPeriodically() {
+	for {
+		h.activeClientsMutex.Lock()
+		interval := h.config.keepaliveInterval
+		h.activeClientsMutex.Unlock()
+
+		if interval <= 0 {
+			time.Sleep(keepaliveDisabledRecheck)
+			continue
+		}
+		time.Sleep(interval)
+		h.reapStaleSessions()
+	}
+}
+
+// Sends every session that can be pinged a request its client must answer
+// and closes the sessions that fail to, which removes them from the hub.
+// This catches connections that dropped without the server noticing, whose
+// users would otherwise stay listed until TCP gives up on them.
+func (h *Hub) reapStaleSessions() {
+	var wg sync.WaitGroup
+	for _, s := range h.sessionsOf(Everyone()) {
+		prober, ok := s.client.(LatencyProber)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(s *Session) {
+			defer wg.Done()
+			_, err := prober.Ping()
+			if err == nil || errors.Is(err, ErrPingUnsupported) {
+				return
+			}
+			log.Printf("Closing session %s of %s, it did not answer a keepalive: %v", s.ID, s.User, err)
+			s.client.Close()
+		}(s)
+	}
+	wg.Wait()
+}
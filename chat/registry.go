@@ -0,0 +1,201 @@
+package chat
+
+import "sync"
+
+// The sessions connected to this node by user, with an index of the users in
+// each room. The state is owned by a single goroutine and every operation is
+// a request sent to it over a channel. The registry never calls back into
+// the hub or takes its locks, so deliveries look up their audience without
+// activeClientsMutex, and join and leave may still use it with the mutex
+// held without a lock ordering to get wrong.
+type sessionRegistry struct {
+	requests chan func(state *registryState)
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// The state owned by the registry goroutine. The session slices are never
+// modified in place, so they stay valid once handed out.
+type registryState struct {
+	byUser map[string][]*Session
+	rooms  map[string]string          // user -> room
+	byRoom map[string]map[string]bool // room -> users
+}
+
+// Returns a registry and starts the goroutine owning it
+func newSessionRegistry() *sessionRegistry {
+	r := &sessionRegistry{
+		requests: make(chan func(*registryState)),
+		stopped:  make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Serves requests one at a time until the registry is stopped
+func (r *sessionRegistry) run() {
+	state := &registryState{
+		byUser: make(map[string][]*Session),
+		rooms:  make(map[string]string),
+		byRoom: make(map[string]map[string]bool),
+	}
+	for {
+		select {
+		case request := <-r.requests:
+			request(state)
+		case <-r.stopped:
+			return
+		}
+	}
+}
+
+// Ends the goroutine owning the sessions. Later requests find no sessions.
+func (r *sessionRegistry) stop() {
+	r.stopOnce.Do(func() { close(r.stopped) })
+}
+
+// Runs fn on the goroutine owning the sessions and waits for it to finish.
+// Does nothing once the registry is stopped.
+func (r *sessionRegistry) do(fn func(state *registryState)) {
+	done := make(chan struct{})
+	select {
+	case r.requests <- func(state *registryState) {
+		fn(state)
+		close(done)
+	}:
+		<-done
+	case <-r.stopped:
+	}
+}
+
+// Adds a session unless its user already has max sessions; a max of 0
+// means no limit. Reports whether it is the user's first session.
+func (r *sessionRegistry) add(sess *Session, max int) (first bool, err error) {
+	r.do(func(state *registryState) {
+		existing := state.byUser[sess.User]
+		if max > 0 && len(existing) >= max {
+			err = ErrTooManySessions
+			return
+		}
+		first = len(existing) == 0
+		state.byUser[sess.User] = append(existing, sess)
+	})
+	return first, err
+}
+
+// Removes a session and returns how many sessions its user has left
+func (r *sessionRegistry) remove(sess *Session) (remaining int) {
+	r.do(func(state *registryState) {
+		var kept []*Session
+		for _, s := range state.byUser[sess.User] {
+			if s.ID != sess.ID {
+				kept = append(kept, s)
+			}
+		}
+		if len(kept) == 0 {
+			delete(state.byUser, sess.User)
+		} else {
+			state.byUser[sess.User] = kept
+		}
+		remaining = len(kept)
+	})
+	return remaining
+}
+
+// Moves the user to the room in the index; an empty room is none
+func (r *sessionRegistry) setRoom(user string, room string) {
+	r.do(func(state *registryState) {
+		from := state.rooms[user]
+		if from == room {
+			return
+		}
+		if members := state.byRoom[from]; members != nil {
+			delete(members, user)
+			if len(members) == 0 {
+				delete(state.byRoom, from)
+			}
+		}
+		if room == "" {
+			delete(state.rooms, user)
+			return
+		}
+		state.rooms[user] = room
+		if state.byRoom[room] == nil {
+			state.byRoom[room] = make(map[string]bool)
+		}
+		state.byRoom[room][user] = true
+	})
+}
+
+// Returns the sessions of a user
+func (r *sessionRegistry) of(user string) []*Session {
+	var sessions []*Session
+	r.do(func(state *registryState) {
+		// Capped so appending to the result cannot write into the registry's
+		// array.
+		list := state.byUser[user]
+		sessions = list[:len(list):len(list)]
+	})
+	return sessions
+}
+
+// Reports whether the user has a session
+func (r *sessionRegistry) has(user string) bool {
+	found := false
+	r.do(func(state *registryState) {
+		found = len(state.byUser[user]) > 0
+	})
+	return found
+}
+
+// Returns the users with a session
+func (r *sessionRegistry) users() []string {
+	var users []string
+	r.do(func(state *registryState) {
+		users = make([]string, 0, len(state.byUser))
+		for user := range state.byUser {
+			users = append(users, user)
+		}
+	})
+	return users
+}
+
+// Returns the users of this node in the room
+func (r *sessionRegistry) usersIn(room string) []string {
+	var users []string
+	r.do(func(state *registryState) {
+		users = make([]string, 0, len(state.byRoom[room]))
+		for user := range state.byRoom[room] {
+			users = append(users, user)
+		}
+	})
+	return users
+}
+
+// Returns the sessions of users in the room
+func (r *sessionRegistry) inRoom(room string) []*Session {
+	var sessions []*Session
+	r.do(func(state *registryState) {
+		members := state.byRoom[room]
+		if len(members) == 0 {
+			return
+		}
+		sessions = make([]*Session, 0, len(members))
+		for user := range members {
+			sessions = append(sessions, state.byUser[user]...)
+		}
+	})
+	return sessions
+}
+
+// Returns every session
+func (r *sessionRegistry) all() []*Session {
+	var sessions []*Session
+	r.do(func(state *registryState) {
+		sessions = make([]*Session, 0, len(state.byUser))
+		for _, list := range state.byUser {
+			sessions = append(sessions, list...)
+		}
+	})
+	return sessions
+}
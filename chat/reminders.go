@@ -0,0 +1,114 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/commands"
+	"group-ssh-chat/storage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Longest delay a reminder may be scheduled for
+const maxReminderDelay = 30 * 24 * time.Hour
+
+// Parses "/remind [@user] <duration> <message>" and schedules the reminder
+func (h *Hub) remind(ctx *commands.CommandContext, args []string) error {
+	if len(args) == 0 {
+		return h.listReminders(ctx)
+	}
+
+	to := ctx.User
+	if strings.HasPrefix(args[0], "@") {
+		to = strings.TrimPrefix(args[0], "@")
+		args = args[1:]
+	}
+	if len(args) < 2 || to == "" {
+		return errors.New("Usage: /remind [@user] <duration> <message>")
+	}
+
+	delay, err := parseReminderDelay(args[0])
+	if err != nil {
+		return err
+	}
+	due := time.Now().Add(delay)
+	if _, err := h.reminders.Add(ctx.User, to, strings.Join(args[1:], " "), due); err != nil {
+		return fmt.Errorf("Failed to save reminder: %v", err)
+	}
+
+	target := "you"
+	if to != ctx.User {
+		target = to
+	}
+	if s := h.invokingSession(ctx); s != nil {
+		s.client.WriteSystem(fmt.Sprintf("Okay, I'll remind %s at %s", target, h.sessionTime(s, due).Format("Jan 2 15:04")))
+	}
+	return nil
+}
+
+// Shows the reminders waiting for the user
+func (h *Hub) listReminders(ctx *commands.CommandContext) error {
+	pending := h.reminders.Pending(ctx.User)
+	if len(pending) == 0 {
+		return errors.New("You have no pending reminders. Usage: /remind [@user] <duration> <message>")
+	}
+
+	if s := h.invokingSession(ctx); s != nil {
+		var sb strings.Builder
+		sb.WriteString("Your pending reminders:")
+		for _, r := range pending {
+			sb.WriteString(fmt.Sprintf("\n  %s  %s", h.sessionTime(s, r.Due).Format("Jan 2 15:04"), r.Text))
+		}
+		s.client.WriteSystem(sb.String())
+	}
+	return nil
+}
+
+// Sends a due reminder to all of the recipient's sessions, reporting false
+// when the recipient is offline so it can be delivered at their next login
+func (h *Hub) deliverReminder(r storage.Reminder) bool {
+	sessions := h.userSessions(r.To)
+	if len(sessions) == 0 {
+		return false
+	}
+	for _, s := range sessions {
+		s.client.WriteSystem(formatReminder(r))
+	}
+	return true
+}
+
+// Delivers the reminders that came due while the user was offline
+func (h *Hub) deliverOverdueReminders(user string, sess *Session) {
+	for _, r := range h.reminders.TakeOverdue(user) {
+		sess.client.WriteSystem(fmt.Sprintf("%s (due %s)", formatReminder(r), h.sessionTime(sess, r.Due).Format("Jan 2 15:04")))
+	}
+}
+
+func formatReminder(r storage.Reminder) string {
+	if r.From != r.To {
+		return fmt.Sprintf("Reminder from %s: %s", r.From, r.Text)
+	}
+	return "Reminder: " + r.Text
+}
+
+// Parses delays like "15m", "1h30m" or "2d"
+func parseReminderDelay(arg string) (time.Duration, error) {
+	var delay time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(arg, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		delay = time.Duration(n) * 24 * time.Hour
+	} else {
+		delay, err = time.ParseDuration(arg)
+	}
+
+	if err != nil || delay <= 0 {
+		return 0, fmt.Errorf("Invalid duration %q, use e.g. 15m, 1h30m or 2d", arg)
+	}
+	if delay > maxReminderDelay {
+		return 0, errors.New("Reminders can be at most 30 days out")
+	}
+	return delay, nil
+}
@@ -0,0 +1,83 @@
+package chat
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Value a client sends instead of a token to ask for one without resuming
+const NewResumeToken = "new"
+
+// Prefix of the system message carrying a session's resume token, followed
+// by the token itself
+const ResumeTokenNotice = "Resume token: "
+
+// State kept for a user whose last session ended while holding a resume
+// token, until they come back with it or the grace period runs out
+type detachedUser struct {
+	token   string
+	room    string
+	search  *searchResults
+	expires *time.Timer
+}
+
+// Returns a new random resume token
+func newResumeToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Parks the user's state instead of removing it when they hold a resume
+// token, so reconnecting within the grace period picks up where they left
+// off. Reports whether the user was detached. Must be called with
+// activeClientsMutex held.
+func (h *Hub) detachLocked(user string) bool {
+	token, ok := h.resumeTokens[user]
+	if !ok || h.config.resumeGrace <= 0 {
+		return false
+	}
+	d := &detachedUser{
+		token:  token,
+		room:   h.userRooms[user],
+		search: h.searches[user],
+	}
+	d.expires = time.AfterFunc(h.config.resumeGrace, func() { h.expireDetached(user, d) })
+	h.detached[user] = d
+	return true
+}
+
+// Ends the grace period of a detached user and announces them leaving
+func (h *Hub) expireDetached(user string, d *detachedUser) {
+	h.activeClientsMutex.Lock()
+	if h.detached[user] != d {
+		h.activeClientsMutex.Unlock()
+		return
+	}
+	delete(h.detached, user)
+	if h.resumeTokens[user] == d.token {
+		delete(h.resumeTokens, user)
+	}
+	h.activeClientsMutex.Unlock()
+
+	h.broadcastPresence(d.room, user, false)
+	h.forgetGuest(user)
+}
+
+// Takes the detached state of the user. Returns it when the token matches
+// and the user may still access the room, or nil together with the room
+// the user silently left when there was state that cannot be resumed. Must
+// be called with activeClientsMutex held.
+func (h *Hub) takeDetachedLocked(user string, token string) (resumed *detachedUser, abandoned string) {
+	d, ok := h.detached[user]
+	if !ok {
+		return nil, ""
+	}
+	d.expires.Stop()
+	delete(h.detached, user)
+	if token == "" || token != d.token || !h.canAccessLocked(user, d.room) {
+		return nil, d.room
+	}
+	return d, ""
+}
@@ -0,0 +1,93 @@
+package chat
+
+import (
+	"fmt"
+	"group-ssh-chat/commands"
+)
+
+// Most missed messages replayed by a single resync
+const maxResyncReplay = 200
+
+// Writes a chat message to a session and keeps track of which sequence
+// numbers reached it. Once output has been dropped, later messages are only
+// sent as part of a resync so the session never sees them out of order.
+func (h *Hub) deliverChat(s *Session, msg Message, cache *RenderCache) {
+	s.syncMutex.Lock()
+	if s.syncRoom != msg.Room {
+		s.syncRoom, s.syncSeq, s.syncID, s.syncGap = msg.Room, msg.Seq-1, 0, false
+	}
+	gap := s.syncGap
+	s.syncMutex.Unlock()
+
+	if gap {
+		h.resyncSession(s)
+		return
+	}
+
+	ok := s.client.WriteMessage(msg, cache)
+
+	s.syncMutex.Lock()
+	defer s.syncMutex.Unlock()
+	if s.syncRoom != msg.Room || s.syncGap {
+		return
+	}
+	if ok {
+		s.syncSeq, s.syncID = msg.Seq, msg.ID
+	} else {
+		s.syncGap = true
+	}
+}
+
+// Replays the messages of the session's current room it has missed.
+// Reports how many were replayed.
+func (h *Hub) resyncSession(s *Session) int {
+	s.syncMutex.Lock()
+	defer s.syncMutex.Unlock()
+
+	if s.syncRoom == "" {
+		return 0
+	}
+	missed := h.history.SinceSeq(s.syncRoom, s.syncSeq, maxResyncReplay)
+	if len(missed) == 0 {
+		s.syncGap = false
+		return 0
+	}
+	title := fmt.Sprintf("Resynced %d missed messages in #%s", len(missed), s.syncRoom)
+	if !s.client.WriteHistory(title, missed) {
+		s.syncGap = true
+		return 0
+	}
+	last := missed[len(missed)-1]
+	s.syncSeq, s.syncID, s.syncGap = last.Seq, last.ID, false
+	return len(missed)
+}
+
+// Handles /resync by replaying what any of the sender's sessions missed
+func (h *Hub) resync(ctx *commands.CommandContext, args []string) error {
+	replayed := 0
+	for _, s := range h.userSessions(ctx.User) {
+		replayed += h.resyncSession(s)
+	}
+	if replayed == 0 {
+		return ctx.Reply("You are up to date")
+	}
+	return nil
+}
+
+// Moves the user's read cursor back to the last message the session
+// received when it closes with undelivered messages, so they are replayed
+// as unread on the next login
+func (h *Hub) rewindReadCursor(s *Session) {
+	s.syncMutex.Lock()
+	room, id, gap := s.syncRoom, s.syncID, s.syncGap
+	s.syncMutex.Unlock()
+	if !gap {
+		return
+	}
+
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	if cur, ok := h.readCursors[s.User][room]; ok && cur > id {
+		h.readCursors[s.User][room] = id
+	}
+}
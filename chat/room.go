@@ -0,0 +1,453 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/commands"
+	"group-ssh-chat/i18n"
+	"group-ssh-chat/storage"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Name of the room users join on connect unless DEFAULT_ROOM names another
+const DefaultRoom = "lobby"
+
+// A named chat room that users can join. Private rooms can only be joined
+// by their creator and invited members. The creator and anyone they /op can
+// moderate the room. In read-only rooms, e.g. #announcements, only they can
+// post. In slow mode everyone else may post once per SlowMode.
+type Room struct {
+	Name      string
+	Topic     string
+	Welcome   string
+	CreatedBy string
+	CreatedAt time.Time
+	Private   bool
+	ReadOnly  bool
+	SlowMode  time.Duration
+	Presence  string
+	Members   map[string]bool
+	Ops       map[string]bool
+	Muted     map[string]time.Time
+
+	// When the last message was sent, and when that was last saved
+	LastActivity  time.Time
+	savedActivity time.Time
+
+	// Archived rooms are hidden and cannot be joined until restored
+	Archived bool
+
+	// When members last posted, for slow mode. Not persisted.
+	lastPost map[string]time.Time
+}
+
+// Returns a new room created by the user
+func newRoom(name string, createdBy string) *Room {
+	return &Room{
+		Name:      name,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+		Members:   map[string]bool{},
+		Ops:       map[string]bool{},
+		Muted:     map[string]time.Time{},
+		lastPost:  map[string]time.Time{},
+	}
+}
+
+// Normalizes a user supplied room name, e.g. "#General" -> "general"
+func normalizeRoomName(name string) string {
+	name = strings.TrimPrefix(strings.TrimSpace(name), "#")
+	return strings.ToLower(name)
+}
+
+// Converts a stored room into a room
+func roomFromStored(sr storage.StoredRoom) *Room {
+	room := newRoom(sr.Name, sr.CreatedBy)
+	room.Topic = sr.Topic
+	room.Welcome = sr.Welcome
+	room.CreatedAt = sr.CreatedAt
+	room.Private = sr.Private
+	room.ReadOnly = sr.ReadOnly
+	room.SlowMode = time.Duration(sr.SlowMode) * time.Second
+	room.Presence = sr.Presence
+	room.LastActivity = sr.LastActivity
+	room.savedActivity = sr.LastActivity
+	room.Archived = sr.Archived
+	for _, member := range sr.Members {
+		room.Members[member] = true
+	}
+	for _, op := range sr.Ops {
+		room.Ops[op] = true
+	}
+	for user, until := range sr.Muted {
+		room.Muted[user] = until
+	}
+	return room
+}
+
+// Converts the room into its persisted form
+func (r *Room) stored() storage.StoredRoom {
+	sr := storage.StoredRoom{
+		Name:      r.Name,
+		Topic:     r.Topic,
+		Welcome:   r.Welcome,
+		CreatedBy: r.CreatedBy,
+		CreatedAt: r.CreatedAt,
+		Private:   r.Private,
+		ReadOnly:  r.ReadOnly,
+		SlowMode:  int(r.SlowMode / time.Second),
+		Presence:  r.Presence,
+
+		LastActivity: r.LastActivity,
+		Archived:     r.Archived,
+	}
+	for member := range r.Members {
+		sr.Members = append(sr.Members, member)
+	}
+	sort.Strings(sr.Members)
+	for op := range r.Ops {
+		sr.Ops = append(sr.Ops, op)
+	}
+	sort.Strings(sr.Ops)
+	if len(r.Muted) > 0 {
+		sr.Muted = make(map[string]time.Time, len(r.Muted))
+		for user, until := range r.Muted {
+			sr.Muted[user] = until
+		}
+	}
+	return sr
+}
+
+// Loads persisted rooms, making sure the configured rooms exist
+func (h *Hub) loadRooms() {
+	for _, sr := range h.roomStore.All() {
+		h.rooms[sr.Name] = roomFromStored(sr)
+	}
+	h.createConfiguredRoomsLocked()
+}
+
+// Creates the default room and those listed in AUTO_ROOMS that do not exist
+// yet. Must be called with activeClientsMutex held.
+func (h *Hub) createConfiguredRoomsLocked() {
+	for _, name := range append([]string{h.config.defaultRoom}, h.config.autoRooms...) {
+		if room, ok := h.rooms[name]; !ok {
+			h.rooms[name] = newRoom(name, "")
+			h.saveRoomLocked(h.rooms[name])
+		} else if room.Archived {
+			room.Archived = false
+			h.saveRoomLocked(room)
+		}
+	}
+	if h.rooms[h.config.defaultRoom].Private {
+		log.Printf("DEFAULT_ROOM #%s is private, new users will not be able to read it", h.config.defaultRoom)
+	}
+}
+
+// Returns the room users join on connect. Must be called with
+// activeClientsMutex held.
+func (h *Hub) defaultRoomLocked() string {
+	return h.config.defaultRoom
+}
+
+// Returns the room users join on connect
+func (h *Hub) defaultRoom() string {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	return h.defaultRoomLocked()
+}
+
+// Persists the room. Must be called with activeClientsMutex held.
+func (h *Hub) saveRoomLocked(room *Room) {
+	if err := h.roomStore.Save(room.stored()); err != nil {
+		log.Printf("Failed to save room #%s: %v", room.Name, err)
+	}
+}
+
+// Reports whether the user may join and read the room, e.g. for clients of
+// the APIs that read it without joining
+func (h *Hub) CanAccess(user string, name string) bool {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	return h.canAccessLocked(user, normalizeRoomName(name))
+}
+
+// Reports whether the user may join and read the room. Rooms that do not
+// exist yet are open to everyone. Must be called with activeClientsMutex held.
+func (h *Hub) canAccessLocked(user string, name string) bool {
+	room, ok := h.rooms[name]
+	if !ok || !room.Private {
+		return true
+	}
+	return room.CreatedBy == user || room.Members[user] || h.isAdminLocked(user)
+}
+
+// Moves the user into a room, creating it if needed. Announcing the move is
+// left to the caller. Must be called with activeClientsMutex held.
+func (h *Hub) enterRoomLocked(user string, name string) error {
+	if !h.canAccessLocked(user, name) {
+		return i18n.Errorf("#%s is private and you have not been invited", name)
+	}
+	if room, ok := h.rooms[name]; ok && room.Archived {
+		return h.checkRoomOpenLocked(name)
+	}
+	if _, ok := h.rooms[name]; !ok {
+		h.rooms[name] = newRoom(name, user)
+		h.saveRoomLocked(h.rooms[name])
+	}
+	h.setRoomLocked(user, name)
+	return nil
+}
+
+// Reports whether the user is an operator of the room. The creator is
+// always an op and server admins have op powers everywhere.
+func (r *Room) isOp(user string, admin bool) bool {
+	return admin || r.Ops[user] || (r.CreatedBy != "" && r.CreatedBy == user)
+}
+
+// Handles /readonly [on|off], showing or changing whether only ops may post
+// in the sender's current room
+func (h *Hub) setReadOnly(ctx *commands.CommandContext, args []string) error {
+	if len(args) == 0 {
+		name := ctx.Room
+		if h.isReadOnly(name) {
+			return ctx.Reply(fmt.Sprintf("#%s is read-only, only its ops can post", name))
+		}
+		return ctx.Reply(fmt.Sprintf("Everyone can post in #%s", name))
+	}
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return errors.New("Usage: /readonly [on|off]")
+	}
+
+	h.activeClientsMutex.Lock()
+	name := h.userRooms[ctx.User]
+	room := h.rooms[name]
+	if room == nil || !room.isOp(ctx.User, h.isAdminLocked(ctx.User)) {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("Only ops of #%s can change who can post", name)
+	}
+	room.ReadOnly = args[0] == "on"
+	h.saveRoomLocked(room)
+	h.activeClientsMutex.Unlock()
+
+	if room.ReadOnly {
+		h.broadcastSystemMessage(name, "#"+name+" is now read-only, only its ops can post")
+	} else {
+		h.broadcastSystemMessage(name, "Everyone can post in #"+name+" again")
+	}
+	return nil
+}
+
+// Reports whether only ops may post in the room
+func (h *Hub) isReadOnly(name string) bool {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	room := h.rooms[name]
+	return room != nil && room.ReadOnly
+}
+
+// Returns an error when the user may not post in the room, because it is
+// read-only or the user posted too recently in slow mode. Otherwise the post
+// counts towards slow mode. Messages, replies and commands announcing
+// something in the room, e.g. /roll or /react, reach it through checkPost.
+func (h *Hub) checkCanPost(user string, name string) error {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	room := h.rooms[name]
+	if room == nil || room.isOp(user, h.isAdminLocked(user)) {
+		return nil
+	}
+	if room.ReadOnly {
+		return i18n.Errorf("Sorry, #%s is read-only and only its ops can post here", name)
+	}
+	if room.SlowMode > 0 {
+		if wait := room.SlowMode - time.Since(room.lastPost[user]); wait > 0 {
+			return i18n.Errorf("#%s is in slow mode, you can post again in %s", name, wait.Round(time.Second))
+		}
+		room.lastPost[user] = time.Now()
+	}
+	return nil
+}
+
+// Handles /slowmode [<seconds>|off], showing or changing how often members
+// who are not ops may post in the sender's current room
+func (h *Hub) setSlowMode(ctx *commands.CommandContext, args []string) error {
+	if len(args) == 0 {
+		h.activeClientsMutex.Lock()
+		name := h.userRooms[ctx.User]
+		var interval time.Duration
+		if room := h.rooms[name]; room != nil {
+			interval = room.SlowMode
+		}
+		h.activeClientsMutex.Unlock()
+		if interval == 0 {
+			return ctx.Reply(fmt.Sprintf("Slow mode is off in #%s", name))
+		}
+		return ctx.Reply(fmt.Sprintf("Slow mode in #%s: one message every %s", name, interval))
+	}
+	seconds := 0
+	if args[0] != "off" {
+		var err error
+		seconds, err = strconv.Atoi(args[0])
+		if err != nil || seconds < 0 || len(args) != 1 {
+			return errors.New("Usage: /slowmode [<seconds>|off]")
+		}
+	}
+
+	h.activeClientsMutex.Lock()
+	name := h.userRooms[ctx.User]
+	room := h.rooms[name]
+	if room == nil || !room.isOp(ctx.User, h.isAdminLocked(ctx.User)) {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("Only ops of #%s can change its slow mode", name)
+	}
+	room.SlowMode = time.Duration(seconds) * time.Second
+	h.saveRoomLocked(room)
+	h.activeClientsMutex.Unlock()
+
+	if seconds == 0 {
+		h.broadcastSystemMessage(name, fmt.Sprintf("%s turned off slow mode in #%s", ctx.User, name))
+	} else {
+		h.broadcastSystemMessage(name, fmt.Sprintf("%s turned on slow mode in #%s, everyone can post once every %s", ctx.User, name, room.SlowMode))
+	}
+	return nil
+}
+
+// Makes the user's current room private or public again
+func (h *Hub) setPrivate(ctx *commands.CommandContext, args []string) error {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return errors.New("Usage: /private on|off")
+	}
+
+	h.activeClientsMutex.Lock()
+	name := h.userRooms[ctx.User]
+	room := h.rooms[name]
+	if room == nil || !room.isOp(ctx.User, h.isAdminLocked(ctx.User)) {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("Only ops of #%s can change its access", name)
+	}
+	if name == h.defaultRoomLocked() {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("#%s cannot be made private", name)
+	}
+	room.Private = args[0] == "on"
+	if room.Private {
+		// Everyone already in the room keeps access.
+		for user, in := range h.userRooms {
+			if in == name {
+				room.Members[user] = true
+			}
+		}
+	}
+	h.saveRoomLocked(room)
+	h.activeClientsMutex.Unlock()
+
+	if room.Private {
+		h.broadcastSystemMessage(name, "#"+name+" is now private. Use /invite to let others in.")
+	} else {
+		h.broadcastSystemMessage(name, "#"+name+" is now public")
+	}
+	return nil
+}
+
+// Adds a user to the invite list of the sender's current room
+func (h *Hub) invite(ctx *commands.CommandContext, args []string) error {
+	user := args[0]
+
+	h.activeClientsMutex.Lock()
+	name := h.userRooms[ctx.User]
+	room := h.rooms[name]
+	if room == nil || !room.Private {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("#%s is not private", name)
+	}
+	if !room.isOp(ctx.User, h.isAdminLocked(ctx.User)) {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("Only ops of #%s can invite users", name)
+	}
+	if room.Members[user] {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("%s is already invited to #%s", user, name)
+	}
+	room.Members[user] = true
+	h.saveRoomLocked(room)
+	h.activeClientsMutex.Unlock()
+
+	h.replySystem(user, fmt.Sprintf("%s invited you to #%s. Type /join #%s to enter.", ctx.User, name, name))
+	return ctx.Reply(fmt.Sprintf("%s invited to #%s", user, name))
+}
+
+// Removes a user from the invite list of the sender's current room, moving
+// them back to the default room if they are in it
+func (h *Hub) uninvite(ctx *commands.CommandContext, args []string) error {
+	user := args[0]
+
+	h.activeClientsMutex.Lock()
+	name := h.userRooms[ctx.User]
+	room := h.rooms[name]
+	if room == nil || !room.Private {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("#%s is not private", name)
+	}
+	if !room.isOp(ctx.User, h.isAdminLocked(ctx.User)) {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("Only ops of #%s can uninvite users", name)
+	}
+	if !room.Members[user] {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("%s is not invited to #%s", user, name)
+	}
+	delete(room.Members, user)
+	h.saveRoomLocked(room)
+	evicted := h.userRooms[user] == name && !h.canAccessLocked(user, name)
+	fallback := h.defaultRoomLocked()
+	if evicted {
+		h.setRoomLocked(user, fallback)
+	}
+	h.activeClientsMutex.Unlock()
+
+	if evicted {
+		h.broadcastSystemMessage(name, user+" was removed from #"+name)
+		h.broadcastPresence(fallback, user, true)
+		h.replySystem(user, fmt.Sprintf("You were removed from #%s and moved to #%s", name, fallback))
+	}
+	return ctx.Reply(fmt.Sprintf("%s uninvited from #%s", user, name))
+}
+
+// Shows the sessions of a user who just joined a room its topic, whether
+// they may post and its welcome message
+func (h *Hub) introduceRoom(sessions []*Session, name string) {
+	h.activeClientsMutex.Lock()
+	var topic, welcome string
+	readOnly := false
+	if room, ok := h.rooms[name]; ok {
+		topic, welcome, readOnly = room.Topic, room.Welcome, room.ReadOnly
+	}
+	h.activeClientsMutex.Unlock()
+
+	for _, s := range sessions {
+		if topic != "" {
+			s.client.WriteSystem(h.tr(s, "Topic: %s", topic))
+		}
+		if readOnly {
+			s.client.WriteSystem(h.tr(s, "#%s is read-only, only its ops can post", name))
+		}
+		if welcome != "" {
+			s.client.WriteSystem(welcome)
+		}
+	}
+}
+
+// Returns the topic of the room
+func (h *Hub) topicOf(name string) string {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	if room, ok := h.rooms[name]; ok {
+		return room.Topic
+	}
+	return ""
+}
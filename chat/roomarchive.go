@@ -0,0 +1,134 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/commands"
+	"group-ssh-chat/i18n"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How often rooms are checked for archival
+const roomArchiveInterval = time.Hour
+
+// Archives idle rooms on startup and every roomArchiveInterval after, while
+// ROOM_ARCHIVE_AFTER is set
+func (h *Hub) archiveIdleRoomsPeriodically() {
+	ticker := time.NewTicker(roomArchiveInterval)
+	defer ticker.Stop()
+	for {
+		h.archiveIdleRooms()
+		<-ticker.C
+	}
+}
+
+// Archives the rooms nobody is in that have had no messages for
+// ROOM_ARCHIVE_AFTER. Archived rooms keep their history but are hidden and
+// cannot be joined until an op restores them. The default room and those
+// in AUTO_ROOMS are never archived.
+func (h *Hub) archiveIdleRooms() {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	after := h.config.roomArchiveAfter
+	if after <= 0 {
+		return
+	}
+	kept := map[string]bool{h.config.defaultRoom: true}
+	for _, name := range h.config.autoRooms {
+		kept[name] = true
+	}
+	occupied := map[string]bool{}
+	for _, room := range h.userRooms {
+		occupied[room] = true
+	}
+	for _, d := range h.detached {
+		occupied[d.room] = true
+	}
+	for room, sessions := range h.tails {
+		if len(sessions) > 0 {
+			occupied[room] = true
+		}
+	}
+	for sub := range h.subscriptions {
+		occupied[sub.room] = true
+	}
+
+	for name, room := range h.rooms {
+		if room.Archived || kept[name] || occupied[name] || len(h.remoteUsersInLocked(name)) > 0 {
+			continue
+		}
+		lastActive := room.LastActivity
+		if lastActive.IsZero() {
+			lastActive = room.CreatedAt
+		}
+		if time.Since(lastActive) < after {
+			continue
+		}
+		room.Archived = true
+		h.saveRoomLocked(room)
+		log.Printf("Archived #%s, inactive since %s", name, lastActive.Format(time.DateTime))
+	}
+}
+
+// Returns an error when the room does not exist or is archived. Must be
+// called with activeClientsMutex held.
+func (h *Hub) checkRoomOpenLocked(name string) error {
+	room, ok := h.rooms[name]
+	if !ok {
+		return fmt.Errorf("#%s does not exist", name)
+	}
+	if room.Archived {
+		return i18n.Errorf("#%s is archived, its ops can restore it with /room restore %s", name, name)
+	}
+	return nil
+}
+
+// Handles /room restore [<name>], bringing back an archived room or listing
+// those the sender may restore
+func (h *Hub) room(ctx *commands.CommandContext, args []string) error {
+	if len(args) == 0 || args[0] != "restore" || len(args) > 2 {
+		return i18n.Errorf("Usage: %s", "/room restore [<name>]")
+	}
+	admin := h.isAdmin(ctx.User)
+	if len(args) == 1 {
+		var names []string
+		h.activeClientsMutex.Lock()
+		for name, room := range h.rooms {
+			if room.Archived && room.isOp(ctx.User, admin) {
+				names = append(names, "#"+name)
+			}
+		}
+		h.activeClientsMutex.Unlock()
+		if len(names) == 0 {
+			return errors.New("There are no archived rooms you can restore")
+		}
+		sort.Strings(names)
+		return ctx.Reply("Archived rooms you can restore: " + strings.Join(names, ", "))
+	}
+
+	name := normalizeRoomName(args[1])
+	h.activeClientsMutex.Lock()
+	room, ok := h.rooms[name]
+	if !ok || !room.Archived {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("#%s is not archived", name)
+	}
+	if !room.isOp(ctx.User, admin) {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("Only ops of #%s can restore it", name)
+	}
+	room.Archived = false
+	// Count the restore as activity so the room is not archived again
+	// right away.
+	room.LastActivity = time.Now()
+	room.savedActivity = room.LastActivity
+	h.saveRoomLocked(room)
+	h.activeClientsMutex.Unlock()
+
+	log.Printf("%s restored #%s", ctx.User, name)
+	return ctx.Reply(fmt.Sprintf("Restored #%s, /join it to go there", name))
+}
@@ -0,0 +1,89 @@
+package chat
+
+import (
+	"fmt"
+	"group-ssh-chat/commands"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// Longest a room's last activity goes unsaved, so busy rooms do not write
+// the room store for every message
+const activitySaveInterval = time.Minute
+
+// Longest topic shown by /rooms, in cells
+const maxListedTopicWidth = 40
+
+// Records a message sent at t as the room's latest activity
+func (h *Hub) touchRoom(name string, t time.Time) {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	room, ok := h.rooms[name]
+	if !ok || !t.After(room.LastActivity) {
+		return
+	}
+	room.LastActivity = t
+	if t.Sub(room.savedActivity) >= activitySaveInterval {
+		room.savedActivity = t
+		h.saveRoomLocked(room)
+	}
+}
+
+// Returns the rooms anyone may join, most recently active first
+func (h *Hub) PublicRooms() []RoomInfo {
+	var public []RoomInfo
+	for _, info := range h.Rooms() {
+		if !info.Private && !info.Archived {
+			public = append(public, info)
+		}
+	}
+	// Rooms are sorted by name, which breaks ties.
+	sort.SliceStable(public, func(i, j int) bool {
+		return public[i].LastActivity.After(public[j].LastActivity)
+	})
+	return public
+}
+
+// Handles /rooms, listing the public rooms with how many users are in them,
+// when they were last active and their topics
+func (h *Hub) listRooms(ctx *commands.CommandContext, args []string) error {
+	rooms := h.PublicRooms()
+	if s := h.invokingSession(ctx); s != nil {
+		var sb strings.Builder
+		sb.WriteString(h.trPlural(s, len(rooms), "%d public room, most active first:", "%d public rooms, most active first:", len(rooms)))
+		for _, room := range rooms {
+			activity := h.tr(s, "no messages yet")
+			if !room.LastActivity.IsZero() {
+				activity = h.tr(s, "%s ago", formatAge(time.Since(room.LastActivity)))
+			}
+			line := fmt.Sprintf("\n  %-20s %s  %-16s %s",
+				"#"+room.Name,
+				h.tr(s, "%3d online", room.Online),
+				activity,
+				runewidth.Truncate(room.Topic, maxListedTopicWidth, "…"))
+			sb.WriteString(strings.TrimRight(line, " "))
+		}
+		s.client.WriteSystem(sb.String())
+	}
+	return nil
+}
+
+// Returns a rough age like "45s", "3m", "2h" or "5d"
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d/time.Second))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	}
+	return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+}
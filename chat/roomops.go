@@ -0,0 +1,247 @@
+package chat
+
+import (
+	"fmt"
+	"group-ssh-chat/commands"
+	"group-ssh-chat/i18n"
+	"strings"
+	"time"
+)
+
+// Returns the sender's current room if they are an op there. Must be called
+// with activeClientsMutex held.
+func (h *Hub) opRoomLocked(sender string) (*Room, error) {
+	name := h.userRooms[sender]
+	room := h.rooms[name]
+	if room == nil || !room.isOp(sender, h.isAdminLocked(sender)) {
+		return nil, fmt.Errorf("You are not an op of #%s", name)
+	}
+	return room, nil
+}
+
+// Grants or revokes op status in the sender's current room
+func (h *Hub) setOp(grant bool) commands.CommandHandler {
+	return func(ctx *commands.CommandContext, args []string) error {
+		user := args[0]
+
+		h.activeClientsMutex.Lock()
+		room, err := h.opRoomLocked(ctx.User)
+		if err != nil {
+			h.activeClientsMutex.Unlock()
+			return err
+		}
+		if user == room.CreatedBy {
+			h.activeClientsMutex.Unlock()
+			return fmt.Errorf("%s created #%s and is always an op", user, room.Name)
+		}
+		if room.Ops[user] == grant {
+			h.activeClientsMutex.Unlock()
+			if grant {
+				return fmt.Errorf("%s is already an op of #%s", user, room.Name)
+			}
+			return fmt.Errorf("%s is not an op of #%s", user, room.Name)
+		}
+		if grant {
+			room.Ops[user] = true
+		} else {
+			delete(room.Ops, user)
+		}
+		h.saveRoomLocked(room)
+		h.activeClientsMutex.Unlock()
+
+		if grant {
+			h.broadcastSystemMessage(room.Name, fmt.Sprintf("%s made %s an op of #%s", ctx.User, user, room.Name))
+		} else {
+			h.broadcastSystemMessage(room.Name, fmt.Sprintf("%s removed %s as op of #%s", ctx.User, user, room.Name))
+		}
+		return nil
+	}
+}
+
+// Moves a user out of the sender's current room and back to the default room
+func (h *Hub) kick(ctx *commands.CommandContext, args []string) error {
+	user := args[0]
+	reason := strings.Join(args[1:], " ")
+
+	h.activeClientsMutex.Lock()
+	room, err := h.opRoomLocked(ctx.User)
+	if err != nil {
+		h.activeClientsMutex.Unlock()
+		return err
+	}
+	fallback := h.defaultRoomLocked()
+	if room.Name == fallback {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("Users cannot be kicked from #%s", fallback)
+	}
+	if h.userRooms[user] != room.Name {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("%s is not in #%s", user, room.Name)
+	}
+	if user == room.CreatedBy || h.isAdminLocked(user) {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("%s cannot be kicked from #%s", user, room.Name)
+	}
+	h.setRoomLocked(user, fallback)
+	h.activeClientsMutex.Unlock()
+
+	notice := fmt.Sprintf("%s was kicked from #%s by %s", user, room.Name, ctx.User)
+	if reason != "" {
+		notice += " (" + reason + ")"
+	}
+	h.broadcastSystemMessage(room.Name, notice)
+	h.broadcastPresence(fallback, user, true)
+	return h.replySystem(user, notice+". You are now in #"+fallback)
+}
+
+// Handles /welcome [<text>|-], showing or changing what users are told
+// when they join the sender's current room
+func (h *Hub) welcome(ctx *commands.CommandContext, args []string) error {
+	if len(args) == 0 {
+		name := ctx.Room
+		h.activeClientsMutex.Lock()
+		welcome := ""
+		if room, ok := h.rooms[name]; ok {
+			welcome = room.Welcome
+		}
+		h.activeClientsMutex.Unlock()
+		if welcome == "" {
+			return ctx.Reply("#" + name + " has no welcome message")
+		}
+		return ctx.Reply("Welcome message of #" + name + ": " + welcome)
+	}
+
+	h.activeClientsMutex.Lock()
+	room, err := h.opRoomLocked(ctx.User)
+	if err != nil {
+		h.activeClientsMutex.Unlock()
+		return err
+	}
+	room.Welcome = strings.Join(args, " ")
+	if room.Welcome == "-" {
+		room.Welcome = ""
+	}
+	h.saveRoomLocked(room)
+	h.activeClientsMutex.Unlock()
+
+	if room.Welcome == "" {
+		return ctx.Reply("Cleared the welcome message of #" + room.Name)
+	}
+	return ctx.Reply("Users joining #" + room.Name + " will see: " + room.Welcome)
+}
+
+// Mutes a user in the sender's current room, optionally for a limited time
+func (h *Hub) mute(ctx *commands.CommandContext, args []string) error {
+	user := args[0]
+	var until time.Time
+	if len(args) == 2 {
+		d, err := time.ParseDuration(args[1])
+		if err != nil || d <= 0 {
+			return fmt.Errorf("Invalid duration %q, e.g. 10m or 1h", args[1])
+		}
+		until = time.Now().Add(d)
+	}
+
+	h.activeClientsMutex.Lock()
+	room, err := h.opRoomLocked(ctx.User)
+	if err != nil {
+		h.activeClientsMutex.Unlock()
+		return err
+	}
+	if room.isOp(user, h.isAdminLocked(user)) {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("%s is an op of #%s and cannot be muted", user, room.Name)
+	}
+	room.Muted[user] = until
+	h.saveRoomLocked(room)
+	h.activeClientsMutex.Unlock()
+
+	notice := fmt.Sprintf("%s was muted in #%s by %s", user, room.Name, ctx.User)
+	if !until.IsZero() {
+		notice += " for " + args[1]
+	}
+	h.broadcastSystemMessage(room.Name, notice)
+	if h.roomOf(user) != room.Name {
+		h.replySystem(user, notice)
+	}
+	return nil
+}
+
+// Lifts a mute in the sender's current room
+func (h *Hub) unmute(ctx *commands.CommandContext, args []string) error {
+	user := args[0]
+
+	h.activeClientsMutex.Lock()
+	room, err := h.opRoomLocked(ctx.User)
+	if err != nil {
+		h.activeClientsMutex.Unlock()
+		return err
+	}
+	if _, ok := room.Muted[user]; !ok {
+		h.activeClientsMutex.Unlock()
+		return fmt.Errorf("%s is not muted in #%s", user, room.Name)
+	}
+	delete(room.Muted, user)
+	h.saveRoomLocked(room)
+	h.activeClientsMutex.Unlock()
+
+	h.broadcastSystemMessage(room.Name, fmt.Sprintf("%s was unmuted in #%s by %s", user, room.Name, ctx.User))
+	return nil
+}
+
+// Returns an error if the user is muted in the room. Expired mutes are
+// cleared on the way.
+func (h *Hub) checkNotMuted(user string, name string) error {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	room := h.rooms[name]
+	if room == nil {
+		return nil
+	}
+	until, ok := room.Muted[user]
+	if !ok {
+		return nil
+	}
+	if !until.IsZero() && time.Now().After(until) {
+		delete(room.Muted, user)
+		h.saveRoomLocked(room)
+		return nil
+	}
+	if until.IsZero() {
+		return i18n.Errorf("You are muted in #%s", name)
+	}
+	return i18n.Errorf("You are muted in #%s for another %s", name, time.Until(until).Round(time.Second))
+}
+
+// Shows or, for ops, changes the topic of the sender's current room
+func (h *Hub) topic(ctx *commands.CommandContext, args []string) error {
+	if len(args) == 0 {
+		name := ctx.Room
+		topic := h.topicOf(name)
+		if topic == "" {
+			return ctx.Reply("#" + name + " has no topic")
+		}
+		return ctx.Reply("Topic of #" + name + ": " + topic)
+	}
+
+	h.activeClientsMutex.Lock()
+	room, err := h.opRoomLocked(ctx.User)
+	if err != nil {
+		h.activeClientsMutex.Unlock()
+		return err
+	}
+	room.Topic = strings.Join(args, " ")
+	if room.Topic == "-" {
+		room.Topic = ""
+	}
+	h.saveRoomLocked(room)
+	h.activeClientsMutex.Unlock()
+
+	if room.Topic == "" {
+		h.broadcastSystemMessage(room.Name, ctx.User+" cleared the topic of #"+room.Name)
+	} else {
+		h.broadcastSystemMessage(room.Name, ctx.User+" set the topic of #"+room.Name+": "+room.Topic)
+	}
+	return nil
+}
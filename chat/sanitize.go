@@ -0,0 +1,88 @@
+package chat
+
+import (
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Cleans text typed or posted by users before it is handled: terminal escape
+// sequences and control characters other than newlines and tabs are removed
+// so they cannot corrupt other users' terminals, invalid UTF-8 is replaced
+// and the text is normalized to NFC so equal looking text compares equal.
+func sanitizeInput(text string) string {
+	text = strings.ToValidUTF8(text, "�")
+
+	var sb strings.Builder
+	sb.Grow(len(text))
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\x1b':
+			i = skipEscape(runes, i)
+		case r == '\u009b':
+			// Single character CSI
+			i = skipCSI(runes, i+1)
+		case r == '\u009d' || r == '\u0090' || r == '\u009e' || r == '\u009f':
+			// Single character OSC, DCS, PM and APC
+			i = skipString(runes, i+1)
+		case r == '\n' || r == '\t':
+			sb.WriteRune(r)
+		case r < 0x20 || r == 0x7f || r >= 0x80 && r < 0xa0:
+			// C0 and C1 control characters
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return norm.NFC.String(sb.String())
+}
+
+// Skips the escape sequence starting with ESC at i and returns the index of
+// its last rune
+func skipEscape(runes []rune, i int) int {
+	if i+1 >= len(runes) || runes[i+1] < 0x20 {
+		return i
+	}
+	switch runes[i+1] {
+	case '[':
+		return skipCSI(runes, i+2)
+	case ']', 'P', '^', '_', 'X':
+		return skipString(runes, i+2)
+	}
+	// Two character sequences, possibly with intermediate bytes, e.g. ESC ( B
+	j := i + 1
+	for j < len(runes)-1 && runes[j] >= 0x20 && runes[j] <= 0x2f {
+		j++
+	}
+	return j
+}
+
+// Skips the parameters of a control sequence starting at i up to and
+// including its final byte and returns the index of the last rune
+func skipCSI(runes []rune, i int) int {
+	for ; i < len(runes); i++ {
+		if runes[i] >= 0x40 && runes[i] <= 0x7e {
+			return i
+		}
+		if runes[i] < 0x20 || runes[i] > 0x7e {
+			// Malformed, e.g. a newline in the middle
+			return i - 1
+		}
+	}
+	return len(runes) - 1
+}
+
+// Skips a control string like an OSC hyperlink or window title starting at
+// i, which ends with BEL or ST, and returns the index of the last rune
+func skipString(runes []rune, i int) int {
+	for ; i < len(runes); i++ {
+		switch {
+		case runes[i] == '\a' || runes[i] == '\u009c':
+			return i
+		case runes[i] == '\x1b' && i+1 < len(runes) && runes[i+1] == '\\':
+			return i + 1
+		}
+	}
+	return len(runes) - 1
+}
@@ -0,0 +1,44 @@
+package chat
+
+import "testing"
+
+func TestSanitizeInput(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"plain", "hello world", "hello world"},
+		{"newlines and tabs kept", "a\tb\nc", "a\tb\nc"},
+		{"csi color", "\x1b[31mred\x1b[0m", "red"},
+		{"csi clear screen", "a\x1b[2J\x1b[Hb", "ab"},
+		{"csi private mode", "\x1b[?1049hx", "x"},
+		{"csi unterminated", "a\x1b[12;", "a"},
+		{"csi broken by newline", "a\x1b[1\nb", "a\nb"},
+		{"osc title with bel", "\x1b]0;pwned\x07text", "text"},
+		{"osc hyperlink with st", "\x1b]8;;http://evil\x1b\\link\x1b]8;;\x1b\\", "link"},
+		{"osc unterminated", "a\x1b]0;title", "a"},
+		{"dcs", "\x1bPq#0;2;0;0;0\x1b\\after", "after"},
+		{"apc and pm", "\x1b_apc\x1b\\\x1b^pm\x1b\\x", "x"},
+		{"charset selection", "\x1b(Bx", "x"},
+		{"keypad mode", "\x1b=x", "x"},
+		{"bare esc at end", "x\x1b", "x"},
+		{"esc before control", "x\x1b\ny", "x\ny"},
+		{"c0 controls", "a\x00b\x07c\x08d\re\x7f", "abcde"},
+		{"c1 csi", "a\u009b31mb", "ab"},
+		{"c1 osc", "a\u009d0;title\u009cb", "ab"},
+		{"c1 dcs", "a\u0090q\u009cb", "ab"},
+		{"c1 other", "a\u0085b\u0080c", "abc"},
+		{"invalid utf-8", "a\xffb\xc3", "a�b�"},
+		{"escape hidden in invalid utf-8", "\xff\x1b[31mx", "�x"},
+		{"nfc", "cafe\u0301", "caf\u00e9"},
+		{"unicode kept", "héllo 世界 👋", "héllo 世界 👋"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeInput(tt.input); got != tt.want {
+				t.Errorf("sanitizeInput(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/commands"
+	"group-ssh-chat/storage"
+	"regexp"
+	"strings"
+)
+
+// Number of search results shown per page
+const searchPageSize = 15
+
+// The results of a user's last /search, kept so /more can page through them
+type searchResults struct {
+	title string
+	msgs  []storage.StoredMessage
+	shown int
+}
+
+// Implemented by history stores keeping a full-text index, which answer
+// plain text searches with ranked results
+type rankedSearcher interface {
+	SearchRanked(room string, query string) []storage.StoredMessage
+}
+
+// Parses "/search [-r] <query> [#room]" arguments and runs the search
+func (h *Hub) search(ctx *commands.CommandContext, args []string) error {
+	regex := false
+	if len(args) > 0 && (args[0] == "-r" || args[0] == "--regex") {
+		regex = true
+		args = args[1:]
+	}
+
+	room := ctx.Room
+	if len(args) > 1 && strings.HasPrefix(args[len(args)-1], "#") {
+		room = normalizeRoomName(args[len(args)-1])
+		args = args[:len(args)-1]
+	}
+	if len(args) == 0 {
+		return errors.New("Usage: /search [-r] <query> [#room]")
+	}
+
+	h.activeClientsMutex.Lock()
+	_, exists := h.rooms[room]
+	allowed := h.canAccessLocked(ctx.User, room)
+	h.activeClientsMutex.Unlock()
+	if !exists || !allowed {
+		return fmt.Errorf("Room #%s does not exist", room)
+	}
+
+	query := strings.Join(args, " ")
+	match, err := searchMatcher(query, regex)
+	if err != nil {
+		return err
+	}
+
+	results := &searchResults{
+		title: fmt.Sprintf("Search results for %q in #%s", query, room),
+	}
+	if index, ok := h.history.(rankedSearcher); ok && !regex {
+		results.title += ", best matches first"
+		results.msgs = append(index.SearchRanked(room, query), h.searchArchived(room, match)...)
+	} else {
+		results.msgs = append(h.searchArchived(room, match), h.history.Search(room, match)...)
+	}
+	if len(results.msgs) == 0 {
+		return fmt.Errorf("No messages matching %q in #%s", query, room)
+	}
+
+	h.activeClientsMutex.Lock()
+	h.searches[ctx.User] = results
+	h.activeClientsMutex.Unlock()
+
+	return h.showSearchPage(ctx)
+}
+
+// Shows the next page of the user's last search results
+func (h *Hub) showSearchPage(ctx *commands.CommandContext) error {
+	h.activeClientsMutex.Lock()
+	results, ok := h.searches[ctx.User]
+	if !ok {
+		h.activeClientsMutex.Unlock()
+		return errors.New("No more results, start a new /search")
+	}
+	start := results.shown
+	end := start + searchPageSize
+	if end >= len(results.msgs) {
+		end = len(results.msgs)
+		delete(h.searches, ctx.User)
+	}
+	results.shown = end
+	h.activeClientsMutex.Unlock()
+
+	title := fmt.Sprintf("%s (%d-%d of %d)", results.title, start+1, end, len(results.msgs))
+	if end < len(results.msgs) {
+		title += ", type /more for the next page"
+	}
+	if s := h.invokingSession(ctx); s != nil {
+		s.client.WriteHistory(title, results.msgs[start:end])
+	}
+	return nil
+}
+
+// Returns a case-insensitive matcher for a plain text or regex query
+func searchMatcher(query string, regex bool) (func(string) bool, error) {
+	if regex {
+		re, err := regexp.Compile("(?i)" + query)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regular expression: %v", err)
+		}
+		return re.MatchString, nil
+	}
+
+	query = strings.ToLower(query)
+	return func(text string) bool {
+		return strings.Contains(strings.ToLower(text), query)
+	}, nil
+}
@@ -0,0 +1,77 @@
+package chat
+
+import (
+	"group-ssh-chat/storage"
+	"group-ssh-chat/ui"
+	"image"
+	"sync"
+	"time"
+)
+
+// A transport specific connection (SSH terminal, WebSocket, ...) that renders
+// hub output for a single session. Writes must not block the hub; WriteChat
+// and WriteHistory report false when the output had to be dropped. WriteChat
+// is called concurrently for every recipient of a broadcast and may share
+// formatted output with them through the cache.
+type Client interface {
+	// Writes a message of any type. Chat messages sent to many sessions
+	// share a cache for their rendered form. Reports whether the message
+	// was written rather than dropped.
+	WriteMessage(msg Message, cache *RenderCache) bool
+	WriteSystem(text string)
+	WriteUserList(room string, users []string)
+	WriteHistory(title string, msgs []storage.StoredMessage) bool
+	WriteDivider(label string)
+	WriteImage(url string, img image.Image)
+	SetPreferences(prefs Preferences)
+	Clear()
+	Close() error
+}
+
+// Implemented by clients that pass on environment variables set on the
+// user's side, such as LANG, TZ or CHAT_ROOM
+type EnvironmentSource interface {
+	Environment() map[string]string
+}
+
+// Implemented by clients that know what their terminal can render
+type CapabilityReporter interface {
+	Capabilities() ui.Capabilities
+}
+
+// Implemented by clients that know how many lines their terminal shows. A
+// height of 0 means output is not paged, as for programs reading the chat.
+type HeightReporter interface {
+	Height() int
+}
+
+// A single connected client of a user. A user may have several sessions open.
+type Session struct {
+	ID          string
+	User        string
+	RemoteAddr  string
+	ConnectedAt time.Time
+	client      Client
+
+	// Environment variables sent by the client, see EnvironmentSource
+	Env map[string]string
+
+	// Delivery state of chat messages in the session's current room
+	syncMutex sync.Mutex
+	syncRoom  string
+	syncSeq   int64
+	syncID    int64
+	syncGap   bool
+
+	// Lines collected in /paste mode. Only touched by the goroutine
+	// handling the session's input.
+	pasting bool
+	pasted  []string
+}
+
+// The message a reply refers to, rendered above the reply for context
+type Quote struct {
+	ID   int64  `json:"id"`
+	From string `json:"from,omitempty"`
+	Text string `json:"text,omitempty"`
+}
@@ -0,0 +1,48 @@
+package chat
+
+import (
+	"fmt"
+	"group-ssh-chat/commands"
+	"strings"
+	"time"
+)
+
+// Number of rooms and users listed by /stats
+const statsTopN = 10
+
+// Handles /stats
+func (h *Hub) showStats(ctx *commands.CommandContext, args []string) error {
+	s := h.stats.Snapshot()
+
+	var sb strings.Builder
+	sb.WriteString("Server statistics:")
+	sb.WriteString(fmt.Sprintf("\n  Uptime          %s (since %s)", s.Uptime.Round(time.Second), s.StartedAt.Format(time.DateTime)))
+	sb.WriteString(fmt.Sprintf("\n  Online users    %d (%d sessions)", s.OnlineUsers, s.Sessions))
+	sb.WriteString(fmt.Sprintf("\n  Peak users      %d", s.PeakUsers))
+	sb.WriteString(fmt.Sprintf("\n  Messages today  %d", s.MessagesToday))
+	if lookups := s.HistoryCacheHits + s.HistoryCacheMisses; lookups > 0 {
+		sb.WriteString(fmt.Sprintf("\n  History cache   %d%% hits (%d reads)", s.HistoryCacheHits*100/lookups, lookups))
+	}
+
+	if len(s.Rooms) > 0 {
+		sb.WriteString("\nMessages per room today:")
+		for i, c := range s.Rooms {
+			if i == statsTopN {
+				sb.WriteString(fmt.Sprintf("\n  … and %d more", len(s.Rooms)-statsTopN))
+				break
+			}
+			sb.WriteString(fmt.Sprintf("\n  #%-15s %d", c.Name, c.Count))
+		}
+	}
+	if len(s.Users) > 0 {
+		sb.WriteString("\nMessages per user today:")
+		for i, c := range s.Users {
+			if i == statsTopN {
+				sb.WriteString(fmt.Sprintf("\n  … and %d more", len(s.Users)-statsTopN))
+				break
+			}
+			sb.WriteString(fmt.Sprintf("\n  %-16s %d", c.Name, c.Count))
+		}
+	}
+	return ctx.Reply(sb.String())
+}
@@ -0,0 +1,72 @@
+package chat
+
+import (
+	"group-ssh-chat/storage"
+)
+
+// Number of messages a subscription holds for its reader before it is
+// dropped for falling behind
+const subscriptionBuffer = 256
+
+// A feed of the chat messages sent to a room, for services consuming the
+// chat programmatically
+type Subscription struct {
+	room     string
+	messages chan storage.StoredMessage
+}
+
+// Returns the channel the room's messages arrive on. It is closed when the
+// subscription is cancelled or when the reader fell too far behind; readers
+// catch up from the history with the ID of the last message they got.
+func (sub *Subscription) Messages() <-chan storage.StoredMessage {
+	return sub.messages
+}
+
+// Subscribes to the chat messages sent to an existing room from now on, on
+// this or any other node of the cluster
+func (h *Hub) Subscribe(room string) (*Subscription, error) {
+	sub := &Subscription{
+		room:     normalizeRoomName(room),
+		messages: make(chan storage.StoredMessage, subscriptionBuffer),
+	}
+
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	if err := h.checkRoomOpenLocked(sub.room); err != nil {
+		return nil, err
+	}
+	h.subscriptions[sub] = true
+	return sub, nil
+}
+
+// Ends the subscription and closes its channel
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+	h.closeSubscriptionLocked(sub)
+}
+
+func (h *Hub) closeSubscriptionLocked(sub *Subscription) {
+	if h.subscriptions[sub] {
+		delete(h.subscriptions, sub)
+		close(sub.messages)
+	}
+}
+
+// Hands a chat message to the subscriptions of its room. Subscriptions
+// that are full are closed rather than holding up the room.
+func (h *Hub) notifySubscriptions(msg storage.StoredMessage) {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	for sub := range h.subscriptions {
+		if sub.room != msg.Room {
+			continue
+		}
+		select {
+		case sub.messages <- msg:
+		default:
+			h.closeSubscriptionLocked(sub)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package chat
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Streams new chat messages of a room to client until the returned stop
+// function is called. Unlike a session a tail does not join the room: it is
+// not announced, listed or counted as online.
+func (h *Hub) Tail(user string, room string, remoteAddr string, client Client) (func(), error) {
+	room = normalizeRoomName(room)
+	if room == "" {
+		room = h.defaultRoom()
+	}
+
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	if err := h.checkRoomOpenLocked(room); err != nil {
+		return nil, err
+	}
+	if !h.canAccessLocked(user, room) {
+		return nil, fmt.Errorf("#%s is private and you have not been invited", room)
+	}
+	sess := &Session{
+		ID:          uuid.New().String(),
+		User:        user,
+		RemoteAddr:  remoteAddr,
+		ConnectedAt: time.Now(),
+		client:      client,
+	}
+	h.tails[room] = append(h.tails[room], sess)
+
+	return func() { h.removeTail(room, sess) }, nil
+}
+
+// Stops streaming the room to a tail
+func (h *Hub) removeTail(room string, sess *Session) {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	var updated []*Session
+	for _, s := range h.tails[room] {
+		if s != sess {
+			updated = append(updated, s)
+		}
+	}
+	if len(updated) == 0 {
+		delete(h.tails, room)
+	} else {
+		h.tails[room] = updated
+	}
+}
+
+// Returns the tails of a single user
+func (h *Hub) userTails(user string) []*Session {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	var sessions []*Session
+	for _, tails := range h.tails {
+		for _, s := range tails {
+			if s.User == user {
+				sessions = append(sessions, s)
+			}
+		}
+	}
+	return sessions
+}
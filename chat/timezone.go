@@ -0,0 +1,90 @@
+package chat
+
+import (
+	"errors"
+	"group-ssh-chat/i18n"
+	"sync"
+	"time"
+)
+
+// Time zones already loaded, by name
+var (
+	locationsMutex sync.Mutex
+	locations      = map[string]*time.Location{}
+)
+
+// Loads a time zone by its IANA name, e.g. "Europe/Berlin"
+func loadLocation(name string) (*time.Location, error) {
+	locationsMutex.Lock()
+	defer locationsMutex.Unlock()
+	if loc, ok := locations[name]; ok {
+		return loc, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations[name] = loc
+	return loc, nil
+}
+
+// Validates a value for the tz setting
+func validateTimeZone(value string) error {
+	if value == "auto" {
+		return nil
+	}
+	// The empty name and "Local" would mean the server's zone, not the user's.
+	if value == "" || value == "Local" {
+		return errors.New("Invalid value for tz, expected auto or a zone like Europe/Berlin")
+	}
+	if _, err := loadLocation(value); err != nil {
+		return i18n.Errorf("Unknown time zone %s, expected auto or a zone like Europe/Berlin", value)
+	}
+	return nil
+}
+
+// Returns the time zone to show times in: the tz setting, or with "auto"
+// the TZ sent by the client, or else the server's zone
+func (p Preferences) Location(env map[string]string) *time.Location {
+	name := p.Get("tz")
+	if name == "auto" {
+		name = env["TZ"]
+	}
+	if name == "" || name == "Local" {
+		return time.Local
+	}
+	loc, err := loadLocation(name)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// Returns the layout of message timestamps for the timeformat and clock
+// settings
+func (p Preferences) TimeLayout() string {
+	twelve := p.Get("clock") == "12h"
+	switch p.Get("timeformat") {
+	case "minutes":
+		if twelve {
+			return "3:04 PM"
+		}
+		return "15:04"
+	case "date":
+		if twelve {
+			return "Jan 2 3:04 PM"
+		}
+		return "Jan 2 15:04"
+	case "iso":
+		return "2006-01-02T15:04:05"
+	}
+	if twelve {
+		return "3:04:05 PM"
+	}
+	return "15:04:05"
+}
+
+// Returns t in the time zone of the session's user
+func (h *Hub) sessionTime(sess *Session, t time.Time) time.Time {
+	return t.In(h.preferencesOf(sess.User).Location(sess.Env))
+}
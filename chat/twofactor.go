@@ -0,0 +1,94 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/commands"
+	"group-ssh-chat/storage"
+	"group-ssh-chat/totp"
+	"group-ssh-chat/ui"
+	"strings"
+	"time"
+)
+
+// Issuer shown in authenticator apps
+const totpIssuer = "group-ssh-chat"
+
+// Handles "/2fa setup|confirm <code>|disable <code>|status"
+func (h *Hub) twoFactor(ctx *commands.CommandContext, args []string) error {
+	if len(args) == 0 {
+		return errors.New("Usage: /2fa setup|confirm <code>|disable <code>|status")
+	}
+
+	switch args[0] {
+	case "status":
+		secret, ok := h.totpSecrets.Get(ctx.User)
+		switch {
+		case !ok:
+			return ctx.Reply("Two-factor authentication is off")
+		case secret.Pending:
+			return ctx.Reply("Two-factor authentication is awaiting confirmation, use /2fa confirm <code>")
+		default:
+			return ctx.Reply("Two-factor authentication is on")
+		}
+
+	case "setup":
+		if secret, ok := h.totpSecrets.Get(ctx.User); ok && !secret.Pending {
+			return errors.New("Two-factor authentication is already on, disable it first to set up a new device")
+		}
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return fmt.Errorf("Failed to generate secret: %v", err)
+		}
+		if err := h.totpSecrets.Set(ctx.User, storage.TOTPSecret{Secret: secret, Pending: true}); err != nil {
+			return fmt.Errorf("Failed to save secret: %v", err)
+		}
+
+		uri := totp.URI(totpIssuer, ctx.User, secret)
+		var sb strings.Builder
+		if lines, err := ui.QRCode(uri); err == nil && ctx.Caps.Unicode {
+			sb.WriteString("Scan this code with your authenticator app or enter the secret manually:")
+			sb.WriteString("\n" + strings.Join(lines, "\n"))
+		} else {
+			sb.WriteString("Enter this secret in your authenticator app:")
+		}
+		sb.WriteString("\nSecret: " + secret)
+		sb.WriteString("\nThen run /2fa confirm <code> to turn it on.")
+		return ctx.Reply(sb.String())
+
+	case "confirm":
+		secret, ok := h.totpSecrets.Get(ctx.User)
+		if !ok || !secret.Pending {
+			return errors.New("Nothing to confirm, run /2fa setup first")
+		}
+		if len(args) != 2 {
+			return errors.New("Usage: /2fa confirm <code>")
+		}
+		if _, valid := totp.Validate(secret.Secret, args[1], time.Now()); !valid {
+			return errors.New("Invalid code, check your authenticator app and try again")
+		}
+		secret.Pending = false
+		if err := h.totpSecrets.Set(ctx.User, secret); err != nil {
+			return fmt.Errorf("Failed to save secret: %v", err)
+		}
+		return ctx.Reply("Two-factor authentication is now on, you will be asked for a code at your next login")
+
+	case "disable":
+		secret, ok := h.totpSecrets.Get(ctx.User)
+		if !ok {
+			return errors.New("Two-factor authentication is already off")
+		}
+		if len(args) != 2 {
+			return errors.New("Usage: /2fa disable <code>")
+		}
+		if _, valid := totp.Validate(secret.Secret, args[1], time.Now()); !valid {
+			return errors.New("Invalid code")
+		}
+		if err := h.totpSecrets.Delete(ctx.User); err != nil {
+			return fmt.Errorf("Failed to remove secret: %v", err)
+		}
+		return ctx.Reply("Two-factor authentication is now off")
+	}
+
+	return errors.New("Usage: /2fa setup|confirm <code>|disable <code>|status")
+}
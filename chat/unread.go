@@ -0,0 +1,112 @@
+package chat
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Most unread messages replayed when returning to a room
+const maxUnreadReplay = 50
+
+// Moves the read cursor of every user in the room up to the given message,
+// since users in the room see messages as they arrive
+func (h *Hub) advanceReadCursors(room string, id int64) {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	for user, userRoom := range h.userRooms {
+		if userRoom == room {
+			h.setReadCursorLocked(user, room, id)
+		}
+	}
+}
+
+// Records the last message the user has read in a room.
+// The activeClientsMutex must be held.
+func (h *Hub) setReadCursorLocked(user string, room string, id int64) {
+	if h.readCursors[user] == nil {
+		h.readCursors[user] = map[string]int64{}
+	}
+	if cur, ok := h.readCursors[user][room]; !ok || id > cur {
+		h.readCursors[user][room] = id
+	}
+}
+
+// Returns the last message the user has read in a room and whether the
+// user has been in the room before
+func (h *Hub) readCursor(user string, room string) (int64, bool) {
+	h.activeClientsMutex.Lock()
+	defer h.activeClientsMutex.Unlock()
+
+	id, ok := h.readCursors[user][room]
+	return id, ok
+}
+
+// Replays the messages the user missed in a room below an unread divider and
+// marks them as read. Rooms visited for the first time have nothing unread.
+func (h *Hub) showUnread(user string, room string, sessions []*Session) {
+	latest := h.history.LatestID(room)
+	cursor, visited := h.readCursor(user, room)
+
+	if visited && latest > cursor {
+		unread := h.history.Since(room, cursor, maxUnreadReplay)
+		if missed := h.history.CountSince(room, cursor); missed > len(unread) {
+			for _, s := range sessions {
+				s.client.WriteSystem(fmt.Sprintf("%d unread messages, showing the last %d", missed, len(unread)))
+			}
+		}
+		for _, s := range sessions {
+			s.client.WriteDivider("unread")
+			s.client.WriteHistory("", unread)
+		}
+	}
+
+	h.activeClientsMutex.Lock()
+	h.setReadCursorLocked(user, room, latest)
+	h.activeClientsMutex.Unlock()
+}
+
+// Tells the user how many unread messages are waiting in rooms other than
+// the one they are in
+func (h *Hub) showUnreadSummary(user string, sessions []*Session) {
+	h.activeClientsMutex.Lock()
+	current := h.userRooms[user]
+	cursors := make(map[string]int64, len(h.readCursors[user]))
+	for room, id := range h.readCursors[user] {
+		if room != current && h.canAccessLocked(user, room) {
+			cursors[room] = id
+		}
+	}
+	h.activeClientsMutex.Unlock()
+
+	var rooms []string
+	for room := range cursors {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+
+	for _, room := range rooms {
+		if n := h.history.CountSince(room, cursors[room]); n > 0 {
+			for _, s := range sessions {
+				s.client.WriteSystem(fmt.Sprintf("%d unread in #%s", n, room))
+			}
+		}
+	}
+}
+
+// Marks all messages in every room the user has visited as read
+func (h *Hub) markAllRead(user string) {
+	h.activeClientsMutex.Lock()
+	rooms := make([]string, 0, len(h.readCursors[user]))
+	for room := range h.readCursors[user] {
+		rooms = append(rooms, room)
+	}
+	h.activeClientsMutex.Unlock()
+
+	for _, room := range rooms {
+		latest := h.history.LatestID(room)
+		h.activeClientsMutex.Lock()
+		h.setReadCursorLocked(user, room, latest)
+		h.activeClientsMutex.Unlock()
+	}
+}
@@ -0,0 +1,170 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"group-ssh-chat/audit"
+	"group-ssh-chat/commands"
+	"group-ssh-chat/storage"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Everything stored about a user, as exported by /userdata export
+type UserData struct {
+	User          string                  `json:"user"`
+	ExportedAt    time.Time               `json:"exported_at"`
+	Preferences   map[string]string       `json:"preferences"`
+	Messages      []storage.StoredMessage `json:"messages"`
+	Reactions     []UserReaction          `json:"reactions"`
+	Whispers      []storage.QueuedWhisper `json:"queued_whispers"`
+	Reminders     []storage.Reminder      `json:"reminders"`
+	RegisteredKey *storage.RegisteredKey  `json:"registered_key,omitempty"`
+	TwoFactor     bool                    `json:"two_factor_enabled"`
+	Identity      *storage.LinkedIdentity `json:"linked_identity,omitempty"`
+	AuditEvents   []audit.Event           `json:"audit_events"`
+}
+
+// A reaction the user left on a message
+type UserReaction struct {
+	MessageID int64  `json:"message_id"`
+	Room      string `json:"room"`
+	Emoji     string `json:"emoji"`
+}
+
+// Collects everything stored about the user
+func (h *Hub) ExportUserData(user string) (UserData, error) {
+	// Lists start out empty so they are exported as [] rather than null.
+	data := UserData{
+		User:        user,
+		ExportedAt:  time.Now().UTC(),
+		Preferences: h.prefsStore.Get(user),
+		Messages:    []storage.StoredMessage{},
+		Reactions:   []UserReaction{},
+		Whispers:    append([]storage.QueuedWhisper{}, h.inbox.Involving(user)...),
+		Reminders:   append([]storage.Reminder{}, h.reminders.Involving(user)...),
+		AuditEvents: []audit.Event{},
+	}
+	h.history.Scan(func(msg storage.StoredMessage) {
+		if msg.From == user {
+			data.Messages = append(data.Messages, msg)
+		}
+		for _, r := range msg.Reactions {
+			for _, u := range r.Users {
+				if u == user {
+					data.Reactions = append(data.Reactions, UserReaction{MessageID: msg.ID, Room: msg.Room, Emoji: r.Emoji})
+				}
+			}
+		}
+	})
+	if key, ok := h.registeredKeys.Get(user); ok {
+		data.RegisteredKey = &key
+	}
+	_, data.TwoFactor = h.totpSecrets.Get(user)
+	if identity, ok := h.Identity(user); ok {
+		data.Identity = &identity
+	}
+
+	events, err := h.auditLog.Events(user)
+	if err != nil {
+		return data, fmt.Errorf("failed to read the audit log: %w", err)
+	}
+	data.AuditEvents = append(data.AuditEvents, events...)
+	return data, nil
+}
+
+// Disconnects the user and removes everything stored about them: messages
+// and reactions are scrubbed, settings, queued whispers, reminders, keys,
+// two-factor secrets and linked identities are deleted and audit entries
+// are redacted
+func (h *Hub) DeleteUserData(user string) error {
+	h.Disconnect(user, "your data is being deleted")
+
+	h.activeClientsMutex.Lock()
+	delete(h.readCursors, user)
+	if dnd, ok := h.dnd[user]; ok {
+		dnd.timer.Stop()
+		delete(h.dnd, user)
+	}
+	if d, ok := h.detached[user]; ok {
+		d.expires.Stop()
+		delete(h.detached, user)
+	}
+	delete(h.resumeTokens, user)
+	h.activeClientsMutex.Unlock()
+
+	var errs []error
+	if _, err := h.history.Scrub(user); err != nil {
+		errs = append(errs, fmt.Errorf("messages: %w", err))
+	}
+	if err := h.prefsStore.Delete(user); err != nil {
+		errs = append(errs, fmt.Errorf("preferences: %w", err))
+	}
+	if err := h.inbox.DeleteUser(user); err != nil {
+		errs = append(errs, fmt.Errorf("queued whispers: %w", err))
+	}
+	if err := h.reminders.RemoveUser(user); err != nil {
+		errs = append(errs, fmt.Errorf("reminders: %w", err))
+	}
+	if _, err := h.registeredKeys.Remove(user); err != nil {
+		errs = append(errs, fmt.Errorf("registered key: %w", err))
+	}
+	if err := h.totpSecrets.Delete(user); err != nil {
+		errs = append(errs, fmt.Errorf("two-factor secret: %w", err))
+	}
+	if _, ok := h.Identity(user); ok {
+		if err := h.identities.Delete(user); err != nil {
+			errs = append(errs, fmt.Errorf("linked identity: %w", err))
+		}
+	}
+	if _, err := h.auditLog.Redact(user); err != nil {
+		errs = append(errs, fmt.Errorf("audit log: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// Handles /userdata export|delete <user>. Exports are written as JSON to
+// USERDATA_EXPORT_DIR.
+func (h *Hub) userData(ctx *commands.CommandContext, args []string) error {
+	if !h.isAdmin(ctx.User) {
+		return errNotAdmin
+	}
+	if len(args) != 2 || (args[0] != "export" && args[0] != "delete") {
+		return errors.New("Usage: /userdata export|delete <user>")
+	}
+	user := args[1]
+
+	if args[0] == "delete" {
+		if user == ctx.User {
+			return errors.New("You cannot delete your own data while connected")
+		}
+		if err := h.DeleteUserData(user); err != nil {
+			log.Printf("Failed to delete the data of %s: %v", user, err)
+			return fmt.Errorf("Some data of %s could not be deleted: %v", user, err)
+		}
+		log.Printf("%s deleted the data of %s", ctx.User, user)
+		return ctx.Reply("Deleted the data of " + user)
+	}
+
+	dir := os.Getenv("USERDATA_EXPORT_DIR")
+	if dir == "" {
+		return errors.New("Exports are disabled, set USERDATA_EXPORT_DIR or use chatctl userdata export")
+	}
+	data, err := h.ExportUserData(user)
+	if err != nil {
+		return fmt.Errorf("Failed to export the data of %s: %v", user, err)
+	}
+	encoded, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.json", user, data.ExportedAt.Format("20060102-150405.000")))
+	if err := os.WriteFile(path, encoded, 0600); err != nil {
+		return fmt.Errorf("Failed to write the export: %v", err)
+	}
+	log.Printf("%s exported the data of %s to %s", ctx.User, user, path)
+	return ctx.Reply(fmt.Sprintf("Exported %d messages of %s to %s", len(data.Messages), user, path))
+}
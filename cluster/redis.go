@@ -0,0 +1,241 @@
+package cluster
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Channel used unless CLUSTER_CHANNEL names another one
+	defaultChannel = "group-ssh-chat"
+
+	dialTimeout = 5 * time.Second
+
+	// Bounds of the wait between attempts to resubscribe after the
+	// connection to Redis was lost
+	minRetryDelay = time.Second
+	maxRetryDelay = 30 * time.Second
+)
+
+// Used for sharing events between server instances over Redis pub/sub.
+// Events published while a node is disconnected from Redis are lost for
+// that node.
+type Redis struct {
+	addr     string
+	password string
+	db       int
+	channel  string
+
+	mu  sync.Mutex
+	pub *conn
+}
+
+// A connection to Redis speaking the RESP protocol
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Returns a backplane connecting to the Redis server at CLUSTER_REDIS_URL,
+// e.g. redis://:password@localhost:6379/0, or nil when the variable is not
+// set. CLUSTER_CHANNEL names the pub/sub channel shared by the nodes.
+func New() *Redis {
+	rawURL := os.Getenv("CLUSTER_REDIS_URL")
+	if rawURL == "" {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "redis" || u.Host == "" {
+		log.Fatalf("Invalid CLUSTER_REDIS_URL %q", rawURL)
+	}
+
+	r := &Redis{
+		addr:    u.Host,
+		channel: os.Getenv("CLUSTER_CHANNEL"),
+	}
+	if u.Port() == "" {
+		r.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if password, ok := u.User.Password(); ok {
+		r.password = password
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if r.db, err = strconv.Atoi(db); err != nil {
+			log.Fatalf("Invalid database in CLUSTER_REDIS_URL %q", rawURL)
+		}
+	}
+	if r.channel == "" {
+		r.channel = defaultChannel
+	}
+	return r
+}
+
+// Publishes payload to all nodes, reconnecting once when the connection
+// was lost
+func (r *Redis) Publish(payload []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if r.pub == nil {
+			if r.pub, err = r.dial(); err != nil {
+				continue
+			}
+		}
+		if _, err = r.pub.do("PUBLISH", r.channel, string(payload)); err == nil {
+			return nil
+		}
+		r.pub.Close()
+		r.pub = nil
+	}
+	return err
+}
+
+// Calls handle for every payload published on the channel. Runs forever,
+// resubscribing with a growing delay whenever the connection drops.
+func (r *Redis) Subscribe(handle func(payload []byte)) error {
+	delay := minRetryDelay
+	for {
+		subscribed, err := r.subscribe(handle)
+		if subscribed {
+			delay = minRetryDelay
+		}
+		log.Printf("Lost cluster subscription, retrying in %s: %v", delay, err)
+		time.Sleep(delay)
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// Subscribes to the channel and hands payloads to handle until the
+// connection fails. Reports whether the subscription was set up.
+func (r *Redis) subscribe(handle func(payload []byte)) (bool, error) {
+	c, err := r.dial()
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+
+	if err := c.send("SUBSCRIBE", r.channel); err != nil {
+		return false, err
+	}
+	subscribed := false
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return subscribed, err
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 3 {
+			return subscribed, fmt.Errorf("unexpected reply %v", reply)
+		}
+		kind, _ := parts[0].(string)
+		switch kind {
+		case "subscribe":
+			subscribed = true
+			log.Printf("Subscribed to cluster channel %s on %s", r.channel, r.addr)
+		case "message":
+			if payload, ok := parts[2].(string); ok {
+				handle([]byte(payload))
+			}
+		}
+	}
+}
+
+// Connects to Redis, authenticating and selecting the database if needed
+func (r *Redis) dial() (*conn, error) {
+	nc, err := net.DialTimeout("tcp", r.addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	if r.password != "" {
+		if _, err := c.do("AUTH", r.password); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+	if r.db != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(r.db)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Sends a command and returns its reply
+func (c *conn) do(args ...string) (interface{}, error) {
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// Sends a command as an array of bulk strings
+func (c *conn) send(args ...string) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(c, sb.String())
+	return err
+}
+
+// Reads a reply. Strings come back as string, integers as int64, arrays as
+// []interface{} and error replies as error.
+func (c *conn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
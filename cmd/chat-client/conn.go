@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
+)
+
+// Returns the SSH settings for connecting as the user with the identity
+// file or the ssh-agent, answering two-factor prompts on the input line
+func clientConfig(opts options, t *tui) (*ssh.ClientConfig, error) {
+	var methods []ssh.AuthMethod
+	if signer, err := loadIdentity(opts.identity); err == nil {
+		methods = append(methods, ssh.PublicKeys(signer))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no key at %s and no ssh-agent running", opts.identity)
+	}
+	methods = append(methods, ssh.KeyboardInteractive(t.answer))
+
+	return &ssh.ClientConfig{
+		User:            opts.user,
+		Auth:            methods,
+		HostKeyCallback: t.trustOnFirstUse(opts.knownHosts),
+	}, nil
+}
+
+// Loads a private key, asking for its passphrase when it is encrypted. Runs
+// before the interface starts, so it reads the terminal directly.
+func loadIdentity(path string) (ssh.Signer, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return signer, err
+	}
+	fmt.Fprintf(os.Stderr, "Passphrase for %s: ", path)
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKeyWithPassphrase(pem, passphrase)
+}
+
+// Asks the server's keyboard-interactive questions, e.g. a one-time code
+func (t *tui) answer(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	if instruction != "" {
+		t.notice(instruction)
+	}
+	answers := make([]string, len(questions))
+	for i, question := range questions {
+		answer, err := t.ask(strings.TrimSpace(question), echos[i])
+		if err != nil {
+			return nil, err
+		}
+		answers[i] = strings.TrimSpace(answer)
+	}
+	return answers, nil
+}
+
+// Checks host keys against the known hosts file. Keys of unknown hosts are
+// shown and added after the user confirms them; changed keys are refused.
+func (t *tui) trustOnFirstUse(path string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if _, err := os.Stat(path); err == nil {
+			check, err := knownhosts.New(path)
+			if err != nil {
+				return err
+			}
+			err = check(hostname, remote, key)
+			var keyErr *knownhosts.KeyError
+			if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+				return err
+			}
+		}
+
+		t.notice(fmt.Sprintf("The server %s is not known yet. Its key fingerprint is %s.", hostname, ssh.FingerprintSHA256(key)))
+		answer, err := t.ask("Trust it? [y/N]", true)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+			return errors.New("host key not trusted")
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+		return err
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+const (
+	// Delay before the first reconnect attempt, doubled after every failure
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+
+	// A connection that lasted at least this long resets the delay
+	stableConnection = time.Minute
+)
+
+// Longest line of session output read, larger lines end the session
+const maxLineLength = 1 << 20
+
+// Command the server answers with a session speaking JSON lines instead of
+// drawing a terminal
+const jsonCommand = "--json"
+
+// Environment variable carrying the token that resumes the previous session
+// after a reconnect
+const resumeEnv = "CHAT_RESUME"
+
+func main() {
+	home, _ := os.UserHomeDir()
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil && name == "" {
+		name = u.Username
+	}
+
+	var opts options
+	flag.StringVar(&opts.user, "user", name, "chat username")
+	flag.StringVar(&opts.identity, "identity", filepath.Join(home, ".ssh", "id_ed25519"), "private key file, the ssh-agent is used when it does not exist")
+	flag.StringVar(&opts.knownHosts, "known-hosts", filepath.Join(home, ".ssh", "known_hosts"), "known hosts file, unknown servers are added after confirmation")
+	flag.StringVar(&opts.logPath, "log", "", "append received messages to this file")
+	flag.StringVar(&opts.notify, "notify", "", "shell command run for mentions and whispers, with the line in $CHAT_MESSAGE")
+	noReconnect := flag.Bool("no-reconnect", false, "exit when the connection drops")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: chat-client [flags] host[:port]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts.addr = flag.Arg(0)
+	if _, _, err := net.SplitHostPort(opts.addr); err != nil {
+		opts.addr += ":2022"
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		log.Fatal("chat-client must be run in a terminal")
+	}
+
+	t := newTUI(opts)
+	config, err := clientConfig(opts, t)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, err := newOutput(opts, t)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer out.Close()
+	// Log output would draw over the interface
+	log.SetOutput(io.Discard)
+
+	go func() {
+		delay := minReconnectDelay
+		for {
+			started := time.Now()
+			t.setState("connecting")
+			err := run(opts.addr, config, t, out)
+			if errors.Is(err, errRefused) || *noReconnect {
+				t.stop(err)
+				return
+			}
+
+			if time.Since(started) > stableConnection {
+				delay = minReconnectDelay
+			}
+			t.notice(fmt.Sprintf("Connection lost (%v), reconnecting in %s...", err, delay))
+			t.setState("reconnecting in " + delay.String())
+			time.Sleep(delay)
+			delay *= 2
+			if delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+		}
+	}()
+
+	if err := t.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		out.Close()
+		os.Exit(1)
+	}
+}
+
+// Command line settings
+type options struct {
+	addr       string
+	user       string
+	identity   string
+	knownHosts string
+	logPath    string
+	notify     string
+}
+
+// The server turned the session away, so reconnecting will not help
+var errRefused = errors.New("the server closed the session")
+
+// Connects, opens a JSON chat session and relays lines typed in the
+// interface until the session ends
+func run(addr string, config *ssh.ClientConfig, t *tui, out *output) error {
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return err
+	}
+	out.Reset()
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	// Servers without session resumption refuse the variable, which is fine.
+	session.Setenv(resumeEnv, out.ResumeToken())
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.Start(jsonCommand); err != nil {
+		return err
+	}
+	t.setState("connected")
+
+	ended := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(nil, maxLineLength)
+		for scanner.Scan() {
+			out.handleLine(scanner.Text())
+		}
+		ended <- session.Wait()
+	}()
+
+	for {
+		select {
+		case line := <-t.lines:
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			if _, err := io.WriteString(stdin, line+"\n"); err != nil {
+				return err
+			}
+		case err := <-ended:
+			if reason := out.Refused(); reason != "" {
+				return fmt.Errorf("%w: %s", errRefused, reason)
+			}
+			if err == nil {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rivo/tview"
+)
+
+// Shortest time between two runs of the notification command
+const notifyInterval = 2 * time.Second
+
+// Start of the system message carrying the resume token
+const resumeNotice = "Resume token: "
+
+// System messages naming the room the user is in after joining or
+// connecting
+var roomNotice = regexp.MustCompile(`You (?:joined|are in|are now in) #([^\s.]+)`)
+
+// A line of the server's JSON output: a chat message, or another event such
+// as a user list
+type event struct {
+	ID    int64     `json:"id"`
+	Type  string    `json:"type"`
+	From  string    `json:"from"`
+	To    string    `json:"to"`
+	Room  string    `json:"room"`
+	Time  time.Time `json:"time"`
+	Text  string    `json:"text"`
+	Users []string  `json:"users"`
+	Quote *struct {
+		ID int64 `json:"id"`
+	} `json:"quote"`
+}
+
+// Turns session output into lines for the message pane while logging
+// received lines and running the notification command for mentions and
+// whispers
+type output struct {
+	user   string
+	notify string
+	log    *os.File
+	ui     *tui
+
+	mu          sync.Mutex
+	lastNotify  time.Time
+	refused     string
+	resumeToken string
+}
+
+// Returns the output for the options, opening the log file when one is set
+func newOutput(opts options, ui *tui) (*output, error) {
+	o := &output{user: strings.ToLower(opts.user), notify: opts.notify, ui: ui}
+	if opts.logPath != "" {
+		f, err := os.OpenFile(opts.logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		o.log = f
+	}
+	return o, nil
+}
+
+// Shows, logs and notifies about a line of session output. Lines that are
+// not JSON come from the server before the session starts, e.g. when it
+// refuses the connection.
+func (o *output) handleLine(raw string) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return
+	}
+	var ev event
+	if err := json.Unmarshal([]byte(raw), &ev); err != nil {
+		ev = event{Type: "system", Text: raw}
+		if strings.HasPrefix(raw, "Connection refused:") {
+			o.mu.Lock()
+			o.refused = raw
+			o.mu.Unlock()
+		}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if ev.Type == "system" {
+		if token, ok := strings.CutPrefix(ev.Text, resumeNotice); ok {
+			// The token is only for the client, so it is neither shown,
+			// logged nor worth notifying about.
+			o.resumeToken = strings.TrimSpace(token)
+			return
+		}
+		if strings.HasPrefix(ev.Text, "Disconnected by an admin") {
+			o.refused = ev.Text
+		}
+		if m := roomNotice.FindStringSubmatch(ev.Text); m != nil {
+			o.ui.setRoom(m[1])
+		}
+	}
+
+	o.ui.show(render(ev, true))
+	line := render(ev, false)
+	if o.log != nil {
+		fmt.Fprintf(o.log, "%s %s\n", time.Now().Format(time.RFC3339), line)
+	}
+
+	whisper := ev.Type == "whisper" && strings.EqualFold(ev.To, o.user)
+	mention := ev.Type == "chat" && strings.Contains(strings.ToLower(ev.Text), "@"+o.user)
+	if o.notify != "" && (whisper || mention) && time.Since(o.lastNotify) > notifyInterval {
+		o.lastNotify = time.Now()
+		cmd := exec.Command("sh", "-c", o.notify)
+		cmd.Env = append(os.Environ(), "CHAT_MESSAGE="+line)
+		if err := cmd.Start(); err != nil {
+			log.Printf("Failed to run the notification command: %v", err)
+			return
+		}
+		go cmd.Wait()
+	}
+}
+
+// Returns the line shown for an event, with style tags for the message pane
+// when styled is set and as plain text for the log otherwise
+func render(ev event, styled bool) string {
+	style := func(tag string, text string) string {
+		if !styled {
+			return text
+		}
+		return tag + tview.Escape(text) + "[-:-:-]"
+	}
+	text := ev.Text
+	if styled {
+		text = tview.Escape(text)
+	}
+	// Continuation lines are indented below the first
+	text = strings.ReplaceAll(text, "\n", "\n    ")
+
+	stamp := ""
+	if !ev.Time.IsZero() {
+		stamp = style("[gray]", ev.Time.Local().Format("15:04")) + " "
+	}
+	switch ev.Type {
+	case "chat":
+		reply := ""
+		if ev.Quote != nil {
+			reply = style("[gray]", fmt.Sprintf(" (re [%d])", ev.Quote.ID))
+		}
+		return fmt.Sprintf("%s%s %s%s: %s", stamp, style("[gray]", fmt.Sprintf("[%d]", ev.ID)), style("[yellow::b]", ev.From), reply, text)
+	case "action":
+		return fmt.Sprintf("%s%s %s", stamp, style("[yellow::b]", "* "+ev.From), text)
+	case "whisper":
+		return fmt.Sprintf("%s%s %s", stamp, style("[fuchsia]", fmt.Sprintf("[%s -> %s]", ev.From, ev.To)), text)
+	case "users":
+		return style("[gray]", fmt.Sprintf("* Users in #%s: %s", ev.Room, strings.Join(ev.Users, ", ")))
+	case "divider":
+		return style("[gray]", "--- "+ev.Text+" ---")
+	}
+	return stamp + style("[gray]", "* ") + text
+}
+
+// Forgets the state of the previous session
+func (o *output) Reset() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.refused = ""
+}
+
+// Returns the token for resuming the last session, or "new" to ask the
+// server for one
+func (o *output) ResumeToken() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.resumeToken == "" {
+		return "new"
+	}
+	return o.resumeToken
+}
+
+// Returns why the server turned the session away, or "" when it did not
+func (o *output) Refused() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.refused
+}
+
+// Closes the log file
+func (o *output) Close() error {
+	if o.log == nil {
+		return nil
+	}
+	return o.log.Close()
+}
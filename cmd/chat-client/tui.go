@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Lines kept in the message pane, older ones scroll away
+const maxPaneLines = 5000
+
+// Label of the input line while chatting
+const inputLabel = "> "
+
+// The terminal interface: a scrolling message pane, a status bar and an
+// input line. Lines typed into the input line are handed to whoever reads
+// them, the chat session or a login prompt.
+type tui struct {
+	app      *tview.Application
+	messages *tview.TextView
+	status   *tview.TextView
+	input    *tview.InputField
+
+	// Lines submitted with Enter. Unbuffered, so a line is only taken out
+	// of the input line once something accepted it.
+	lines chan string
+
+	mu    sync.Mutex
+	addr  string
+	user  string
+	room  string
+	state string
+	err   error // why the client stopped, printed after the interface closes
+}
+
+// Returns the interface for chatting as the user on addr
+func newTUI(opts options) *tui {
+	t := &tui{
+		app:   tview.NewApplication(),
+		lines: make(chan string),
+		addr:  opts.addr,
+		user:  opts.user,
+		state: "connecting",
+	}
+
+	t.messages = tview.NewTextView().
+		SetDynamicColors(true).
+		SetWrap(true).
+		SetWordWrap(true).
+		SetMaxLines(maxPaneLines).
+		SetChangedFunc(func() { t.app.Draw() })
+	t.status = tview.NewTextView().SetDynamicColors(true)
+	t.status.SetBackgroundColor(tcell.ColorDarkBlue)
+	t.input = tview.NewInputField().
+		SetLabel(inputLabel).
+		SetFieldBackgroundColor(tcell.ColorDefault).
+		SetDoneFunc(t.submit)
+	t.renderStatus()
+
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(t.messages, 0, 1, false).
+		AddItem(t.status, 1, 0, false).
+		AddItem(t.input, 1, 0, true)
+	t.app.SetRoot(layout, true).SetInputCapture(t.capture)
+	return t
+}
+
+// Runs the interface until the user quits or stop is called, and returns
+// why the client stopped
+func (t *tui) Run() error {
+	if err := t.app.Run(); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Closes the interface, making Run return err
+func (t *tui) stop(err error) {
+	t.mu.Lock()
+	t.err = err
+	t.mu.Unlock()
+	t.app.Stop()
+}
+
+// Handles the keys that work wherever the cursor is: paging through the
+// message pane and Ctrl-D on an empty line to quit
+func (t *tui) capture(event *tcell.EventKey) *tcell.EventKey {
+	switch event.Key() {
+	case tcell.KeyPgUp, tcell.KeyPgDn:
+		t.messages.InputHandler()(event, func(tview.Primitive) {})
+		return nil
+	case tcell.KeyCtrlD:
+		if t.input.GetText() == "" {
+			t.stop(nil)
+			return nil
+		}
+	}
+	return event
+}
+
+// Hands the typed line to its reader. Without one, e.g. while reconnecting,
+// the line stays in the input so it can be sent later.
+func (t *tui) submit(key tcell.Key) {
+	if key != tcell.KeyEnter {
+		return
+	}
+	select {
+	case t.lines <- t.input.GetText():
+		t.input.SetText("")
+	default:
+		t.show("[gray]* Not connected, press Enter again once the connection is back[-]")
+	}
+}
+
+// Asks a question on the input line and waits for the answer. Answers
+// typed without echo are masked.
+func (t *tui) ask(question string, echo bool) (string, error) {
+	t.app.QueueUpdateDraw(func() {
+		t.input.SetLabel(question + " ")
+		if !echo {
+			t.input.SetMaskCharacter('*')
+		}
+	})
+	defer t.app.QueueUpdateDraw(func() {
+		t.input.SetLabel(inputLabel).SetMaskCharacter(0)
+	})
+	answer, ok := <-t.lines
+	if !ok {
+		return "", errors.New("interrupted")
+	}
+	return answer, nil
+}
+
+// Appends a line with style tags to the message pane
+func (t *tui) show(line string) {
+	fmt.Fprintln(t.messages, line)
+}
+
+// Appends plain text to the message pane
+func (t *tui) notice(text string) {
+	t.show("[gray]* " + tview.Escape(text) + "[-]")
+}
+
+// Shows the connection state, e.g. "connected", in the status bar
+func (t *tui) setState(state string) {
+	t.mu.Lock()
+	t.state = state
+	t.mu.Unlock()
+	t.app.QueueUpdateDraw(t.renderStatus)
+}
+
+// Shows the room the user is in in the status bar
+func (t *tui) setRoom(room string) {
+	t.mu.Lock()
+	t.room = room
+	t.mu.Unlock()
+	t.app.QueueUpdateDraw(t.renderStatus)
+}
+
+// Redraws the status bar. Must be called from the interface goroutine.
+func (t *tui) renderStatus() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parts := []string{tview.Escape(t.user + "@" + t.addr)}
+	if t.room != "" {
+		parts = append(parts, tview.Escape("#"+t.room))
+	}
+	parts = append(parts, tview.Escape(t.state))
+	t.status.SetText(" " + strings.Join(parts, "  ·  ") + "  ·  PgUp/PgDn scroll, Ctrl-D quits")
+}
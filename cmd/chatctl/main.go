@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	godotenv.Load()
+
+	socketPath := flag.String("socket", os.Getenv("ADMIN_SOCKET_PATH"), "path of the server's admin socket")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: chatctl [-socket path] <command> [args...]")
+		fmt.Fprintln(os.Stderr, "run 'chatctl help' for the list of commands")
+	}
+	flag.Parse()
+	if flag.NArg() == 0 || *socketPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	conn, err := net.Dial("unix", *socketPath)
+	if err != nil {
+		log.Fatal("Failed to connect to the admin socket: ", err)
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintln(conn, strings.Join(flag.Args(), " ")); err != nil {
+		log.Fatal(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	if err != nil {
+		log.Fatal("No response from server: ", err)
+	}
+	if status = strings.TrimSpace(status); status != "OK" {
+		fmt.Fprintln(os.Stderr, strings.TrimPrefix(status, "ERR "))
+		os.Exit(1)
+	}
+	io.Copy(os.Stdout, reader)
+}
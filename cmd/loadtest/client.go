@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Delay before reconnecting after a failed connection attempt
+const retryDelay = time.Second
+
+// Marks the messages of the test as "lt:<client>:<unix nanoseconds>:", so
+// every client can tell from a message it receives when and by whom it was
+// sent
+var probePattern = regexp.MustCompile(`lt:(\d+):(\d+):`)
+
+// A simulated chat user
+type client struct {
+	id     int
+	opts   options
+	config *ssh.ClientConfig
+	res    *results
+	rand   *rand.Rand
+}
+
+// Returns client number id, logging in with the shared key or one derived
+// from the seed and its name
+func newClient(id int, opts options, shared ssh.Signer, res *results) (*client, error) {
+	name := fmt.Sprintf("%s%d", opts.prefix, id)
+	signer := shared
+	if signer == nil {
+		seed := sha256.Sum256([]byte(opts.seed + "/" + name))
+		var err error
+		if signer, err = ssh.NewSignerFromKey(ed25519.NewKeyFromSeed(seed[:])); err != nil {
+			return nil, err
+		}
+	}
+	return &client{
+		id:   id,
+		opts: opts,
+		config: &ssh.ClientConfig{
+			User: name,
+			Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			// The test targets a server of our own.
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         10 * time.Second,
+		},
+		res:  res,
+		rand: rand.New(rand.NewSource(int64(id))),
+	}, nil
+}
+
+// Keeps the client connected and chatting until ctx is done. With churn
+// each connection lasts a random time around the churn duration.
+func (c *client) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		lifetime := c.opts.duration
+		if c.opts.churn > 0 {
+			lifetime = time.Duration(c.rand.ExpFloat64() * float64(c.opts.churn))
+		}
+		connCtx, cancel := context.WithTimeout(ctx, lifetime)
+		err := c.session(connCtx)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			c.res.failures.Add(1)
+			select {
+			case <-time.After(retryDelay):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// Connects, chats until ctx is done or the server closes the session, then
+// disconnects
+func (c *client) session(ctx context.Context) error {
+	conn, err := ssh.Dial("tcp", c.opts.addr, c.config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		return err
+	}
+	// A wide terminal keeps the server from wrapping the probes.
+	if err := session.RequestPty("dumb", 24, 1000, ssh.TerminalModes{}); err != nil {
+		return err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.Shell(); err != nil {
+		return err
+	}
+	c.res.connects.Add(1)
+	c.res.online.Add(1)
+	defer c.res.online.Add(-1)
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		c.readProbes(stdout, time.Now())
+	}()
+
+	if c.opts.room != "" {
+		if _, err := io.WriteString(stdin, "/join "+c.opts.room+"\r"); err != nil {
+			return err
+		}
+	}
+	for {
+		// Messages follow a Poisson process at the configured rate.
+		wait := time.Duration(c.rand.ExpFloat64() / c.opts.rate * float64(time.Second))
+		select {
+		case <-time.After(wait):
+		case <-closed:
+			c.res.disconnects.Add(1)
+			return nil
+		case <-ctx.Done():
+			c.res.disconnects.Add(1)
+			return nil
+		}
+		if _, err := io.WriteString(stdin, c.probe()+"\r"); err != nil {
+			return err
+		}
+		c.res.sent.Add(1)
+	}
+}
+
+// Returns a message stamped with the client and the current time, padded
+// to the configured size
+func (c *client) probe() string {
+	text := fmt.Sprintf("lt:%d:%d:", c.id, time.Now().UnixNano())
+	if pad := c.opts.size - len(text); pad > 0 {
+		text += strings.Repeat("x", pad)
+	}
+	return text
+}
+
+// Records the latency of every probe of another client in the output until
+// it ends. The client's own probes are skipped, as the terminal echoes them
+// while they are typed, and so are probes sent before the client connected,
+// which the server replays from the history.
+func (c *client) readProbes(stdout io.Reader, connected time.Time) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		received := time.Now()
+		for _, m := range probePattern.FindAllStringSubmatch(scanner.Text(), -1) {
+			sender, _ := strconv.Atoi(m[1])
+			sent, err := strconv.ParseInt(m[2], 10, 64)
+			if sender == c.id || err != nil || sent < connected.UnixNano() {
+				continue
+			}
+			c.res.received.Add(1)
+			c.res.latency.add(received.Sub(time.Unix(0, sent)))
+		}
+	}
+}
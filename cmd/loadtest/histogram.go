@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Bounds of the latency histogram. Each bucket is 10% wider than the one
+// before, so percentiles are accurate to within 10%.
+const (
+	histogramBase   = 10 * time.Microsecond
+	histogramGrowth = 1.1
+	histogramSize   = 200
+)
+
+// Counts latencies in exponentially growing buckets, so millions of samples
+// take constant space
+type histogram struct {
+	mu      sync.Mutex
+	buckets [histogramSize]uint64
+	count   uint64
+	max     time.Duration
+}
+
+// Records a latency
+func (h *histogram) add(d time.Duration) {
+	i := 0
+	if d > histogramBase {
+		i = int(math.Ceil(math.Log(float64(d)/float64(histogramBase)) / math.Log(histogramGrowth)))
+	}
+	if i >= histogramSize {
+		i = histogramSize - 1
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buckets[i]++
+	h.count++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Returns the upper bound of the bucket holding the p-th percentile, 0 < p
+// <= 100, or 0 without samples
+func (h *histogram) percentile(p float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(p / 100 * float64(h.count)))
+	var seen uint64
+	for i, n := range h.buckets {
+		seen += n
+		if seen >= rank {
+			bound := time.Duration(float64(histogramBase) * math.Pow(histogramGrowth, float64(i)))
+			if bound > h.max {
+				return h.max
+			}
+			return bound
+		}
+	}
+	return h.max
+}
+
+// Returns the number of samples and the largest one
+func (h *histogram) summary() (uint64, time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count, h.max
+}
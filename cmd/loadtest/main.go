@@ -0,0 +1,154 @@
+// Command loadtest connects many synthetic SSH clients to a chat server,
+// has them chat at a steady rate while connecting and disconnecting, and
+// reports how long broadcasts take to reach the other clients.
+//
+// Every client logs in as <prefix><n> with a key derived from -seed, so
+// the same names get the same keys on every run. The server must admit
+// them, e.g. with open registration without approval or the guest
+// provider, and allow enough connections from the machine running the
+// test.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Command line settings
+type options struct {
+	addr     string
+	clients  int
+	prefix   string
+	seed     string
+	identity string
+	room     string
+	rate     float64
+	size     int
+	churn    time.Duration
+	ramp     time.Duration
+	duration time.Duration
+	report   time.Duration
+}
+
+// Counters shared by all clients
+type results struct {
+	online      atomic.Int64
+	connects    atomic.Int64
+	failures    atomic.Int64
+	disconnects atomic.Int64
+	sent        atomic.Int64
+	received    atomic.Int64
+	latency     histogram
+}
+
+func main() {
+	var opts options
+	flag.IntVar(&opts.clients, "clients", 10, "number of simulated clients")
+	flag.StringVar(&opts.prefix, "prefix", "load", "username prefix, followed by the client number")
+	flag.StringVar(&opts.seed, "seed", "loadtest", "seed the client keys are derived from")
+	flag.StringVar(&opts.identity, "identity", "", "private key file used by every client instead of derived keys")
+	flag.StringVar(&opts.room, "room", "", "room the clients join, the lobby when empty")
+	flag.Float64Var(&opts.rate, "rate", 0.5, "messages per second sent by each client")
+	flag.IntVar(&opts.size, "size", 40, "approximate message length in bytes")
+	flag.DurationVar(&opts.churn, "churn", 0, "average connection lifetime before a client reconnects, 0 to stay connected")
+	flag.DurationVar(&opts.ramp, "ramp", 5*time.Second, "time over which the clients connect at the start")
+	flag.DurationVar(&opts.duration, "duration", time.Minute, "length of the test")
+	flag.DurationVar(&opts.report, "report", 5*time.Second, "interval between progress lines")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: loadtest [flags] host[:port]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || opts.clients <= 0 || opts.rate <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts.addr = flag.Arg(0)
+	if _, _, err := net.SplitHostPort(opts.addr); err != nil {
+		opts.addr += ":2022"
+	}
+
+	var shared ssh.Signer
+	if opts.identity != "" {
+		pem, err := os.ReadFile(opts.identity)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if shared, err = ssh.ParsePrivateKey(pem); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.duration)
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	res := &results{}
+	var wg sync.WaitGroup
+	for i := 0; i < opts.clients; i++ {
+		c, err := newClient(i, opts, shared, res)
+		if err != nil {
+			log.Fatal(err)
+		}
+		wg.Add(1)
+		go func(delay time.Duration) {
+			defer wg.Done()
+			select {
+			case <-time.After(delay):
+				c.run(ctx)
+			case <-ctx.Done():
+			}
+		}(opts.ramp * time.Duration(i) / time.Duration(opts.clients))
+	}
+
+	start := time.Now()
+	ticker := time.NewTicker(opts.report)
+	defer ticker.Stop()
+	for done := false; !done; {
+		select {
+		case <-ticker.C:
+			printProgress(time.Since(start), res)
+		case <-ctx.Done():
+			done = true
+		}
+	}
+	wg.Wait()
+	printSummary(time.Since(start), opts, res)
+}
+
+// Prints a one line status of the running test
+func printProgress(elapsed time.Duration, res *results) {
+	fmt.Printf("%6s  online %d  sent %d  received %d  p50 %s  p99 %s\n",
+		elapsed.Round(time.Second), res.online.Load(), res.sent.Load(), res.received.Load(),
+		res.latency.percentile(50).Round(time.Microsecond), res.latency.percentile(99).Round(time.Microsecond))
+}
+
+// Prints the totals and latency percentiles of the finished test
+func printSummary(elapsed time.Duration, opts options, res *results) {
+	seconds := elapsed.Seconds()
+	samples, slowest := res.latency.summary()
+	fmt.Printf("\n%d clients for %s\n", opts.clients, elapsed.Round(time.Second))
+	fmt.Printf("  connects      %d (%d failed, %d disconnects)\n", res.connects.Load(), res.failures.Load(), res.disconnects.Load())
+	fmt.Printf("  sent          %d (%.1f/s)\n", res.sent.Load(), float64(res.sent.Load())/seconds)
+	fmt.Printf("  delivered     %d (%.1f/s)\n", res.received.Load(), float64(res.received.Load())/seconds)
+	if samples == 0 {
+		fmt.Println("  no broadcasts were received")
+		return
+	}
+	fmt.Println("Broadcast latency:")
+	for _, p := range []float64{50, 90, 99, 99.9} {
+		fmt.Printf("  p%-5v %s\n", p, res.latency.percentile(p).Round(time.Microsecond))
+	}
+	fmt.Printf("  max    %s\n", slowest.Round(time.Microsecond))
+}
@@ -1,20 +1,189 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"group-ssh-chat/adminctl"
+	"group-ssh-chat/archive"
+	"group-ssh-chat/audit"
 	"group-ssh-chat/auth"
+	"group-ssh-chat/chat"
+	"group-ssh-chat/cluster"
+	"group-ssh-chat/config"
+	"group-ssh-chat/connlimit"
+	"group-ssh-chat/fts"
+	"group-ssh-chat/graceful"
+	"group-ssh-chat/grpcapi"
+	"group-ssh-chat/i18n"
+	"group-ssh-chat/oauth"
+	"group-ssh-chat/plugins"
+	"group-ssh-chat/restapi"
+	"group-ssh-chat/retention"
+	"group-ssh-chat/securitypolicy"
 	"group-ssh-chat/sshserver"
+	"group-ssh-chat/stats"
+	"group-ssh-chat/storage"
+	"group-ssh-chat/telnet"
+	"group-ssh-chat/trivia"
+	"group-ssh-chat/web"
+	"group-ssh-chat/wsgateway"
 	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 func main() {
+	generateHostKey := flag.Bool("generate-hostkey", false, "generate an ed25519 host key at HOST_SSH_PRIVATE_KEY_PATH and exit")
+	flag.Parse()
+
 	godotenv.Load()
 
-	sshAuth := auth.New()
-	sshServer := sshserver.New(sshAuth)
-	
+	if *generateHostKey {
+		if _, err := auth.GenerateHostKey(os.Getenv("HOST_SSH_PRIVATE_KEY_PATH")); err != nil {
+			log.Fatal("Failed to generate host key: ", err)
+		}
+		return
+	}
+
+	if err := graceful.Init(); err != nil {
+		log.Fatal(err)
+	}
+
+	auditLog := audit.New()
+	defer auditLog.Close()
+
+	totpSecrets := storage.NewSecretStore()
+	registeredKeys := storage.NewKeyStore()
+	sshAuth := auth.New(totpSecrets, registeredKeys)
+	policy := securitypolicy.New()
+	collector := stats.New()
+	store := storage.Open()
+	history := store.History()
+	if cache := storage.NewHistoryCache(history); cache != nil {
+		history = cache
+		collector.WatchHistoryCache(cache)
+	}
+	if index := fts.New(history); index != nil {
+		history = index
+	}
+	// Catalogs must be loaded before the hub checks DEFAULT_LANGUAGE.
+	i18n.Load()
+	hub := chat.New(store.Preferences(), history, storage.NewRoomStore(), storage.NewReminderStore(), storage.NewInboxStore(), totpSecrets, registeredKeys, policy, auditLog, collector)
+	defer hub.Close()
+	trivia.New(hub)
+	plugins.StartExecPlugins(hub)
+	plugins.LoadWASMPlugins(hub)
+	plugins.LoadLuaScripts(hub)
+	if backplane := cluster.New(); backplane != nil {
+		hub.JoinCluster(backplane)
+	}
+	if archiver := archive.New(history); archiver != nil {
+		archiver.Start()
+		hub.SetArchiver(archiver)
+	}
+	hub.SetAccountLinking(oauth.New(), storage.NewIdentityStore())
+	if pruner := retention.New(history); pruner != nil {
+		pruner.Start()
+	}
+	limiter := connlimit.New()
+	sshServer := sshserver.New(sshAuth, hub, limiter, policy, auditLog)
+
+	if gateway := wsgateway.New(sshServer); gateway != nil {
+		go serve(gateway.ListenAndServe)
+	}
+
+	if exporter := stats.NewExporter(collector); exporter != nil {
+		go serve(exporter.ListenAndServe)
+	}
+
+	reloader := config.NewReloader()
+	reloader.OnReload(hub.Reload)
+	reloader.OnReload(limiter.Reload)
+	reloader.OnReload(policy.Reload)
+	reloader.OnReload(sshServer.Reload)
+	reloader.HandleSIGHUP()
+	handleSIGUSR2()
+
+	if console := adminctl.New(hub, reloader.Reload); console != nil {
+		go serve(console.ListenAndServe)
+	}
+
+	if dashboard := web.New(hub, policy, auditLog, collector); dashboard != nil {
+		go serve(dashboard.ListenAndServe)
+	}
+
+	if api := restapi.New(hub); api != nil {
+		go serve(api.ListenAndServe)
+	}
+
+	if grpcServer := grpcapi.New(hub); grpcServer != nil {
+		go serve(grpcServer.ListenAndServe)
+	}
+
+	if telnetServer := telnet.New(hub, sshServer, sshAuth); telnetServer != nil {
+		go serve(telnetServer.ListenAndServe)
+	}
+
 	log.Println("SSH server is listening for incoming connections.")
 	sshServer.AcceptConnections()
 
+	select {
+	case <-graceful.Upgrading():
+		drain(hub)
+	default:
+	}
+}
+
+// Runs a listener until it fails. A listener closed by a graceful upgrade is
+// not an error.
+func serve(listenAndServe func() error) {
+	if err := listenAndServe(); !errors.Is(err, net.ErrClosed) {
+		log.Fatal(err)
+	}
+}
+
+// Hands the listeners over to a new process whenever SIGUSR2 is received
+func handleSIGUSR2() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	go func() {
+		for range signals {
+			log.Println("Received SIGUSR2, upgrading")
+			if err := graceful.Upgrade(); err != nil {
+				log.Printf("Upgrade failed: %v", err)
+			}
+		}
+	}()
+}
+
+// Waits for the remaining sessions to end after an upgrade, for at most
+// UPGRADE_DRAIN_TIMEOUT when set
+func drain(hub *chat.Hub) {
+	hub.Announce("The server is being upgraded. Reconnect at any time to switch to the new version.")
+
+	var deadline <-chan time.Time
+	if d, err := time.ParseDuration(os.Getenv("UPGRADE_DRAIN_TIMEOUT")); err == nil && d > 0 {
+		deadline = time.After(d)
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	last := -1
+	for n := len(hub.Sessions()); n > 0; n = len(hub.Sessions()) {
+		if n != last {
+			log.Printf("Draining %d sessions", n)
+			last = n
+		}
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			log.Println("Drain timeout reached, closing remaining sessions")
+			return
+		}
+	}
+	log.Println("All sessions drained, exiting")
 }
@@ -0,0 +1,222 @@
+package commands
+
+import (
+	"errors"
+	"group-ssh-chat/i18n"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Longest command name repeated back by HandleCommand when it is unknown
+const maxEchoedNameLength = 32
+
+// Returned by HandleCommand when no command is registered under the given name
+var ErrUnknownCommand = errors.New("unknown command")
+
+// Returned by HandleCommand when the sender's role is below the command's
+// permission level
+var ErrNotPermitted = errors.New("not permitted")
+
+// Who may run a command. Each level includes the ones below it.
+type Permission int
+
+const (
+	// Anyone connected
+	Everyone Permission = iota
+	// Ops of the sender's current room
+	RoomOp
+	// Server admins
+	Admin
+)
+
+// Returns who the permission level is for, e.g. "room ops"
+func (p Permission) String() string {
+	switch p {
+	case RoomOp:
+		return "room ops"
+	case Admin:
+		return "admins"
+	default:
+		return "everyone"
+	}
+}
+
+// The group a command is listed under in /help
+type Category string
+
+const (
+	Chat       Category = "chat"
+	Rooms      Category = "rooms"
+	Moderation Category = "moderation"
+	Fun        Category = "fun"
+	Account    Category = "account"
+	Server     Category = "server"
+	// Commands registered without a category, like those of plugins
+	Other Category = "other"
+)
+
+// Categories in the order /help lists them
+var Categories = []Category{Chat, Rooms, Moderation, Fun, Account, Server, Other}
+
+// Executes a command in the context it was invoked in with the arguments
+// split by SplitN
+type CommandHandler func(ctx *CommandContext, args []string) error
+
+// A slash command that can be invoked from the chat
+type Command struct {
+	Name        string
+	Usage       string
+	Description string
+	Category    Category
+	Permission  Permission
+	Handler     CommandHandler
+
+	// Sample invocations shown by /help <command>
+	Examples []string
+
+	// When above 0, the most arguments the handler gets: the last one holds
+	// the rest of the line as typed, quotes included, for free text like the
+	// message of /whisper
+	MaxArgs int
+
+	// The arguments the command takes. When set, HandleCommand rejects
+	// arguments that do not fit with a usage error before calling the
+	// handler, Usage defaults to the line they describe and a trailing Text
+	// argument sets MaxArgs.
+	Args []Arg
+
+	// Shorter names the command can also be invoked by, like w for whisper
+	Aliases []string
+}
+
+// Used for registering and dispatching slash commands
+type CommandManager struct {
+	commands map[string]Command
+	aliases  map[string]string
+	roleOf   func(sender string) Permission
+}
+
+// Returns new command manager struct reference
+func NewCommandManager() *CommandManager {
+	return &CommandManager{
+		commands: map[string]Command{},
+		aliases:  map[string]string{},
+	}
+}
+
+// Registers a command and its aliases, replacing any previous command with
+// the same name
+func (cm *CommandManager) Register(cmd Command) {
+	if cmd.Category == "" {
+		cmd.Category = Other
+	}
+	if cmd.Args != nil {
+		if cmd.Usage == "" {
+			cmd.Usage = cmd.generatedUsage()
+		}
+		if n := len(cmd.Args); n > 0 && cmd.Args[n-1].Type == Text {
+			cmd.MaxArgs = n
+		}
+	}
+	cm.commands[cmd.Name] = cmd
+	for _, alias := range cmd.Aliases {
+		cm.aliases[alias] = cmd.Name
+	}
+}
+
+// Returns the command registered under the name or one of its aliases
+func (cm *CommandManager) Lookup(name string) (Command, bool) {
+	name = strings.ToLower(name)
+	if target, ok := cm.aliases[name]; ok {
+		name = target
+	}
+	cmd, ok := cm.commands[name]
+	return cmd, ok
+}
+
+// Sets how the role of a sender is looked up before dispatch. Without a
+// resolver every sender counts as Everyone.
+func (cm *CommandManager) SetRoleResolver(roleOf func(sender string) Permission) {
+	cm.roleOf = roleOf
+}
+
+// Returns the role of the sender
+func (cm *CommandManager) role(sender string) Permission {
+	if cm.roleOf == nil {
+		return Everyone
+	}
+	return cm.roleOf(sender)
+}
+
+// Reports whether the input line should be treated as a command
+func IsCommand(input string) bool {
+	return strings.HasPrefix(input, "/")
+}
+
+// Parses the input line and runs the matching command handler
+func (cm *CommandManager) HandleCommand(ctx *CommandContext, input string) error {
+	fields := SplitN(strings.TrimPrefix(input, "/"), 2)
+	if len(fields) == 0 {
+		return ErrUnknownCommand
+	}
+
+	cmd, ok := cm.Lookup(fields[0])
+	if !ok {
+		return i18n.Errorf("%w: /%s", ErrUnknownCommand, echoName(fields[0]))
+	}
+	if cmd.Permission > Everyone && cmd.Permission > cm.role(ctx.User) {
+		if cmd.Permission == Admin {
+			return i18n.Errorf("%w: /%s is only available to admins", ErrNotPermitted, cmd.Name)
+		}
+		return i18n.Errorf("%w: /%s is only available to ops of the room", ErrNotPermitted, cmd.Name)
+	}
+	var args []string
+	if len(fields) == 2 {
+		args = SplitN(fields[1], cmd.MaxArgs)
+	}
+	if err := cmd.checkArgs(args); err != nil {
+		return err
+	}
+	return cmd.Handler(ctx, args)
+}
+
+// Makes a command name typed by a user safe to show back: invalid UTF-8 and
+// control characters are dropped and long names are shortened
+func echoName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(name, ""))
+	if utf8.RuneCountInString(name) > maxEchoedNameLength {
+		name = string([]rune(name)[:maxEchoedNameLength]) + "…"
+	}
+	return name
+}
+
+// Returns all registered commands sorted by name
+func (cm *CommandManager) Commands() []Command {
+	cmds := make([]Command, 0, len(cm.commands))
+	for _, cmd := range cm.commands {
+		cmds = append(cmds, cmd)
+	}
+	sort.Slice(cmds, func(i, j int) bool {
+		return cmds[i].Name < cmds[j].Name
+	})
+	return cmds
+}
+
+// Returns the commands the sender's role allows them to run, sorted by name
+func (cm *CommandManager) CommandsFor(sender string) []Command {
+	role := cm.role(sender)
+	var cmds []Command
+	for _, cmd := range cm.Commands() {
+		if cmd.Permission <= role {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
@@ -0,0 +1,151 @@
+package commands
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func FuzzHandleCommand(f *testing.F) {
+	for _, input := range []string{"/echo hello world", "/ECHO", "/", "/ ", "/unknown x", "/echo\t\ta\n b", "/\xff\xfe", "no slash"} {
+		f.Add(input)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		cm := NewCommandManager()
+		var got []string
+		called := false
+		cm.Register(Command{Name: "echo", Handler: func(ctx *CommandContext, args []string) error {
+			called = true
+			got = args
+			return nil
+		}})
+
+		err := cm.HandleCommand(&CommandContext{User: "alice"}, input)
+		if !called {
+			if !errors.Is(err, ErrUnknownCommand) {
+				t.Fatalf("%q: handler not called, err = %v", input, err)
+			}
+			if msg := err.Error(); len(msg) > len(ErrUnknownCommand.Error())+4*maxEchoedNameLength+8 || !utf8.ValidString(msg) {
+				t.Fatalf("%q: unsafe error %q", input, msg)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", input, err)
+		}
+		if strings.ContainsAny(input, `"\\`) {
+			return
+		}
+		// Without quotes or escapes arguments are split on whitespace only
+		want := strings.Fields(strings.TrimPrefix(input, "/"))[1:]
+		if strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+			t.Fatalf("%q: got %q, want %q", input, got, want)
+		}
+	})
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		line string
+		n    int
+		want []string
+	}{
+		{`bob hello "there friend"`, 0, []string{"bob", "hello", "there friend"}},
+		{`  a   b  `, 0, []string{"a", "b"}},
+		{`"" x`, 0, []string{"", "x"}},
+		{`"a \"b\" c"d`, 0, []string{`a "b" cd`}},
+		{`one\ arg \\ \x`, 0, []string{"one arg", `\`, `\x`}},
+		{`don't 5" "open quote`, 0, []string{"don't", `5"`, "open quote"}},
+		{`bob hello  "there friend" `, 2, []string{"bob", `hello  "there friend"`}},
+		{`"bob smith" hi`, 2, []string{"bob smith", "hi"}},
+		{`only`, 3, []string{"only"}},
+	}
+	for _, tt := range tests {
+		got := SplitN(tt.line, tt.n)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("SplitN(%q, %d) = %q, want %q", tt.line, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestPermission(t *testing.T) {
+	cm := NewCommandManager()
+	cm.SetRoleResolver(func(sender string) Permission {
+		if sender == "alice" {
+			return Admin
+		}
+		return Everyone
+	})
+	ran := false
+	cm.Register(Command{Name: "kick", Permission: RoomOp, Handler: func(ctx *CommandContext, args []string) error {
+		ran = true
+		return nil
+	}})
+	cm.Register(Command{Name: "help", Handler: func(ctx *CommandContext, args []string) error { return nil }})
+
+	if err := cm.HandleCommand(&CommandContext{User: "bob"}, "/kick carol"); !errors.Is(err, ErrNotPermitted) || ran {
+		t.Fatalf("bob ran /kick: err = %v", err)
+	}
+	if err := cm.HandleCommand(&CommandContext{User: "alice"}, "/kick carol"); err != nil || !ran {
+		t.Fatalf("alice could not run /kick: err = %v", err)
+	}
+	if got := len(cm.CommandsFor("bob")); got != 1 {
+		t.Fatalf("bob sees %d commands, want 1", got)
+	}
+	if got := len(cm.CommandsFor("alice")); got != 2 {
+		t.Fatalf("alice sees %d commands, want 2", got)
+	}
+}
+
+func TestAlias(t *testing.T) {
+	cm := NewCommandManager()
+	var got []string
+	cm.Register(Command{Name: "whisper", Aliases: []string{"w"}, Handler: func(ctx *CommandContext, args []string) error {
+		got = args
+		return nil
+	}})
+
+	if err := cm.HandleCommand(&CommandContext{User: "alice"}, "/W bob hi"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, " ") != "bob hi" {
+		t.Fatalf("got %q", got)
+	}
+	if cmd, ok := cm.Lookup("w"); !ok || cmd.Name != "whisper" {
+		t.Fatalf("Lookup(w) = %v, %v", cmd.Name, ok)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	cm := NewCommandManager()
+	var got []string
+	cm.Register(Command{
+		Name:    "whisper",
+		Args:    []Arg{{Name: "user"}, {Name: "message", Type: Text}},
+		Handler: func(ctx *CommandContext, args []string) error { got = args; return nil },
+	})
+	cm.Register(Command{
+		Name:    "vote",
+		Args:    []Arg{{Name: "number", Type: Number}, {Name: "note", Optional: true}},
+		Handler: func(ctx *CommandContext, args []string) error { return nil },
+	})
+
+	if cmd, _ := cm.Lookup("whisper"); cmd.Usage != "/whisper <user> <message>" {
+		t.Fatalf("usage = %q", cmd.Usage)
+	}
+	if err := cm.HandleCommand(&CommandContext{User: "alice"}, "/whisper bob"); err == nil || err.Error() != "Usage: /whisper <user> <message>" {
+		t.Fatalf("err = %v", err)
+	}
+	if err := cm.HandleCommand(&CommandContext{User: "alice"}, "/whisper bob hello  there"); err != nil || len(got) != 2 || got[1] != "hello  there" {
+		t.Fatalf("err = %v, args = %q", err, got)
+	}
+	for _, input := range []string{"/vote", "/vote x", "/vote 1 a b"} {
+		if err := cm.HandleCommand(&CommandContext{User: "alice"}, input); err == nil || err.Error() != "Usage: /vote <number> [<note>]" {
+			t.Fatalf("%s: err = %v", input, err)
+		}
+	}
+	if err := cm.HandleCommand(&CommandContext{User: "alice"}, "/vote 2"); err != nil {
+		t.Fatal(err)
+	}
+}
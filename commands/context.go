@@ -0,0 +1,41 @@
+package commands
+
+import "group-ssh-chat/ui"
+
+// Who runs a command, where and on what. Handlers read the room and the
+// session's abilities from here instead of looking them up, and replies go to
+// the session the command was typed in unless sent with ReplyAll.
+type CommandContext struct {
+	// The user running the command
+	User string
+	// The session the command was typed in
+	SessionID string
+	// The user's current room when the command was typed
+	Room string
+	// What the session's terminal can render
+	Caps ui.Capabilities
+	// Lines the session's terminal shows, 0 when its output is not paged
+	Height int
+
+	// Write a system message to the session, or to every session of the user
+	Write    func(text string)
+	WriteAll func(text string)
+}
+
+// Answers the invoking session with a system message. Returns nil, so a
+// handler can end with return ctx.Reply(...).
+func (ctx *CommandContext) Reply(text string) error {
+	if ctx.Write != nil {
+		ctx.Write(text)
+	}
+	return nil
+}
+
+// Like Reply, but tells every session of the user, for changes that affect
+// them all
+func (ctx *CommandContext) ReplyAll(text string) error {
+	if ctx.WriteAll != nil {
+		ctx.WriteAll(text)
+	}
+	return nil
+}
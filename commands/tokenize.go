@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Splits a command line into arguments on whitespace. A "double quoted"
+// argument can hold whitespace, and a backslash before a quote, a backslash
+// or whitespace makes it literal, so \" is a quote that opens nothing.
+// Quotes only open at the start of an argument, which keeps words like
+// don't or 5" as typed, and an unterminated quote runs to the end of the line.
+func Split(line string) []string {
+	return SplitN(line, 0)
+}
+
+// Like Split, but returns at most n arguments, the last one holding the rest
+// of the line as typed. An n of 0 means no limit.
+func SplitN(line string, n int) []string {
+	var args []string
+	rest := strings.TrimLeftFunc(line, unicode.IsSpace)
+	for rest != "" {
+		if n > 0 && len(args) == n-1 {
+			return append(args, strings.TrimRightFunc(rest, unicode.IsSpace))
+		}
+		var arg string
+		arg, rest = nextArg(rest)
+		args = append(args, arg)
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+	}
+	return args
+}
+
+// Returns the unquoted argument at the start of s and what follows it
+func nextArg(s string) (string, string) {
+	var sb strings.Builder
+	quoted := strings.HasPrefix(s, `"`)
+	if quoted {
+		s = s[1:]
+	}
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '\\' && i+size < len(s):
+			next, nextSize := utf8.DecodeRuneInString(s[i+size:])
+			if next == '"' || next == '\\' || unicode.IsSpace(next) {
+				sb.WriteString(s[i+size : i+size+nextSize])
+				size += nextSize
+			} else {
+				sb.WriteByte('\\')
+			}
+		case r == '"' && quoted:
+			quoted = false
+		case unicode.IsSpace(r) && !quoted:
+			return sb.String(), s[i:]
+		default:
+			sb.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return sb.String(), ""
+}
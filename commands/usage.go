@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"group-ssh-chat/i18n"
+	"strconv"
+	"strings"
+)
+
+// The kind of value an argument takes
+type ArgType int
+
+const (
+	// A single argument
+	Word ArgType = iota
+	// A whole number
+	Number
+	// The rest of the line as typed, only valid as the last argument
+	Text
+)
+
+// An argument of a command. Optional arguments come after the required ones.
+type Arg struct {
+	Name     string
+	Type     ArgType
+	Optional bool
+}
+
+// Returns the usage line described by the command's arguments, like
+// /whisper <user> <message>
+func (cmd Command) generatedUsage() string {
+	parts := []string{"/" + cmd.Name}
+	for _, arg := range cmd.Args {
+		if arg.Optional {
+			parts = append(parts, "[<"+arg.Name+">]")
+		} else {
+			parts = append(parts, "<"+arg.Name+">")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Checks the arguments against the command's spec. Commands without one
+// check their arguments themselves.
+func (cmd Command) checkArgs(args []string) error {
+	if cmd.Args == nil {
+		return nil
+	}
+	required := 0
+	for _, arg := range cmd.Args {
+		if !arg.Optional {
+			required++
+		}
+	}
+	if len(args) < required || len(args) > len(cmd.Args) {
+		return i18n.Errorf("Usage: %s", cmd.Usage)
+	}
+	for i, value := range args {
+		if cmd.Args[i].Type == Number {
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				return i18n.Errorf("Usage: %s", cmd.Usage)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/joho/godotenv"
+)
+
+// Settings that take effect when the configuration is reloaded
+var reloadable = []string{
+	"ADMIN_USERS",
+	"MOTD",
+	"DEFAULT_LANGUAGE",
+	"DEFAULT_ROOM",
+	"AUTO_ROOMS",
+	"ROOM_ARCHIVE_AFTER",
+	"SESSION_KEEPALIVE",
+	"BANNED_WORDS",
+	"EDIT_WINDOW",
+	"WRITE_TIMEOUT",
+	"MAX_SESSIONS_PER_USER",
+	"PRESENCE_BATCH_WINDOW",
+	"USERDATA_EXPORT_DIR",
+	"RESUME_GRACE",
+	"MAX_CONNECTIONS",
+	"MAX_CONNECTIONS_PER_IP",
+	"CONNECTION_COOLDOWN",
+	"AUTH_MAX_FAILURES",
+	"AUTH_FAILURE_WINDOW",
+	"AUTH_BLOCK_DURATION",
+	"AUTH_TARPIT_DELAY",
+	"SSH_BANNER",
+	"SSH_BANNER_PATH",
+	"MAX_MESSAGE_LENGTH",
+	"GUEST_POSTING",
+	"GUEST_SLOWMODE",
+}
+
+// Settings that are only read at startup. Reloads keep their current values
+// so the running server stays consistent with them.
+var immutable = []string{
+	"SSH_SERVER_HOST",
+	"SSH_SERVER_PORT",
+	"SSH_LISTEN_ADDRESSES",
+	"PROXY_PROTOCOL",
+	"PROXY_PROTOCOL_TRUSTED",
+	"HOST_SSH_PRIVATE_KEY_PATH",
+	"HOST_SSH_KEYS_DIR",
+	"HOST_SSH_KEY_PASSPHRASE",
+	"WEBSOCKET_LISTEN_ADDRESS",
+	"WEBSOCKET_TOKENS_PATH",
+	"WEBSOCKET_TLS_CERT_PATH",
+	"WEBSOCKET_TLS_KEY_PATH",
+	"TELNET_LISTEN_ADDRESS",
+	"TELNET_ALLOW_UNAUTHENTICATED",
+	"METRICS_LISTEN_ADDRESS",
+	"ADMIN_SOCKET_PATH",
+	"AUDIT_LOG_PATH",
+	"AUDIT_LOG_MESSAGES",
+	"AUDIT_LOG_MAX_SIZE_MB",
+	"AUDIT_LOG_MAX_BACKUPS",
+	"PREFERENCES_PATH",
+	"HISTORY_PATH",
+	"ROOMS_PATH",
+	"REMINDERS_PATH",
+	"INBOX_PATH",
+	"TOTP_SECRETS_PATH",
+	"REGISTERED_KEYS_PATH",
+	"PREVIEW_HOSTS",
+	"PREVIEW_MAX_BYTES",
+	"PREVIEW_TIMEOUT",
+	"GIF_API_KEY",
+	"GIF_API_URL",
+	"GIF_RATING",
+	"GIF_RESULTS",
+	"GIF_COOLDOWN",
+	"TRIVIA_QUESTIONS_PATH",
+	"TRIVIA_ROUNDS",
+	"TRIVIA_ROUND_TIME",
+	"HISTORY_MAX_AGE",
+	"HISTORY_MAX_MESSAGES",
+	"HISTORY_PRUNE_INTERVAL",
+	"CLUSTER_REDIS_URL",
+	"CLUSTER_CHANNEL",
+	"STORAGE_BACKEND",
+	"POSTGRES_URL",
+	"HISTORY_CACHE_SIZE",
+	"HISTORY_CACHE_ROOMS",
+	"HISTORY_CACHE_TTL",
+	"ARCHIVE_S3_BUCKET",
+	"ARCHIVE_S3_ENDPOINT",
+	"ARCHIVE_S3_REGION",
+	"ARCHIVE_S3_ACCESS_KEY",
+	"ARCHIVE_S3_SECRET_KEY",
+	"ARCHIVE_PREFIX",
+	"ARCHIVE_AFTER",
+	"ARCHIVE_AT",
+	"SEARCH_INDEX",
+	"LDAP_URL",
+	"LDAP_START_TLS",
+	"LDAP_BIND_DN",
+	"LDAP_BIND_PASSWORD",
+	"LDAP_BASE_DN",
+	"LDAP_USER_FILTER",
+	"LDAP_GROUP_ATTRIBUTE",
+	"LDAP_MEMBER_GROUP",
+	"LDAP_ADMIN_GROUP",
+	"OAUTH_GITHUB_CLIENT_ID",
+	"OAUTH_GITHUB_URL",
+	"OAUTH_GITHUB_API_URL",
+	"OAUTH_GOOGLE_CLIENT_ID",
+	"OAUTH_GOOGLE_CLIENT_SECRET",
+	"LINKED_IDENTITIES_PATH",
+	"AUTH_PROVIDERS",
+	"OPEN_REGISTRATION",
+	"REGISTRATION_REQUIRES_APPROVAL",
+	"STRICT_USERNAMES",
+	"AUTHORIZED_KEYS_PATH",
+	"DASHBOARD_LISTEN_ADDRESS",
+	"DASHBOARD_TOKEN",
+	"API_LISTEN_ADDRESS",
+	"API_TOKENS_PATH",
+	"GRPC_LISTEN_ADDRESS",
+	"GRPC_TLS_CERT_PATH",
+	"GRPC_TLS_KEY_PATH",
+	"GRPC_CLIENT_CA_PATH",
+	"EXEC_PLUGINS",
+	"WASM_PLUGINS_DIR",
+	"WASM_PLUGIN_MEMORY_MB",
+	"WASM_PLUGIN_TIMEOUT",
+	"LUA_SCRIPTS_DIR",
+	"LUA_SCRIPT_TIMEOUT",
+	"SSH_SERVER_VERSION",
+	"SSH_ALGORITHMS",
+	"SSH_CIPHERS",
+	"SSH_MACS",
+	"SSH_KEX_ALGORITHMS",
+	"HANDSHAKE_TIMEOUT",
+	"MAX_HANDSHAKES",
+	"HANDSHAKE_QUEUE",
+	"LOCALES_DIR",
+}
+
+// Used for re-reading the .env file at runtime and notifying the components
+// that support changing their settings while running
+type Reloader struct {
+	mu    sync.Mutex
+	hooks []func()
+}
+
+// Returns a reloader without any registered components
+func NewReloader() *Reloader {
+	return &Reloader{}
+}
+
+// Registers a function that re-reads its settings from the environment
+func (r *Reloader) OnReload(hook func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, hook)
+}
+
+// Reloads the configuration whenever the process receives SIGHUP
+func (r *Reloader) HandleSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			log.Println("Received SIGHUP, reloading configuration")
+			if err := r.Reload(); err != nil {
+				log.Printf("Failed to reload configuration: %v", err)
+			}
+		}
+	}()
+}
+
+// Re-reads the .env file, logs which settings changed and applies them
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	before := snapshot()
+	if err := godotenv.Overload(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	after := snapshot()
+
+	var changed []string
+	for _, key := range reloadable {
+		if before[key] != after[key] {
+			changed = append(changed, key)
+		}
+	}
+	for _, key := range immutable {
+		if before[key] != after[key] {
+			log.Printf("Config reload: %s changed but only takes effect after a restart", key)
+			os.Setenv(key, before[key])
+		}
+	}
+
+	if len(changed) == 0 {
+		log.Println("Config reload: no changes")
+	} else {
+		log.Printf("Config reload: changed %s", strings.Join(changed, ", "))
+	}
+	for _, hook := range r.hooks {
+		hook()
+	}
+	return nil
+}
+
+// Returns the current values of all known settings
+func snapshot() map[string]string {
+	values := map[string]string{}
+	for _, key := range append(reloadable, immutable...) {
+		values[key] = os.Getenv(key)
+	}
+	return values
+}
@@ -0,0 +1,59 @@
+package confusable
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Letters from other scripts, digits and symbols that are easily mistaken
+// for a Latin letter, mapped to that letter
+var lookalikes = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'i', 'ї': 'i', 'ј': 'j',
+	'ѕ': 's', 'ԁ': 'd', 'ӏ': 'l', 'һ': 'h', 'ԛ': 'q', 'ԝ': 'w', 'ь': 'b', 'ɡ': 'g',
+	'А': 'a', 'В': 'b', 'Е': 'e', 'К': 'k', 'М': 'm', 'Н': 'h', 'О': 'o', 'Р': 'p',
+	'С': 'c', 'Т': 't', 'У': 'y', 'Х': 'x', 'І': 'l', 'Ј': 'j', 'Ѕ': 's', 'Ԁ': 'd',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+	'τ': 't', 'υ': 'u', 'χ': 'x', 'γ': 'y', 'ω': 'w',
+	'Α': 'a', 'Β': 'b', 'Ε': 'e', 'Ζ': 'z', 'Η': 'h', 'Ι': 'l', 'Κ': 'k', 'Μ': 'm',
+	'Ν': 'n', 'Ο': 'o', 'Ρ': 'p', 'Τ': 't', 'Υ': 'y', 'Χ': 'x',
+	// Latin letters and digits that look alike
+	'I': 'l', '|': 'l', '1': 'l', '0': 'o', '5': 's', 'ı': 'i', 'ł': 'l', 'ø': 'o',
+}
+
+// Letter pairs that read as a single letter at a glance
+var pairs = strings.NewReplacer("rn", "m", "vv", "w", "cl", "d")
+
+// Returns the form of s that looks-alike strings share, e.g. "admin" for
+// both "Admin" and "аdmіn" with Cyrillic letters. Compatibility forms like
+// fullwidth letters are folded and accents dropped.
+func Skeleton(s string) string {
+	var sb strings.Builder
+	for _, r := range norm.NFKD.String(s) {
+		if unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Cf, r) {
+			// Accents and invisible characters
+			continue
+		}
+		if l, ok := lookalikes[r]; ok {
+			r = l
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return pairs.Replace(sb.String())
+}
+
+// Returns the first of the names that looks like name without being the
+// same name
+func Find(name string, names []string) (string, bool) {
+	skeleton := Skeleton(name)
+	for _, other := range names {
+		if other != name && Skeleton(other) == skeleton {
+			return other, true
+		}
+	}
+	return "", false
+}
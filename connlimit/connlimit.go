@@ -0,0 +1,119 @@
+package connlimit
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTooManyConnections       = errors.New("server connection limit reached")
+	ErrTooManyConnectionsFromIP = errors.New("per-IP connection limit reached")
+	ErrCoolingDown              = errors.New("IP is cooling down after exceeding the connection limit")
+)
+
+// Used for capping the number of concurrent connections in total and per
+// source IP. An IP that exceeds its cap is refused for a cooldown period.
+type Limiter struct {
+	mu            sync.Mutex
+	maxTotal      int
+	maxPerIP      int
+	cooldown      time.Duration
+	total         int
+	perIP         map[string]int
+	cooldownUntil map[string]time.Time
+}
+
+// Returns a limiter configured from MAX_CONNECTIONS, MAX_CONNECTIONS_PER_IP
+// and CONNECTION_COOLDOWN. A limit of zero means unlimited.
+func New() *Limiter {
+	l := &Limiter{
+		perIP:         map[string]int{},
+		cooldownUntil: map[string]time.Time{},
+	}
+	l.configure()
+
+	return l
+}
+
+// Re-reads the limits from the environment. Open connections are kept even
+// when they exceed the new limits.
+func (l *Limiter) Reload() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.configure()
+}
+
+// Reads the limits from the environment
+func (l *Limiter) configure() {
+	l.maxTotal = envInt("MAX_CONNECTIONS", 0)
+	l.maxPerIP = envInt("MAX_CONNECTIONS_PER_IP", 0)
+	l.cooldown = time.Minute
+	if d, err := time.ParseDuration(os.Getenv("CONNECTION_COOLDOWN")); err == nil {
+		l.cooldown = d
+	}
+}
+
+// Reserves a connection slot for the remote address. Every successful call
+// must be paired with a Release once the connection closes.
+func (l *Limiter) Acquire(addr net.Addr) error {
+	ip := hostOf(addr)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until, ok := l.cooldownUntil[ip]; ok {
+		if time.Now().Before(until) {
+			return ErrCoolingDown
+		}
+		delete(l.cooldownUntil, ip)
+	}
+	if l.maxTotal > 0 && l.total >= l.maxTotal {
+		return ErrTooManyConnections
+	}
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		if l.cooldown > 0 {
+			l.cooldownUntil[ip] = time.Now().Add(l.cooldown)
+		}
+		return ErrTooManyConnectionsFromIP
+	}
+
+	l.total++
+	l.perIP[ip]++
+	return nil
+}
+
+// Frees the slot reserved by Acquire
+func (l *Limiter) Release(addr net.Addr) {
+	ip := hostOf(addr)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if l.perIP[ip] <= 1 {
+		delete(l.perIP, ip)
+	} else {
+		l.perIP[ip]--
+	}
+}
+
+// Returns the IP part of a network address
+func hostOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func envInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,261 @@
+package fts
+
+import (
+	"group-ssh-chat/storage"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	// BM25 parameters: how quickly repeated terms stop adding to the score
+	// and how much long messages are penalised
+	bm25K1 = 1.2
+	bm25B  = 0.75
+
+	// Most results returned for a query, best first
+	maxResults = 200
+)
+
+// A full-text index over the message history kept in front of a history
+// store. Writes made through it update the index; messages written by
+// other server instances sharing the backend are picked up on restart.
+type IndexedHistory struct {
+	storage.History
+
+	mu       sync.RWMutex
+	postings map[string]map[int64]int
+	docs     map[int64]document
+	totalLen int
+}
+
+// An indexed message
+type document struct {
+	room   string
+	length int
+	terms  []string
+}
+
+// Returns history with a full-text index built from its messages when
+// SEARCH_INDEX is "true", or nil otherwise
+func New(history storage.History) *IndexedHistory {
+	if os.Getenv("SEARCH_INDEX") != "true" {
+		return nil
+	}
+
+	ih := &IndexedHistory{History: history}
+	start := time.Now()
+	ih.rebuild()
+	log.Printf("Indexed %d messages for search in %s", len(ih.docs), time.Since(start).Round(time.Millisecond))
+	return ih
+}
+
+// Returns the messages of the room containing every word of the query, best
+// matches first. A word also matches longer words starting with it. Queries
+// without any words, e.g. only punctuation, are matched as plain text.
+func (ih *IndexedHistory) SearchRanked(room string, query string) []storage.StoredMessage {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		query = strings.ToLower(query)
+		return ih.History.Search(room, func(text string) bool {
+			return strings.Contains(strings.ToLower(text), query)
+		})
+	}
+	ids := ih.rank(room, terms)
+
+	msgs := make([]storage.StoredMessage, 0, len(ids))
+	for _, id := range ids {
+		if msg, err := ih.History.Get(id); err == nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// Returns the IDs of the room's messages matching all terms, ordered by
+// their BM25 score and then newest first
+func (ih *IndexedHistory) rank(room string, terms []string) []int64 {
+	ih.mu.RLock()
+	defer ih.mu.RUnlock()
+
+	if len(terms) == 0 || len(ih.docs) == 0 {
+		return nil
+	}
+	avgLen := float64(ih.totalLen) / float64(len(ih.docs))
+	n := float64(len(ih.docs))
+
+	var scores map[int64]float64
+	for _, term := range terms {
+		// Score each message by its best matching word for the term
+		termScores := map[int64]float64{}
+		for word, docs := range ih.postings {
+			if !strings.HasPrefix(word, term) {
+				continue
+			}
+			df := float64(len(docs))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			for id, tf := range docs {
+				doc := ih.docs[id]
+				if doc.room != room {
+					continue
+				}
+				f := float64(tf)
+				score := idf * f * (bm25K1 + 1) / (f + bm25K1*(1-bm25B+bm25B*float64(doc.length)/avgLen))
+				if score > termScores[id] {
+					termScores[id] = score
+				}
+			}
+		}
+
+		if scores == nil {
+			scores = termScores
+			continue
+		}
+		for id := range scores {
+			if s, ok := termScores[id]; ok {
+				scores[id] += s
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	ids := make([]int64, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] > ids[j]
+	})
+	if len(ids) > maxResults {
+		ids = ids[:maxResults]
+	}
+	return ids
+}
+
+// Splits text into lower case words of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Adds a message to the index, replacing an earlier version of it
+func (ih *IndexedHistory) index(msg storage.StoredMessage) {
+	ih.mu.Lock()
+	defer ih.mu.Unlock()
+	ih.indexLocked(msg)
+}
+
+// Like index, with the lock held
+func (ih *IndexedHistory) indexLocked(msg storage.StoredMessage) {
+	ih.removeLocked(msg.ID)
+	if msg.Deleted {
+		return
+	}
+	words := tokenize(msg.Text)
+	doc := document{room: msg.Room, length: len(words)}
+	for _, word := range words {
+		docs, ok := ih.postings[word]
+		if !ok {
+			docs = map[int64]int{}
+			ih.postings[word] = docs
+		}
+		if docs[msg.ID] == 0 {
+			doc.terms = append(doc.terms, word)
+		}
+		docs[msg.ID]++
+	}
+	ih.docs[msg.ID] = doc
+	ih.totalLen += doc.length
+}
+
+// Drops a message from the index
+func (ih *IndexedHistory) removeLocked(id int64) {
+	doc, ok := ih.docs[id]
+	if !ok {
+		return
+	}
+	for _, word := range doc.terms {
+		delete(ih.postings[word], id)
+		if len(ih.postings[word]) == 0 {
+			delete(ih.postings, word)
+		}
+	}
+	delete(ih.docs, id)
+	ih.totalLen -= doc.length
+}
+
+// Indexes the whole history again, after changes too large to follow one
+// message at a time. Holding the lock throughout makes messages stored
+// meanwhile wait and get indexed afterwards.
+func (ih *IndexedHistory) rebuild() {
+	ih.mu.Lock()
+	defer ih.mu.Unlock()
+
+	ih.postings = map[string]map[int64]int{}
+	ih.docs = map[int64]document{}
+	ih.totalLen = 0
+	ih.History.Scan(ih.indexLocked)
+}
+
+// Stores a new message and indexes it
+func (ih *IndexedHistory) Append(msg storage.StoredMessage) (storage.StoredMessage, error) {
+	msg, err := ih.History.Append(msg)
+	if err == nil {
+		ih.index(msg)
+	}
+	return msg, err
+}
+
+// Replaces the text of a message and indexes the new text
+func (ih *IndexedHistory) Edit(id int64, text string) (storage.StoredMessage, error) {
+	msg, err := ih.History.Edit(id, text)
+	if err == nil {
+		ih.index(msg)
+	}
+	return msg, err
+}
+
+// Marks a message as deleted and drops it from the index
+func (ih *IndexedHistory) Delete(id int64) error {
+	if err := ih.History.Delete(id); err != nil {
+		return err
+	}
+	ih.mu.Lock()
+	defer ih.mu.Unlock()
+	ih.removeLocked(id)
+	return nil
+}
+
+// Deletes the user's messages and rebuilds the index
+func (ih *IndexedHistory) Scrub(user string) (int, error) {
+	defer ih.rebuild()
+	return ih.History.Scrub(user)
+}
+
+// Removes old messages and rebuilds the index
+func (ih *IndexedHistory) Prune(maxAge time.Duration, maxPerRoom int) (int, error) {
+	n, err := ih.History.Prune(maxAge, maxPerRoom)
+	if n > 0 {
+		ih.rebuild()
+	}
+	return n, err
+}
+
+// Removes messages of a room and rebuilds the index
+func (ih *IndexedHistory) Purge(room string, before time.Time) (int, error) {
+	n, err := ih.History.Purge(room, before)
+	if n > 0 {
+		ih.rebuild()
+	}
+	return n, err
+}
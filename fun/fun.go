@@ -0,0 +1,92 @@
+package fun
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxDice  = 100
+	maxSides = 1000
+)
+
+var ErrInvalidDice = fmt.Errorf("dice must look like 2d6 or 1d20+3, with at most %d dice of up to %d sides", maxDice, maxSides)
+
+// Matches dice notation: count, sides and an optional modifier
+var dicePattern = regexp.MustCompile(`^(\d*)d(\d+)([+-]\d+)?$`)
+
+// Returns a uniformly random integer in [0, n) read from crypto/rand
+func randomInt(n int) int {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		// The system's random source failing leaves nothing sensible to do.
+		panic(err)
+	}
+	return int(v.Int64())
+}
+
+// Rolls dice given in dice notation such as "2d6" or "d20+3" and returns a
+// description of the rolls and their total, e.g. "3 + 5 = 8"
+func Roll(spec string) (string, error) {
+	m := dicePattern.FindStringSubmatch(strings.ToLower(spec))
+	if m == nil {
+		return "", ErrInvalidDice
+	}
+	count := 1
+	if m[1] != "" {
+		count, _ = strconv.Atoi(m[1])
+	}
+	sides, _ := strconv.Atoi(m[2])
+	modifier := 0
+	if m[3] != "" {
+		modifier, _ = strconv.Atoi(m[3])
+	}
+	if count < 1 || count > maxDice || sides < 2 || sides > maxSides {
+		return "", ErrInvalidDice
+	}
+
+	rolls := make([]string, count)
+	total := modifier
+	for i := range rolls {
+		roll := randomInt(sides) + 1
+		rolls[i] = strconv.Itoa(roll)
+		total += roll
+	}
+	desc := strings.Join(rolls, " + ")
+	if modifier > 0 {
+		desc += fmt.Sprintf(" + %d", modifier)
+	} else if modifier < 0 {
+		desc += fmt.Sprintf(" - %d", -modifier)
+	}
+	if count == 1 && modifier == 0 {
+		return desc, nil
+	}
+	return fmt.Sprintf("%s = %d", desc, total), nil
+}
+
+// Flips a coin
+func Flip() string {
+	if randomInt(2) == 0 {
+		return "heads"
+	}
+	return "tails"
+}
+
+// Picks one of the "|" separated choices, e.g. "pizza | sushi | tacos"
+func Choose(choices string) (string, error) {
+	var options []string
+	for _, option := range strings.Split(choices, "|") {
+		if option = strings.TrimSpace(option); option != "" {
+			options = append(options, option)
+		}
+	}
+	if len(options) < 2 {
+		return "", errors.New("give at least two choices separated by |")
+	}
+	return options[randomInt(len(options))], nil
+}
@@ -0,0 +1,137 @@
+package giphy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Endpoint queried unless GIF_API_URL points elsewhere. Any API answering
+// in the Giphy search response format works.
+const defaultAPIURL = "https://api.giphy.com/v1/gifs/search"
+
+var (
+	ErrNoResults   = errors.New("no GIFs found")
+	ErrRateLimited = errors.New("GIF search rate limit reached")
+)
+
+// Used for searching a GIF API on behalf of chat users, who may each search
+// at most once per cooldown
+type Client struct {
+	apiURL   string
+	apiKey   string
+	rating   string
+	results  int
+	cooldown time.Duration
+	http     *http.Client
+
+	mu       sync.Mutex
+	lastUsed map[string]time.Time
+}
+
+// Search response, reduced to the fields used
+type searchResponse struct {
+	Data []struct {
+		URL    string `json:"url"`
+		Images struct {
+			Original struct {
+				URL string `json:"url"`
+			} `json:"original"`
+		} `json:"images"`
+	} `json:"data"`
+}
+
+// Returns a client using GIF_API_KEY, or nil when the variable is not set.
+// GIF_API_URL, GIF_RATING (default "g"), GIF_RESULTS (results to pick from,
+// default 10) and GIF_COOLDOWN (time between searches per user, default
+// 30s) tune it.
+func New() *Client {
+	apiKey := os.Getenv("GIF_API_KEY")
+	if apiKey == "" {
+		return nil
+	}
+
+	c := &Client{
+		apiURL:   os.Getenv("GIF_API_URL"),
+		apiKey:   apiKey,
+		rating:   os.Getenv("GIF_RATING"),
+		results:  10,
+		cooldown: 30 * time.Second,
+		http:     &http.Client{Timeout: 5 * time.Second},
+		lastUsed: map[string]time.Time{},
+	}
+	if c.apiURL == "" {
+		c.apiURL = defaultAPIURL
+	}
+	if c.rating == "" {
+		c.rating = "g"
+	}
+	if n, err := strconv.Atoi(os.Getenv("GIF_RESULTS")); err == nil && n > 0 {
+		c.results = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("GIF_COOLDOWN")); err == nil && d >= 0 {
+		c.cooldown = d
+	}
+
+	return c
+}
+
+// Searches for query on behalf of user and returns the URL of a random
+// result among the best matches
+func (c *Client) Search(user string, query string) (string, error) {
+	if err := c.reserve(user); err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"api_key": {c.apiKey},
+		"q":       {query},
+		"limit":   {strconv.Itoa(c.results)},
+		"rating":  {c.rating},
+	}
+	resp, err := c.http.Get(c.apiURL + "?" + params.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GIF API returned %s", resp.Status)
+	}
+
+	var result searchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid GIF API response: %w", err)
+	}
+	var urls []string
+	for _, gif := range result.Data {
+		if gif.Images.Original.URL != "" {
+			urls = append(urls, gif.Images.Original.URL)
+		} else if gif.URL != "" {
+			urls = append(urls, gif.URL)
+		}
+	}
+	if len(urls) == 0 {
+		return "", ErrNoResults
+	}
+	return urls[rand.Intn(len(urls))], nil
+}
+
+// Records a search by user, refusing it while the user is cooling down
+func (c *Client) reserve(user string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if last, ok := c.lastUsed[user]; ok && now.Sub(last) < c.cooldown {
+		return fmt.Errorf("%w, try again in %s", ErrRateLimited, (c.cooldown - now.Sub(last)).Round(time.Second))
+	}
+	c.lastUsed[user] = now
+	return nil
+}
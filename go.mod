@@ -3,10 +3,30 @@ module group-ssh-chat
 go 1.20
 
 require (
-	github.com/google/uuid v1.4.0
+	github.com/gdamore/tcell/v2 v2.8.1
+	github.com/go-ldap/ldap/v3 v3.4.6
+	github.com/google/uuid v1.6.0
 	github.com/joho/godotenv v1.5.1
-	golang.org/x/crypto v0.16.0
-	golang.org/x/term v0.15.0
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-runewidth v0.0.16
+	github.com/rivo/tview v0.42.0
+	github.com/tetratelabs/wazero v1.7.3
+	github.com/yuin/gopher-lua v1.1.1
+	golang.org/x/crypto v0.23.0
+	golang.org/x/net v0.25.0
+	golang.org/x/term v0.28.0
+	golang.org/x/text v0.21.0
+	google.golang.org/grpc v1.63.2
+	google.golang.org/protobuf v1.33.0
+	rsc.io/qr v0.2.0
 )
 
-require golang.org/x/sys v0.15.0 // indirect
+require (
+	github.com/Azure/go-ntlmssp v0.0.0-20221128193559-754e69321358 // indirect
+	github.com/gdamore/encoding v1.0.1 // indirect
+	github.com/go-asn1-ber/asn1-ber v1.5.5 // indirect
+	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
+	github.com/rivo/uniseg v0.4.7 // indirect
+	golang.org/x/sys v0.29.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240227224415-6ceb2ff114de // indirect
+)
@@ -0,0 +1,225 @@
+package graceful
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Environment variable telling an upgraded process which listeners it
+// inherited, as comma separated "<name>=<network>:<address>" entries in file
+// descriptor order starting at fd 3. The address is the one given to Listen.
+const inheritEnv = "GRACEFUL_LISTENERS"
+
+// How long a new process must stay up before the old one stops accepting
+const upgradeGracePeriod = 2 * time.Second
+
+// A listener that can be handed over to an upgraded process. The key is
+// what the new process passes to Listen, not the bound address, which
+// differs for wildcard hosts and port 0.
+type tracked struct {
+	name     string
+	key      string
+	listener net.Listener
+}
+
+var (
+	mu        sync.Mutex
+	listeners []tracked
+	inherited = map[string]net.Listener{}
+	upgrading = make(chan struct{})
+	upgraded  bool
+)
+
+// Picks up the listeners passed by the process that started this one during
+// an upgrade. Must be called before any Listen.
+func Init() error {
+	spec := os.Getenv(inheritEnv)
+	os.Unsetenv(inheritEnv)
+	if spec == "" {
+		return nil
+	}
+
+	entries := strings.Split(spec, ",")
+	files := make([]*os.File, len(entries))
+	for i, entry := range entries {
+		fd := 3 + i
+		syscall.CloseOnExec(fd)
+		files[i] = os.NewFile(uintptr(fd), entry)
+	}
+	if err := inherit(entries, files); err != nil {
+		return err
+	}
+	log.Printf("Inherited %d listeners from the previous process", len(inherited))
+	return nil
+}
+
+// Turns the files passed by the previous process into listeners under their
+// entries. The files are closed.
+func inherit(entries []string, files []*os.File) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i, entry := range entries {
+		listener, err := net.FileListener(files[i])
+		files[i].Close()
+		if err != nil {
+			return fmt.Errorf("inherited fd %d (%s) is not a listening socket: %w", 3+i, entry, err)
+		}
+		inherited[entry] = listener
+	}
+	return nil
+}
+
+// Returns the key a listener is inherited under
+func listenerKey(name string, network string, address string) string {
+	return name + "=" + network + ":" + address
+}
+
+// Returns the inherited listener for the name and address, or opens a new
+// one. Unix socket paths left behind by a previous run are removed first.
+// The listener is handed over to the new process on Upgrade.
+func Listen(name string, network string, address string) (net.Listener, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	key := listenerKey(name, network, address)
+	listener, ok := inherited[key]
+	if ok {
+		delete(inherited, key)
+	} else {
+		if network == "unix" {
+			if err := os.Remove(address); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+		var err error
+		if listener, err = net.Listen(network, address); err != nil {
+			return nil, err
+		}
+	}
+	listeners = append(listeners, tracked{name: name, key: key, listener: listener})
+	return listener, nil
+}
+
+// Returns the inherited listeners registered under the name with Track
+func Take(name string) []net.Listener {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var taken []net.Listener
+	for key, listener := range inherited {
+		if strings.HasPrefix(key, name+"=") {
+			taken = append(taken, listener)
+			delete(inherited, key)
+		}
+	}
+	return taken
+}
+
+// Registers a listener that was not opened by Listen, such as a socket
+// passed by systemd, so that it is handed over on Upgrade as well
+func Track(name string, listener net.Listener) {
+	mu.Lock()
+	defer mu.Unlock()
+	addr := listener.Addr()
+	key := listenerKey(name, addr.Network(), addr.String())
+	listeners = append(listeners, tracked{name: name, key: key, listener: listener})
+}
+
+// Returns a channel that is closed once an upgrade has handed over the
+// listeners and this process should drain its remaining sessions
+func Upgrading() <-chan struct{} {
+	return upgrading
+}
+
+// Starts a new copy of the executable that inherits all listeners, then
+// closes them in this process so new connections go to the new process only.
+// Existing connections are left alone.
+func Upgrade() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if upgraded {
+		return errors.New("an upgrade is already in progress")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	files, entries, err := handoff()
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(), inheritEnv+"="+strings.Join(entries, ","))
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// Keep serving if the new process fails during startup.
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		return fmt.Errorf("new process exited during startup: %v", err)
+	case <-time.After(upgradeGracePeriod):
+	}
+
+	log.Printf("Started new process %d, no longer accepting connections", cmd.Process.Pid)
+	upgraded = true
+	close(upgrading)
+	for _, t := range listeners {
+		// The socket file now belongs to the new process.
+		if ul, ok := t.listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+		t.listener.Close()
+	}
+	return nil
+}
+
+// Returns duplicates of the tracked listeners' file descriptors with the
+// entries to inherit them under
+func handoff() ([]*os.File, []string, error) {
+	var files []*os.File
+	var entries []string
+	for _, t := range listeners {
+		file, err := listenerFile(t.listener)
+		if err != nil {
+			return files, nil, fmt.Errorf("cannot hand over %s listener %s: %w", t.name, t.listener.Addr(), err)
+		}
+		files = append(files, file)
+		entries = append(entries, t.key)
+	}
+	return files, entries, nil
+}
+
+// Returns a duplicate of the listener's file descriptor
+func listenerFile(listener net.Listener) (*os.File, error) {
+	switch l := listener.(type) {
+	case *net.TCPListener:
+		return l.File()
+	case *net.UnixListener:
+		return l.File()
+	}
+	return nil, fmt.Errorf("unsupported listener type %T", listener)
+}
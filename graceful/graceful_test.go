@@ -0,0 +1,85 @@
+package graceful
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Hands the tracked listeners over to this process again, as an upgrade
+// does for the new one
+func reinherit(t *testing.T) {
+	t.Helper()
+	mu.Lock()
+	files, entries, err := handoff()
+	for _, l := range listeners {
+		if ul, ok := l.listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+		l.listener.Close()
+	}
+	listeners = nil
+	mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inherit(entries, files); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpgradeKeepsListeners(t *testing.T) {
+	t.Cleanup(func() {
+		for _, l := range listeners {
+			l.listener.Close()
+		}
+		listeners = nil
+		inherited = map[string]net.Listener{}
+	})
+
+	socket := filepath.Join(t.TempDir(), "admin.sock")
+	tests := []struct {
+		name    string
+		network string
+		address string
+	}{
+		{"ssh", "tcp", ":0"},
+		{"web", "tcp", "0.0.0.0:0"},
+		{"admin", "unix", socket},
+	}
+
+	bound := map[string]string{}
+	for _, tt := range tests {
+		listener, err := Listen(tt.name, tt.network, tt.address)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		bound[tt.name] = listener.Addr().String()
+	}
+
+	reinherit(t)
+
+	for _, tt := range tests {
+		listener, err := Listen(tt.name, tt.network, tt.address)
+		if err != nil {
+			t.Fatalf("%s: listening again: %v", tt.name, err)
+		}
+		if got := listener.Addr().String(); got != bound[tt.name] {
+			t.Errorf("%s: got a new listener on %s, want the inherited one on %s", tt.name, got, bound[tt.name])
+		}
+	}
+	if len(inherited) != 0 {
+		t.Errorf("listeners left unclaimed: %v", inherited)
+	}
+	if _, err := os.Stat(socket); err != nil {
+		t.Errorf("inherited unix socket was removed: %v", err)
+	}
+
+	// The inherited socket must still accept connections.
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("dialing inherited unix socket: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,190 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"group-ssh-chat/chat"
+	"group-ssh-chat/graceful"
+	"group-ssh-chat/grpcapi/chatpb"
+	"group-ssh-chat/storage"
+	"log"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Number of history messages read at a time when a subscriber catches up
+const replayPageSize = 500
+
+// Serves the Chat gRPC service. Clients must present a certificate signed
+// by GRPC_CLIENT_CA_PATH; its common name is the name they publish as.
+type Server struct {
+	chatpb.UnimplementedChatServer
+	listenAddress string
+	tlsConfig     *tls.Config
+	hub           *chat.Hub
+}
+
+// Returns a gRPC server listening on GRPC_LISTEN_ADDRESS, or nil when the
+// variable is not set. GRPC_TLS_CERT_PATH, GRPC_TLS_KEY_PATH and
+// GRPC_CLIENT_CA_PATH are required with it.
+func New(hub *chat.Hub) *Server {
+	listenAddress := os.Getenv("GRPC_LISTEN_ADDRESS")
+	if listenAddress == "" {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(os.Getenv("GRPC_TLS_CERT_PATH"), os.Getenv("GRPC_TLS_KEY_PATH"))
+	if err != nil {
+		log.Fatalf("Failed to load the gRPC certificate, err: %v", err)
+	}
+	caBytes, err := os.ReadFile(os.Getenv("GRPC_CLIENT_CA_PATH"))
+	if err != nil {
+		log.Fatalf("Failed to load the gRPC client CA, err: %v", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caBytes) {
+		log.Fatal("No certificates found in GRPC_CLIENT_CA_PATH")
+	}
+
+	return &Server{
+		listenAddress: listenAddress,
+		tlsConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clientCAs,
+			MinVersion:   tls.VersionTLS12,
+		},
+		hub: hub,
+	}
+}
+
+// Serves the Chat service until the listener fails
+func (s *Server) ListenAndServe() error {
+	listener, err := graceful.Listen("grpc", "tcp", s.listenAddress)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(s.tlsConfig)))
+	chatpb.RegisterChatServer(server, s)
+
+	log.Println("gRPC API is serving on", s.listenAddress)
+	return server.Serve(listener)
+}
+
+// Streams the messages of a room the client may read, first those after the
+// requested ID. The stream ends when the client loses access to the room.
+func (s *Server) Subscribe(req *chatpb.SubscribeRequest, stream chatpb.Chat_SubscribeServer) error {
+	client, err := clientName(stream.Context())
+	if err != nil {
+		return err
+	}
+	if err := s.checkAccess(client, req.Room); err != nil {
+		return err
+	}
+	// Subscribe before replaying so nothing sent in between is missed.
+	sub, err := s.hub.Subscribe(req.Room)
+	if err != nil {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	defer s.hub.Unsubscribe(sub)
+
+	last := req.AfterId
+	if last > 0 {
+		for {
+			if err := s.checkAccess(client, req.Room); err != nil {
+				return err
+			}
+			messages, err := s.hub.RoomHistory(req.Room, last, replayPageSize)
+			if err != nil {
+				return status.Error(codes.NotFound, err.Error())
+			}
+			for _, msg := range messages {
+				if err := stream.Send(toProto(msg)); err != nil {
+					return err
+				}
+				last = msg.ID
+			}
+			if len(messages) < replayPageSize {
+				break
+			}
+		}
+	}
+
+	log.Printf("gRPC client %s subscribed to #%s", client, req.Room)
+	for {
+		select {
+		case msg, ok := <-sub.Messages():
+			if !ok {
+				return status.Errorf(codes.ResourceExhausted, "fell behind, resubscribe with after_id %d", last)
+			}
+			if msg.ID <= last {
+				continue
+			}
+			if err := s.checkAccess(client, req.Room); err != nil {
+				return err
+			}
+			if err := stream.Send(toProto(msg)); err != nil {
+				return err
+			}
+			last = msg.ID
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// Returns a PermissionDenied error unless the client may read the room
+func (s *Server) checkAccess(client string, room string) error {
+	if !s.hub.CanAccess(client, room) {
+		return status.Errorf(codes.PermissionDenied, "#%s is private and %s has not been invited", room, client)
+	}
+	return nil
+}
+
+// Posts a message to a room as the client
+func (s *Server) Publish(ctx context.Context, req *chatpb.PublishRequest) (*chatpb.PublishResponse, error) {
+	client, err := clientName(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.hub.Post(client, req.Room, req.Text); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &chatpb.PublishResponse{}, nil
+}
+
+// Returns the common name of the client certificate
+func clientName(ctx context.Context) (string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "no peer")
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return "", status.Error(codes.Unauthenticated, "no client certificate")
+	}
+	name := info.State.PeerCertificates[0].Subject.CommonName
+	if name == "" {
+		return "", status.Error(codes.Unauthenticated, "client certificate has no common name")
+	}
+	return name, nil
+}
+
+// Converts a stored message into its wire form
+func toProto(msg storage.StoredMessage) *chatpb.Message {
+	return &chatpb.Message{
+		Id:      msg.ID,
+		Room:    msg.Room,
+		From:    msg.From,
+		Text:    msg.Text,
+		Time:    timestamppb.New(msg.Time),
+		ReplyTo: msg.ReplyTo,
+	}
+}
@@ -0,0 +1,51 @@
+package i18n
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// An error whose message can be shown in the language of whoever reads it
+type Error struct {
+	format string
+	args   []any
+	err    error
+}
+
+// Returns an error formatted like fmt.Errorf, including wrapping with %w,
+// that Localize translates
+func Errorf(format string, args ...any) error {
+	return &Error{format: format, args: args, err: fmt.Errorf(format, args...)}
+}
+
+// Returns the message in English
+func (e *Error) Error() string {
+	return e.err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return errors.Unwrap(e.err)
+}
+
+// Returns the message of an error in the language. Errors made by Errorf
+// are translated with their arguments, errors they wrap included; the text
+// of any other error is looked up as is.
+func Localize(lang string, err error) string {
+	e, ok := err.(*Error)
+	if !ok {
+		return Translate(lang, err.Error())
+	}
+	args := make([]any, len(e.args))
+	for i, arg := range e.args {
+		if inner, ok := arg.(error); ok {
+			arg = Localize(lang, inner)
+		}
+		args[i] = arg
+	}
+	format := e.format
+	if text, ok := lookup(lang, format, "other"); ok {
+		format = text
+	}
+	return fmt.Sprintf(strings.ReplaceAll(format, "%w", "%v"), args...)
+}
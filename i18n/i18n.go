@@ -0,0 +1,189 @@
+// Package i18n translates the chat's system messages. The English text of
+// a message is its key: catalogs map it to the text in another language, or
+// to one text per plural form. Catalogs for some languages are built in and
+// more can be added or overridden with JSON files in LOCALES_DIR, named by
+// language code, e.g. de.json:
+//
+//	{
+//	  "You joined #%s": "Du bist #%s beigetreten",
+//	  "%d user": {"one": "%d Benutzer", "other": "%d Benutzer"}
+//	}
+//
+// Translations keep the verbs of the English text and may reorder them with
+// explicit argument indexes like %[2]s.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Language of the message keys, which needs no catalog
+const English = "en"
+
+//go:embed locales/*.json
+var builtin embed.FS
+
+// The texts of one message keyed by plural form ("one", "few", "many" or
+// "other"). Messages without plural forms only have "other".
+type entry map[string]string
+
+func (e *entry) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*e = entry{"other": text}
+		return nil
+	}
+	forms := map[string]string{}
+	if err := json.Unmarshal(data, &forms); err != nil {
+		return errors.New("expected a string or an object of plural forms")
+	}
+	if forms["other"] == "" {
+		return errors.New(`plural forms without "other"`)
+	}
+	*e = forms
+	return nil
+}
+
+var (
+	catalogsMutex sync.RWMutex
+	catalogs      = map[string]map[string]entry{}
+)
+
+func init() {
+	paths, _ := builtin.ReadDir("locales")
+	for _, p := range paths {
+		data, err := builtin.ReadFile("locales/" + p.Name())
+		if err != nil {
+			panic(err)
+		}
+		if err := addCatalog(strings.TrimSuffix(p.Name(), ".json"), data); err != nil {
+			panic(fmt.Sprintf("built-in catalog %s: %v", p.Name(), err))
+		}
+	}
+}
+
+// Loads the catalogs in LOCALES_DIR, if set. Their messages are added to
+// the built-in catalog of the same language, replacing its translations.
+func Load() {
+	dir := os.Getenv("LOCALES_DIR")
+	if dir == "" {
+		return
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		log.Printf("Failed to list message catalogs: %v", err)
+		return
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = addCatalog(strings.TrimSuffix(filepath.Base(path), ".json"), data)
+		}
+		if err != nil {
+			log.Printf("Failed to load message catalog %s: %v", path, err)
+		}
+	}
+}
+
+func addCatalog(lang string, data []byte) error {
+	var messages map[string]entry
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return err
+	}
+	lang = strings.ToLower(lang)
+	catalogsMutex.Lock()
+	defer catalogsMutex.Unlock()
+	if catalogs[lang] == nil {
+		catalogs[lang] = map[string]entry{}
+	}
+	for key, e := range messages {
+		catalogs[lang][key] = e
+	}
+	return nil
+}
+
+// Returns the languages messages can be shown in, sorted
+func Languages() []string {
+	catalogsMutex.RLock()
+	defer catalogsMutex.RUnlock()
+	langs := []string{English}
+	for lang := range catalogs {
+		if lang != English {
+			langs = append(langs, lang)
+		}
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Reports whether messages can be shown in the language
+func Supported(lang string) bool {
+	if lang == English {
+		return true
+	}
+	catalogsMutex.RLock()
+	defer catalogsMutex.RUnlock()
+	return catalogs[lang] != nil
+}
+
+// Returns the supported language of a POSIX locale like "de_DE.UTF-8", or
+// "" when there is none
+func Match(locale string) string {
+	if i := strings.IndexAny(locale, "_.@"); i >= 0 {
+		locale = locale[:i]
+	}
+	lang := strings.ToLower(locale)
+	if lang == "" || !Supported(lang) {
+		return ""
+	}
+	return lang
+}
+
+// Returns the form of the message in the language, or nothing when it has
+// not been translated
+func lookup(lang string, key string, form string) (string, bool) {
+	catalogsMutex.RLock()
+	defer catalogsMutex.RUnlock()
+	e, ok := catalogs[lang][key]
+	if !ok {
+		return "", false
+	}
+	if text, ok := e[form]; ok {
+		return text, true
+	}
+	return e["other"], true
+}
+
+// Formats a message in the language, falling back to English
+func Translate(lang string, format string, args ...any) string {
+	if text, ok := lookup(lang, format, "other"); ok {
+		format = text
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Formats the singular or a plural form of a message for a count of n in
+// the language. The English singular is the key of the message; the count
+// is passed among args like any other value.
+func Plural(lang string, n int, one string, other string, args ...any) string {
+	format := other
+	if n == 1 {
+		format = one
+	}
+	if text, ok := lookup(lang, one, pluralForm(lang, n)); ok {
+		format = text
+	}
+	return fmt.Sprintf(format, args...)
+}
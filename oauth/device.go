@@ -0,0 +1,281 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDenied  = errors.New("authorization was denied")
+	ErrExpired = errors.New("the code expired before it was entered")
+)
+
+// An account at an identity provider
+type Identity struct {
+	Provider string
+	Subject  string
+	Login    string
+	Name     string
+}
+
+// A pending device authorization. The user enters Code at URL while the
+// server polls for the outcome.
+type DeviceCode struct {
+	Provider  string
+	Code      string
+	URL       string
+	ExpiresAt time.Time
+
+	provider   *provider
+	deviceCode string
+	interval   time.Duration
+}
+
+// Used for linking chat accounts to accounts at identity providers with the
+// OAuth 2.0 device authorization grant, which needs no browser redirect back
+// to the server
+type Linker struct {
+	providers map[string]*provider
+	http      *http.Client
+}
+
+// An identity provider supporting the device flow
+type provider struct {
+	name         string
+	deviceURL    string
+	tokenURL     string
+	userURL      string
+	clientID     string
+	clientSecret string
+	scope        string
+	identity     func(body []byte) (Identity, error)
+}
+
+// Returns a linker for the providers with a client ID set, or nil when there
+// are none. OAUTH_GITHUB_CLIENT_ID enables GitHub, OAUTH_GITHUB_URL and
+// OAUTH_GITHUB_API_URL point it at GitHub Enterprise.
+// OAUTH_GOOGLE_CLIENT_ID and OAUTH_GOOGLE_CLIENT_SECRET enable Google.
+func New() *Linker {
+	l := &Linker{
+		providers: map[string]*provider{},
+		http:      &http.Client{Timeout: 10 * time.Second},
+	}
+
+	if clientID := os.Getenv("OAUTH_GITHUB_CLIENT_ID"); clientID != "" {
+		base := strings.TrimSuffix(os.Getenv("OAUTH_GITHUB_URL"), "/")
+		if base == "" {
+			base = "https://github.com"
+		}
+		api := strings.TrimSuffix(os.Getenv("OAUTH_GITHUB_API_URL"), "/")
+		if api == "" {
+			api = "https://api.github.com"
+		}
+		l.providers["github"] = &provider{
+			name:      "GitHub",
+			deviceURL: base + "/login/device/code",
+			tokenURL:  base + "/login/oauth/access_token",
+			userURL:   api + "/user",
+			clientID:  clientID,
+			scope:     "read:user",
+			identity:  githubIdentity,
+		}
+	}
+	if clientID := os.Getenv("OAUTH_GOOGLE_CLIENT_ID"); clientID != "" {
+		l.providers["google"] = &provider{
+			name:         "Google",
+			deviceURL:    "https://oauth2.googleapis.com/device/code",
+			tokenURL:     "https://oauth2.googleapis.com/token",
+			userURL:      "https://openidconnect.googleapis.com/v1/userinfo",
+			clientID:     clientID,
+			clientSecret: os.Getenv("OAUTH_GOOGLE_CLIENT_SECRET"),
+			scope:        "openid profile email",
+			identity:     googleIdentity,
+		}
+	}
+
+	if len(l.providers) == 0 {
+		return nil
+	}
+	return l
+}
+
+// Returns the keys of the configured providers, e.g. "github"
+func (l *Linker) Providers() []string {
+	names := make([]string, 0, len(l.providers))
+	for name := range l.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Requests a code for the user to enter at the provider
+func (l *Linker) Start(providerKey string) (*DeviceCode, error) {
+	p, ok := l.providers[providerKey]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q", providerKey)
+	}
+
+	var resp struct {
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURI string `json:"verification_uri"`
+		// Google's name for verification_uri
+		VerificationURL string `json:"verification_url"`
+		ExpiresIn       int    `json:"expires_in"`
+		Interval        int    `json:"interval"`
+	}
+	status, err := l.post(p.deviceURL, url.Values{"client_id": {p.clientID}, "scope": {p.scope}}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK || resp.DeviceCode == "" {
+		return nil, fmt.Errorf("%s refused the device code request (status %d)", p.name, status)
+	}
+
+	dc := &DeviceCode{
+		Provider:   p.name,
+		Code:       resp.UserCode,
+		URL:        resp.VerificationURI,
+		ExpiresAt:  time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second),
+		provider:   p,
+		deviceCode: resp.DeviceCode,
+		interval:   time.Duration(resp.Interval) * time.Second,
+	}
+	if dc.URL == "" {
+		dc.URL = resp.VerificationURL
+	}
+	if dc.interval <= 0 {
+		dc.interval = 5 * time.Second
+	}
+	return dc, nil
+}
+
+// Polls the provider until the user entered the code, then returns their
+// identity. Gives up when the code expires or ctx is done.
+func (l *Linker) Wait(ctx context.Context, dc *DeviceCode) (Identity, error) {
+	p := dc.provider
+	interval := dc.interval
+	for {
+		select {
+		case <-ctx.Done():
+			return Identity{}, ctx.Err()
+		case <-time.After(interval):
+		}
+		if time.Now().After(dc.ExpiresAt) {
+			return Identity{}, ErrExpired
+		}
+
+		var resp struct {
+			AccessToken string `json:"access_token"`
+			Error       string `json:"error"`
+		}
+		form := url.Values{
+			"client_id":   {p.clientID},
+			"device_code": {dc.deviceCode},
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		}
+		if p.clientSecret != "" {
+			form.Set("client_secret", p.clientSecret)
+		}
+		// Providers answer pending polls with an error code, which GitHub
+		// sends with status 200 and Google with 4xx.
+		if _, err := l.post(p.tokenURL, form, &resp); err != nil {
+			return Identity{}, err
+		}
+		switch resp.Error {
+		case "":
+			if resp.AccessToken == "" {
+				return Identity{}, fmt.Errorf("%s returned no access token", p.name)
+			}
+			return l.identity(p, resp.AccessToken)
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "access_denied":
+			return Identity{}, ErrDenied
+		case "expired_token":
+			return Identity{}, ErrExpired
+		default:
+			return Identity{}, fmt.Errorf("%s: %s", p.name, resp.Error)
+		}
+	}
+}
+
+// Fetches the account the access token belongs to
+func (l *Linker) identity(p *provider, token string) (Identity, error) {
+	req, err := http.NewRequest(http.MethodGet, p.userURL, nil)
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	res, err := l.http.Do(req)
+	if err != nil {
+		return Identity{}, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return Identity{}, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return Identity{}, fmt.Errorf("%s user lookup failed (status %d)", p.name, res.StatusCode)
+	}
+	return p.identity(body)
+}
+
+// Posts a form and decodes the JSON response, returning its status
+func (l *Linker) post(endpoint string, form url.Values, v any) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	res, err := l.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v); err != nil {
+		return res.StatusCode, fmt.Errorf("unexpected response from %s (status %d)", endpoint, res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// Parses GitHub's user response
+func githubIdentity(body []byte) (Identity, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := json.Unmarshal(body, &user); err != nil || user.ID == 0 {
+		return Identity{}, errors.New("unexpected GitHub user response")
+	}
+	return Identity{Provider: "github", Subject: strconv.FormatInt(user.ID, 10), Login: user.Login, Name: user.Name}, nil
+}
+
+// Parses Google's OpenID Connect userinfo response
+func googleIdentity(body []byte) (Identity, error) {
+	var user struct {
+		Sub   string `json:"sub"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(body, &user); err != nil || user.Sub == "" {
+		return Identity{}, errors.New("unexpected Google user response")
+	}
+	return Identity{Provider: "google", Subject: user.Sub, Login: user.Email, Name: user.Name}, nil
+}
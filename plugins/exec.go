@@ -0,0 +1,215 @@
+package plugins
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"group-ssh-chat/chat"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Number of events held for a plugin that is busy or restarting. Further
+// events are dropped.
+const eventBuffer = 256
+
+// Longest wait before restarting a plugin that keeps exiting
+const maxRestartDelay = time.Minute
+
+// An event written to a plugin's stdin as one JSON line
+type Event struct {
+	Type string `json:"type"` // "message", "join" or "leave"
+	Room string `json:"room"`
+	User string `json:"user"`
+	Text string `json:"text,omitempty"`
+}
+
+// An action read from a plugin's stdout as one JSON line:
+//
+//	{"action": "post", "room": "lobby", "text": "hello"}
+//	{"action": "mute", "room": "lobby", "user": "spammer", "duration": "10m"}
+type Action struct {
+	Action   string `json:"action"`
+	Room     string `json:"room"`
+	Text     string `json:"text,omitempty"`
+	User     string `json:"user,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// An external program extending the chat. It receives chat events on stdin
+// and answers with actions on stdout, posting under the name of its
+// executable. Plugins that exit are restarted.
+type ExecPlugin struct {
+	name   string
+	path   string
+	hub    *chat.Hub
+	events chan Event
+}
+
+// Starts the programs listed in EXEC_PLUGINS, separated by commas
+func StartExecPlugins(hub *chat.Hub) {
+	for _, path := range strings.Split(os.Getenv("EXEC_PLUGINS"), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		p := &ExecPlugin{
+			name:   strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+			path:   path,
+			hub:    hub,
+			events: make(chan Event, eventBuffer),
+		}
+		go p.run()
+		hub.AddBot(p)
+	}
+}
+
+// Queues a chat message for the plugin, except its own
+func (p *ExecPlugin) OnMessage(msg chat.Message) {
+	if msg.From != p.name {
+		p.send(Event{Type: "message", Room: msg.Room, User: msg.From, Text: msg.Text})
+	}
+}
+
+// Queues a join or leave for the plugin
+func (p *ExecPlugin) OnPresence(room string, user string, joined bool) {
+	event := Event{Type: "leave", Room: room, User: user}
+	if joined {
+		event.Type = "join"
+	}
+	p.send(event)
+}
+
+func (p *ExecPlugin) send(event Event) {
+	select {
+	case p.events <- event:
+	default:
+		log.Printf("Plugin %s is not keeping up, dropped a %s event", p.name, event.Type)
+	}
+}
+
+// Keeps the plugin running, waiting longer between restarts the more often
+// it exits shortly after starting
+func (p *ExecPlugin) run() {
+	delay := time.Second
+	for {
+		started := time.Now()
+		if err := p.runOnce(); err != nil {
+			log.Printf("Plugin %s exited: %v", p.name, err)
+		} else {
+			log.Printf("Plugin %s exited", p.name)
+		}
+		if time.Since(started) > maxRestartDelay {
+			delay = time.Second
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+// Runs the program until it exits, feeding it events and carrying out its
+// actions
+func (p *ExecPlugin) runOnce() error {
+	cmd := exec.Command(p.path)
+	cmd.Stderr = &logWriter{prefix: "Plugin " + p.name + ": "}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Printf("Started plugin %s (pid %d)", p.name, cmd.Process.Pid)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.readActions(stdout)
+	}()
+
+	encoder := json.NewEncoder(stdin)
+	for {
+		select {
+		case event := <-p.events:
+			if err := encoder.Encode(event); err != nil {
+				// The plugin stopped reading; wait for it to exit.
+				<-done
+				return cmd.Wait()
+			}
+		case <-done:
+			stdin.Close()
+			return cmd.Wait()
+		}
+	}
+}
+
+// Carries out the actions the plugin writes until its stdout is closed
+func (p *ExecPlugin) readActions(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var action Action
+		if err := json.Unmarshal([]byte(line), &action); err != nil {
+			log.Printf("Plugin %s wrote an invalid action: %q", p.name, line)
+			continue
+		}
+		if err := p.perform(action); err != nil {
+			log.Printf("Plugin %s %s failed: %v", p.name, action.Action, err)
+		}
+	}
+}
+
+func (p *ExecPlugin) perform(action Action) error {
+	switch action.Action {
+	case "post":
+		if strings.TrimSpace(action.Text) == "" {
+			return errors.New("empty text")
+		}
+		return p.hub.Say(p.name, action.Room, action.Text)
+	case "mute":
+		var d time.Duration
+		if action.Duration != "" {
+			var err error
+			if d, err = time.ParseDuration(action.Duration); err != nil || d < 0 {
+				return fmt.Errorf("invalid duration %q", action.Duration)
+			}
+		}
+		return p.hub.Mute(p.name, action.Room, action.User, d)
+	}
+	return fmt.Errorf("unknown action %q", action.Action)
+}
+
+// Writes a plugin's stderr to the server log line by line
+type logWriter struct {
+	prefix string
+	buf    []byte
+}
+
+func (lw *logWriter) Write(b []byte) (int, error) {
+	lw.buf = append(lw.buf, b...)
+	for {
+		i := bytes.IndexByte(lw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		log.Print(lw.prefix + string(lw.buf[:i]))
+		lw.buf = lw.buf[i+1:]
+	}
+	return len(b), nil
+}
@@ -0,0 +1,262 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"group-ssh-chat/chat"
+	"group-ssh-chat/commands"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// How long a script may run for one command or message unless
+// LUA_SCRIPT_TIMEOUT says otherwise
+const defaultLuaTimeout = time.Second
+
+// A Lua script from LUA_SCRIPTS_DIR adding custom commands and
+// auto-responses. Scripts only get the base, string, table and math
+// libraries, without the functions that load code or files, and every call
+// into them is aborted after a timeout. Each script posts under the name of
+// its file.
+//
+// When loaded, a script registers its handlers with the chat table:
+//
+//	chat.command(name, usage, description, fn(sender, args))  adds /<name>
+//	chat.on_message(pattern, fn(room, from, text, ...))       runs fn for
+//	    room messages matching the Lua pattern, with its captures
+//
+// and handlers act through:
+//
+//	chat.send(room, text)   posts to a room as the script
+//	chat.reply(user, text)  tells a user privately
+//	chat.users(room)        returns the users in a room
+//	chat.room_of(user)      returns the room a user is in
+type LuaScript struct {
+	name     string
+	hub      *chat.Hub
+	timeout  time.Duration
+	commands []commands.Command
+
+	mu         sync.Mutex
+	state      *lua.LState
+	responders []luaResponder
+}
+
+// An auto-response registered with chat.on_message
+type luaResponder struct {
+	pattern string
+	fn      *lua.LFunction
+}
+
+// Loads the *.lua scripts in LUA_SCRIPTS_DIR. A script that fails to load
+// is skipped.
+func LoadLuaScripts(hub *chat.Hub) {
+	dir := os.Getenv("LUA_SCRIPTS_DIR")
+	if dir == "" {
+		return
+	}
+	timeout := defaultLuaTimeout
+	if d, err := time.ParseDuration(os.Getenv("LUA_SCRIPT_TIMEOUT")); err == nil && d > 0 {
+		timeout = d
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.lua"))
+	if err != nil {
+		log.Printf("Failed to list Lua scripts: %v", err)
+		return
+	}
+	for _, path := range paths {
+		if err := loadLuaScript(hub, path, timeout); err != nil {
+			log.Printf("Failed to load Lua script %s: %v", path, err)
+		}
+	}
+}
+
+// Runs a script's top level and registers the commands and responders it
+// defined
+func loadLuaScript(hub *chat.Hub, path string, timeout time.Duration) error {
+	s := &LuaScript{
+		name:    strings.TrimSuffix(filepath.Base(path), ".lua"),
+		hub:     hub,
+		timeout: timeout,
+		state:   lua.NewState(lua.Options{SkipOpenLibs: true}),
+	}
+	s.openLibs()
+	s.state.SetGlobal("chat", s.api(true))
+
+	fn, err := s.state.LoadFile(path)
+	if err != nil {
+		s.state.Close()
+		return err
+	}
+	s.mu.Lock()
+	err = s.callLocked(fn, 0)
+	// Registering is only possible while loading.
+	s.state.SetGlobal("chat", s.api(false))
+	s.mu.Unlock()
+	if err != nil {
+		s.state.Close()
+		return err
+	}
+
+	for _, cmd := range s.commands {
+		hub.RegisterCommand(cmd)
+	}
+	if len(s.responders) > 0 {
+		hub.AddBot(s)
+	}
+	log.Printf("Loaded Lua script %s (%d commands, %d auto-responses)", s.name, len(s.commands), len(s.responders))
+	return nil
+}
+
+// Opens the libraries scripts may use and removes what would let them
+// reach outside the sandbox
+func (s *LuaScript) openLibs() {
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		s.state.Push(s.state.NewFunction(lib.open))
+		s.state.Push(lua.LString(lib.name))
+		s.state.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module", "getfenv", "setfenv", "collectgarbage", "_printregs"} {
+		s.state.SetGlobal(name, lua.LNil)
+	}
+	s.state.SetGlobal("print", s.state.NewFunction(func(L *lua.LState) int {
+		var parts []string
+		for i := 1; i <= L.GetTop(); i++ {
+			parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+		}
+		log.Printf("Lua script %s: %s", s.name, strings.Join(parts, " "))
+		return 0
+	}))
+}
+
+// Returns the chat table, with the functions that register handlers only
+// while the script is loading
+func (s *LuaScript) api(loading bool) *lua.LTable {
+	L := s.state
+	functions := map[string]lua.LGFunction{
+		"send": func(L *lua.LState) int {
+			room, text := L.CheckString(1), L.CheckString(2)
+			if strings.TrimSpace(text) == "" {
+				return 0
+			}
+			if err := s.hub.Say(s.name, room, text); err != nil {
+				L.RaiseError("%s", err)
+			}
+			return 0
+		},
+		"reply": func(L *lua.LState) int {
+			s.hub.Notify(L.CheckString(1), L.CheckString(2))
+			return 0
+		},
+		"users": func(L *lua.LState) int {
+			users := L.NewTable()
+			for _, user := range s.hub.UsersIn(L.CheckString(1)) {
+				users.Append(lua.LString(user))
+			}
+			L.Push(users)
+			return 1
+		},
+		"room_of": func(L *lua.LState) int {
+			L.Push(lua.LString(s.hub.RoomOf(L.CheckString(1))))
+			return 1
+		},
+	}
+	if loading {
+		functions["command"] = func(L *lua.LState) int {
+			name := strings.ToLower(strings.TrimPrefix(L.CheckString(1), "/"))
+			usage, description, fn := L.CheckString(2), L.CheckString(3), L.CheckFunction(4)
+			if name == "" {
+				L.ArgError(1, "empty command name")
+			}
+			s.commands = append(s.commands, commands.Command{
+				Name:        name,
+				Usage:       usage,
+				Description: description,
+				Handler: func(ctx *commands.CommandContext, args []string) error {
+					return s.handleCommand(fn, ctx.User, args)
+				},
+			})
+			return 0
+		}
+		functions["on_message"] = func(L *lua.LState) int {
+			s.responders = append(s.responders, luaResponder{pattern: L.CheckString(1), fn: L.CheckFunction(2)})
+			return 0
+		}
+	}
+	return L.SetFuncs(L.NewTable(), functions)
+}
+
+// Runs a command the script registered
+func (s *LuaScript) handleCommand(fn *lua.LFunction, sender string, args []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	argsTable := s.state.NewTable()
+	for _, arg := range args {
+		argsTable.Append(lua.LString(arg))
+	}
+	if err := s.callLocked(fn, 0, lua.LString(sender), argsTable); err != nil {
+		log.Printf("Lua script %s failed: %v", s.name, err)
+		return fmt.Errorf("The %s script failed", s.name)
+	}
+	return nil
+}
+
+// Runs the auto-responses matching a chat message. Messages of the script
+// itself are ignored so it cannot answer itself.
+func (s *LuaScript) OnMessage(msg chat.Message) {
+	if msg.From == s.name {
+		return
+	}
+	go s.respond(msg.Room, msg.From, msg.Text)
+}
+
+func (s *LuaScript) respond(room string, from string, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	match := s.state.GetField(s.state.GetGlobal(lua.StringLibName), "match")
+	for _, responder := range s.responders {
+		top := s.state.GetTop()
+		err := s.callLocked(match, lua.MultRet, lua.LString(text), lua.LString(responder.pattern))
+		captures := []lua.LValue{}
+		for i := top + 1; i <= s.state.GetTop(); i++ {
+			captures = append(captures, s.state.Get(i))
+		}
+		s.state.SetTop(top)
+		if err != nil {
+			log.Printf("Lua script %s has an invalid pattern %q: %v", s.name, responder.pattern, err)
+			continue
+		}
+		if len(captures) == 0 || captures[0] == lua.LNil {
+			continue
+		}
+		args := append([]lua.LValue{lua.LString(room), lua.LString(from), lua.LString(text)}, captures...)
+		if err := s.callLocked(responder.fn, 0, args...); err != nil {
+			log.Printf("Lua script %s failed: %v", s.name, err)
+		}
+	}
+}
+
+// Calls a Lua function, aborting it after the script's timeout
+func (s *LuaScript) callLocked(fn lua.LValue, nret int, args ...lua.LValue) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	s.state.SetContext(ctx)
+	defer s.state.RemoveContext()
+	return s.state.CallByParam(lua.P{Fn: fn, NRet: nret, Protect: true}, args...)
+}
@@ -0,0 +1,365 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"group-ssh-chat/chat"
+	"group-ssh-chat/commands"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Limits of a WASM plugin unless its <name>.json or WASM_PLUGIN_MEMORY_MB
+// and WASM_PLUGIN_TIMEOUT say otherwise
+const (
+	defaultWASMMemoryMB = 16
+	defaultWASMTimeout  = 100 * time.Millisecond
+)
+
+// A plugin compiled to WebAssembly, loaded from WASM_PLUGINS_DIR. Plugins
+// run sandboxed: they cannot touch files, the network or the clock beyond
+// what WASI offers without any mounts, their memory is capped and every
+// call into them is aborted after a timeout.
+//
+// A plugin exports alloc(size) returning a pointer the server can write
+// size bytes to, and optionally:
+//
+//	init()                                   register commands and filters
+//	on_command(sender, args)                 handle a registered command
+//	filter_message(room, from, text) -> i32  return 1 to block the message
+//
+// Strings are passed as a pointer and a length. From the "chat" module the
+// plugin can import:
+//
+//	register_command(name, usage)  during init, adds /<name>
+//	register_filter()              during init, filters room messages
+//	reply(text)                    tells the sender of the command or message
+//	post(text)                     posts to the sender's room as the plugin
+//	set_text(text)                 in filter_message, rewrites the message
+//	log(text)                      writes to the server log
+type WASMPlugin struct {
+	name     string
+	hub      *chat.Hub
+	limits   wasmLimits
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+
+	mu     sync.Mutex
+	module api.Module
+}
+
+// Per-plugin limits, read from <name>.json next to the plugin
+type wasmLimits struct {
+	MemoryMB int    `json:"memory_mb"`
+	Timeout  string `json:"timeout"`
+
+	timeout time.Duration
+}
+
+// What a call into a plugin did, collected by the host functions
+type wasmCall struct {
+	sender   string
+	room     string
+	init     bool
+	commands []commands.Command
+	filter   bool
+	replies  []string
+	text     *string
+}
+
+type wasmCallKey struct{}
+
+// Loads the *.wasm plugins in WASM_PLUGINS_DIR. A plugin that fails to load
+// is skipped.
+func LoadWASMPlugins(hub *chat.Hub) {
+	dir := os.Getenv("WASM_PLUGINS_DIR")
+	if dir == "" {
+		return
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+	if err != nil {
+		log.Printf("Failed to list WASM plugins: %v", err)
+		return
+	}
+	for _, path := range paths {
+		if err := loadWASMPlugin(hub, path); err != nil {
+			log.Printf("Failed to load WASM plugin %s: %v", path, err)
+		}
+	}
+}
+
+// Compiles and initializes a plugin and registers its commands and filter
+func loadWASMPlugin(hub *chat.Hub, path string) error {
+	name := strings.TrimSuffix(filepath.Base(path), ".wasm")
+	limits, err := readWASMLimits(strings.TrimSuffix(path, ".wasm") + ".json")
+	if err != nil {
+		return err
+	}
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	config := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(limits.MemoryMB) * 16). // 64 KiB pages
+		WithCloseOnContextDone(true)
+	p := &WASMPlugin{
+		name:    name,
+		hub:     hub,
+		limits:  limits,
+		runtime: wazero.NewRuntimeWithConfig(ctx, config),
+	}
+	if err := p.instantiateHost(ctx); err != nil {
+		p.runtime.Close(ctx)
+		return err
+	}
+	if p.compiled, err = p.runtime.CompileModule(ctx, code); err != nil {
+		p.runtime.Close(ctx)
+		return err
+	}
+
+	call := &wasmCall{init: true}
+	p.mu.Lock()
+	err = p.callLocked(call, "init")
+	p.mu.Unlock()
+	if err != nil {
+		p.runtime.Close(ctx)
+		return err
+	}
+
+	for _, cmd := range call.commands {
+		hub.RegisterCommand(cmd)
+	}
+	if call.filter {
+		hub.AddMessageFilter(p.filterMessage)
+	}
+	log.Printf("Loaded WASM plugin %s (%d commands, filter: %v, memory %d MB, timeout %s)", name, len(call.commands), call.filter, limits.MemoryMB, limits.timeout)
+	return nil
+}
+
+// Reads the plugin's limits, falling back to the server wide defaults
+func readWASMLimits(path string) (wasmLimits, error) {
+	limits := wasmLimits{MemoryMB: defaultWASMMemoryMB, timeout: defaultWASMTimeout}
+	if n, err := strconv.Atoi(os.Getenv("WASM_PLUGIN_MEMORY_MB")); err == nil && n > 0 {
+		limits.MemoryMB = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("WASM_PLUGIN_TIMEOUT")); err == nil && d > 0 {
+		limits.timeout = d
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return limits, nil
+	}
+	if err != nil {
+		return limits, err
+	}
+	var own wasmLimits
+	if err := json.Unmarshal(data, &own); err != nil {
+		return limits, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if own.MemoryMB > 0 {
+		limits.MemoryMB = own.MemoryMB
+	}
+	if own.Timeout != "" {
+		d, err := time.ParseDuration(own.Timeout)
+		if err != nil || d <= 0 {
+			return limits, fmt.Errorf("invalid timeout %q in %s", own.Timeout, path)
+		}
+		limits.timeout = d
+	}
+	return limits, nil
+}
+
+// Provides the functions plugins can import. WASI is available without
+// any files, environment or arguments so plugins built for it start.
+func (p *WASMPlugin) instantiateHost(ctx context.Context) error {
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, p.runtime); err != nil {
+		return err
+	}
+	_, err := p.runtime.NewHostModuleBuilder("chat").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, namePtr, nameLen, usagePtr, usageLen uint32) {
+		call := ctx.Value(wasmCallKey{}).(*wasmCall)
+		name, ok := readString(m, namePtr, nameLen)
+		usage, _ := readString(m, usagePtr, usageLen)
+		if !call.init || !ok || name == "" {
+			return
+		}
+		if usage == "" {
+			usage = "/" + name
+		}
+		call.commands = append(call.commands, commands.Command{
+			Name:        strings.ToLower(name),
+			Usage:       usage,
+			Description: "Provided by the " + p.name + " plugin",
+			Handler:     p.handleCommand,
+		})
+	}).Export("register_command").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context) {
+		call := ctx.Value(wasmCallKey{}).(*wasmCall)
+		if call.init {
+			call.filter = true
+		}
+	}).Export("register_filter").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, ptr, length uint32) {
+		call := ctx.Value(wasmCallKey{}).(*wasmCall)
+		if text, ok := readString(m, ptr, length); ok && call.sender != "" {
+			call.replies = append(call.replies, text)
+		}
+	}).Export("reply").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, ptr, length uint32) {
+		call := ctx.Value(wasmCallKey{}).(*wasmCall)
+		text, ok := readString(m, ptr, length)
+		if !ok || call.room == "" || strings.TrimSpace(text) == "" {
+			return
+		}
+		// Sent once the call returns, so a post from a filter follows the
+		// message it reacts to.
+		room := call.room
+		go func() {
+			if err := p.hub.Say(p.name, room, text); err != nil {
+				log.Printf("WASM plugin %s post failed: %v", p.name, err)
+			}
+		}()
+	}).Export("post").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, ptr, length uint32) {
+		call := ctx.Value(wasmCallKey{}).(*wasmCall)
+		if text, ok := readString(m, ptr, length); ok && call.text != nil {
+			*call.text = text
+		}
+	}).Export("set_text").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context, m api.Module, ptr, length uint32) {
+		if text, ok := readString(m, ptr, length); ok {
+			log.Printf("WASM plugin %s: %s", p.name, text)
+		}
+	}).Export("log").
+		Instantiate(ctx)
+	return err
+}
+
+// Runs a registered command of the plugin
+func (p *WASMPlugin) handleCommand(ctx *commands.CommandContext, args []string) error {
+	call := &wasmCall{sender: ctx.User, room: ctx.Room}
+	p.mu.Lock()
+	err := p.callLocked(call, "on_command", ctx.User, strings.Join(args, " "))
+	p.mu.Unlock()
+	if err != nil {
+		log.Printf("WASM plugin %s failed: %v", p.name, err)
+		return fmt.Errorf("The %s plugin failed", p.name)
+	}
+	for _, reply := range call.replies {
+		ctx.Reply(reply)
+	}
+	return nil
+}
+
+// Lets the plugin block or rewrite a room message. A plugin that fails
+// lets messages through.
+func (p *WASMPlugin) filterMessage(msg chat.Message) (string, error) {
+	filtered := msg.Text
+	call := &wasmCall{sender: msg.From, room: msg.Room, text: &filtered}
+	p.mu.Lock()
+	err := p.callLocked(call, "filter_message", msg.Room, msg.From, msg.Text)
+	p.mu.Unlock()
+	if err == errBlocked {
+		reason := fmt.Sprintf("Your message was blocked by the %s plugin", p.name)
+		if len(call.replies) > 0 {
+			reason = strings.Join(call.replies, " ")
+		}
+		return "", errors.New(reason)
+	}
+	if err != nil {
+		log.Printf("WASM plugin %s failed: %v", p.name, err)
+		return msg.Text, nil
+	}
+	for _, reply := range call.replies {
+		p.hub.Notify(msg.From, reply)
+	}
+	return filtered, nil
+}
+
+// Returned by callLocked when filter_message asks to block the message
+var errBlocked = errors.New("blocked")
+
+// Calls an exported function with string arguments, instantiating the
+// module first if needed. A plugin that failed, e.g. ran out of time or
+// memory, is closed and starts afresh on the next call. A function the plugin does not
+// export is skipped.
+func (p *WASMPlugin) callLocked(call *wasmCall, function string, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), wasmCallKey{}, call), p.limits.timeout)
+	defer cancel()
+
+	if p.module == nil || p.module.IsClosed() {
+		module, err := p.runtime.InstantiateModule(ctx, p.compiled, wazero.NewModuleConfig().
+			WithName(p.name).
+			WithStartFunctions("_initialize").
+			WithStdout(&logWriter{prefix: "WASM plugin " + p.name + ": "}).
+			WithStderr(&logWriter{prefix: "WASM plugin " + p.name + ": "}))
+		if err != nil {
+			return err
+		}
+		p.module = module
+	}
+
+	fn := p.module.ExportedFunction(function)
+	if fn == nil {
+		return nil
+	}
+	var params []uint64
+	for _, arg := range args {
+		ptr, err := p.writeString(ctx, arg)
+		if err != nil {
+			return err
+		}
+		params = append(params, uint64(ptr), uint64(len(arg)))
+	}
+	results, err := fn.Call(ctx, params...)
+	if err != nil {
+		// A trap can leave the plugin in any state, so start over.
+		p.module.Close(context.Background())
+		p.module = nil
+		return err
+	}
+	if len(results) > 0 && uint32(results[0]) == 1 {
+		return errBlocked
+	}
+	return nil
+}
+
+// Copies a string into memory the plugin allocated for it
+func (p *WASMPlugin) writeString(ctx context.Context, s string) (uint32, error) {
+	alloc := p.module.ExportedFunction("alloc")
+	if alloc == nil {
+		return 0, errors.New("plugin does not export alloc")
+	}
+	results, err := alloc.Call(ctx, uint64(len(s)))
+	if err != nil {
+		return 0, err
+	}
+	ptr := uint32(results[0])
+	if !p.module.Memory().Write(ptr, []byte(s)) {
+		return 0, errors.New("alloc returned memory out of range")
+	}
+	return ptr, nil
+}
+
+// Reads a string from the plugin's memory
+func readString(m api.Module, ptr uint32, length uint32) (string, bool) {
+	b, ok := m.Memory().Read(ptr, length)
+	if !ok {
+		return "", false
+	}
+	return string(b), true
+}
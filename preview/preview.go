@@ -0,0 +1,234 @@
+package preview
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Size in pixels that previews are scaled down to fit in. Previews are
+	// drawn with two pixels per terminal cell vertically.
+	maxPreviewWidth  = 48
+	maxPreviewHeight = 32
+
+	// Images with more pixels than this are not decoded
+	maxSourcePixels = 4096 * 4096
+
+	// Number of previews kept so an image linked again is not refetched
+	cacheSize = 64
+
+	// Number of images downloaded at the same time
+	maxConcurrentFetches = 4
+)
+
+var (
+	ErrHostNotAllowed = errors.New("host is not allowed for previews")
+	ErrNotAnImage     = errors.New("not an image")
+	ErrTooLarge       = errors.New("image is too large")
+)
+
+// Used for fetching images linked in chat from an allowlist of hosts and
+// scaling them down to previews
+type Fetcher struct {
+	hosts    map[string]bool
+	maxBytes int64
+	client   *http.Client
+	slots    chan struct{}
+
+	mu    sync.Mutex
+	cache map[string]image.Image
+	order []string
+}
+
+// Returns a fetcher allowing the hosts in PREVIEW_HOSTS, or nil when the
+// variable is not set. PREVIEW_MAX_BYTES caps the size of a download and
+// PREVIEW_TIMEOUT how long it may take.
+func New() *Fetcher {
+	hosts := map[string]bool{}
+	for _, host := range strings.Split(os.Getenv("PREVIEW_HOSTS"), ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts[host] = true
+		}
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	f := &Fetcher{
+		hosts:    hosts,
+		maxBytes: 2 << 20,
+		slots:    make(chan struct{}, maxConcurrentFetches),
+		cache:    map[string]image.Image{},
+	}
+	if n, err := strconv.ParseInt(os.Getenv("PREVIEW_MAX_BYTES"), 10, 64); err == nil && n > 0 {
+		f.maxBytes = n
+	}
+	timeout := 5 * time.Second
+	if d, err := time.ParseDuration(os.Getenv("PREVIEW_TIMEOUT")); err == nil && d > 0 {
+		timeout = d
+	}
+	f.client = &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return errors.New("too many redirects")
+			}
+			if !f.Allowed(req.URL.String()) {
+				return ErrHostNotAllowed
+			}
+			return nil
+		},
+	}
+
+	return f
+}
+
+// Reports whether rawURL is an http(s) URL on an allowed host
+func (f *Fetcher) Allowed(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	return f.hosts[strings.ToLower(u.Hostname())]
+}
+
+// Returns the image at rawURL scaled down to fit a preview
+func (f *Fetcher) Fetch(rawURL string) (image.Image, error) {
+	if !f.Allowed(rawURL) {
+		return nil, ErrHostNotAllowed
+	}
+
+	f.mu.Lock()
+	img, ok := f.cache[rawURL]
+	f.mu.Unlock()
+	if ok {
+		return img, nil
+	}
+
+	f.slots <- struct{}{}
+	defer func() { <-f.slots }()
+
+	img, err := f.download(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	img = scale(img, maxPreviewWidth, maxPreviewHeight)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.cache[rawURL]; !ok {
+		if len(f.order) == cacheSize {
+			delete(f.cache, f.order[0])
+			f.order = f.order[1:]
+		}
+		f.cache[rawURL] = img
+		f.order = append(f.order, rawURL)
+	}
+	return img, nil
+}
+
+// Downloads and decodes an image, refusing anything that is not an image or
+// exceeds the size limits
+func (f *Fetcher) download(rawURL string) (image.Image, error) {
+	resp, err := f.client.Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		return nil, ErrNotAnImage
+	}
+	if resp.ContentLength > f.maxBytes {
+		return nil, ErrTooLarge
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > f.maxBytes {
+		return nil, ErrTooLarge
+	}
+
+	// Check the dimensions first so a small file cannot expand into a huge
+	// bitmap.
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		return nil, ErrNotAnImage
+	}
+	if cfg.Width*cfg.Height > maxSourcePixels {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, ErrNotAnImage
+	}
+	return img, nil
+}
+
+// Scales img down to fit within width x height pixels keeping its aspect
+// ratio. Every target pixel is the average of the source pixels it covers.
+func scale(img image.Image, width int, height int) image.Image {
+	bounds := img.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	if sw == 0 || sh == 0 {
+		return img
+	}
+	tw, th := sw, sh
+	if tw > width {
+		tw, th = width, sh*width/sw
+	}
+	if th > height {
+		tw, th = tw*height/th, height
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := bounds.Min.Y+y*sh/th, bounds.Min.Y+(y+1)*sh/th
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < tw; x++ {
+			x0, x1 := bounds.Min.X+x*sw/tw, bounds.Min.X+(x+1)*sw/tw
+			if x1 == x0 {
+				x1++
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(b / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
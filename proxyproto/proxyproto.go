@@ -0,0 +1,276 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signature that starts every PROXY protocol v2 header
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// Longest possible PROXY protocol v1 header including the trailing CRLF
+const maxV1HeaderLength = 107
+
+// How long a client has to send the header before it is dropped
+const headerTimeout = 5 * time.Second
+
+var errInvalidHeader = errors.New("invalid PROXY protocol header")
+
+// A listener that reads the HAProxy PROXY protocol header from accepted
+// connections and reports the client address it carries as the remote
+// address. Headers are read in the background so slow clients do not hold up
+// Accept.
+type Listener struct {
+	inner     net.Listener
+	trusted   []*net.IPNet
+	accepted  chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// A connection whose remote address was taken from its PROXY header
+type Conn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote net.Addr
+}
+
+// Wraps the listener when PROXY_PROTOCOL is "true", otherwise returns it
+// unchanged. PROXY_PROTOCOL_TRUSTED optionally lists the comma separated
+// CIDRs of the load balancers allowed to send headers; connections from other
+// sources are passed through as they are.
+func FromEnv(inner net.Listener) net.Listener {
+	if os.Getenv("PROXY_PROTOCOL") != "true" {
+		return inner
+	}
+
+	var trusted []*net.IPNet
+	for _, cidr := range strings.Split(os.Getenv("PROXY_PROTOCOL_TRUSTED"), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatalf("Invalid PROXY_PROTOCOL_TRUSTED entry %q: %v", cidr, err)
+		}
+		trusted = append(trusted, network)
+	}
+	log.Println("Expecting PROXY protocol headers on", inner.Addr())
+	return NewListener(inner, trusted)
+}
+
+// Returns a listener that expects a PROXY header on every connection from a
+// trusted network, or from anywhere when trusted is empty
+func NewListener(inner net.Listener, trusted []*net.IPNet) *Listener {
+	l := &Listener{
+		inner:    inner,
+		trusted:  trusted,
+		accepted: make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+
+	return l
+}
+
+// Returns the next connection whose header has been read
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accepted:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Closes the underlying listener
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.inner.Close()
+}
+
+// Returns the address of the underlying listener
+func (l *Listener) Addr() net.Addr {
+	return l.inner.Addr()
+}
+
+// Accepts connections from the underlying listener and reads their headers
+func (l *Listener) acceptLoop() {
+	for {
+		conn, err := l.inner.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go l.handshake(conn)
+	}
+}
+
+// Reads the header of a new connection and hands it to Accept
+func (l *Listener) handshake(conn net.Conn) {
+	if !l.isTrusted(conn.RemoteAddr()) {
+		l.deliver(conn)
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(headerTimeout))
+	reader := bufio.NewReader(conn)
+	remote, err := readHeader(reader)
+	if err != nil {
+		log.Printf("Dropped connection from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	if remote == nil {
+		remote = conn.RemoteAddr()
+	}
+	l.deliver(&Conn{Conn: conn, reader: reader, remote: remote})
+}
+
+// Passes the connection to Accept, or closes it when the listener is closed
+func (l *Listener) deliver(conn net.Conn) {
+	select {
+	case l.accepted <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// Reports whether the address may send a PROXY header
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	if len(l.trusted) == 0 {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range l.trusted {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads data following the PROXY header
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// Returns the client address from the PROXY header
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Reads a v1 or v2 header. A nil address means the header carried no
+// client address (v1 UNKNOWN or v2 LOCAL) and the socket address applies.
+func readHeader(r *bufio.Reader) (net.Addr, error) {
+	prefix, err := r.Peek(5)
+	if err != nil {
+		return nil, err
+	}
+	if string(prefix) == "PROXY" {
+		return readV1Header(r)
+	}
+	signature, err := r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(signature, v2Signature) {
+		return readV2Header(r)
+	}
+	return nil, errors.New("missing PROXY protocol header")
+}
+
+// Parses "PROXY TCP4 <src> <dst> <sport> <dport>\r\n"
+func readV1Header(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxV1HeaderLength {
+			return nil, errInvalidHeader
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errInvalidHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, errInvalidHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// Parses the binary v2 header, skipping any TLVs
+func readV2Header(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", header[12]>>4)
+	}
+	command := header[12] & 0x0f
+	family := header[13] >> 4
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	// LOCAL connections are health checks from the proxy itself.
+	if command == 0 {
+		return nil, nil
+	}
+	if command != 1 {
+		return nil, errInvalidHeader
+	}
+	switch family {
+	case 1: // AF_INET
+		if len(payload) < 12 {
+			return nil, errInvalidHeader
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, errInvalidHeader
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	}
+	// AF_UNSPEC and AF_UNIX carry no usable client IP.
+	return nil, nil
+}
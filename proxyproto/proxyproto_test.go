@@ -0,0 +1,156 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Returns a v2 header with the given version and command, address family
+// and payload
+func v2Header(versionCommand byte, family byte, payload []byte) []byte {
+	header := append([]byte(nil), v2Signature...)
+	header = append(header, versionCommand, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return append(header, payload...)
+}
+
+// Returns a v2 PROXY TCP over IPv4 payload
+func v4Payload(src string, srcPort uint16) []byte {
+	payload := append([]byte(nil), net.ParseIP(src).To4()...)
+	payload = append(payload, 10, 0, 0, 1)
+	payload = binary.BigEndian.AppendUint16(payload, srcPort)
+	return binary.BigEndian.AppendUint16(payload, 2022)
+}
+
+// Returns a v2 PROXY TCP over IPv6 payload
+func v6Payload(src string, srcPort uint16) []byte {
+	payload := append([]byte(nil), net.ParseIP(src).To16()...)
+	payload = append(payload, net.ParseIP("::1").To16()...)
+	payload = binary.BigEndian.AppendUint16(payload, srcPort)
+	return binary.BigEndian.AppendUint16(payload, 2022)
+}
+
+func TestReadHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		want    string // client address, "" for none
+		wantErr bool
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 192.0.2.1 10.0.0.1 51234 2022\r\n"), "192.0.2.1:51234", false},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 ::1 51234 2022\r\n"), "[2001:db8::1]:51234", false},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "", false},
+		{"v1 truncated", []byte("PROXY TCP4 192.0.2.1 10.0.0.1"), "", true},
+		{"v1 oversized", []byte("PROXY TCP4 " + strings.Repeat("1", maxV1HeaderLength) + "\r\n"), "", true},
+		{"v1 bad address", []byte("PROXY TCP4 not-an-ip 10.0.0.1 51234 2022\r\n"), "", true},
+		{"v1 bad port", []byte("PROXY TCP4 192.0.2.1 10.0.0.1 99999 2022\r\n"), "", true},
+		{"v1 wrong protocol", []byte("PROXY UDP4 192.0.2.1 10.0.0.1 51234 2022\r\n"), "", true},
+		{"v1 missing fields", []byte("PROXY TCP4 192.0.2.1\r\n"), "", true},
+		{"v2 tcp4", v2Header(0x21, 0x11, v4Payload("192.0.2.1", 51234)), "192.0.2.1:51234", false},
+		{"v2 tcp6", v2Header(0x21, 0x21, v6Payload("2001:db8::1", 51234)), "[2001:db8::1]:51234", false},
+		{"v2 with tlvs", v2Header(0x21, 0x11, append(v4Payload("192.0.2.1", 51234), 0x04, 0x00, 0x01, 0x00)), "192.0.2.1:51234", false},
+		{"v2 local", v2Header(0x20, 0x00, nil), "", false},
+		{"v2 unspec", v2Header(0x21, 0x00, nil), "", false},
+		{"v2 truncated header", v2Header(0x21, 0x11, nil)[:14], "", true},
+		{"v2 truncated payload", v2Header(0x21, 0x11, v4Payload("192.0.2.1", 51234))[:20], "", true},
+		{"v2 short ipv4 payload", v2Header(0x21, 0x11, make([]byte, 8)), "", true},
+		{"v2 short ipv6 payload", v2Header(0x21, 0x21, make([]byte, 20)), "", true},
+		{"v2 oversized length", append(v2Header(0x21, 0x11, nil)[:14], 0xff, 0xff, 1, 2, 3), "", true},
+		{"v2 wrong version", v2Header(0x11, 0x11, v4Payload("192.0.2.1", 51234)), "", true},
+		{"v2 unknown command", v2Header(0x22, 0x11, v4Payload("192.0.2.1", 51234)), "", true},
+		{"wrong signature", []byte("\r\n\r\n\x00\r\nQUIZ\n\x21\x11\x00\x00"), "", true},
+		{"no header", []byte("SSH-2.0-OpenSSH_9.6\r\n"), "", true},
+		{"empty", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Data following the header must be left for the connection.
+			r := bufio.NewReader(bytes.NewReader(append(tt.input, "rest"...)))
+			addr, err := readHeader(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != tt.want {
+				t.Errorf("got address %q, want %q", got, tt.want)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "rest" {
+				t.Errorf("data after the header is %q, want %q", rest, "rest")
+			}
+		})
+	}
+}
+
+func TestListenerTrustedNetworks(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted string
+		send    string
+		want    string // expected remote address, "" for the socket address
+	}{
+		{"trusted source sends header", "127.0.0.0/8", "PROXY TCP4 192.0.2.1 10.0.0.1 51234 2022\r\nhello", "192.0.2.1:51234"},
+		{"untrusted source passes through", "10.0.0.0/8", "PROXY TCP4 192.0.2.1 10.0.0.1 51234 2022\r\nhello", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, network, err := net.ParseCIDR(tt.trusted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inner, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := NewListener(inner, []*net.IPNet{network})
+			defer l.Close()
+
+			client, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			if _, err := client.Write([]byte(tt.send)); err != nil {
+				t.Fatal(err)
+			}
+			client.(*net.TCPConn).CloseWrite()
+
+			conn, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			data, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := tt.want
+			wantData := "hello"
+			if want == "" {
+				// Untouched: the socket address and the header as data
+				want = client.LocalAddr().String()
+				wantData = tt.send
+			}
+			if got := conn.RemoteAddr().String(); got != want {
+				t.Errorf("remote address %q, want %q", got, want)
+			}
+			if string(data) != wantData {
+				t.Errorf("read %q, want %q", data, wantData)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package restapi
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"group-ssh-chat/chat"
+	"group-ssh-chat/graceful"
+	"group-ssh-chat/storage"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Number of messages returned by the history endpoint without a limit, and
+// the most it returns at once
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// Serves a versioned REST API for managing the chat from external tooling.
+// Requests authenticate with a bearer token from API_TOKENS_PATH; the name
+// next to the token is who messages are posted as and what the server log
+// records.
+//
+//	GET  /api/v1/users                   online users
+//	POST /api/v1/users/<user>/kick       disconnect a user, {"reason": "..."}
+//	GET  /api/v1/rooms                   all rooms
+//	GET  /api/v1/rooms/<room>/messages   history, ?limit=<n>&after=<id>
+//	POST /api/v1/rooms/<room>/messages   post a message, {"text": "..."}
+type Server struct {
+	listenAddress string
+	tokens        map[string]string
+	hub           *chat.Hub
+}
+
+// Returns an API server listening on API_LISTEN_ADDRESS, or nil when the
+// variable is not set
+func New(hub *chat.Hub) *Server {
+	listenAddress := os.Getenv("API_LISTEN_ADDRESS")
+	if listenAddress == "" {
+		return nil
+	}
+
+	s := &Server{
+		listenAddress: listenAddress,
+		tokens:        map[string]string{},
+		hub:           hub,
+	}
+	s.initTokens()
+
+	return s
+}
+
+// Serves the API until the listener fails
+func (s *Server) ListenAndServe() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/users", s.authorized(s.handleUsers))
+	mux.HandleFunc("/api/v1/users/", s.authorized(s.handleUser))
+	mux.HandleFunc("/api/v1/rooms", s.authorized(s.handleRooms))
+	mux.HandleFunc("/api/v1/rooms/", s.authorized(s.handleRoom))
+
+	listener, err := graceful.Listen("api", "tcp", s.listenAddress)
+	if err != nil {
+		return err
+	}
+	log.Println("REST API is serving on", s.listenAddress)
+	return http.Serve(listener, mux)
+}
+
+// A handler for an authenticated request, called with the token's name
+type handlerFunc func(w http.ResponseWriter, r *http.Request, client string)
+
+// Rejects requests without a known bearer token
+func (s *Server) authorized(next handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, ok := s.clientForRequest(r)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or unknown API token"))
+			return
+		}
+		next(w, r, client)
+	}
+}
+
+// Looks up the name of the token sent in the bearer header
+func (s *Server) clientForRequest(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	for t, client := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return client, true
+		}
+	}
+	return "", false
+}
+
+// An online user
+type user struct {
+	Name        string    `json:"name"`
+	Room        string    `json:"room"`
+	Sessions    int       `json:"sessions"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// Handles GET /api/v1/users
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request, _ string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	// Sessions are sorted by user and connection time, so the first session
+	// of each user is their oldest.
+	users := []user{}
+	for _, session := range s.hub.Sessions() {
+		if n := len(users); n > 0 && users[n-1].Name == session.User {
+			users[n-1].Sessions++
+			continue
+		}
+		users = append(users, user{Name: session.User, Room: session.Room, Sessions: 1, ConnectedAt: session.ConnectedAt})
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+// Handles POST /api/v1/users/<user>/kick
+func (s *Server) handleUser(w http.ResponseWriter, r *http.Request, client string) {
+	name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/users/"), "/")
+	if name == "" || action != "kick" {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	n := s.hub.Disconnect(name, body.Reason)
+	if n == 0 {
+		writeError(w, http.StatusNotFound, errors.New(name+" is not online"))
+		return
+	}
+	log.Printf("API client %s kicked %s", client, name)
+	writeJSON(w, http.StatusOK, map[string]int{"closed_sessions": n})
+}
+
+// A room as listed by the API
+type room struct {
+	Name     string `json:"name"`
+	Topic    string `json:"topic,omitempty"`
+	Private  bool   `json:"private"`
+	ReadOnly bool   `json:"read_only"`
+	Online   int    `json:"online"`
+}
+
+// Handles GET /api/v1/rooms, listing the rooms the client may read
+func (s *Server) handleRooms(w http.ResponseWriter, r *http.Request, client string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	rooms := []room{}
+	for _, info := range s.hub.Rooms() {
+		if !s.hub.CanAccess(client, info.Name) {
+			continue
+		}
+		rooms = append(rooms, room{Name: info.Name, Topic: info.Topic, Private: info.Private, ReadOnly: info.ReadOnly, Online: info.Online})
+	}
+	writeJSON(w, http.StatusOK, rooms)
+}
+
+// Handles GET and POST /api/v1/rooms/<room>/messages
+func (s *Server) handleRoom(w http.ResponseWriter, r *http.Request, client string) {
+	name, resource, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/rooms/"), "/")
+	if name == "" || resource != "messages" {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.getMessages(w, r, client, name)
+	case http.MethodPost:
+		s.postMessage(w, r, client, name)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// Writes the history of a room the client may read
+func (s *Server) getMessages(w http.ResponseWriter, r *http.Request, client string, name string) {
+	if !s.hub.CanAccess(client, name) {
+		writeError(w, http.StatusForbidden, errors.New("#"+name+" is private and "+client+" has not been invited"))
+		return
+	}
+	limit := defaultHistoryLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, errors.New("limit must be a positive number"))
+			return
+		}
+		limit = n
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+	var after int64
+	if v := r.URL.Query().Get("after"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("after must be a message ID"))
+			return
+		}
+		after = n
+	}
+
+	messages, err := s.hub.RoomHistory(name, after, limit)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if messages == nil {
+		messages = []storage.StoredMessage{}
+	}
+	writeJSON(w, http.StatusOK, messages)
+}
+
+// Posts a message to a room as the client. The same rules apply as for
+// users, e.g. the client must be invited to private rooms and be an op of
+// read-only rooms.
+func (s *Server) postMessage(w http.ResponseWriter, r *http.Request, client string, name string) {
+	var body struct {
+		Text string `json:"text"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if err := s.hub.Post(client, name, body.Text); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"status": "posted"})
+}
+
+// Reads "<token> <name>" lines from the tokens file
+func (s *Server) initTokens() {
+	tokensBytes, err := os.ReadFile(os.Getenv("API_TOKENS_PATH"))
+	if err != nil {
+		log.Fatalf("Failed to load API tokens, err: %v", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(tokensBytes))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			log.Fatalf("Invalid API token line: %q", line)
+		}
+		s.tokens[fields[0]] = fields[1]
+	}
+}
+
+// Reports whether the request uses the method, answering 405 otherwise
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	return false
+}
+
+// Decodes a JSON request body, answering 400 when it is invalid. An empty
+// body leaves v unchanged.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(v)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, errors.New("invalid JSON body"))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("API failed to write a response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,76 @@
+package retention
+
+import (
+	"group-ssh-chat/storage"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Used for pruning the message history in the background so it does not
+// grow without bound
+type Pruner struct {
+	history    storage.History
+	maxAge     time.Duration
+	maxPerRoom int
+	interval   time.Duration
+}
+
+// Returns a pruner enforcing HISTORY_MAX_AGE (e.g. "720h" or "30d") and
+// HISTORY_MAX_MESSAGES (newest messages kept per room), or nil when neither
+// is set. HISTORY_PRUNE_INTERVAL sets how often it runs, default 1h.
+func New(history storage.History) *Pruner {
+	p := &Pruner{
+		history:  history,
+		interval: time.Hour,
+	}
+	if d, err := ParseAge(os.Getenv("HISTORY_MAX_AGE")); err == nil && d > 0 {
+		p.maxAge = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("HISTORY_MAX_MESSAGES")); err == nil && n > 0 {
+		p.maxPerRoom = n
+	}
+	if p.maxAge == 0 && p.maxPerRoom == 0 {
+		return nil
+	}
+	if d, err := time.ParseDuration(os.Getenv("HISTORY_PRUNE_INTERVAL")); err == nil && d > 0 {
+		p.interval = d
+	}
+	return p
+}
+
+// Prunes the history right away and then once per interval
+func (p *Pruner) Start() {
+	go func() {
+		for {
+			p.prune()
+			time.Sleep(p.interval)
+		}
+	}()
+}
+
+// Removes the messages beyond the limits
+func (p *Pruner) prune() {
+	removed, err := p.history.Prune(p.maxAge, p.maxPerRoom)
+	if err != nil {
+		log.Printf("Failed to prune history: %v", err)
+		return
+	}
+	if removed > 0 {
+		log.Printf("Pruned %d messages from the history", removed)
+	}
+}
+
+// Parses a duration that may also be given in days, e.g. "30d"
+func ParseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,159 @@
+package scheduler
+
+import (
+	"group-ssh-chat/storage"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Delivers a due reminder, returning false when the recipient is offline
+type DeliverFunc func(r storage.Reminder) bool
+
+// Used for firing persisted reminders at their due time. Reminders whose
+// recipient is offline stay pending until TakeOverdue is called for them.
+type Scheduler struct {
+	// Serializes delivery so a reminder is never handed out twice
+	mu        sync.Mutex
+	store     *storage.ReminderStore
+	deliver   DeliverFunc
+	attempted map[string]bool
+	wake      chan struct{}
+}
+
+// Returns a new scheduler firing the reminders kept in store
+func New(store *storage.ReminderStore, deliver DeliverFunc) *Scheduler {
+	return &Scheduler{
+		store:     store,
+		deliver:   deliver,
+		attempted: map[string]bool{},
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Runs the scheduling loop in the background
+func (s *Scheduler) Start() {
+	go s.run()
+}
+
+// Schedules a new reminder
+func (s *Scheduler) Add(from string, to string, text string, due time.Time) (storage.Reminder, error) {
+	r := storage.Reminder{
+		ID:   uuid.New().String(),
+		From: from,
+		To:   to,
+		Text: text,
+		Due:  due,
+	}
+	if err := s.store.Add(r); err != nil {
+		return storage.Reminder{}, err
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return r, nil
+}
+
+// Returns the user's pending reminders ordered by due time
+func (s *Scheduler) Pending(user string) []storage.Reminder {
+	var pending []storage.Reminder
+	for _, r := range s.store.All() {
+		if r.To == user {
+			pending = append(pending, r)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Due.Before(pending[j].Due)
+	})
+	return pending
+}
+
+// Returns the reminders set by or for the user
+func (s *Scheduler) Involving(user string) []storage.Reminder {
+	var reminders []storage.Reminder
+	for _, r := range s.store.All() {
+		if r.From == user || r.To == user {
+			reminders = append(reminders, r)
+		}
+	}
+	return reminders
+}
+
+// Cancels the reminders set by or for the user
+func (s *Scheduler) RemoveUser(user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.RemoveUser(user)
+}
+
+// Removes and returns the user's reminders that came due while they were offline
+func (s *Scheduler) TakeOverdue(user string) []storage.Reminder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var overdue []storage.Reminder
+	for _, r := range s.Pending(user) {
+		if r.Due.After(now) {
+			break
+		}
+		s.remove(r.ID)
+		overdue = append(overdue, r)
+	}
+	return overdue
+}
+
+// Fires due reminders and sleeps until the next one is due
+func (s *Scheduler) run() {
+	for {
+		next := s.fireDue()
+
+		var timer <-chan time.Time
+		if !next.IsZero() {
+			timer = time.After(time.Until(next))
+		}
+		select {
+		case <-timer:
+		case <-s.wake:
+		}
+	}
+}
+
+// Delivers all due reminders and returns the due time of the next pending one
+func (s *Scheduler) fireDue() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var next time.Time
+
+	for _, r := range s.store.All() {
+		if r.Due.After(now) {
+			if next.IsZero() || r.Due.Before(next) {
+				next = r.Due
+			}
+			continue
+		}
+
+		attempted := s.attempted[r.ID]
+		s.attempted[r.ID] = true
+		if !attempted && s.deliver(r) {
+			s.remove(r.ID)
+		}
+	}
+	return next
+}
+
+// Drops a reminder from the store once it has been delivered. The mutex must be held.
+func (s *Scheduler) remove(id string) {
+	delete(s.attempted, id)
+
+	if err := s.store.Remove(id); err != nil {
+		log.Println("Failed to remove reminder:", err)
+	}
+}
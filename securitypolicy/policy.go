@@ -0,0 +1,185 @@
+package securitypolicy
+
+import (
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// An IP that is temporarily refused because of repeated auth failures
+type Block struct {
+	IP       string
+	Until    time.Time
+	Failures int
+}
+
+// Used for tracking failed authentication attempts per remote IP and
+// blocking IPs that fail too often, similar to fail2ban
+type Policy struct {
+	mu            sync.Mutex
+	maxFailures   int
+	window        time.Duration
+	blockDuration time.Duration
+	tarpitDelay   time.Duration
+	failures      map[string][]time.Time
+	blocked       map[string]Block
+}
+
+// Returns a policy configured from AUTH_MAX_FAILURES, AUTH_FAILURE_WINDOW,
+// AUTH_BLOCK_DURATION and AUTH_TARPIT_DELAY. Blocked IPs are held open for
+// the tarpit delay before being closed; a delay of zero closes immediately.
+func New() *Policy {
+	p := &Policy{
+		failures: map[string][]time.Time{},
+		blocked:  map[string]Block{},
+	}
+	p.configure()
+
+	return p
+}
+
+// Re-reads the thresholds from the environment. Existing blocks keep their
+// original expiry.
+func (p *Policy) Reload() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.configure()
+}
+
+// Reads the thresholds from the environment
+func (p *Policy) configure() {
+	p.maxFailures = 5
+	p.window = 10 * time.Minute
+	p.blockDuration = 15 * time.Minute
+	p.tarpitDelay = 0
+	if n, err := strconv.Atoi(os.Getenv("AUTH_MAX_FAILURES")); err == nil {
+		p.maxFailures = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUTH_FAILURE_WINDOW")); err == nil {
+		p.window = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUTH_BLOCK_DURATION")); err == nil {
+		p.blockDuration = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUTH_TARPIT_DELAY")); err == nil {
+		p.tarpitDelay = d
+	}
+}
+
+// Records a failed authentication attempt and blocks the IP once it has
+// failed maxFailures times within the window
+func (p *Policy) RecordFailure(addr net.Addr) {
+	if p.maxFailures <= 0 {
+		return
+	}
+	ip := hostOf(addr)
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	recent := p.failures[ip][:0]
+	for _, t := range p.failures[ip] {
+		if now.Sub(t) < p.window {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	p.failures[ip] = recent
+
+	if len(recent) >= p.maxFailures {
+		p.blocked[ip] = Block{IP: ip, Until: now.Add(p.blockDuration), Failures: len(recent)}
+		delete(p.failures, ip)
+		log.Printf("Blocked %s for %s after %d failed logins", ip, p.blockDuration, len(recent))
+	}
+}
+
+// Forgets the failures of an IP after it authenticated successfully
+func (p *Policy) RecordSuccess(addr net.Addr) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.failures, hostOf(addr))
+}
+
+// Reports whether connections from the address are currently refused
+func (p *Policy) IsBlocked(addr net.Addr) bool {
+	ip := hostOf(addr)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	block, ok := p.blocked[ip]
+	if ok && time.Now().After(block.Until) {
+		delete(p.blocked, ip)
+		return false
+	}
+	return ok
+}
+
+// Returns how long a blocked connection should be held before closing it
+func (p *Policy) TarpitDelay() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.tarpitDelay
+}
+
+// Returns the currently blocked IPs ordered by IP
+func (p *Policy) Blocked() []Block {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var blocks []Block
+	for ip, block := range p.blocked {
+		if now.After(block.Until) {
+			delete(p.blocked, ip)
+			continue
+		}
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].IP < blocks[j].IP
+	})
+	return blocks
+}
+
+// Refuses connections from an IP for the duration, e.g. when an admin bans
+// a user
+func (p *Policy) Block(ip string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.blocked[ip] = Block{IP: ip, Until: time.Now().Add(d), Failures: p.blocked[ip].Failures}
+	delete(p.failures, ip)
+	log.Printf("Blocked %s for %s", ip, d)
+}
+
+// Lifts the block on an IP, reporting whether it was blocked
+func (p *Policy) Unblock(ip string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.blocked[ip]
+	delete(p.blocked, ip)
+	return ok
+}
+
+// Lifts all blocks
+func (p *Policy) UnblockAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.blocked = map[string]Block{}
+}
+
+// Returns the IP part of a network address
+func hostOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
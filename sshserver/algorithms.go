@@ -0,0 +1,133 @@
+package sshserver
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// A set of ciphers, MACs and key exchanges offered to clients, in order of
+// preference
+type algorithmProfile struct {
+	ciphers      []string
+	macs         []string
+	keyExchanges []string
+}
+
+// Profiles selectable with SSH_ALGORITHMS. "modern" only offers
+// authenticated encryption or CTR with SHA-2 MACs and elliptic curve or
+// large group key exchanges; "compatible" adds the SHA-1, CBC and RC4 based
+// algorithms old clients need.
+var algorithmProfiles = map[string]algorithmProfile{
+	"modern": {
+		ciphers: []string{
+			"chacha20-poly1305@openssh.com", "aes256-gcm@openssh.com", "aes128-gcm@openssh.com",
+			"aes256-ctr", "aes192-ctr", "aes128-ctr",
+		},
+		macs: []string{
+			"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+			"hmac-sha2-256", "hmac-sha2-512",
+		},
+		keyExchanges: []string{
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group16-sha512", "diffie-hellman-group14-sha256",
+		},
+	},
+	"compatible": {
+		ciphers: []string{
+			"chacha20-poly1305@openssh.com", "aes256-gcm@openssh.com", "aes128-gcm@openssh.com",
+			"aes256-ctr", "aes192-ctr", "aes128-ctr",
+			"aes128-cbc", "3des-cbc", "arcfour256", "arcfour128", "arcfour",
+		},
+		// The CBC ciphers break with encrypt-then-MAC, which clients
+		// prefer, so it is left out.
+		macs: []string{
+			"hmac-sha2-256", "hmac-sha2-512", "hmac-sha1", "hmac-sha1-96",
+		},
+		keyExchanges: []string{
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group16-sha512", "diffie-hellman-group14-sha256",
+			"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1",
+		},
+	},
+}
+
+// Profile used when SSH_ALGORITHMS is not set
+const defaultAlgorithmProfile = "modern"
+
+// Returns the algorithms to offer: the profile named by SSH_ALGORITHMS,
+// with the lists in SSH_CIPHERS, SSH_MACS and SSH_KEX_ALGORITHMS replacing
+// the profile's when set. Only algorithms of the profiles are accepted in
+// the lists.
+func algorithmConfig() ssh.Config {
+	name := os.Getenv("SSH_ALGORITHMS")
+	if name == "" {
+		name = defaultAlgorithmProfile
+	}
+	profile, ok := algorithmProfiles[name]
+	if !ok {
+		log.Fatalf("Unknown SSH_ALGORITHMS profile %q, available: modern, compatible", name)
+	}
+	var supported algorithmProfile
+	for _, p := range []algorithmProfile{algorithmProfiles["modern"], algorithmProfiles["compatible"]} {
+		supported.ciphers = union(supported.ciphers, p.ciphers)
+		supported.macs = union(supported.macs, p.macs)
+		supported.keyExchanges = union(supported.keyExchanges, p.keyExchanges)
+	}
+
+	config := ssh.Config{
+		Ciphers:      algorithmList("SSH_CIPHERS", profile.ciphers, supported.ciphers),
+		MACs:         algorithmList("SSH_MACS", profile.macs, supported.macs),
+		KeyExchanges: algorithmList("SSH_KEX_ALGORITHMS", profile.keyExchanges, supported.keyExchanges),
+	}
+	log.Printf("SSH algorithms: %s profile, ciphers %s, MACs %s, key exchanges %s", name,
+		strings.Join(config.Ciphers, ","), strings.Join(config.MACs, ","), strings.Join(config.KeyExchanges, ","))
+	return config
+}
+
+// Returns the comma separated algorithms in the variable, or the profile's
+// when it is not set
+func algorithmList(key string, profile []string, supported []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return profile
+	}
+	var list []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !contains(supported, name) {
+			log.Fatalf("Unsupported algorithm %q in %s, available: %s", name, key, strings.Join(supported, ", "))
+		}
+		list = append(list, name)
+	}
+	if len(list) == 0 {
+		log.Fatalf("%s lists no algorithms", key)
+	}
+	return list
+}
+
+// Returns a with the elements of b it lacks appended
+func union(a []string, b []string) []string {
+	for _, s := range b {
+		if !contains(a, s) {
+			a = append(a, s)
+		}
+	}
+	return a
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package sshserver
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// Prefix every SSH 2.0 version string starts with (RFC 4253 section 4.2)
+const versionPrefix = "SSH-2.0-"
+
+// Returns the version string sent before the handshake from
+// SSH_SERVER_VERSION, e.g. "GroupChat_1.4" becomes "SSH-2.0-GroupChat_1.4".
+// Empty keeps the library's default.
+func serverVersion() string {
+	version := os.Getenv("SSH_SERVER_VERSION")
+	if version == "" {
+		return ""
+	}
+	if !strings.HasPrefix(version, versionPrefix) {
+		version = versionPrefix + version
+	}
+	// The identification line is at most 255 characters including CR LF.
+	if len(version) > 253 {
+		log.Fatal("SSH_SERVER_VERSION is too long")
+	}
+	for _, r := range version {
+		if r < ' ' || r > '~' {
+			log.Fatalf("SSH_SERVER_VERSION may only contain printable ASCII, got %q", version)
+		}
+	}
+	return version
+}
+
+// Returns the banner shown before login, read from SSH_BANNER_PATH or taken
+// from SSH_BANNER. Lines end in CR LF so every client shows them alike.
+func loadBanner() string {
+	banner := os.Getenv("SSH_BANNER")
+	if path := os.Getenv("SSH_BANNER_PATH"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Failed to read SSH_BANNER_PATH: %v", err)
+		} else {
+			banner = string(b)
+		}
+	}
+	if banner == "" {
+		return ""
+	}
+	banner = strings.ReplaceAll(strings.TrimRight(banner, "\r\n"), "\r\n", "\n")
+	return strings.ReplaceAll(banner, "\n", "\r\n") + "\r\n"
+}
+
+// Re-reads the pre-login banner. The version string only changes on
+// restart.
+func (ss *SSHServer) Reload() {
+	ss.banner.Store(loadBanner())
+}
+
+// Returns the current pre-login banner
+func (ss *SSHServer) bannerText() string {
+	return ss.banner.Load().(string)
+}
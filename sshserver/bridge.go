@@ -0,0 +1,487 @@
+package sshserver
+
+import (
+	"bufio"
+	"fmt"
+	"group-ssh-chat/chat"
+	"group-ssh-chat/storage"
+	"group-ssh-chat/ui"
+	"image"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	bell        = "\a"
+	clearScreen = "\033[2J\033[H"
+
+	// Number of pending output lines buffered per session before dropping
+	outboxSize = 256
+
+	// Output format of programs reading the chat, see SetOutput
+	OutputJSON = "json"
+)
+
+// Bridges a terminal stream (SSH channel, WebSocket, ...) to a chat hub
+// session: reads input lines into the hub and renders hub output
+type SSHTerminalBridge struct {
+	hub        *chat.Hub
+	user       string
+	caps       ui.Capabilities
+	termWidth  int
+	termHeight int
+	prefs      chat.Preferences
+	prefsMutex sync.RWMutex
+	terminal   *term.Terminal
+	stream     io.ReadWriter // below the terminal, used directly by programs
+	closer     io.Closer
+	outbox     chan []byte
+	done       chan struct{}
+	closeOnce  sync.Once
+
+	// Measures the network round trip, see SetPinger. The last measurement
+	// is shown in the prompt and guarded by prefsMutex.
+	pinger        func() error
+	latency       time.Duration
+	promptChanged chan struct{}
+
+	// Sent by the client to resume an earlier session, set before Serve
+	resumeToken string
+
+	// Environment variables the client sent, set before Serve
+	env map[string]string
+
+	// Time zone of timestamps, from the preferences and the client's TZ.
+	// Guarded by prefsMutex.
+	location *time.Location
+
+	// Output format the client asked for, see SetOutput. Guarded by
+	// prefsMutex.
+	output string
+}
+
+// Returns a new bridge rendering to rw and closing closer on exit. Writes to
+// rw that block for longer than the write timeout close the bridge.
+func NewSSHTerminalBridge(hub *chat.Hub, rw io.ReadWriter, closer io.Closer) *SSHTerminalBridge {
+	b := &SSHTerminalBridge{
+		hub:        hub,
+		closer:     closer,
+		termWidth:  defaultTerminalWidth,
+		termHeight: defaultTerminalHeight,
+		caps:       ui.DefaultCapabilities,
+		outbox:     make(chan []byte, outboxSize),
+		done:       make(chan struct{}),
+		location:   time.Local,
+
+		promptChanged: make(chan struct{}, 1),
+	}
+	// UTF-8 needs at most 4 bytes per character
+	input := &lineLimiter{ReadWriter: rw, limit: 4 * hub.MaxMessageLength()}
+	b.stream = &deadlineWriter{ReadWriter: input, timeout: writeTimeout(), expire: b.writeTimedOut}
+	b.terminal = term.NewTerminal(b.stream, "> ")
+	b.terminal.AutoCompleteCallback = b.complete
+	return b
+}
+
+// Joins the hub as user and serves terminal input until the client disconnects
+func (b *SSHTerminalBridge) Serve(user string, remoteAddr string) {
+	defer b.Close()
+	b.user = user
+	go b.writeLoop()
+	if b.pinger != nil {
+		go b.sampleLatency()
+	}
+
+	sess, err := b.hub.JoinResumable(user, remoteAddr, b.resumeToken, b)
+	if err != nil {
+		log.Printf("Refused session for %s: %v", user, err)
+		fmt.Fprintf(b.terminal, "Connection refused: %v\n", err)
+		return
+	}
+	defer b.hub.Leave(sess)
+
+	if b.isProgram() {
+		b.serveProgram(sess)
+		return
+	}
+
+	// Lines of a bracketed paste are held back until the user presses Enter
+	// and then sent as a single message.
+	if b.Capabilities().Color {
+		b.terminal.SetBracketedPasteMode(true)
+	}
+	var pasted []string
+	for {
+		line, err := b.terminal.ReadLine()
+		if err == term.ErrPasteIndicator {
+			if len(pasted) == 0 {
+				b.WriteSystem("Press Enter to send the pasted lines as one message")
+			}
+			pasted = append(pasted, line)
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Println("Read error:", err)
+			}
+			return
+		}
+		if len(pasted) > 0 {
+			if line != "" {
+				pasted = append(pasted, line)
+			}
+			line = strings.Join(pasted, "\n")
+			pasted = nil
+		}
+		b.hub.HandleInput(sess, line)
+	}
+}
+
+// Passes the lines a program writes to the hub until it closes its input.
+// Without a terminal in between nothing is echoed back into the output.
+func (b *SSHTerminalBridge) serveProgram(sess *chat.Session) {
+	reader := bufio.NewReader(b.stream)
+	for {
+		line, err := reader.ReadString('\n')
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			b.hub.HandleInput(sess, line)
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Println("Read error:", err)
+			}
+			return
+		}
+	}
+}
+
+// Writes queued output to the terminal until the bridge is closed
+func (b *SSHTerminalBridge) writeLoop() {
+	output := io.Writer(b.terminal)
+	if b.isProgram() {
+		output = b.stream
+	}
+	for {
+		select {
+		case p := <-b.outbox:
+			if _, err := output.Write(b.adapt(p)); err != nil {
+				if err != io.EOF {
+					log.Println("Write error:", err)
+				}
+				b.Close()
+				return
+			}
+		case <-b.promptChanged:
+			if b.isProgram() {
+				continue
+			}
+			// Writing nothing redraws the prompt and the input line.
+			b.terminal.SetPrompt(b.prompt())
+			if _, err := b.terminal.Write(nil); err != nil {
+				b.Close()
+				return
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// Rewrites output for terminals that cannot render color or Unicode. JSON
+// output is left alone.
+func (b *SSHTerminalBridge) adapt(p []byte) []byte {
+	caps := b.Capabilities()
+	if _, ok := b.renderer().(jsonRenderer); ok || (caps.Color && caps.Unicode) {
+		return p
+	}
+	s := string(p)
+	if !caps.Color {
+		s = ui.StripANSI(s)
+	}
+	if !caps.Unicode {
+		s = ui.ASCIIFallback(s)
+	}
+	return []byte(s)
+}
+
+// Closes a session whose client stopped accepting output, which ends Serve
+// and removes the session from the hub
+func (b *SSHTerminalBridge) writeTimedOut() {
+	log.Printf("Write to %s timed out, closing session", b.user)
+	b.Close()
+}
+
+// Returns the number of writes waiting for the terminal
+func (b *SSHTerminalBridge) QueuedOutput() int {
+	return len(b.outbox)
+}
+
+// Queues output for the terminal, dropping it when the client is not keeping
+// up. Reports whether the output was queued.
+func (b *SSHTerminalBridge) enqueue(p []byte) bool {
+	select {
+	case b.outbox <- p:
+		return true
+	case <-b.done:
+		return false
+	default:
+		log.Println("Dropped output for slow client")
+		return false
+	}
+}
+
+// Everything a rendered chat message depends on besides the message itself.
+// Recipients with the same style share the formatted output of a broadcast.
+type chatStyle struct {
+	renderer   Renderer
+	palette    ui.Palette
+	width      int
+	timestamps bool
+	layout     string
+	zone       string
+	emoji      bool
+	hyperlinks bool
+	mention    bool
+}
+
+// Renders a message with the session's renderer, ringing the bell for
+// mentions and whispers when the user wants it. Chat messages are rendered
+// once per style and shared through the cache.
+func (b *SSHTerminalBridge) WriteMessage(msg chat.Message, cache *chat.RenderCache) bool {
+	v, r := b.view(), b.renderer()
+	var out []byte
+	alert := false
+	switch msg.Type {
+	case chat.ChatMessage:
+		alert = msg.From != b.user && chat.Mentions(msg.Text, b.user)
+		style := chatStyle{
+			renderer:   r,
+			palette:    v.Palette,
+			width:      v.Width,
+			timestamps: v.Prefs.Enabled("timestamps"),
+			layout:     v.Prefs.TimeLayout(),
+			zone:       v.Zone.String(),
+			emoji:      v.emoji(),
+			hyperlinks: v.Caps.Hyperlinks,
+			mention:    alert,
+		}
+		out = cache.Render(style, func() []byte { return r.Message(v, msg) })
+	case chat.WhisperMessage:
+		alert = msg.From != b.user
+		out = r.Message(v, msg)
+	default:
+		out = r.Message(v, msg)
+	}
+	if alert && v.Prefs.Enabled("bell") {
+		// The cached output is shared, so the bell goes on a copy.
+		out = append(out[:len(out):len(out)], r.Bell()...)
+	}
+	return b.enqueue(out)
+}
+
+// Renders a notice from the server
+func (b *SSHTerminalBridge) WriteSystem(text string) {
+	b.WriteMessage(chat.Message{Type: chat.SystemMessage, Time: time.Now(), Text: text}, nil)
+}
+
+// Renders messages from the history with their original timestamps
+func (b *SSHTerminalBridge) WriteHistory(title string, msgs []storage.StoredMessage) bool {
+	return b.enqueue(b.renderer().History(b.view(), title, msgs))
+}
+
+// Renders the members of a room
+func (b *SSHTerminalBridge) WriteUserList(room string, users []string) {
+	b.enqueue(b.renderer().UserList(b.view(), room, users))
+}
+
+// Renders a horizontal rule with a centered label, e.g. "――― unread ―――"
+func (b *SSHTerminalBridge) WriteDivider(label string) {
+	b.enqueue(b.renderer().Divider(b.view(), label))
+}
+
+// Renders a preview of a linked image if the session's output can show it
+func (b *SSHTerminalBridge) WriteImage(url string, img image.Image) {
+	if out := b.renderer().Image(b.view(), url, img); len(out) > 0 {
+		b.enqueue(out)
+	}
+}
+
+// Returns when a message was sent, or now for messages that do not say
+func messageTime(msg chat.Message) time.Time {
+	if msg.Time.IsZero() {
+		return time.Now()
+	}
+	return msg.Time
+}
+
+// Records the size of the client's terminal window
+func (b *SSHTerminalBridge) SetWindowSize(width int, height int) {
+	if err := b.terminal.SetSize(width, height); err != nil {
+		log.Println("Failed to resize terminal:", err)
+	}
+
+	b.prefsMutex.Lock()
+	defer b.prefsMutex.Unlock()
+	b.termWidth = width
+	b.termHeight = height
+}
+
+// Returns the terminal width in cells
+func (b *SSHTerminalBridge) width() int {
+	b.prefsMutex.RLock()
+	defer b.prefsMutex.RUnlock()
+	return b.termWidth
+}
+
+// Returns the terminal height in lines, or 0 for programs, whose output is
+// not paged
+func (b *SSHTerminalBridge) Height() int {
+	b.prefsMutex.RLock()
+	defer b.prefsMutex.RUnlock()
+	if b.output == OutputJSON {
+		return 0
+	}
+	return b.termHeight
+}
+
+// Records the terminal type requested by the client, e.g. "xterm" or "dumb",
+// and what that terminal can render
+func (b *SSHTerminalBridge) SetTerminalType(termType string) {
+	b.prefsMutex.Lock()
+	defer b.prefsMutex.Unlock()
+	b.caps = ui.DetectCapabilities(termType)
+}
+
+// Sets the token the client sent to resume an earlier session. Must be
+// called before Serve.
+func (b *SSHTerminalBridge) SetResumeToken(token string) {
+	b.resumeToken = token
+}
+
+// Records an environment variable sent by the client. Must be called
+// before Serve.
+func (b *SSHTerminalBridge) SetEnv(name string, value string) {
+	if b.env == nil {
+		b.env = map[string]string{}
+	}
+	b.env[name] = value
+}
+
+// Returns the environment variables sent by the client
+func (b *SSHTerminalBridge) Environment() map[string]string {
+	return b.env
+}
+
+// Returns what the client's terminal can render. Accessibility mode turns
+// off color and hyperlinks, whose escape codes screen readers may read out.
+func (b *SSHTerminalBridge) Capabilities() ui.Capabilities {
+	b.prefsMutex.RLock()
+	defer b.prefsMutex.RUnlock()
+	caps := b.caps
+	if b.prefs.Enabled("accessibility") {
+		caps.Color = false
+		caps.Hyperlinks = false
+	}
+	return caps
+}
+
+// Applies the user's display preferences to subsequent output
+func (b *SSHTerminalBridge) SetPreferences(prefs chat.Preferences) {
+	b.prefsMutex.Lock()
+	b.prefs = prefs
+	b.location = prefs.Location(b.env)
+	b.prefsMutex.Unlock()
+	b.refreshPrompt()
+}
+
+// Returns the current display preferences and the palette to render with.
+// Terminals that cannot render color always get the monochrome palette.
+func (b *SSHTerminalBridge) style() (chat.Preferences, ui.Palette) {
+	b.prefsMutex.RLock()
+	defer b.prefsMutex.RUnlock()
+
+	if !b.caps.Color || b.prefs.Enabled("accessibility") {
+		return b.prefs, ui.Theme(ui.MonochromeTheme)
+	}
+	return b.prefs, ui.Theme(b.prefs.Get("theme"))
+}
+
+// Returns the time zone timestamps are shown in
+func (b *SSHTerminalBridge) zone() *time.Location {
+	b.prefsMutex.RLock()
+	defer b.prefsMutex.RUnlock()
+	return b.location
+}
+
+// Clears the screen if the session's output can
+func (b *SSHTerminalBridge) Clear() {
+	if out := b.renderer().Clear(b.view()); len(out) > 0 {
+		b.enqueue(out)
+	}
+}
+
+// Returns the state of the session output is rendered for
+func (b *SSHTerminalBridge) view() *View {
+	prefs, palette := b.style()
+	return &View{
+		User:    b.user,
+		Prefs:   prefs,
+		Palette: palette,
+		Caps:    b.Capabilities(),
+		Width:   b.width(),
+		Zone:    b.zone(),
+		hub:     b.hub,
+	}
+}
+
+// Returns the renderer for the session: JSON when the client asked for it,
+// plain text in accessibility mode and ANSI text otherwise
+func (b *SSHTerminalBridge) renderer() Renderer {
+	b.prefsMutex.RLock()
+	defer b.prefsMutex.RUnlock()
+
+	switch {
+	case b.jsonOutputLocked():
+		return jsonRenderer{}
+	case b.prefs.Enabled("accessibility"):
+		return plainRenderer
+	}
+	return ansiRenderer
+}
+
+// Reports whether output is JSON, because the client asked for it or the
+// user set it. Must be called with prefsMutex held.
+func (b *SSHTerminalBridge) jsonOutputLocked() bool {
+	return b.output == OutputJSON || b.prefs.Get("output") == OutputJSON
+}
+
+// Makes the session one for a program when format is OutputJSON: output is
+// written as JSON lines and input read as plain lines, without a terminal
+// echoing input or drawing a prompt. Must be called before Serve.
+func (b *SSHTerminalBridge) SetOutput(format string) {
+	b.prefsMutex.Lock()
+	defer b.prefsMutex.Unlock()
+	b.output = format
+}
+
+// Reports whether the session is one for a program, see SetOutput
+func (b *SSHTerminalBridge) isProgram() bool {
+	b.prefsMutex.RLock()
+	defer b.prefsMutex.RUnlock()
+	return b.output == OutputJSON
+}
+
+// Stops the bridge and closes the underlying connection
+func (b *SSHTerminalBridge) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		close(b.done)
+		err = b.closer.Close()
+	})
+	return err
+}
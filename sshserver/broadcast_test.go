@@ -0,0 +1,65 @@
+package sshserver
+
+import (
+	"fmt"
+	"group-ssh-chat/audit"
+	"group-ssh-chat/chat"
+	"group-ssh-chat/securitypolicy"
+	"group-ssh-chat/stats"
+	"group-ssh-chat/storage"
+	"io"
+	"log"
+	"os"
+	"testing"
+)
+
+// A terminal that throws away everything written to it
+type discardTerminal struct{}
+
+func (discardTerminal) Read(p []byte) (int, error)  { return 0, io.EOF }
+func (discardTerminal) Write(p []byte) (int, error) { return len(p), nil }
+func (discardTerminal) Close() error                { return nil }
+
+// Returns a hub backed by in-memory stores with n bridged sessions in the
+// default room, and the session of the first user
+func newBenchmarkHub(b *testing.B, n int) (*chat.Hub, *chat.Session) {
+	b.Helper()
+	for _, key := range []string{"HISTORY_PATH", "PREFERENCES_PATH", "ROOMS_PATH", "REMINDERS_PATH", "INBOX_PATH", "TOTP_SECRETS_PATH", "REGISTERED_KEYS_PATH", "AUDIT_LOG_PATH", "MAX_SESSIONS_PER_USER"} {
+		b.Setenv(key, "")
+	}
+	// Join announcements overflow the outboxes while the room fills up.
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	hub := chat.New(storage.NewPreferencesStore(), storage.NewHistoryStore(), storage.NewRoomStore(), storage.NewReminderStore(), storage.NewInboxStore(), storage.NewSecretStore(), storage.NewKeyStore(), securitypolicy.New(), audit.New(), stats.New())
+
+	var first *chat.Session
+	for i := 0; i < n; i++ {
+		bridge := NewSSHTerminalBridge(hub, discardTerminal{}, discardTerminal{})
+		bridge.user = fmt.Sprintf("user%d", i)
+		go bridge.writeLoop()
+		b.Cleanup(func() { bridge.Close() })
+
+		sess, err := hub.Join(bridge.user, "bench", bridge)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if first == nil {
+			first = sess
+		}
+	}
+	return hub, first
+}
+
+func benchmarkBroadcast(b *testing.B, n int) {
+	hub, sender := newBenchmarkHub(b, n)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hub.HandleInput(sender, "hello @user1, this is a broadcast benchmark")
+	}
+}
+
+func BenchmarkBroadcast100(b *testing.B)  { benchmarkBroadcast(b, 100) }
+func BenchmarkBroadcast1000(b *testing.B) { benchmarkBroadcast(b, 1000) }
+func BenchmarkBroadcast2000(b *testing.B) { benchmarkBroadcast(b, 2000) }
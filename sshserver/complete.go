@@ -0,0 +1,61 @@
+package sshserver
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Completes the room name after /join when Tab is pressed, from the public
+// rooms. With several matches the input is completed as far as they agree
+// and the matches are listed, most active first.
+func (b *SSHTerminalBridge) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	command, partial, found := strings.Cut(line[:pos], " ")
+	if !found || !strings.EqualFold(command, "/join") || strings.ContainsAny(partial, " \t") {
+		return "", 0, false
+	}
+	partial = strings.ToLower(strings.TrimPrefix(partial, "#"))
+
+	var matches []string
+	for _, room := range b.hub.PublicRooms() {
+		if strings.HasPrefix(room.Name, partial) {
+			matches = append(matches, room.Name)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+
+	completion := matches[0]
+	if len(matches) == 1 {
+		completion += " "
+	} else {
+		for _, m := range matches[1:] {
+			completion = commonPrefix(completion, m)
+		}
+		if completion == partial {
+			listed := make([]string, len(matches))
+			for i, m := range matches {
+				listed[i] = "#" + m
+			}
+			b.WriteSystem(strings.Join(listed, "  "))
+		}
+	}
+	head := command + " " + completion
+	return head + strings.TrimLeft(line[pos:], " "), len(head), true
+}
+
+// Returns the longest common prefix of two strings, without splitting a
+// character
+func commonPrefix(a string, b string) string {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	for n > 0 && n < len(a) && !utf8.RuneStart(a[n]) {
+		n--
+	}
+	return a[:n]
+}
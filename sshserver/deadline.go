@@ -0,0 +1,46 @@
+package sshserver
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// How long a single write to a client may block before the session is
+// considered wedged, unless WRITE_TIMEOUT says otherwise
+const defaultWriteTimeout = 30 * time.Second
+
+// Returned by writes that did not complete within the write timeout
+var ErrWriteTimeout = errors.New("write timed out")
+
+// Wraps a client stream so that a write blocking for longer than the timeout
+// calls expire, which is expected to close the stream and so unblock the
+// write. Transports like SSH channels have no write deadlines of their own.
+type deadlineWriter struct {
+	io.ReadWriter
+	timeout time.Duration
+	expire  func()
+}
+
+// Writes p, reporting ErrWriteTimeout when the write outlived the timeout
+func (dw *deadlineWriter) Write(p []byte) (int, error) {
+	if dw.timeout <= 0 {
+		return dw.ReadWriter.Write(p)
+	}
+	timer := time.AfterFunc(dw.timeout, dw.expire)
+	n, err := dw.ReadWriter.Write(p)
+	if !timer.Stop() {
+		return n, ErrWriteTimeout
+	}
+	return n, err
+}
+
+// Returns the write timeout for new sessions from WRITE_TIMEOUT. A timeout
+// of 0 disables it.
+func writeTimeout() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("WRITE_TIMEOUT")); err == nil && d >= 0 {
+		return d
+	}
+	return defaultWriteTimeout
+}
@@ -0,0 +1,42 @@
+package sshserver
+
+import "regexp"
+
+var emojiShortcode = regexp.MustCompile(`:[a-z0-9_+\-]+:`)
+
+// Shortcodes expanded when the emoji preference is on
+var emojiShortcodes = map[string]string{
+	":smile:":      "😄",
+	":grin:":       "😁",
+	":joy:":        "😂",
+	":wink:":       "😉",
+	":heart:":      "❤️",
+	":thumbsup:":   "👍",
+	":+1:":         "👍",
+	":thumbsdown:": "👎",
+	":-1:":         "👎",
+	":tada:":       "🎉",
+	":fire:":       "🔥",
+	":rocket:":     "🚀",
+	":eyes:":       "👀",
+	":thinking:":   "🤔",
+	":wave:":       "👋",
+	":clap:":       "👏",
+	":ok:":         "🆗",
+	":check:":      "✅",
+	":x:":          "❌",
+	":coffee:":     "☕",
+	":cry:":        "😢",
+	":sob:":        "😭",
+	":shrug:":      "🤷",
+}
+
+// Replaces known :shortcodes: in text with their emoji
+func expandEmoji(text string) string {
+	return emojiShortcode.ReplaceAllStringFunc(text, func(code string) string {
+		if emoji, ok := emojiShortcodes[code]; ok {
+			return emoji
+		}
+		return code
+	})
+}
@@ -0,0 +1,86 @@
+package sshserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Usage of the commands accepted in "exec" requests
+const execUsage = "usage: post #room <message> | tail [#room] | --json"
+
+// Command that starts a chat session for a program instead of a one-shot
+// command, e.g. `ssh chat.example.com --json`. The program writes input
+// lines and reads the chat as newline-delimited JSON.
+const jsonFlag = "--json"
+
+// Payload of an "exec" channel request (RFC 4254 section 6.5)
+type execRequest struct {
+	Command string
+}
+
+// Payload of an "exit-status" channel request (RFC 4254 section 6.10)
+type exitStatus struct {
+	Status uint32
+}
+
+// Runs a one-shot command from an "exec" request, e.g.
+// `ssh chat.example.com "post #general deploy finished"`, and closes the
+// channel with its exit status
+func (ss *SSHServer) runExec(conn *ssh.ServerConn, channel ssh.Channel, command string) {
+	user := connUser(conn)
+	log.Printf("Exec requested by %s: %s", user, command)
+
+	var status exitStatus
+	if err := ss.exec(conn, channel, user, command); err != nil {
+		fmt.Fprintln(channel.Stderr(), err)
+		status.Status = 1
+	}
+	if _, err := channel.SendRequest("exit-status", false, ssh.Marshal(&status)); err != nil {
+		log.Printf("Failed to send exit status: %v", err)
+	}
+	channel.Close()
+}
+
+// Executes a one-shot command as user, writing its output to channel
+func (ss *SSHServer) exec(conn *ssh.ServerConn, channel ssh.Channel, user string, command string) error {
+	verb, args, _ := strings.Cut(strings.TrimSpace(command), " ")
+	switch verb {
+	case "post":
+		room, text, _ := strings.Cut(strings.TrimSpace(args), " ")
+		if room == "" || strings.TrimSpace(text) == "" {
+			return errors.New(execUsage)
+		}
+		return ss.hub.Post(user, room, text)
+	case "tail":
+		return ss.tail(conn, channel, user, strings.TrimSpace(args))
+	default:
+		return fmt.Errorf("unknown command %q, %s", verb, execUsage)
+	}
+}
+
+// Streams new messages of the room to channel as plain lines until the
+// connection closes, e.g. `ssh chat.example.com tail #general | grep deploy`
+func (ss *SSHServer) tail(conn *ssh.ServerConn, channel ssh.Channel, user string, room string) error {
+	if strings.ContainsAny(room, " \t") {
+		return errors.New(execUsage)
+	}
+	client := newTailClient(channel)
+	stop, err := ss.hub.Tail(user, room, conn.RemoteAddr().String(), client)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	// Closing stdin does not end the stream, only the connection going away
+	// or the client failing to write does.
+	go func() {
+		conn.Wait()
+		client.Close()
+	}()
+	client.writeLoop()
+	return nil
+}
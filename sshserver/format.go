@@ -0,0 +1,146 @@
+package sshserver
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+)
+
+const (
+	// Usernames wider than this many terminal cells are truncated with an ellipsis
+	maxUsernameWidth = 16
+
+	// Continuation lines are only indented when at least this many cells remain
+	minWrapWidth = 20
+
+	// Width assumed until the client reports its window size
+	defaultTerminalWidth = 80
+)
+
+// Shortens a username to at most maxUsernameWidth terminal cells
+func truncateUsername(name string) string {
+	return runewidth.Truncate(name, maxUsernameWidth, "…")
+}
+
+// Splits text into lines of at most width terminal cells, breaking at the
+// last space that fits and splitting words that are wider than a line
+func wrapText(text string, width int) []string {
+	if width < 1 || runewidth.StringWidth(text) <= width {
+		return []string{text}
+	}
+
+	var lines []string
+	for runewidth.StringWidth(text) > width {
+		cut, lastSpace, w := 0, -1, 0
+		for i, r := range text {
+			rw := runewidth.RuneWidth(r)
+			if w+rw > width {
+				break
+			}
+			w += rw
+			cut = i + utf8.RuneLen(r)
+			if r == ' ' {
+				lastSpace = i
+			}
+		}
+
+		switch {
+		case lastSpace > 0:
+			lines = append(lines, text[:lastSpace])
+			text = text[lastSpace+1:]
+		case cut > 0:
+			lines = append(lines, text[:cut])
+			text = text[cut:]
+		default:
+			// A single rune wider than the line, emit it on its own.
+			_, size := utf8.DecodeRuneInString(text)
+			lines = append(lines, text[:size])
+			text = text[size:]
+		}
+	}
+	return append(lines, text)
+}
+
+// Wraps text to the terminal width, indenting continuation lines so they
+// line up after a prefix that is prefixWidth cells wide
+func wrapWithIndent(text string, prefixWidth int, termWidth int) []string {
+	if termWidth-prefixWidth < minWrapWidth {
+		return wrapText(text, termWidth)
+	}
+
+	lines := wrapText(text, termWidth-prefixWidth)
+	indent := strings.Repeat(" ", prefixWidth)
+	for i := 1; i < len(lines); i++ {
+		lines[i] = indent + lines[i]
+	}
+	return lines
+}
+
+// Draws rows inside a titled box no wider than maxWidth cells
+func drawBox(title string, rows []string, maxWidth int) string {
+	inner := runewidth.StringWidth(title) + 2
+	for _, row := range rows {
+		if w := runewidth.StringWidth(row); w > inner {
+			inner = w
+		}
+	}
+	if maxWidth > 4 && inner > maxWidth-4 {
+		inner = maxWidth - 4
+	}
+
+	title = runewidth.Truncate(title, inner-2, "…")
+	var sb strings.Builder
+	sb.WriteString("┌─ " + title + " " + strings.Repeat("─", inner-runewidth.StringWidth(title)-1) + "┐\n")
+	for _, row := range rows {
+		row = runewidth.Truncate(row, inner, "…")
+		sb.WriteString("│ " + runewidth.FillRight(row, inner) + " │\n")
+	}
+	sb.WriteString("└" + strings.Repeat("─", inner+2) + "┘")
+	return sb.String()
+}
+
+// Reports whether a message spans several lines and is rendered as a block
+func isBlock(text string) bool {
+	return strings.Contains(text, "\n")
+}
+
+// Marks the start and end of a code block in a message
+const codeFence = "```"
+
+// Matches the language tag that may follow an opening fence, e.g. ```go
+var fenceLanguage = regexp.MustCompile(`^[A-Za-z0-9_+#.-]*$`)
+
+// A run of message text that is either prose or fenced code
+type segment struct {
+	text string
+	code bool
+}
+
+// Reports whether a message contains fenced code
+func hasCode(text string) bool {
+	return strings.Count(text, codeFence) >= 2
+}
+
+// Splits text on ``` fences into prose and code segments. An unterminated
+// fence runs to the end of the text. A language tag on the line opening a
+// block is dropped, as are blank lines around fences.
+func splitFences(text string) []segment {
+	parts := strings.Split(text, codeFence)
+	segments := make([]segment, 0, len(parts))
+	for i, part := range parts {
+		code := i%2 == 1
+		if code {
+			if first, rest, ok := strings.Cut(part, "\n"); ok && fenceLanguage.MatchString(first) {
+				part = rest
+			}
+		}
+		part = strings.Trim(part, "\n")
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		segments = append(segments, segment{text: part, code: code})
+	}
+	return segments
+}
@@ -0,0 +1,81 @@
+package sshserver
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// Time a connection has to complete the SSH handshake, including
+	// authentication, unless HANDSHAKE_TIMEOUT says otherwise
+	defaultHandshakeTimeout = time.Minute
+
+	// Handshakes run at once unless MAX_HANDSHAKES says otherwise
+	defaultMaxHandshakes = 64
+
+	// Connections waiting for a handshake slot unless HANDSHAKE_QUEUE says
+	// otherwise. Connections beyond that are closed right away.
+	defaultHandshakeQueue = 16
+)
+
+// Bounds the handshakes in progress, so a flood of connections that never
+// finish their handshake cannot tie up the server
+type handshakeGate struct {
+	timeout time.Duration
+	pending chan struct{} // handshakes running or queued
+	active  chan struct{} // handshakes running
+}
+
+// Returns a gate configured by HANDSHAKE_TIMEOUT, MAX_HANDSHAKES and
+// HANDSHAKE_QUEUE
+func newHandshakeGate() *handshakeGate {
+	timeout := defaultHandshakeTimeout
+	if d, err := time.ParseDuration(os.Getenv("HANDSHAKE_TIMEOUT")); err == nil && d > 0 {
+		timeout = d
+	}
+	active := envInt("MAX_HANDSHAKES", defaultMaxHandshakes)
+	if active < 1 {
+		active = defaultMaxHandshakes
+	}
+	queue := envInt("HANDSHAKE_QUEUE", defaultHandshakeQueue)
+	if queue < 0 {
+		queue = defaultHandshakeQueue
+	}
+	return &handshakeGate{
+		timeout: timeout,
+		pending: make(chan struct{}, active+queue),
+		active:  make(chan struct{}, active),
+	}
+}
+
+// Reserves a place for a new connection without blocking. Reports false
+// when the queue is full; otherwise the connection must call start before
+// and finish after its handshake.
+func (g *handshakeGate) admit() bool {
+	select {
+	case g.pending <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Waits for a free handshake slot
+func (g *handshakeGate) start() {
+	g.active <- struct{}{}
+}
+
+// Frees the slot and place of a finished handshake
+func (g *handshakeGate) finish() {
+	<-g.active
+	<-g.pending
+}
+
+func envInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,73 @@
+package sshserver
+
+import (
+	"encoding/json"
+	"group-ssh-chat/chat"
+	"group-ssh-chat/storage"
+	"image"
+	"log"
+	"time"
+)
+
+// Renders output as one JSON object per line for programs reading the
+// chat. Messages are written as chat.Message; the other output has a type
+// of its own:
+//
+//	{"type": "users", "room": "lobby", "users": ["alice", "bob"]}
+//	{"type": "divider", "text": "unread"}
+type jsonRenderer struct{}
+
+// A line of JSON output that is not a message
+type jsonEvent struct {
+	Type  string   `json:"type"`
+	Room  string   `json:"room,omitempty"`
+	Text  string   `json:"text,omitempty"`
+	Users []string `json:"users,omitempty"`
+}
+
+func (jsonRenderer) Message(v *View, msg chat.Message) []byte {
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	return jsonLine(msg)
+}
+
+// Writes the title as a notice followed by the messages. Reactions and
+// edits are left out.
+func (r jsonRenderer) History(v *View, title string, msgs []storage.StoredMessage) []byte {
+	var out []byte
+	if title != "" {
+		out = r.Message(v, chat.Message{Type: chat.SystemMessage, Text: title})
+	}
+	for _, stored := range msgs {
+		msg := chat.Message{ID: stored.ID, Seq: stored.Seq, Type: chat.ChatMessage, From: stored.From, Room: stored.Room, Time: stored.Time, Text: stored.Text}
+		if stored.ReplyTo != 0 {
+			msg.Quote = &chat.Quote{ID: stored.ReplyTo}
+		}
+		out = append(out, jsonLine(msg)...)
+	}
+	return out
+}
+
+func (jsonRenderer) UserList(v *View, room string, users []string) []byte {
+	return jsonLine(jsonEvent{Type: "users", Room: room, Users: users})
+}
+
+func (jsonRenderer) Divider(v *View, label string) []byte {
+	return jsonLine(jsonEvent{Type: "divider", Text: label})
+}
+
+// Images are not written, programs can fetch the links themselves
+func (jsonRenderer) Image(v *View, url string, img image.Image) []byte { return nil }
+func (jsonRenderer) Clear(v *View) []byte                              { return nil }
+func (jsonRenderer) Bell() []byte                                      { return nil }
+
+// Encodes a value as a line of JSON
+func jsonLine(value any) []byte {
+	out, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Failed to encode output: %v", err)
+		return nil
+	}
+	return append(out, '\n')
+}
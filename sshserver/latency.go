@@ -0,0 +1,93 @@
+package sshserver
+
+import (
+	"errors"
+	"group-ssh-chat/chat"
+	"time"
+)
+
+const (
+	// How often the network round trip shown in the prompt is measured
+	latencySampleInterval = 15 * time.Second
+
+	// How long a ping waits for the client to answer
+	pingTimeout = 10 * time.Second
+
+	// Channel request sent to measure the round trip. Clients answer
+	// requests they do not know with a failure, which is just as good.
+	pingRequest = "keepalive@openssh.com"
+)
+
+// Sets the function that sends a request the client must answer. Must be
+// called before Serve; bridges without one cannot measure latency.
+func (b *SSHTerminalBridge) SetPinger(ping func() error) {
+	b.pinger = ping
+}
+
+// Returns the network round trip to the client
+func (b *SSHTerminalBridge) Ping() (time.Duration, error) {
+	if b.pinger == nil {
+		return 0, chat.ErrPingUnsupported
+	}
+	start := time.Now()
+	result := make(chan error, 1)
+	go func() {
+		result <- b.pinger()
+	}()
+	select {
+	case err := <-result:
+		if err != nil {
+			return 0, err
+		}
+	case <-time.After(pingTimeout):
+		return 0, errors.New("no answer from the client")
+	case <-b.done:
+		return 0, errors.New("session closed")
+	}
+	rtt := time.Since(start)
+
+	b.prefsMutex.Lock()
+	b.latency = rtt
+	b.prefsMutex.Unlock()
+	b.refreshPrompt()
+	return rtt, nil
+}
+
+// Measures the round trip periodically for the prompt until the bridge is
+// closed
+func (b *SSHTerminalBridge) sampleLatency() {
+	ticker := time.NewTicker(latencySampleInterval)
+	defer ticker.Stop()
+	for {
+		b.Ping()
+		select {
+		case <-ticker.C:
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// Returns the prompt, led by the last measured round trip when the user
+// wants to see it. In accessibility mode there is none: without a prompt
+// the terminal only has to move the cursor around while the user is typing.
+// JSON output has none either, so every line is an object.
+func (b *SSHTerminalBridge) prompt() string {
+	b.prefsMutex.RLock()
+	defer b.prefsMutex.RUnlock()
+	if b.prefs.Enabled("accessibility") || b.jsonOutputLocked() {
+		return ""
+	}
+	if b.latency == 0 || !b.prefs.Enabled("latency") {
+		return "> "
+	}
+	return "[" + chat.FormatLatency(b.latency) + "] > "
+}
+
+// Asks the write loop to redraw the prompt
+func (b *SSHTerminalBridge) refreshPrompt() {
+	select {
+	case b.promptChanged <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,29 @@
+package sshserver
+
+import "io"
+
+// Passes input through until a line grows past limit bytes, then drops the
+// rest of the line up to the next Enter. Keeps a client from growing the
+// terminal's line buffer without bound; the shortened line is still checked
+// against the message length limit by the hub.
+type lineLimiter struct {
+	io.ReadWriter
+	limit int
+	n     int // bytes of the current line so far
+}
+
+// Reads input, dropping the bytes of the current line beyond the limit
+func (ll *lineLimiter) Read(p []byte) (int, error) {
+	n, err := ll.ReadWriter.Read(p)
+	kept := 0
+	for _, c := range p[:n] {
+		if c == '\r' || c == '\n' {
+			ll.n = 0
+		} else if ll.n++; ll.n > ll.limit {
+			continue
+		}
+		p[kept] = c
+		kept++
+	}
+	return kept, err
+}
@@ -0,0 +1,68 @@
+package sshserver
+
+import (
+	"errors"
+	"unicode"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Window sizes beyond these are clamped, so a client cannot make the
+	// server render lines of gigabytes
+	maxTerminalWidth  = 1000
+	maxTerminalHeight = 1000
+
+	// Height assumed when the client reports none
+	defaultTerminalHeight = 24
+
+	// Longest accepted terminal type, real ones look like "xterm-256color"
+	maxTermTypeLength = 64
+)
+
+// Decodes a "pty-req" payload, clamping the window size to what the server
+// renders and rejecting terminal types that are not short printable names
+func parsePtyRequest(payload []byte) (ptyRequest, error) {
+	var pty ptyRequest
+	if err := ssh.Unmarshal(payload, &pty); err != nil {
+		return ptyRequest{}, err
+	}
+	if len(pty.Term) > maxTermTypeLength {
+		return ptyRequest{}, errors.New("terminal type too long")
+	}
+	for _, r := range pty.Term {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return ptyRequest{}, errors.New("invalid terminal type")
+		}
+	}
+	pty.Columns, pty.Rows = clampWindowSize(pty.Columns, pty.Rows)
+	return pty, nil
+}
+
+// Decodes a "window-change" payload, clamping the window size to what the
+// server renders
+func parseWindowChange(payload []byte) (windowChangeRequest, error) {
+	var win windowChangeRequest
+	if err := ssh.Unmarshal(payload, &win); err != nil {
+		return windowChangeRequest{}, err
+	}
+	win.Columns, win.Rows = clampWindowSize(win.Columns, win.Rows)
+	return win, nil
+}
+
+// Replaces a missing window size with the defaults and caps oversized ones
+func clampWindowSize(columns uint32, rows uint32) (uint32, uint32) {
+	switch {
+	case columns == 0:
+		columns = defaultTerminalWidth
+	case columns > maxTerminalWidth:
+		columns = maxTerminalWidth
+	}
+	switch {
+	case rows == 0:
+		rows = defaultTerminalHeight
+	case rows > maxTerminalHeight:
+		rows = maxTerminalHeight
+	}
+	return columns, rows
+}
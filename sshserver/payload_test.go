@@ -0,0 +1,47 @@
+package sshserver
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func FuzzParsePtyRequest(f *testing.F) {
+	f.Add(ssh.Marshal(ptyRequest{Term: "xterm-256color", Columns: 80, Rows: 24}))
+	f.Add(ssh.Marshal(ptyRequest{Term: "dumb", Columns: 0, Rows: 0}))
+	f.Add(ssh.Marshal(ptyRequest{Term: "xterm", Columns: 1 << 31, Rows: 1 << 31}))
+	f.Add([]byte{0, 0, 0, 255})
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		pty, err := parsePtyRequest(payload)
+		if err != nil {
+			return
+		}
+		if pty.Columns < 1 || pty.Columns > maxTerminalWidth || pty.Rows < 1 || pty.Rows > maxTerminalHeight {
+			t.Fatalf("window size %dx%d not clamped", pty.Columns, pty.Rows)
+		}
+		if len(pty.Term) > maxTermTypeLength {
+			t.Fatalf("terminal type of %d bytes accepted", len(pty.Term))
+		}
+	})
+}
+
+func FuzzParseWindowChange(f *testing.F) {
+	f.Add(ssh.Marshal(windowChangeRequest{Columns: 120, Rows: 40}))
+	f.Add(ssh.Marshal(windowChangeRequest{Columns: 0xffffffff, Rows: 0}))
+	f.Add([]byte{0, 0, 0})
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		win, err := parseWindowChange(payload)
+		if err != nil {
+			return
+		}
+		if win.Columns < 1 || win.Columns > maxTerminalWidth || win.Rows < 1 || win.Rows > maxTerminalHeight {
+			t.Fatalf("window size %dx%d not clamped", win.Columns, win.Rows)
+		}
+		// Rendering at the parsed width must not blow up.
+		lines := wrapWithIndent("a fairly long line of text that needs wrapping", 10, int(win.Columns))
+		if len(lines) == 0 {
+			t.Fatal("wrapping lost the text")
+		}
+	})
+}
@@ -0,0 +1,430 @@
+package sshserver
+
+import (
+	"fmt"
+	"group-ssh-chat/chat"
+	"group-ssh-chat/storage"
+	"group-ssh-chat/ui"
+	"image"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// Formats hub output for a session. The bridge picks one per session: ANSI
+// text for terminals, plain text for screen readers and JSON for programs.
+type Renderer interface {
+	// Renders a chat message, whisper, action or notice
+	Message(v *View, msg chat.Message) []byte
+	// Renders messages from the history below a title, if any
+	History(v *View, title string, msgs []storage.StoredMessage) []byte
+	UserList(v *View, room string, users []string) []byte
+	// Renders a horizontal rule with a label, e.g. "――― unread ―――"
+	Divider(v *View, label string) []byte
+	// Renders a preview of a linked image, or nothing when the output
+	// cannot show one
+	Image(v *View, url string, img image.Image) []byte
+	// Returns what clears the screen, or nothing
+	Clear(v *View) []byte
+	// Returns what alerts the user to a mention or whisper, or nothing
+	Bell() []byte
+}
+
+// What a renderer needs to know about the session it renders for
+type View struct {
+	User    string
+	Prefs   chat.Preferences
+	Palette ui.Palette
+	Caps    ui.Capabilities
+	Width   int
+	Zone    *time.Location
+
+	hub *chat.Hub
+}
+
+// Reports whether :shortcodes: are expanded, which takes both the user's
+// preference and a terminal that can show emoji
+func (v *View) emoji() bool {
+	return v.Prefs.Enabled("emoji") && v.Caps.Unicode
+}
+
+// Returns the name a user is shown with: the display name of a linked
+// account, or the username
+func (v *View) displayName(user string) string {
+	if identity, ok := v.hub.Identity(user); ok && identity.DisplayName() != "" {
+		return identity.DisplayName()
+	}
+	return user
+}
+
+// Renders output as lines of text for a terminal. The plain variant leaves
+// out escape codes and the decoration screen readers trip over, and labels
+// every line with who it is from.
+type textRenderer struct {
+	plain bool
+}
+
+var (
+	ansiRenderer  Renderer = textRenderer{}
+	plainRenderer Renderer = textRenderer{plain: true}
+)
+
+func (r textRenderer) Message(v *View, msg chat.Message) []byte {
+	switch msg.Type {
+	case chat.ChatMessage:
+		return r.chat(v, msg)
+	case chat.WhisperMessage:
+		return r.whisper(v, msg)
+	case chat.ActionMessage:
+		return r.notice(v, msg.From+" "+msg.Text)
+	}
+	if msg.Flag(chat.MetaAnnouncement) {
+		return r.notice(v, "Announcement: "+msg.Text)
+	}
+	return r.notice(v, msg.Text)
+}
+
+// Renders a chat message from a user, highlighting mentions of the viewer
+func (r textRenderer) chat(v *View, msg chat.Message) []byte {
+	t := messageTime(msg)
+	var sb strings.Builder
+	if msg.Quote != nil {
+		r.appendQuote(&sb, v, msg.Quote)
+	}
+	label, labelWidth := r.messageLabel(v, msg.ID, msg.From)
+	textStyle := ""
+	if msg.From != v.User && chat.Mentions(msg.Text, v.User) {
+		textStyle = v.Palette.Mention
+	}
+	switch {
+	case hasCode(msg.Text):
+		r.appendCodeMessage(&sb, v, t, label, labelWidth, textStyle, msg.Text)
+	case isBlock(msg.Text):
+		r.appendBlock(&sb, v, t, label, msg.Text)
+	case v.emoji():
+		r.appendWrapped(&sb, v, t, label, labelWidth, textStyle, expandEmoji(msg.Text))
+	default:
+		r.appendWrapped(&sb, v, t, label, labelWidth, textStyle, msg.Text)
+	}
+	return []byte(sb.String())
+}
+
+// Renders a private message between two users. Whispers that waited for
+// the user to log in say when they were sent.
+func (r textRenderer) whisper(v *View, msg chat.Message) []byte {
+	text := msg.Text
+	if v.emoji() {
+		text = expandEmoji(text)
+	}
+	if msg.Flag(chat.MetaQueued) {
+		text = fmt.Sprintf("[sent while you were away, %s] %s", msg.Time.In(v.Zone).Format("Jan 2 15:04"), text)
+	}
+
+	label := fmt.Sprintf("[%s -> %s]", truncateUsername(msg.From), truncateUsername(msg.To))
+	if r.plain {
+		label = fmt.Sprintf("%s whispers to %s:", truncateUsername(msg.From), truncateUsername(msg.To))
+	}
+	var sb strings.Builder
+	r.appendWrapped(&sb, v, time.Now(), v.Palette.Paint(v.Palette.Whisper, label)+" ", runewidth.StringWidth(label)+1, "", text)
+	return []byte(sb.String())
+}
+
+// Renders a notice from the server
+func (r textRenderer) notice(v *View, text string) []byte {
+	var sb strings.Builder
+	t := time.Now()
+	for i, line := range strings.Split(text, "\n") {
+		if i == 0 {
+			line = r.systemPrefix() + line
+		}
+		r.appendWrapped(&sb, v, t, "", 0, v.Palette.System, line)
+		t = time.Time{}
+	}
+	return []byte(sb.String())
+}
+
+// Returns what notices from the server start with: "* ", or a label a
+// screen reader can speak
+func (r textRenderer) systemPrefix() string {
+	if r.plain {
+		return "Server: "
+	}
+	return "* "
+}
+
+// Renders messages from the history with their original timestamps
+func (r textRenderer) History(v *View, title string, msgs []storage.StoredMessage) []byte {
+	var sb strings.Builder
+	if title != "" {
+		r.appendWrapped(&sb, v, time.Now(), "", 0, v.Palette.System, r.systemPrefix()+title)
+	}
+	for _, msg := range msgs {
+		label, labelWidth := r.messageLabel(v, msg.ID, msg.From)
+		if hasCode(msg.Text) {
+			r.appendCodeMessage(&sb, v, msg.Time, label, labelWidth, "", msg.Text)
+			continue
+		}
+		if isBlock(msg.Text) {
+			r.appendBlock(&sb, v, msg.Time, label, msg.Text)
+			continue
+		}
+		text := msg.Text
+		if v.emoji() {
+			text = expandEmoji(text)
+		}
+		if msg.ReplyTo != 0 && r.plain {
+			text = fmt.Sprintf("in reply to message %d: %s", msg.ReplyTo, text)
+		} else if msg.ReplyTo != 0 {
+			text = fmt.Sprintf("↳[%d] %s", msg.ReplyTo, text)
+		}
+		if msg.EditedAt != nil {
+			text += " (edited)"
+		}
+		if tally := chat.ReactionTally(msg.Reactions); tally != "" {
+			if v.emoji() {
+				tally = expandEmoji(tally)
+			}
+			text += "  " + tally
+		}
+		r.appendWrapped(&sb, v, msg.Time, label, labelWidth, "", text)
+	}
+	return []byte(sb.String())
+}
+
+// Renders the members of a room inside a box, or as a sentence in plain
+// text
+func (r textRenderer) UserList(v *View, room string, users []string) []byte {
+	rows := make([]string, len(users))
+	for i, user := range users {
+		rows[i] = truncateUsername(user)
+		if name := v.displayName(user); name != user {
+			rows[i] += " (" + name + ")"
+		}
+	}
+	if r.plain {
+		return r.notice(v, fmt.Sprintf("Users in #%s: %s", room, strings.Join(rows, ", ")))
+	}
+	box := drawBox(fmt.Sprintf("#%s (%d)", room, len(users)), rows, v.Width)
+
+	var sb strings.Builder
+	ts, _ := r.prefix(v, time.Now())
+	sb.WriteString(ts + "\n")
+	for _, line := range strings.Split(box, "\n") {
+		sb.WriteString(v.Palette.Paint(v.Palette.System, line) + "\n")
+	}
+	return []byte(sb.String())
+}
+
+// Renders a horizontal rule with a centered label. Plain text only has the
+// label.
+func (r textRenderer) Divider(v *View, label string) []byte {
+	if r.plain {
+		return []byte(label + "\n")
+	}
+	label = " " + label + " "
+	side := (v.Width - runewidth.StringWidth(label)) / 2
+	if side < 3 {
+		side = 3
+	}
+	rule := strings.Repeat("―", side) + label + strings.Repeat("―", side)
+	return []byte(v.Palette.Paint(v.Palette.Mention, rule) + "\n")
+}
+
+// Renders a preview of a linked image below a caption naming the link.
+// Screen readers cannot make sense of the preview, so plain text skips it.
+func (r textRenderer) Image(v *View, url string, img image.Image) []byte {
+	if r.plain {
+		return nil
+	}
+	var sb strings.Builder
+	r.appendWrapped(&sb, v, time.Now(), "", 0, v.Palette.System, "* Preview of "+url)
+	_, tsWidth := r.prefix(v, time.Time{})
+	margin := strings.Repeat(" ", tsWidth+2)
+	for _, line := range ui.RenderImage(img, v.Caps) {
+		sb.WriteString(margin + line + "\n")
+	}
+	return []byte(sb.String())
+}
+
+// Clears the terminal screen, except in plain text where moving the cursor
+// home would lose the screen reader's place
+func (r textRenderer) Clear(v *View) []byte {
+	if r.plain {
+		return nil
+	}
+	return []byte(clearScreen)
+}
+
+func (r textRenderer) Bell() []byte {
+	return []byte(bell)
+}
+
+// Returns the "[id] name: " label of a chat message and its width in cells.
+// Users who linked an account are labelled "[id] (AL) Display Name: ". In
+// plain text the speaker comes first: "name (message id): ".
+func (r textRenderer) messageLabel(v *View, id int64, from string) (string, int) {
+	ref := fmt.Sprintf("[%d]", id)
+	name := truncateUsername(from)
+	if r.plain {
+		label := fmt.Sprintf("%s (message %d): ", truncateUsername(v.displayName(from)), id)
+		return label, runewidth.StringWidth(label)
+	}
+	label := v.Palette.Paint(v.Palette.Timestamp, ref) + " "
+	width := len(ref) + 1
+	if identity, ok := v.hub.Identity(from); ok && identity.DisplayName() != "" {
+		name = truncateUsername(identity.DisplayName())
+		if initials := identity.Initials(); initials != "" {
+			badge := "(" + initials + ")"
+			label += v.Palette.Paint(v.Palette.Timestamp, badge) + " "
+			width += runewidth.StringWidth(badge) + 1
+		}
+	}
+	label += v.Palette.Paint(v.Palette.Username, name) + ": "
+	return label, width + runewidth.StringWidth(name) + 2
+}
+
+// Appends a one line snippet of the message being replied to
+func (r textRenderer) appendQuote(sb *strings.Builder, v *View, quote *chat.Quote) {
+	_, tsWidth := r.prefix(v, time.Now())
+	format := "┌ [%[1]d] %[2]s: %[3]s"
+	if r.plain {
+		format = "In reply to %[2]s (message %[1]d): %[3]s"
+	}
+	snippet := fmt.Sprintf(format, quote.ID, truncateUsername(quote.From), strings.ReplaceAll(quote.Text, "\n", " "))
+	snippet = runewidth.Truncate(snippet, v.Width-tsWidth, "…")
+	sb.WriteString(strings.Repeat(" ", tsWidth) + v.Palette.Paint(v.Palette.Timestamp, snippet) + "\n")
+}
+
+// A time that formats as wide as any other, with two-digit days and hours
+var widestTime = time.Date(2000, time.December, 22, 22, 22, 22, 0, time.UTC)
+
+// Returns the timestamp prefix for a line and its width in cells, or
+// nothing when timestamps are off
+func (r textRenderer) prefix(v *View, t time.Time) (string, int) {
+	if !v.Prefs.Enabled("timestamps") {
+		return "", 0
+	}
+	layout := v.Prefs.TimeLayout()
+	// A zero time is used for continuation lines, which are only indented
+	// as far as the widest timestamp.
+	if t.IsZero() {
+		width := len(widestTime.Format(layout)) + 1
+		return strings.Repeat(" ", width), width
+	}
+	ts := t.In(v.Zone).Format(layout)
+	return v.Palette.Paint(v.Palette.Timestamp, ts) + " ", len(ts) + 1
+}
+
+// Appends a timestamped line to sb, wrapping text to the terminal width
+func (r textRenderer) appendWrapped(sb *strings.Builder, v *View, t time.Time, label string, labelWidth int, textStyle string, text string) {
+	ts, tsWidth := r.prefix(v, t)
+	sb.WriteString(ts + label)
+	urls := linkTargets(v, text)
+	for i, line := range wrapWithIndent(text, tsWidth+labelWidth, v.Width) {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		// Keep the indentation unstyled so highlights don't bleed into the margin.
+		trimmed := strings.TrimLeft(line, " ")
+		margin := line[:len(line)-len(trimmed)]
+		if urls != nil {
+			trimmed = ui.Linkify(trimmed, urls)
+		}
+		sb.WriteString(margin + v.Palette.Paint(textStyle, trimmed))
+	}
+	sb.WriteString("\n")
+}
+
+// Appends a multi-line message such as a paste: the label on a line of its
+// own followed by every line of text behind a gutter, without emoji or
+// mention styling so the text reads exactly as it was written
+func (r textRenderer) appendBlock(sb *strings.Builder, v *View, t time.Time, label string, text string) {
+	ts, tsWidth := r.prefix(v, t)
+	sb.WriteString(ts + strings.TrimRight(label, " ") + "\n")
+
+	indent := strings.Repeat(" ", tsWidth)
+	gutter := v.Palette.Paint(v.Palette.Timestamp, "│") + " "
+	if r.plain {
+		gutter = "  "
+	}
+	urls := linkTargets(v, text)
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\t", "    "), "\n") {
+		for _, part := range wrapText(line, v.Width-tsWidth-2) {
+			if urls != nil {
+				part = ui.Linkify(part, urls)
+			}
+			sb.WriteString(indent + gutter + part + "\n")
+		}
+	}
+}
+
+// Returns the URLs in text that should be rendered as hyperlinks, or nil
+// when there are none or the terminal cannot show them
+func linkTargets(v *View, text string) []string {
+	if !v.Caps.Hyperlinks {
+		return nil
+	}
+	return ui.FindURLs(text)
+}
+
+// Appends a message containing fenced code. Prose is wrapped as usual and
+// each code block is drawn indented below it, without emoji or mention
+// styling so the code reads exactly as it was written.
+func (r textRenderer) appendCodeMessage(sb *strings.Builder, v *View, t time.Time, label string, labelWidth int, textStyle string, text string) {
+	_, tsWidth := r.prefix(v, t)
+	indent := strings.Repeat(" ", labelWidth)
+	labelled := false
+	for _, seg := range splitFences(text) {
+		if seg.code {
+			if !labelled {
+				ts, _ := r.prefix(v, t)
+				sb.WriteString(ts + strings.TrimRight(label, " ") + "\n")
+				labelled = true
+			}
+			r.appendCode(sb, v, tsWidth+2, seg.text)
+			continue
+		}
+		if v.emoji() {
+			seg.text = expandEmoji(seg.text)
+		}
+		for _, line := range strings.Split(seg.text, "\n") {
+			if !labelled {
+				r.appendWrapped(sb, v, t, label, labelWidth, textStyle, line)
+				labelled = true
+				continue
+			}
+			// Later prose lines line up with the text after the label.
+			r.appendWrapped(sb, v, time.Time{}, indent, labelWidth, textStyle, line)
+		}
+	}
+}
+
+// Appends a code block indented by indent cells. Lines are padded to a
+// common width so the background color forms a rectangle; palettes without
+// a code style mark the block with a gutter instead.
+func (r textRenderer) appendCode(sb *strings.Builder, v *View, indent int, code string) {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(code, "\t", "    "), "\n") {
+		lines = append(lines, wrapText(line, v.Width-indent-2)...)
+	}
+	blockWidth := 0
+	for _, line := range lines {
+		if w := runewidth.StringWidth(line); w > blockWidth {
+			blockWidth = w
+		}
+	}
+
+	margin := strings.Repeat(" ", indent)
+	for _, line := range lines {
+		if r.plain {
+			sb.WriteString(margin + line + "\n")
+			continue
+		}
+		if v.Palette.Code == "" {
+			sb.WriteString(margin + "│ " + line + "\n")
+			continue
+		}
+		sb.WriteString(margin + v.Palette.Paint(v.Palette.Code, " "+runewidth.FillRight(line, blockWidth)+" ") + "\n")
+	}
+}
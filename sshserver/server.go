@@ -1,83 +1,323 @@
 package sshserver
 
 import (
+	"errors"
 	"fmt"
+	"group-ssh-chat/audit"
 	"group-ssh-chat/auth"
+	"group-ssh-chat/chat"
+	"group-ssh-chat/connlimit"
+	"group-ssh-chat/graceful"
+	"group-ssh-chat/proxyproto"
+	"group-ssh-chat/securitypolicy"
+	"io"
 	"log"
 	"net"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/google/uuid"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/term"
 )
 
 // An SSHServer is represented by custom struct
 type SSHServer struct {
-	activeClientsMap   map[string][]clientSSHSession
-	activeClientsMutex sync.Mutex
-	sshServerConfig    *ssh.ServerConfig
-	tcpListener        net.Listener
+	auth            *auth.SSHAuth
+	hub             *chat.Hub
+	sshServerConfig *ssh.ServerConfig
+	listeners       []net.Listener
+	limiter         *connlimit.Limiter
+	policy          *securitypolicy.Policy
+	auditLog        *audit.Logger
+	banner          atomic.Value // string
+	handshakes      *handshakeGate
+	tarpitted       chan struct{} // connections held open by the tarpit
 }
 
-type clientSSHSession struct {
-	terminal   *term.Terminal
-	connection *ssh.ServerConn
-	id         string
-}
+// Blocked connections held open by the tarpit at once. Beyond that they are
+// closed right away, so a blocked address cannot tie up unbounded
+// goroutines and file descriptors.
+const maxTarpitted = 256
 
 // Returns new instance of the ssh server
-func New(sauth *auth.SSHAuth) *SSHServer {
+func New(sauth *auth.SSHAuth, hub *chat.Hub, limiter *connlimit.Limiter, policy *securitypolicy.Policy, auditLog *audit.Logger) *SSHServer {
 	ss := &SSHServer{
-		activeClientsMap: make(map[string][]clientSSHSession),
-		sshServerConfig: &ssh.ServerConfig{
-			// Comment below to disable password auth.
-			// PasswordCallback: sauth.HandlePasswordLogin,
-
-			PublicKeyCallback: sauth.HandlePublicKeyLogin,
+		auth:       sauth,
+		hub:        hub,
+		limiter:    limiter,
+		policy:     policy,
+		auditLog:   auditLog,
+		handshakes: newHandshakeGate(),
+		tarpitted:  make(chan struct{}, maxTarpitted),
+	}
+	ss.Reload()
+	ss.sshServerConfig = &ssh.ServerConfig{
+		Config:        algorithmConfig(),
+		ServerVersion: serverVersion(),
+		BannerCallback: func(ssh.ConnMetadata) string {
+			return ss.bannerText()
 		},
 	}
-
-	ss.sshServerConfig.AddHostKey(sauth.HostSSHPrivateKey)
-	ss.initListener()
+	for _, key := range sauth.HostSSHPrivateKeys {
+		ss.sshServerConfig.AddHostKey(key)
+	}
+	ss.initListeners()
 
 	return ss
 }
 
-// Initializes a tcp listener on host and port
-func (ss *SSHServer) initListener() {
-	svrAddress := fmt.Sprintf("%s:%s", os.Getenv("SSH_SERVER_HOST"), os.Getenv("SSH_SERVER_PORT"))
-	listener, err := net.Listen("tcp", svrAddress)
+// The last credentials a connection had rejected. Clients routinely offer
+// several keys, or only query whether a key would be accepted, so a
+// rejection only counts as a failed login when the handshake fails.
+type loginAttempt struct {
+	user        string
+	fingerprint string
+	err         error
+}
+
+// Returns the server config for a connection, with auth callbacks noting
+// rejected credentials in attempt
+func (ss *SSHServer) serverConfig(attempt *loginAttempt) *ssh.ServerConfig {
+	config := *ss.sshServerConfig
+	if ss.auth.Supports(auth.MethodPublicKey) {
+		config.PublicKeyCallback = func(c ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+			perms, err := ss.auth.HandlePublicKeyLogin(c, pubKey)
+			return checkLogin(attempt, c, ssh.FingerprintSHA256(pubKey), perms, err)
+		}
+	}
+	if ss.auth.Supports(auth.MethodPassword) {
+		config.PasswordCallback = func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			perms, err := ss.auth.HandlePasswordLogin(c, pass)
+			return checkLogin(attempt, c, "", perms, err)
+		}
+	}
+	if ss.auth.Supports(auth.MethodKeyboardInteractive) {
+		config.KeyboardInteractiveCallback = func(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			perms, err := ss.auth.HandleKeyboardInteractiveLogin(c, client)
+			return checkLogin(attempt, c, "", perms, err)
+		}
+	}
+	return &config
+}
+
+// Notes rejected credentials in attempt. When the credentials were accepted
+// but a second factor is required, failures of its challenge are noted as
+// well.
+func checkLogin(attempt *loginAttempt, c ssh.ConnMetadata, fingerprint string, perms *ssh.Permissions, err error) (*ssh.Permissions, error) {
+	var partial *ssh.PartialSuccessError
+	if errors.As(err, &partial) {
+		partial.Next.KeyboardInteractiveCallback = noteKeyboardInteractive(attempt, partial.Next.KeyboardInteractiveCallback, fingerprint)
+		return perms, err
+	}
+	if err != nil {
+		attempt.user, attempt.fingerprint, attempt.err = c.User(), fingerprint, err
+	}
+	return perms, err
+}
+
+// Wraps a keyboard-interactive callback so failed challenges are noted in
+// attempt
+func noteKeyboardInteractive(attempt *loginAttempt, next func(ssh.ConnMetadata, ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error), fingerprint string) func(ssh.ConnMetadata, ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	return func(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		perms, err := next(c, client)
+		if err != nil {
+			attempt.user, attempt.fingerprint, attempt.err = c.User(), fingerprint, err
+		}
+		return perms, err
+	}
+}
+
+// Records a connection that failed to log in in the policy and audit log,
+// once however many credentials it tried
+func (ss *SSHServer) recordAuthFailure(remoteAddr net.Addr, attempt *loginAttempt) {
+	ss.policy.RecordFailure(remoteAddr)
+	ss.auditLog.Log(audit.Event{
+		Type:        audit.EventAuthFailure,
+		User:        attempt.user,
+		RemoteAddr:  remoteAddr.String(),
+		Fingerprint: attempt.fingerprint,
+		Error:       attempt.err.Error(),
+	})
+}
+
+// Uses the sockets passed by systemd socket activation when present.
+// Otherwise opens a listener for every address in the comma separated
+// SSH_LISTEN_ADDRESSES, e.g. "0.0.0.0:2022,[::]:2022,unix:/run/chat.sock",
+// falling back to SSH_SERVER_HOST:SSH_SERVER_PORT.
+func (ss *SSHServer) initListeners() {
+	inherited, err := activationListeners()
 	if err != nil {
-		log.Fatal("failed to listen for connection: ", err)
+		log.Fatal("failed to use socket activation: ", err)
+	}
+	if inherited == nil {
+		// Socket activated listeners handed over by a graceful upgrade
+		inherited = graceful.Take("ssh-activated")
+	}
+	for _, listener := range inherited {
+		log.Println("SSH server is listening on socket activated", listener.Addr())
+		graceful.Track("ssh-activated", listener)
+		ss.listeners = append(ss.listeners, proxyproto.FromEnv(listener))
+	}
+	if len(ss.listeners) > 0 {
+		return
+	}
+
+	addresses := os.Getenv("SSH_LISTEN_ADDRESSES")
+	if addresses == "" {
+		addresses = net.JoinHostPort(os.Getenv("SSH_SERVER_HOST"), os.Getenv("SSH_SERVER_PORT"))
 	}
 
-	ss.tcpListener = listener
+	for _, address := range strings.Split(addresses, ",") {
+		if address = strings.TrimSpace(address); address == "" {
+			continue
+		}
+		network := "tcp"
+		if path, ok := strings.CutPrefix(address, "unix:"); ok {
+			network, address = "unix", path
+		}
+		listener, err := graceful.Listen("ssh", network, address)
+		if err != nil {
+			log.Fatal("failed to listen for connection: ", err)
+		}
+		log.Println("SSH server is listening on", listener.Addr())
+		ss.listeners = append(ss.listeners, proxyproto.FromEnv(listener))
+	}
 }
 
-// Accepts tcp connections and makes the ssh handshake
+// Returns the addresses the server listens on
+func (ss *SSHServer) Addrs() []net.Addr {
+	addrs := make([]net.Addr, len(ss.listeners))
+	for i, listener := range ss.listeners {
+		addrs[i] = listener.Addr()
+	}
+	return addrs
+}
+
+// Closes the listeners, which ends AcceptConnections. Established
+// connections stay open.
+func (ss *SSHServer) Close() error {
+	var errs []error
+	for _, listener := range ss.listeners {
+		if err := listener.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Accepts connections on all listeners until they are closed
 func (ss *SSHServer) AcceptConnections() {
+	var wg sync.WaitGroup
+	for _, listener := range ss.listeners {
+		wg.Add(1)
+		go func(listener net.Listener) {
+			defer wg.Done()
+			ss.acceptLoop(listener)
+		}(listener)
+	}
+	wg.Wait()
+}
+
+// Accepts connections on a single listener until it is closed
+func (ss *SSHServer) acceptLoop(listener net.Listener) {
 	for {
-		nConn, err := ss.tcpListener.Accept()
+		nConn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			log.Printf("failed to accept incoming connection: %q", err)
 			continue
 		}
+		ss.auditLog.Log(audit.Event{Type: audit.EventConnect, RemoteAddr: nConn.RemoteAddr().String()})
 
-		// Before use, a handshake must be performed on the incoming
-		// net.Conn.
-		conn, chans, reqs, err := ssh.NewServerConn(nConn, ss.sshServerConfig)
-		if err != nil {
-			log.Printf("failed to handshake: %q", err)
+		// Refuse the connection before spending any work on the handshake.
+		if ss.policy.IsBlocked(nConn.RemoteAddr()) {
+			log.Printf("rejected connection from blocked %s", nConn.RemoteAddr())
+			select {
+			case ss.tarpitted <- struct{}{}:
+				go ss.tarpit(nConn)
+			default:
+				nConn.Close()
+			}
+			continue
+		}
+		if err := ss.limiter.Acquire(nConn.RemoteAddr()); err != nil {
+			log.Printf("rejected connection from %s: %v", nConn.RemoteAddr(), err)
+			nConn.Close()
+			continue
+		}
+		if !ss.handshakes.admit() {
+			log.Printf("rejected connection from %s: too many handshakes in progress", nConn.RemoteAddr())
+			ss.limiter.Release(nConn.RemoteAddr())
+			nConn.Close()
 			continue
 		}
-		log.Printf("logged in with key %s", conn.Permissions.Extensions["pubkey-fp"])
-		go ss.handleConnection(conn, chans, reqs)
 
+		go ss.handshake(nConn)
 	}
 }
 
+// Holds a blocked connection open for the tarpit delay to slow down brute
+// forcing, then closes it and frees its place in the tarpit
+func (ss *SSHServer) tarpit(nConn net.Conn) {
+	time.Sleep(ss.policy.TarpitDelay())
+	nConn.Close()
+	<-ss.tarpitted
+}
+
+// Performs the ssh handshake on a new tcp connection and serves it. The
+// handshake, including the wait for a free slot, must finish within the
+// handshake timeout.
+func (ss *SSHServer) handshake(nConn net.Conn) {
+	defer ss.limiter.Release(nConn.RemoteAddr())
+
+	nConn.SetDeadline(time.Now().Add(ss.handshakes.timeout))
+	ss.handshakes.start()
+	// Before use, a handshake must be performed on the incoming
+	// net.Conn.
+	attempt := &loginAttempt{}
+	conn, chans, reqs, err := ssh.NewServerConn(nConn, ss.serverConfig(attempt))
+	ss.handshakes.finish()
+	if err != nil {
+		log.Printf("failed to handshake: %q", err)
+		if attempt.err != nil {
+			ss.recordAuthFailure(nConn.RemoteAddr(), attempt)
+			return
+		}
+		ss.auditLog.Log(audit.Event{
+			Type:       audit.EventHandshake,
+			RemoteAddr: nConn.RemoteAddr().String(),
+			Error:      err.Error(),
+		})
+		return
+	}
+	nConn.SetDeadline(time.Time{})
+	if err := ss.auth.CompleteRegistration(connUser(conn), conn.Permissions); err != nil {
+		log.Printf("failed to register key for %s: %q", connUser(conn), err)
+		conn.Close()
+		return
+	}
+	if role, ok := conn.Permissions.Extensions[auth.RoleExtension]; ok {
+		log.Printf("logged in with directory password as %s", role)
+		ss.hub.SetDirectoryRole(connUser(conn), role == auth.RoleAdmin)
+	} else {
+		log.Printf("logged in with key %s", conn.Permissions.Extensions["pubkey-fp"])
+		ss.hub.SetLoginKey(connUser(conn), conn.Permissions.Extensions["pubkey-fp"])
+	}
+	ss.hub.SetGuest(connUser(conn), conn.Permissions.Extensions[auth.GuestExtension] != "")
+	ss.policy.RecordSuccess(conn.RemoteAddr())
+	ss.auditLog.Log(audit.Event{
+		Type:        audit.EventAuthSuccess,
+		User:        connUser(conn),
+		RemoteAddr:  conn.RemoteAddr().String(),
+		Fingerprint: conn.Permissions.Extensions["pubkey-fp"],
+	})
+	ss.handleConnection(conn, chans, reqs)
+}
+
 // Handles a single ssh connection and manages the channels from the connection
 func (ss *SSHServer) handleConnection(conn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request) {
 	go ssh.DiscardRequests(reqs)
@@ -98,103 +338,144 @@ func (ss *SSHServer) handleConnection(conn *ssh.ServerConn, chans <-chan ssh.New
 			continue
 		}
 
-		termSession := term.NewTerminal(sessionChannel, "> ")
-
-		ss.activeClientsMutex.Lock()
-		clientsess := clientSSHSession{
-			terminal:   termSession,
-			connection: conn,
-			id:         uuid.New().String(),
-		}
-		_, ok := ss.activeClientsMap[conn.User()]
-		if !ok {
-			ss.activeClientsMap[conn.User()] = make([]clientSSHSession, 0)
+		if conn.Permissions.Extensions[auth.RegistrationExtension] == auth.RegistrationPending {
+			go ssh.DiscardRequests(sshRequests)
+			fmt.Fprintf(sessionChannel, "Your key has been registered as %q and is awaiting admin approval.\r\n", conn.User())
+			sessionChannel.Close()
+			continue
 		}
-		ss.activeClientsMap[conn.User()] = append(
-			ss.activeClientsMap[conn.User()],
-			clientsess,
-		)
-		ss.activeClientsMutex.Unlock()
-
-		go ss.handleSessionInput(conn.User(), &clientsess)
 
 		// Sessions have out-of-band requests such as "shell",
-		// "pty-req" and "env".
-		go ss.handleSSHRequests(sshRequests)
+		// "pty-req" and "env". The chat starts with the "shell" request.
+		bridge := NewSSHTerminalBridge(ss.hub, sessionChannel, conn)
+		bridge.SetPinger(func() error {
+			_, err := sessionChannel.SendRequest(pingRequest, true, nil)
+			return err
+		})
+		go ss.handleSSHRequests(conn, sessionChannel, bridge, sshRequests)
 	}
 }
 
-// Handles text input from the client session channel
-func (ss *SSHServer) handleSessionInput(user string, clientsess *clientSSHSession) {
-	defer clientsess.connection.Close()
-	for {
-		line, err := clientsess.terminal.ReadLine()
-		if err != nil {
-			if err.Error() != "EOF" {
-				log.Println("Read error:", err)
-			}
-			ss.removeClientSession(clientsess.id, true)
-			break
-		}
-		ss.activeClientsMutex.Lock()
-		for _, sessions := range ss.activeClientsMap {
-
-			for _, cs := range sessions {
-				_, err := cs.terminal.Write([]byte(fmt.Sprintf("%s said: %q\n", user, line)))
-				if err != nil {
-					if err.Error() != "EOF" {
-						log.Println("Write error:", err)
-					}
-					ss.removeClientSession(cs.id, false)
-				}
-			}
+// Attaches a client from another transport (e.g. a browser over WebSocket)
+// to the chat hub and serves its input until the client disconnects
+func (ss *SSHServer) ServeTerminal(user string, remoteAddr string, rwc io.ReadWriteCloser) {
+	NewSSHTerminalBridge(ss.hub, rwc, rwc).Serve(user, remoteAddr)
+}
 
-		}
-		ss.activeClientsMutex.Unlock()
-	}
+// Payload of a "pty-req" channel request (RFC 4254 section 6.2)
+type ptyRequest struct {
+	Term     string
+	Columns  uint32
+	Rows     uint32
+	WidthPx  uint32
+	HeightPx uint32
+	Modes    string
 }
 
-// removes the client session based on the
-func (ss *SSHServer) removeClientSession(sessionId string, lock bool) {
-	if lock {
-		ss.activeClientsMutex.Lock()
-	}
-	for user, sessions := range ss.activeClientsMap {
-		var updatedSessions []clientSSHSession
-		for _, session := range sessions {
-			if session.id != sessionId {
-				updatedSessions = append(updatedSessions, session)
-			}
-		}
+// Payload of an "env" channel request (RFC 4254 section 6.4)
+type envRequest struct {
+	Name  string
+	Value string
+}
 
-		// Update the map with the filtered sessions
-		ss.activeClientsMap[user] = updatedSessions
-		log.Println("Removed Session:", sessionId)
-		if len(ss.activeClientsMap[user]) == 0 {
-			delete(ss.activeClientsMap, user)
-			log.Println("Removed all channels for:", user)
-		}
-	}
+// Environment variable a client sets to resume an earlier session, see
+// chat.Hub.JoinResumable
+const resumeEnv = "CHAT_RESUME"
 
-	if lock {
-		ss.activeClientsMutex.Unlock()
-	}
+// Environment variables a client may send for its session: its locale, its
+// time zone for timestamps and a room to start in
+var sessionEnv = map[string]bool{
+	"LANG":      true,
+	"LC_ALL":    true,
+	"TZ":        true,
+	"CHAT_ROOM": true,
+}
+
+// Longest value accepted for a session environment variable
+const maxEnvValueLength = 256
 
+// Payload of a "window-change" channel request (RFC 4254 section 6.7)
+type windowChangeRequest struct {
+	Columns  uint32
+	Rows     uint32
+	WidthPx  uint32
+	HeightPx uint32
 }
 
-// Handles ssh requests and replies to them to service the ssh connection
-func (ss *SSHServer) handleSSHRequests(sshRequests <-chan *ssh.Request) {
+// Handles ssh requests and replies to them to service the ssh connection.
+// A session runs either the interactive chat ("shell") or a one-shot
+// command ("exec"), whichever is requested first.
+func (ss *SSHServer) handleSSHRequests(conn *ssh.ServerConn, channel ssh.Channel, bridge *SSHTerminalBridge, sshRequests <-chan *ssh.Request) {
+	started := false
 	for req := range sshRequests {
-		if req.Type == "pty-req" {
-			termLen := req.Payload[3]
-			term := string(req.Payload[4 : termLen+4])
-			log.Printf("PTY requested: %s", term)
-			if req.WantReply {
-				req.Reply(true, nil)
+		switch req.Type {
+		case "pty-req":
+			pty, err := parsePtyRequest(req.Payload)
+			if err != nil {
+				log.Printf("Malformed pty-req: %v", err)
+				req.Reply(false, nil)
+				continue
 			}
-		}
-		if req.Type == "shell" {
+			log.Printf("PTY requested: %s", pty.Term)
+			bridge.SetTerminalType(pty.Term)
+			bridge.SetWindowSize(int(pty.Columns), int(pty.Rows))
 			req.Reply(true, nil)
+		case "window-change":
+			win, err := parseWindowChange(req.Payload)
+			if err != nil {
+				log.Printf("Malformed window-change: %v", err)
+				continue
+			}
+			bridge.SetWindowSize(int(win.Columns), int(win.Rows))
+		case "env":
+			var env envRequest
+			if err := ssh.Unmarshal(req.Payload, &env); err != nil || started || len(env.Value) > maxEnvValueLength {
+				req.Reply(false, nil)
+				continue
+			}
+			switch {
+			case env.Name == resumeEnv:
+				bridge.SetResumeToken(env.Value)
+			case sessionEnv[env.Name]:
+				bridge.SetEnv(env.Name, env.Value)
+			default:
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+		case "shell":
+			if started {
+				req.Reply(false, nil)
+				continue
+			}
+			started = true
+			req.Reply(true, nil)
+			go bridge.Serve(connUser(conn), conn.RemoteAddr().String())
+		case "exec":
+			var exec execRequest
+			if started || ssh.Unmarshal(req.Payload, &exec) != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			started = true
+			req.Reply(true, nil)
+			if strings.TrimSpace(exec.Command) == jsonFlag {
+				bridge.SetOutput(OutputJSON)
+				go bridge.Serve(connUser(conn), conn.RemoteAddr().String())
+				continue
+			}
+			go ss.runExec(conn, channel, exec.Command)
+		default:
+			req.Reply(false, nil)
 		}
 	}
 }
+
+// Returns the chat username of an authenticated connection, which is the key's
+// canonical username when auth overrode the login name
+func connUser(conn *ssh.ServerConn) string {
+	if user, ok := conn.Permissions.Extensions[auth.UserExtension]; ok {
+		return user
+	}
+	return conn.User()
+}
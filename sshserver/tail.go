@@ -0,0 +1,97 @@
+package sshserver
+
+import (
+	"group-ssh-chat/chat"
+	"group-ssh-chat/storage"
+	"image"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Renders the chat messages of a tailed room as plain lines, one message per
+// line, for piping into other programs. Everything else the hub sends is
+// dropped.
+type tailClient struct {
+	w         io.Writer
+	outbox    chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Returns a tail client writing to w
+func newTailClient(w io.Writer) *tailClient {
+	return &tailClient{
+		w:      w,
+		outbox: make(chan []byte, outboxSize),
+		done:   make(chan struct{}),
+	}
+}
+
+// Writes queued lines until the client is closed or a write fails
+func (tc *tailClient) writeLoop() {
+	defer tc.Close()
+	for {
+		select {
+		case p := <-tc.outbox:
+			if _, err := tc.w.Write(p); err != nil {
+				return
+			}
+		case <-tc.done:
+			return
+		}
+	}
+}
+
+// Queues a line, dropping it when the reader is not keeping up
+func (tc *tailClient) enqueue(p []byte) bool {
+	select {
+	case tc.outbox <- p:
+		return true
+	case <-tc.done:
+		return false
+	default:
+		log.Println("Dropped output for slow tail")
+		return false
+	}
+}
+
+// Formats a message as "<RFC 3339 time> <user>: <text>". Line breaks in the
+// text are flattened so every message stays on one line.
+func tailLine(t time.Time, from string, text string) string {
+	text = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(text)
+	return t.Format(time.RFC3339) + " " + from + ": " + text + "\n"
+}
+
+// Writes a chat message as a line. Tails only carry chat messages.
+func (tc *tailClient) WriteMessage(msg chat.Message, cache *chat.RenderCache) bool {
+	if msg.Type != chat.ChatMessage {
+		return true
+	}
+	return tc.enqueue([]byte(tailLine(messageTime(msg), msg.From, msg.Text)))
+}
+
+// Writes replayed messages as lines, e.g. after output was dropped
+func (tc *tailClient) WriteHistory(title string, msgs []storage.StoredMessage) bool {
+	var sb strings.Builder
+	for _, msg := range msgs {
+		sb.WriteString(tailLine(msg.Time, msg.From, msg.Text))
+	}
+	return tc.enqueue([]byte(sb.String()))
+}
+
+// Tails only carry chat messages
+func (tc *tailClient) WriteSystem(text string)                   {}
+func (tc *tailClient) WriteUserList(room string, users []string) {}
+func (tc *tailClient) WriteDivider(label string)                 {}
+func (tc *tailClient) WriteImage(url string, img image.Image)    {}
+func (tc *tailClient) SetPreferences(prefs chat.Preferences)     {}
+func (tc *tailClient) Clear()                                    {}
+
+// Stops the client. The caller closes the underlying stream.
+func (tc *tailClient) Close() error {
+	tc.closeOnce.Do(func() { close(tc.done) })
+	return nil
+}
@@ -0,0 +1,73 @@
+package stats
+
+import (
+	"fmt"
+	"group-ssh-chat/graceful"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Serves the collected statistics in the Prometheus text exposition format
+type Exporter struct {
+	listenAddress string
+	collector     *Collector
+}
+
+// Returns an exporter listening on METRICS_LISTEN_ADDRESS, or nil when the
+// variable is not set
+func NewExporter(collector *Collector) *Exporter {
+	listenAddress := os.Getenv("METRICS_LISTEN_ADDRESS")
+	if listenAddress == "" {
+		return nil
+	}
+
+	return &Exporter{listenAddress: listenAddress, collector: collector}
+}
+
+// Serves /metrics until the listener fails
+func (e *Exporter) ListenAndServe() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", e.handleMetrics)
+
+	listener, err := graceful.Listen("metrics", "tcp", e.listenAddress)
+	if err != nil {
+		return err
+	}
+	log.Println("Metrics exporter is serving on", e.listenAddress)
+	return http.Serve(listener, mux)
+}
+
+// Writes the current snapshot as Prometheus metrics
+func (e *Exporter) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	s := e.collector.Snapshot()
+
+	var sb strings.Builder
+	gauge := func(name string, help string, value any) {
+		fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+	}
+	gauge("chat_uptime_seconds", "Seconds since the server started.", int64(s.Uptime.Seconds()))
+	gauge("chat_online_users", "Users with at least one open session.", s.OnlineUsers)
+	gauge("chat_sessions", "Open sessions.", s.Sessions)
+	gauge("chat_peak_online_users", "Most users online at once since the server started.", s.PeakUsers)
+	gauge("chat_messages_today", "Chat messages sent since local midnight.", s.MessagesToday)
+
+	counter := func(name string, help string, value any) {
+		fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s counter\n%s %v\n", name, help, name, name, value)
+	}
+	counter("chat_messages_total", "Chat messages sent since the server started.", s.TotalMessages)
+	if lookups := s.HistoryCacheHits + s.HistoryCacheMisses; lookups > 0 {
+		counter("chat_history_cache_hits_total", "Reads of recent history served from the cache.", s.HistoryCacheHits)
+		counter("chat_history_cache_misses_total", "Reads of recent history that went to storage.", s.HistoryCacheMisses)
+		gauge("chat_history_cache_hit_ratio", "Share of recent history reads served from the cache.", float64(s.HistoryCacheHits)/float64(lookups))
+	}
+
+	sb.WriteString("# HELP chat_room_messages_today Chat messages sent since local midnight per room.\n# TYPE chat_room_messages_today gauge\n")
+	for _, c := range s.Rooms {
+		fmt.Fprintf(&sb, "chat_room_messages_today{room=%q} %d\n", c.Name, c.Count)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(sb.String()))
+}
@@ -0,0 +1,166 @@
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Collects server usage counters for /stats and the metrics exporter. Daily
+// counters are reset at local midnight.
+type Collector struct {
+	mu            sync.Mutex
+	startedAt     time.Time
+	online        map[string]int
+	peakUsers     int
+	day           time.Time
+	messagesToday int
+	totalMessages int
+	roomMessages  map[string]int
+	userMessages  map[string]int
+	historyCache  CacheCounter
+}
+
+// Implemented by caches that count how many reads they served
+type CacheCounter interface {
+	CacheCounts() (hits int64, misses int64)
+}
+
+// A count for a single room or user
+type Count struct {
+	Name  string
+	Count int
+}
+
+// A point in time copy of the collected statistics. Room and user counts
+// are for the current day and sorted by count, highest first.
+type Snapshot struct {
+	StartedAt     time.Time
+	Uptime        time.Duration
+	OnlineUsers   int
+	Sessions      int
+	PeakUsers     int
+	MessagesToday int
+	TotalMessages int
+	Rooms         []Count
+	Users         []Count
+
+	// Reads of recent history served from the cache and from storage, zero
+	// when there is no cache
+	HistoryCacheHits   int64
+	HistoryCacheMisses int64
+}
+
+// Returns a new collector with the uptime starting now
+func New() *Collector {
+	now := time.Now()
+	return &Collector{
+		startedAt:    now,
+		online:       map[string]int{},
+		day:          startOfDay(now),
+		roomMessages: map[string]int{},
+		userMessages: map[string]int{},
+	}
+}
+
+// Records a new session of the user
+func (c *Collector) SessionOpened(user string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.online[user]++
+	if len(c.online) > c.peakUsers {
+		c.peakUsers = len(c.online)
+	}
+}
+
+// Records a session of the user closing
+func (c *Collector) SessionClosed(user string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.online[user]--; c.online[user] <= 0 {
+		delete(c.online, user)
+	}
+}
+
+// Records a chat message sent by the user to the room
+func (c *Collector) MessageSent(room string, user string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rolloverLocked(time.Now())
+	c.messagesToday++
+	c.totalMessages++
+	c.roomMessages[room]++
+	c.userMessages[user]++
+}
+
+// Includes the counts of the history cache in the statistics
+func (c *Collector) WatchHistoryCache(cache CacheCounter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.historyCache = cache
+}
+
+// Returns a copy of the current statistics
+func (c *Collector) Snapshot() Snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.rolloverLocked(now)
+	sessions := 0
+	for _, n := range c.online {
+		sessions += n
+	}
+	var hits, misses int64
+	if c.historyCache != nil {
+		hits, misses = c.historyCache.CacheCounts()
+	}
+	return Snapshot{
+		StartedAt:     c.startedAt,
+		Uptime:        now.Sub(c.startedAt),
+		OnlineUsers:   len(c.online),
+		Sessions:      sessions,
+		PeakUsers:     c.peakUsers,
+		MessagesToday: c.messagesToday,
+		TotalMessages: c.totalMessages,
+		Rooms:         sortedCounts(c.roomMessages),
+		Users:         sortedCounts(c.userMessages),
+
+		HistoryCacheHits:   hits,
+		HistoryCacheMisses: misses,
+	}
+}
+
+// Resets the daily counters when a new day has started
+func (c *Collector) rolloverLocked(now time.Time) {
+	if day := startOfDay(now); day.After(c.day) {
+		c.day = day
+		c.messagesToday = 0
+		c.roomMessages = map[string]int{}
+		c.userMessages = map[string]int{}
+	}
+}
+
+// Returns midnight of the day t falls on, in local time
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// Returns the counts sorted by count, then name
+func sortedCounts(counts map[string]int) []Count {
+	sorted := make([]Count, 0, len(counts))
+	for name, n := range counts {
+		sorted = append(sorted, Count{Name: name, Count: n})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
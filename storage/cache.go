@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"container/list"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Used for serving reads of recent room history from memory so bursts of
+// reconnecting users replaying what they missed do not all hit the
+// backend. Keeps the newest messages of the most recently read rooms and
+// passes everything else through. Writes made through the cache keep it up
+// to date. Writes by other server instances sharing the backend show up
+// once a room's entry is older than the TTL.
+type HistoryCache struct {
+	History
+
+	size    int
+	maxRoom int
+	ttl     time.Duration
+
+	mu     sync.Mutex
+	rooms  map[string]*list.Element
+	order  *list.List
+	hits   int64
+	misses int64
+}
+
+// The cached newest messages of a room
+type cachedRoom struct {
+	room     string
+	messages []StoredMessage
+	latestID int64
+	loadedAt time.Time
+
+	// Set when messages holds every message of the room
+	complete bool
+}
+
+// Returns a cache in front of history keeping HISTORY_CACHE_SIZE messages
+// per room, or nil when the variable is not set. HISTORY_CACHE_ROOMS caps
+// the number of rooms kept (default 100) and HISTORY_CACHE_TTL how long a
+// room is served before being read again (default 10s).
+func NewHistoryCache(history History) *HistoryCache {
+	size, err := strconv.Atoi(os.Getenv("HISTORY_CACHE_SIZE"))
+	if err != nil || size <= 0 {
+		return nil
+	}
+
+	c := &HistoryCache{
+		History: history,
+		size:    size,
+		maxRoom: 100,
+		ttl:     10 * time.Second,
+		rooms:   map[string]*list.Element{},
+		order:   list.New(),
+	}
+	if n, err := strconv.Atoi(os.Getenv("HISTORY_CACHE_ROOMS")); err == nil && n > 0 {
+		c.maxRoom = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("HISTORY_CACHE_TTL")); err == nil && d > 0 {
+		c.ttl = d
+	}
+	return c
+}
+
+// Returns the number of reads served from the cache and the number that had
+// to go to the backend
+func (c *HistoryCache) CacheCounts() (hits int64, misses int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// Returns the room's cached entry if it holds what covers asks for, loading
+// it when it is missing or stale. Counts the read as a hit or a miss. The
+// backend is read with the lock held so a burst of reads of the same room
+// loads it once.
+func (c *HistoryCache) lookupLocked(room string, covers func(r *cachedRoom) bool) (*cachedRoom, bool) {
+	if el, ok := c.rooms[room]; ok {
+		r := el.Value.(*cachedRoom)
+		if time.Since(r.loadedAt) < c.ttl {
+			c.order.MoveToFront(el)
+			if covers(r) {
+				c.hits++
+				return r, true
+			}
+			c.misses++
+			return nil, false
+		}
+		c.order.Remove(el)
+		delete(c.rooms, room)
+	}
+
+	c.misses++
+	r := &cachedRoom{
+		room:     room,
+		messages: c.History.Recent(room, c.size),
+		latestID: c.History.LatestID(room),
+		loadedAt: time.Now(),
+	}
+	r.complete = len(r.messages) < c.size
+	c.rooms[room] = c.order.PushFront(r)
+	if c.order.Len() > c.maxRoom {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.rooms, oldest.Value.(*cachedRoom).room)
+	}
+	if !covers(r) {
+		return nil, false
+	}
+	return r, true
+}
+
+// Reports whether the entry holds every message with an ID above afterID.
+// Older messages than the first cached one all have smaller IDs.
+func (r *cachedRoom) coversID(afterID int64) bool {
+	return r.complete || len(r.messages) > 0 && afterID >= r.messages[0].ID-1
+}
+
+// Reports whether the entry holds every message with a sequence number
+// above afterSeq
+func (r *cachedRoom) coversSeq(afterSeq int64) bool {
+	return r.complete || len(r.messages) > 0 && afterSeq >= r.messages[0].Seq-1
+}
+
+// Returns the messages of the entry matching keep, at most limit of the
+// newest when limit is positive
+func (r *cachedRoom) filter(keep func(msg StoredMessage) bool, limit int) []StoredMessage {
+	var msgs []StoredMessage
+	for _, msg := range r.messages {
+		if keep(msg) {
+			msgs = append(msgs, msg)
+		}
+	}
+	if limit > 0 && len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+	}
+	return msgs
+}
+
+// Returns up to n of the most recent messages in a room, oldest first
+func (c *HistoryCache) Recent(room string, n int) []StoredMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.lookupLocked(room, func(r *cachedRoom) bool { return r.complete || n <= len(r.messages) })
+	if !ok {
+		return c.History.Recent(room, n)
+	}
+	if n <= 0 {
+		return nil
+	}
+	return r.filter(func(StoredMessage) bool { return true }, n)
+}
+
+// Returns up to limit messages in the room with a sequence number greater
+// than afterSeq, oldest first. A limit of zero returns all of them.
+func (c *HistoryCache) SinceSeq(room string, afterSeq int64, limit int) []StoredMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.lookupLocked(room, func(r *cachedRoom) bool { return r.coversSeq(afterSeq) })
+	if !ok {
+		return c.History.SinceSeq(room, afterSeq, limit)
+	}
+	return r.filter(func(msg StoredMessage) bool { return msg.Seq > afterSeq }, limit)
+}
+
+// Returns up to limit messages in the room with an ID greater than afterID,
+// oldest first. A limit of zero returns all of them.
+func (c *HistoryCache) Since(room string, afterID int64, limit int) []StoredMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.lookupLocked(room, func(r *cachedRoom) bool { return r.coversID(afterID) })
+	if !ok {
+		return c.History.Since(room, afterID, limit)
+	}
+	return r.filter(func(msg StoredMessage) bool { return msg.ID > afterID }, limit)
+}
+
+// Returns the number of messages in the room with an ID greater than afterID
+func (c *HistoryCache) CountSince(room string, afterID int64) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.lookupLocked(room, func(r *cachedRoom) bool { return r.coversID(afterID) })
+	if !ok {
+		return c.History.CountSince(room, afterID)
+	}
+	return len(r.filter(func(msg StoredMessage) bool { return msg.ID > afterID }, 0))
+}
+
+// Returns the ID of the newest message in the room, or zero when it is empty
+func (c *HistoryCache) LatestID(room string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, _ := c.lookupLocked(room, func(*cachedRoom) bool { return true })
+	return r.latestID
+}
+
+// Stores a new message and adds it to the room's entry
+func (c *HistoryCache) Append(msg StoredMessage) (StoredMessage, error) {
+	msg, err := c.History.Append(msg)
+	if err != nil {
+		return msg, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.rooms[msg.Room]; ok {
+		r := el.Value.(*cachedRoom)
+		// The entry may have been loaded after the message was stored
+		if n := len(r.messages); n == 0 || r.messages[n-1].ID < msg.ID {
+			r.messages = append(r.messages, msg)
+			if len(r.messages) > c.size {
+				r.messages = r.messages[len(r.messages)-c.size:]
+				r.complete = false
+			}
+		}
+		if msg.ID > r.latestID {
+			r.latestID = msg.ID
+		}
+	}
+	return msg, nil
+}
+
+// Replaces the text of a message and updates its cached copy
+func (c *HistoryCache) Edit(id int64, text string) (StoredMessage, error) {
+	msg, err := c.History.Edit(id, text)
+	if err == nil {
+		c.replace(msg)
+	}
+	return msg, err
+}
+
+// Toggles the user's reaction to a message and updates its cached copy
+func (c *HistoryCache) React(id int64, user string, emoji string) (StoredMessage, bool, error) {
+	msg, added, err := c.History.React(id, user, emoji)
+	if err == nil {
+		c.replace(msg)
+	}
+	return msg, added, err
+}
+
+// Replaces the cached copy of a message, if there is one
+func (c *HistoryCache) replace(msg StoredMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.rooms[msg.Room]; ok {
+		r := el.Value.(*cachedRoom)
+		for i := range r.messages {
+			if r.messages[i].ID == msg.ID {
+				r.messages[i] = msg
+			}
+		}
+	}
+}
+
+// Marks a message as deleted and drops its cached copy
+func (c *HistoryCache) Delete(id int64) error {
+	if err := c.History.Delete(id); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.rooms {
+		r := el.Value.(*cachedRoom)
+		for i := range r.messages {
+			if r.messages[i].ID == id {
+				r.messages = append(r.messages[:i:i], r.messages[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// Deletes the user's messages and reactions and empties the cache
+func (c *HistoryCache) Scrub(user string) (int, error) {
+	defer c.invalidate()
+	return c.History.Scrub(user)
+}
+
+// Removes old messages and empties the cache
+func (c *HistoryCache) Prune(maxAge time.Duration, maxPerRoom int) (int, error) {
+	defer c.invalidate()
+	return c.History.Prune(maxAge, maxPerRoom)
+}
+
+// Removes messages of a room and empties the cache
+func (c *HistoryCache) Purge(room string, before time.Time) (int, error) {
+	defer c.invalidate()
+	return c.History.Purge(room, before)
+}
+
+// Drops all cached rooms
+func (c *HistoryCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rooms = map[string]*list.Element{}
+	c.order.Init()
+}
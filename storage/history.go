@@ -0,0 +1,675 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Returned when a message ID is not present in the history
+var ErrMessageNotFound = errors.New("message not found")
+
+// A chat message as kept in the history
+type StoredMessage struct {
+	ID       int64      `json:"id"`
+	Seq      int64      `json:"seq,omitempty"`
+	Room     string     `json:"room"`
+	From     string     `json:"from"`
+	Text     string     `json:"text"`
+	Time     time.Time  `json:"time"`
+	ReplyTo  int64      `json:"reply_to,omitempty"`
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+	Deleted  bool       `json:"deleted,omitempty"`
+
+	// Reactions in the order they were first used
+	Reactions []Reaction `json:"reactions,omitempty"`
+}
+
+// The users who reacted to a message with the same emoji
+type Reaction struct {
+	Emoji string   `json:"emoji"`
+	Users []string `json:"users"`
+}
+
+// Used for storing room message history. Every change is appended to a JSON
+// lines file as the full message record, so replaying the file in order
+// (last record per ID wins) restores the current state. The file may be
+// shared with another process, e.g. during a graceful upgrade, so records
+// appended by others are picked up before every write.
+type HistoryStore struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	offset   int64
+	messages map[int64]*StoredMessage
+	rooms    map[string][]int64
+	roomSeq  map[string]int64
+	lastID   int64
+}
+
+// Returns a history store backed by HISTORY_PATH. When the variable is not
+// set history is only kept in memory.
+func NewHistoryStore() *HistoryStore {
+	hs := &HistoryStore{
+		messages: map[int64]*StoredMessage{},
+		rooms:    map[string][]int64{},
+		roomSeq:  map[string]int64{},
+	}
+
+	path := os.Getenv("HISTORY_PATH")
+	if path == "" {
+		return hs
+	}
+	if err := hs.load(path); err != nil {
+		log.Fatal("Failed to load history: ", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatal("Failed to open history: ", err)
+	}
+	hs.path = path
+	hs.file = f
+
+	return hs
+}
+
+// Stores a new message and returns it with its assigned ID and time
+func (hs *HistoryStore) Append(msg StoredMessage) (StoredMessage, error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	err := hs.withFileLock(func() error {
+		hs.lastID++
+		msg.ID = hs.lastID
+		msg.Time = time.Now()
+		hs.apply(&msg)
+		return hs.persist(&msg)
+	})
+	return msg, err
+}
+
+// Returns the message with the given ID
+func (hs *HistoryStore) Get(id int64) (StoredMessage, error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	msg, ok := hs.messages[id]
+	if !ok || msg.Deleted {
+		return StoredMessage{}, ErrMessageNotFound
+	}
+	return *msg, nil
+}
+
+// Replaces the text of a message and marks it as edited
+func (hs *HistoryStore) Edit(id int64, text string) (StoredMessage, error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	var edited StoredMessage
+	err := hs.withFileLock(func() error {
+		msg, ok := hs.messages[id]
+		if !ok || msg.Deleted {
+			return ErrMessageNotFound
+		}
+		now := time.Now()
+		msg.Text = text
+		msg.EditedAt = &now
+		edited = *msg
+		return hs.persist(msg)
+	})
+	return edited, err
+}
+
+// Marks a message as deleted and scrubs its text
+func (hs *HistoryStore) Delete(id int64) error {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	return hs.withFileLock(func() error {
+		msg, ok := hs.messages[id]
+		if !ok || msg.Deleted {
+			return ErrMessageNotFound
+		}
+		msg.Deleted = true
+		msg.Text = ""
+		return hs.persist(msg)
+	})
+}
+
+// Adds the user's reaction to a message, or removes it when the user already
+// reacted with the same emoji. Reports whether the reaction was added.
+func (hs *HistoryStore) React(id int64, user string, emoji string) (StoredMessage, bool, error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	var reacted StoredMessage
+	added := false
+	err := hs.withFileLock(func() error {
+		msg, ok := hs.messages[id]
+		if !ok || msg.Deleted {
+			return ErrMessageNotFound
+		}
+		msg.Reactions, added = toggleReaction(msg.Reactions, user, emoji)
+		reacted = *msg
+		return hs.persist(msg)
+	})
+	return reacted, added, err
+}
+
+// Returns a copy of reactions with the user's reaction with emoji added, or
+// removed when it was there. Reports whether it was added. Copying keeps the
+// reactions of messages handed out earlier unchanged.
+func toggleReaction(reactions []Reaction, user string, emoji string) ([]Reaction, bool) {
+	toggled := make([]Reaction, 0, len(reactions)+1)
+	found, added := false, false
+	for _, r := range reactions {
+		if r.Emoji == emoji {
+			found = true
+			r.Users, added = toggleUser(r.Users, user)
+			if len(r.Users) == 0 {
+				continue
+			}
+		}
+		toggled = append(toggled, r)
+	}
+	if !found {
+		toggled = append(toggled, Reaction{Emoji: emoji, Users: []string{user}})
+		added = true
+	}
+	return toggled, added
+}
+
+// Returns a copy of users with user removed, or added when it was missing.
+// Reports whether the user was added.
+func toggleUser(users []string, user string) ([]string, bool) {
+	toggled, removed := withoutUser(users, user)
+	if removed {
+		return toggled, false
+	}
+	return append(toggled, user), true
+}
+
+// Deletes and scrubs every message sent by the user and takes back their
+// reactions, then compacts the file so no earlier copy of the text remains.
+// Returns the number of messages deleted.
+func (hs *HistoryStore) Scrub(user string) (int, error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	scrubbed := 0
+	err := hs.withFileLock(func() error {
+		for _, msg := range hs.messages {
+			if msg.From == user && !msg.Deleted {
+				*msg = StoredMessage{ID: msg.ID, Seq: msg.Seq, Room: msg.Room, Time: msg.Time, Deleted: true}
+				scrubbed++
+			}
+			msg.Reactions = withoutReactionsOf(msg.Reactions, user)
+		}
+		return hs.compact()
+	})
+	return scrubbed, err
+}
+
+// Returns a copy of reactions without the ones by the user
+func withoutReactionsOf(reactions []Reaction, user string) []Reaction {
+	var kept []Reaction
+	for _, r := range reactions {
+		r.Users, _ = withoutUser(r.Users, user)
+		if len(r.Users) > 0 {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// Returns a copy of users without user and whether it was present
+func withoutUser(users []string, user string) ([]string, bool) {
+	kept := make([]string, 0, len(users))
+	for _, u := range users {
+		if u != user {
+			kept = append(kept, u)
+		}
+	}
+	return kept, len(kept) != len(users)
+}
+
+// Calls fn for every message that is not deleted, room by room in the order
+// the messages were sent. fn must not call the store.
+func (hs *HistoryStore) Scan(fn func(msg StoredMessage)) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	for _, ids := range hs.rooms {
+		for _, id := range ids {
+			if msg := hs.messages[id]; !msg.Deleted {
+				fn(*msg)
+			}
+		}
+	}
+}
+
+// Removes the messages older than maxAge and all but the newest maxPerRoom
+// messages of every room. Zero disables either limit. Returns the number of
+// messages removed.
+func (hs *HistoryStore) Prune(maxAge time.Duration, maxPerRoom int) (int, error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	removed := 0
+	err := hs.withFileLock(func() error {
+		cutoff := time.Time{}
+		if maxAge > 0 {
+			cutoff = time.Now().Add(-maxAge)
+		}
+		for room := range hs.rooms {
+			removed += hs.removeLocked(room, func(msg *StoredMessage, fromEnd int) bool {
+				return msg.Time.Before(cutoff) || (maxPerRoom > 0 && fromEnd >= maxPerRoom)
+			})
+		}
+		if removed == 0 {
+			return nil
+		}
+		return hs.compact()
+	})
+	return removed, err
+}
+
+// Removes the messages of a room sent before the given time, or all of them
+// when it is zero. Returns the number of messages removed.
+func (hs *HistoryStore) Purge(room string, before time.Time) (int, error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	removed := 0
+	err := hs.withFileLock(func() error {
+		removed = hs.removeLocked(room, func(msg *StoredMessage, fromEnd int) bool {
+			return before.IsZero() || msg.Time.Before(before)
+		})
+		if removed == 0 {
+			return nil
+		}
+		return hs.compact()
+	})
+	return removed, err
+}
+
+// Drops the messages of a room matching remove, which is also told how many
+// messages that are not deleted are newer. The room's newest record is kept
+// as a scrubbed, deleted placeholder so IDs and sequence numbers are never
+// handed out again. Returns the number of messages removed.
+func (hs *HistoryStore) removeLocked(room string, remove func(msg *StoredMessage, fromEnd int) bool) int {
+	ids := hs.rooms[room]
+	keep := make([]int64, 0, len(ids))
+	removed, fromEnd := 0, 0
+	for i := len(ids) - 1; i >= 0; i-- {
+		msg := hs.messages[ids[i]]
+		switch {
+		case msg.Deleted && i < len(ids)-1:
+			delete(hs.messages, msg.ID)
+		case msg.Deleted:
+			keep = append(keep, msg.ID)
+		case remove(msg, fromEnd):
+			removed++
+			if i == len(ids)-1 {
+				*msg = StoredMessage{ID: msg.ID, Seq: msg.Seq, Room: msg.Room, Time: msg.Time, Deleted: true}
+				keep = append(keep, msg.ID)
+			} else {
+				delete(hs.messages, msg.ID)
+			}
+		default:
+			fromEnd++
+			keep = append(keep, msg.ID)
+		}
+	}
+	for i, j := 0, len(keep)-1; i < j; i, j = i+1, j-1 {
+		keep[i], keep[j] = keep[j], keep[i]
+	}
+	hs.rooms[room] = keep
+	return removed
+}
+
+// Rewrites the history file with only the current state of the remaining
+// messages. The new file is locked before it replaces the old one so other
+// processes wait for the compaction to finish and then load it.
+func (hs *HistoryStore) compact() error {
+	if hs.file == nil {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(hs.messages))
+	for id := range hs.messages {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	tmpPath := hs.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Flock(int(tmp.Fd()), syscall.LOCK_EX); err != nil {
+		tmp.Close()
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	var size int64
+	for _, id := range ids {
+		line, err := json.Marshal(hs.messages[id])
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		n, _ := w.Write(append(line, '\n'))
+		size += int64(n)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmpPath, hs.path); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	old := hs.file
+	hs.file = tmp
+	hs.offset = size
+	syscall.Flock(int(old.Fd()), syscall.LOCK_UN)
+	return old.Close()
+}
+
+// Returns up to n of the most recent messages in a room, oldest first
+func (hs *HistoryStore) Recent(room string, n int) []StoredMessage {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	ids := hs.rooms[room]
+	var recent []StoredMessage
+	for i := len(ids) - 1; i >= 0 && len(recent) < n; i-- {
+		if msg := hs.messages[ids[i]]; !msg.Deleted {
+			recent = append(recent, *msg)
+		}
+	}
+	for i, j := 0, len(recent)-1; i < j; i, j = i+1, j-1 {
+		recent[i], recent[j] = recent[j], recent[i]
+	}
+	return recent
+}
+
+// Returns up to limit messages in the room with a sequence number greater
+// than afterSeq, oldest first. A limit of zero returns all of them.
+func (hs *HistoryStore) SinceSeq(room string, afterSeq int64, limit int) []StoredMessage {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	ids := hs.rooms[room]
+	start := len(ids)
+	for start > 0 && hs.messages[ids[start-1]].Seq > afterSeq {
+		start--
+	}
+	var msgs []StoredMessage
+	for _, id := range ids[start:] {
+		if msg := hs.messages[id]; !msg.Deleted {
+			msgs = append(msgs, *msg)
+		}
+	}
+	if limit > 0 && len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+	}
+	return msgs
+}
+
+// Returns up to limit messages in the room with an ID greater than afterID,
+// oldest first. A limit of zero returns all of them.
+func (hs *HistoryStore) Since(room string, afterID int64, limit int) []StoredMessage {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	var msgs []StoredMessage
+	for _, id := range hs.rooms[room] {
+		if msg := hs.messages[id]; id > afterID && !msg.Deleted {
+			msgs = append(msgs, *msg)
+		}
+	}
+	if limit > 0 && len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+	}
+	return msgs
+}
+
+// Returns the number of messages in the room with an ID greater than afterID
+func (hs *HistoryStore) CountSince(room string, afterID int64) int {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	count := 0
+	for _, id := range hs.rooms[room] {
+		if id > afterID && !hs.messages[id].Deleted {
+			count++
+		}
+	}
+	return count
+}
+
+// Returns the ID of the newest message in the room, or zero when it is empty
+func (hs *HistoryStore) LatestID(room string) int64 {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	ids := hs.rooms[room]
+	if len(ids) == 0 {
+		return 0
+	}
+	return ids[len(ids)-1]
+}
+
+// Returns all messages in the room whose text matches, oldest first
+func (hs *HistoryStore) Search(room string, match func(text string) bool) []StoredMessage {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	var msgs []StoredMessage
+	for _, id := range hs.rooms[room] {
+		if msg := hs.messages[id]; !msg.Deleted && match(msg.Text) {
+			msgs = append(msgs, *msg)
+		}
+	}
+	return msgs
+}
+
+// Returns the thread a message belongs to: the root message followed by all
+// direct and nested replies to it, oldest first
+func (hs *HistoryStore) Thread(id int64) ([]StoredMessage, error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	msg, ok := hs.messages[id]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	root := hs.rootOf(msg)
+
+	var thread []StoredMessage
+	for _, mid := range hs.rooms[root.Room] {
+		m := hs.messages[mid]
+		if mid >= root.ID && !m.Deleted && hs.rootOf(m).ID == root.ID {
+			thread = append(thread, *m)
+		}
+	}
+	return thread, nil
+}
+
+// Follows reply links up to the message that started the thread
+func (hs *HistoryStore) rootOf(msg *StoredMessage) *StoredMessage {
+	for msg.ReplyTo != 0 {
+		parent, ok := hs.messages[msg.ReplyTo]
+		if !ok {
+			break
+		}
+		msg = parent
+	}
+	return msg
+}
+
+// Records the message in the in-memory indexes
+func (hs *HistoryStore) apply(msg *StoredMessage) {
+	if _, ok := hs.messages[msg.ID]; !ok {
+		hs.rooms[msg.Room] = append(hs.rooms[msg.Room], msg.ID)
+		// Records written before sequence numbers existed get theirs on load.
+		if msg.Seq == 0 {
+			msg.Seq = hs.roomSeq[msg.Room] + 1
+		}
+		if msg.Seq > hs.roomSeq[msg.Room] {
+			hs.roomSeq[msg.Room] = msg.Seq
+		}
+	}
+	hs.messages[msg.ID] = msg
+	if msg.ID > hs.lastID {
+		hs.lastID = msg.ID
+	}
+}
+
+// Appends the current state of the message to the history file
+func (hs *HistoryStore) persist(msg *StoredMessage) error {
+	if hs.file == nil {
+		return nil
+	}
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	n, err := hs.file.Write(append(line, '\n'))
+	hs.offset += int64(n)
+	return err
+}
+
+// Runs fn while holding an exclusive lock on the history file, after applying
+// any records other processes appended since the last read. When another
+// process compacted the file in the meantime it is loaded again first.
+func (hs *HistoryStore) withFileLock(fn func() error) error {
+	if hs.file == nil {
+		return fn()
+	}
+	for {
+		if err := syscall.Flock(int(hs.file.Fd()), syscall.LOCK_EX); err != nil {
+			return err
+		}
+		current, err := hs.isCurrentFile()
+		if err != nil || current {
+			if err != nil {
+				syscall.Flock(int(hs.file.Fd()), syscall.LOCK_UN)
+				return err
+			}
+			break
+		}
+		syscall.Flock(int(hs.file.Fd()), syscall.LOCK_UN)
+		if err := hs.reopen(); err != nil {
+			return err
+		}
+	}
+	// Compaction swaps the file, so unlock whichever is open at the end.
+	defer func() { syscall.Flock(int(hs.file.Fd()), syscall.LOCK_UN) }()
+
+	if err := hs.catchUp(); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// Reports whether the open file is still the one at the history path
+func (hs *HistoryStore) isCurrentFile() (bool, error) {
+	open, err := hs.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	onDisk, err := os.Stat(hs.path)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(open, onDisk), nil
+}
+
+// Replaces the in-memory state with the contents of the file now at the
+// history path
+func (hs *HistoryStore) reopen() error {
+	hs.file.Close()
+	hs.messages = map[int64]*StoredMessage{}
+	hs.rooms = map[string][]int64{}
+	hs.roomSeq = map[string]int64{}
+	hs.lastID = 0
+	hs.offset = 0
+	if err := hs.load(hs.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(hs.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	hs.file = f
+	return nil
+}
+
+// Applies complete records written to the file after the current offset
+func (hs *HistoryStore) catchUp() error {
+	f, err := os.Open(hs.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(hs.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		hs.offset += int64(len(line))
+		var msg StoredMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return err
+		}
+		hs.apply(&msg)
+	}
+}
+
+// Replays the history file into memory
+func (hs *HistoryStore) load(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		hs.offset += int64(len(scanner.Bytes())) + 1
+		var msg StoredMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return err
+		}
+		hs.apply(&msg)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// An account at an identity provider linked to a user's SSH key with /link
+type LinkedIdentity struct {
+	Provider    string    `json:"provider"`
+	Subject     string    `json:"subject"`
+	Login       string    `json:"login,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	Fingerprint string    `json:"fingerprint"`
+	LinkedAt    time.Time `json:"linked_at"`
+	// Set when the name looked like another user's and is not shown
+	NameFlagged bool `json:"name_flagged,omitempty"`
+}
+
+// Returns the name to show for the user, the provider's display name when
+// set and the login otherwise. Flagged names are not shown.
+func (li LinkedIdentity) DisplayName() string {
+	if li.NameFlagged {
+		return ""
+	}
+	if li.Name != "" {
+		return li.Name
+	}
+	return li.Login
+}
+
+// Returns up to two upper case initials of the display name, e.g. "AL" for
+// "Ada Lovelace"
+func (li LinkedIdentity) Initials() string {
+	var initials []rune
+	for _, word := range strings.FieldsFunc(li.DisplayName(), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		initials = append(initials, unicode.ToUpper([]rune(word)[0]))
+	}
+	if len(initials) > 2 {
+		initials = []rune{initials[0], initials[len(initials)-1]}
+	}
+	return string(initials)
+}
+
+// Used for persisting linked identities as a JSON file keyed by username
+type IdentityStore struct {
+	mu         sync.Mutex
+	path       string
+	identities map[string]LinkedIdentity
+}
+
+// Returns an identity store backed by LINKED_IDENTITIES_PATH. When the
+// variable is not set identities are only kept in memory.
+func NewIdentityStore() *IdentityStore {
+	is := &IdentityStore{
+		path:       os.Getenv("LINKED_IDENTITIES_PATH"),
+		identities: map[string]LinkedIdentity{},
+	}
+	if err := readJSONFile(is.path, &is.identities); err != nil {
+		log.Fatal("Failed to load linked identities: ", err)
+	}
+
+	return is
+}
+
+// Returns the identity linked to the user
+func (is *IdentityStore) Get(user string) (LinkedIdentity, bool) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	identity, ok := is.identities[user]
+	return identity, ok
+}
+
+// Links an identity to the user and persists the store
+func (is *IdentityStore) Set(user string, identity LinkedIdentity) error {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	is.identities[user] = identity
+	return writeJSONFile(is.path, is.identities)
+}
+
+// Unlinks the user's identity and persists the store
+func (is *IdentityStore) Delete(user string) error {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	delete(is.identities, user)
+	return writeJSONFile(is.path, is.identities)
+}
+
+// Returns the linked identities by user
+func (is *IdentityStore) All() map[string]LinkedIdentity {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	identities := make(map[string]LinkedIdentity, len(is.identities))
+	for user, identity := range is.identities {
+		identities[user] = identity
+	}
+	return identities
+}
+
+// Returns the user the provider account is linked to, if any
+func (is *IdentityStore) UserOf(provider string, subject string) (string, bool) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	for user, identity := range is.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return user, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Number of queued whispers kept per recipient, oldest are dropped first
+const maxInboxSize = 100
+
+// A whisper sent to a user while they were offline
+type QueuedWhisper struct {
+	ID        int64     `json:"id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Text      string    `json:"text"`
+	Time      time.Time `json:"time"`
+	Delivered bool      `json:"delivered,omitempty"`
+}
+
+// Used for persisting whispers to offline users as a JSON file
+type InboxStore struct {
+	mu     sync.Mutex
+	path   string
+	lastID int64
+	inbox  map[string][]QueuedWhisper
+}
+
+// Returns an inbox store backed by INBOX_PATH. When the variable is not set
+// queued whispers are only kept in memory.
+func NewInboxStore() *InboxStore {
+	is := &InboxStore{
+		path:  os.Getenv("INBOX_PATH"),
+		inbox: map[string][]QueuedWhisper{},
+	}
+	if err := readJSONFile(is.path, &is.inbox); err != nil {
+		log.Fatal("Failed to load inbox: ", err)
+	}
+	for _, msgs := range is.inbox {
+		for _, msg := range msgs {
+			if msg.ID > is.lastID {
+				is.lastID = msg.ID
+			}
+		}
+	}
+
+	return is
+}
+
+// Queues a whisper for its recipient and returns it with its ID assigned
+func (is *InboxStore) Add(msg QueuedWhisper) (QueuedWhisper, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	is.lastID++
+	msg.ID = is.lastID
+	msgs := append(is.inbox[msg.To], msg)
+	if len(msgs) > maxInboxSize {
+		msgs = msgs[len(msgs)-maxInboxSize:]
+	}
+	is.inbox[msg.To] = msgs
+	return msg, writeJSONFile(is.path, is.inbox)
+}
+
+// Returns the user's undelivered whispers and marks them as delivered
+func (is *InboxStore) TakeUndelivered(user string) ([]QueuedWhisper, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	var undelivered []QueuedWhisper
+	msgs := is.inbox[user]
+	for i := range msgs {
+		if !msgs[i].Delivered {
+			undelivered = append(undelivered, msgs[i])
+			msgs[i].Delivered = true
+		}
+	}
+	if len(undelivered) == 0 {
+		return nil, nil
+	}
+	return undelivered, writeJSONFile(is.path, is.inbox)
+}
+
+// Returns a copy of all whispers queued for the user
+func (is *InboxStore) List(user string) []QueuedWhisper {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	msgs := make([]QueuedWhisper, len(is.inbox[user]))
+	copy(msgs, is.inbox[user])
+	return msgs
+}
+
+// Removes all of the user's queued whispers
+func (is *InboxStore) Clear(user string) error {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	delete(is.inbox, user)
+	return writeJSONFile(is.path, is.inbox)
+}
+
+// Returns a copy of all queued whispers sent by or to the user
+func (is *InboxStore) Involving(user string) []QueuedWhisper {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	var msgs []QueuedWhisper
+	for _, queued := range is.inbox {
+		for _, msg := range queued {
+			if msg.From == user || msg.To == user {
+				msgs = append(msgs, msg)
+			}
+		}
+	}
+	return msgs
+}
+
+// Removes all queued whispers sent by or to the user
+func (is *InboxStore) DeleteUser(user string) error {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	delete(is.inbox, user)
+	for to, queued := range is.inbox {
+		kept := queued[:0]
+		for _, msg := range queued {
+			if msg.From != user {
+				kept = append(kept, msg)
+			}
+		}
+		is.inbox[to] = kept
+	}
+	return writeJSONFile(is.path, is.inbox)
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Returned when registering a username that already has a key
+var ErrUserRegistered = errors.New("username is already registered")
+
+// A public key registered by a user on first connect
+type RegisteredKey struct {
+	User         string    `json:"user"`
+	Key          string    `json:"key"`
+	Fingerprint  string    `json:"fingerprint"`
+	RegisteredAt time.Time `json:"registered_at"`
+	Pending      bool      `json:"pending,omitempty"`
+}
+
+// Used for persisting self-registered public keys as a JSON file
+type KeyStore struct {
+	mu   sync.Mutex
+	path string
+	keys map[string]RegisteredKey
+}
+
+// Returns a key store backed by REGISTERED_KEYS_PATH. When the variable is
+// not set registrations are only kept in memory.
+func NewKeyStore() *KeyStore {
+	ks := &KeyStore{
+		path: os.Getenv("REGISTERED_KEYS_PATH"),
+		keys: map[string]RegisteredKey{},
+	}
+	if err := readJSONFile(ks.path, &ks.keys); err != nil {
+		log.Fatal("Failed to load registered keys: ", err)
+	}
+
+	return ks
+}
+
+// Returns the key registered for the user
+func (ks *KeyStore) Get(user string) (RegisteredKey, bool) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok := ks.keys[user]
+	return key, ok
+}
+
+// Stores a new registration unless the username is already taken
+func (ks *KeyStore) Register(key RegisteredKey) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if _, ok := ks.keys[key.User]; ok {
+		return ErrUserRegistered
+	}
+	ks.keys[key.User] = key
+	if err := writeJSONFile(ks.path, ks.keys); err != nil {
+		delete(ks.keys, key.User)
+		return err
+	}
+	return nil
+}
+
+// Clears the pending flag of a registration
+func (ks *KeyStore) Approve(user string) (bool, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok := ks.keys[user]
+	if !ok || !key.Pending {
+		return false, nil
+	}
+	key.Pending = false
+	ks.keys[user] = key
+	return true, writeJSONFile(ks.path, ks.keys)
+}
+
+// Removes a registration, freeing up the username
+func (ks *KeyStore) Remove(user string) (bool, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if _, ok := ks.keys[user]; !ok {
+		return false, nil
+	}
+	delete(ks.keys, user)
+	return true, writeJSONFile(ks.path, ks.keys)
+}
+
+// Returns all registrations sorted by registration time
+func (ks *KeyStore) List() []RegisteredKey {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	keys := make([]RegisteredKey, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].RegisteredAt.Before(keys[j].RegisteredAt)
+	})
+	return keys
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"sync"
+)
+
+// Used for persisting per-user preferences as a JSON file
+type PreferencesStore struct {
+	mu    sync.Mutex
+	path  string
+	prefs map[string]map[string]string
+}
+
+// Returns a preferences store backed by PREFERENCES_PATH. When the variable
+// is not set preferences are only kept in memory.
+func NewPreferencesStore() *PreferencesStore {
+	ps := &PreferencesStore{
+		path:  os.Getenv("PREFERENCES_PATH"),
+		prefs: map[string]map[string]string{},
+	}
+	if err := readJSONFile(ps.path, &ps.prefs); err != nil {
+		log.Fatal("Failed to load preferences: ", err)
+	}
+
+	return ps
+}
+
+// Returns a copy of the stored preferences for the user
+func (ps *PreferencesStore) Get(user string) map[string]string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	prefs := make(map[string]string, len(ps.prefs[user]))
+	for k, v := range ps.prefs[user] {
+		prefs[k] = v
+	}
+	return prefs
+}
+
+// Stores a single preference for the user and persists the store
+func (ps *PreferencesStore) Set(user string, key string, value string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.prefs[user] == nil {
+		ps.prefs[user] = map[string]string{}
+	}
+	ps.prefs[user][key] = value
+
+	return writeJSONFile(ps.path, ps.prefs)
+}
+
+// Removes all preferences of the user and persists the store
+func (ps *PreferencesStore) Delete(user string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	delete(ps.prefs, user)
+	return writeJSONFile(ps.path, ps.prefs)
+}
+
+// Decodes the JSON file at path into v. A missing file or empty path is not an error.
+func readJSONFile(path string, v any) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Atomically replaces the file at path with the JSON encoding of v.
+// An empty path means the data is kept in memory only.
+func writeJSONFile(path string, v any) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// A message to deliver to a user at a later time
+type Reminder struct {
+	ID   string    `json:"id"`
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	Text string    `json:"text"`
+	Due  time.Time `json:"due"`
+}
+
+// Used for persisting pending reminders as a JSON file
+type ReminderStore struct {
+	mu        sync.Mutex
+	path      string
+	reminders []Reminder
+}
+
+// Returns a reminder store backed by REMINDERS_PATH. When the variable is
+// not set reminders are only kept in memory.
+func NewReminderStore() *ReminderStore {
+	rs := &ReminderStore{
+		path: os.Getenv("REMINDERS_PATH"),
+	}
+	if err := readJSONFile(rs.path, &rs.reminders); err != nil {
+		log.Fatal("Failed to load reminders: ", err)
+	}
+
+	return rs
+}
+
+// Returns a copy of all pending reminders
+func (rs *ReminderStore) All() []Reminder {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	reminders := make([]Reminder, len(rs.reminders))
+	copy(reminders, rs.reminders)
+	return reminders
+}
+
+// Stores a new pending reminder
+func (rs *ReminderStore) Add(r Reminder) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.reminders = append(rs.reminders, r)
+	return writeJSONFile(rs.path, rs.reminders)
+}
+
+// Removes the reminders set by or for the user
+func (rs *ReminderStore) RemoveUser(user string) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	kept := rs.reminders[:0]
+	for _, r := range rs.reminders {
+		if r.From != user && r.To != user {
+			kept = append(kept, r)
+		}
+	}
+	rs.reminders = kept
+	return writeJSONFile(rs.path, rs.reminders)
+}
+
+// Removes a delivered reminder
+func (rs *ReminderStore) Remove(id string) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for i, r := range rs.reminders {
+		if r.ID == id {
+			rs.reminders = append(rs.reminders[:i], rs.reminders[i+1:]...)
+			return writeJSONFile(rs.path, rs.reminders)
+		}
+	}
+	return nil
+}
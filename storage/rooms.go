@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A persisted chat room together with its access list
+type StoredRoom struct {
+	Name  string `json:"name"`
+	Topic string `json:"topic,omitempty"`
+	// Shown to users when they join
+	Welcome   string    `json:"welcome,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Private   bool      `json:"private,omitempty"`
+	// Only ops may post
+	ReadOnly bool `json:"read_only,omitempty"`
+	// Seconds other members must wait between posts
+	SlowMode int `json:"slow_mode,omitempty"`
+	// How join and leave notices are sent, empty meaning right away
+	Presence string   `json:"presence,omitempty"`
+	Members  []string `json:"members,omitempty"`
+	Ops      []string `json:"ops,omitempty"`
+	// Muted users mapped to when the mute ends, zero meaning indefinitely
+	Muted map[string]time.Time `json:"muted,omitempty"`
+	// When the last message was sent, saved at most once a minute
+	LastActivity time.Time `json:"last_activity"`
+	// Hidden after being idle, with its history kept
+	Archived bool `json:"archived,omitempty"`
+}
+
+// Used for persisting rooms as a JSON file
+type RoomStore struct {
+	mu    sync.Mutex
+	path  string
+	rooms map[string]StoredRoom
+}
+
+// Returns a room store backed by ROOMS_PATH. When the variable is not set
+// rooms are only kept in memory.
+func NewRoomStore() *RoomStore {
+	rs := &RoomStore{
+		path:  os.Getenv("ROOMS_PATH"),
+		rooms: map[string]StoredRoom{},
+	}
+	if err := readJSONFile(rs.path, &rs.rooms); err != nil {
+		log.Fatal("Failed to load rooms: ", err)
+	}
+
+	return rs
+}
+
+// Returns all stored rooms sorted by name
+func (rs *RoomStore) All() []StoredRoom {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rooms := make([]StoredRoom, 0, len(rs.rooms))
+	for _, room := range rs.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Name < rooms[j].Name })
+	return rooms
+}
+
+// Creates or replaces a room and persists the store
+func (rs *RoomStore) Save(room StoredRoom) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.rooms[room.Name] = room
+	return writeJSONFile(rs.path, rs.rooms)
+}
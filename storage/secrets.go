@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"log"
+	"os"
+	"sync"
+)
+
+// A user's TOTP secret. Pending secrets have been generated by /2fa setup
+// but not yet confirmed with a valid code.
+type TOTPSecret struct {
+	Secret  string `json:"secret"`
+	Pending bool   `json:"pending,omitempty"`
+}
+
+// Used for persisting per-user TOTP secrets as a JSON file with 0600 permissions
+type SecretStore struct {
+	mu      sync.Mutex
+	path    string
+	secrets map[string]TOTPSecret
+}
+
+// Returns a secret store backed by TOTP_SECRETS_PATH. When the variable is
+// not set secrets are only kept in memory.
+func NewSecretStore() *SecretStore {
+	ss := &SecretStore{
+		path:    os.Getenv("TOTP_SECRETS_PATH"),
+		secrets: map[string]TOTPSecret{},
+	}
+	if err := readJSONFile(ss.path, &ss.secrets); err != nil {
+		log.Fatal("Failed to load TOTP secrets: ", err)
+	}
+
+	return ss
+}
+
+// Returns the user's secret
+func (ss *SecretStore) Get(user string) (TOTPSecret, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	secret, ok := ss.secrets[user]
+	return secret, ok
+}
+
+// Stores the user's secret and persists the store
+func (ss *SecretStore) Set(user string, secret TOTPSecret) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.secrets[user] = secret
+	return writeJSONFile(ss.path, ss.secrets)
+}
+
+// Removes the user's secret and persists the store
+func (ss *SecretStore) Delete(user string) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	delete(ss.secrets, user)
+	return writeJSONFile(ss.path, ss.secrets)
+}
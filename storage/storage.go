@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// Storage backends selectable with STORAGE_BACKEND
+const (
+	BackendFile     = "file"
+	BackendPostgres = "postgres"
+)
+
+// Message history of all rooms. Message IDs are unique across rooms and
+// sequence numbers within a room, and neither is ever handed out twice.
+type History interface {
+	// Stores a new message and returns it with its assigned ID and time
+	Append(msg StoredMessage) (StoredMessage, error)
+	// Returns the message with the given ID
+	Get(id int64) (StoredMessage, error)
+	// Replaces the text of a message and marks it as edited
+	Edit(id int64, text string) (StoredMessage, error)
+	// Marks a message as deleted and scrubs its text
+	Delete(id int64) error
+	// Toggles the user's reaction to a message and reports whether it was added
+	React(id int64, user string, emoji string) (StoredMessage, bool, error)
+	// Deletes every message sent by the user and takes back their reactions
+	Scrub(user string) (int, error)
+	// Calls fn for every message that is not deleted. fn must not call the store.
+	Scan(fn func(msg StoredMessage))
+	// Removes messages older than maxAge and all but the newest maxPerRoom per room
+	Prune(maxAge time.Duration, maxPerRoom int) (int, error)
+	// Removes the messages of a room sent before the given time, or all when zero
+	Purge(room string, before time.Time) (int, error)
+	// Returns up to n of the most recent messages in a room, oldest first
+	Recent(room string, n int) []StoredMessage
+	// Returns up to limit messages after a sequence number, oldest first
+	SinceSeq(room string, afterSeq int64, limit int) []StoredMessage
+	// Returns up to limit messages after a message ID, oldest first
+	Since(room string, afterID int64, limit int) []StoredMessage
+	// Returns the number of messages in the room after a message ID
+	CountSince(room string, afterID int64) int
+	// Returns the ID of the newest message in the room, or zero when it is empty
+	LatestID(room string) int64
+	// Returns all messages in the room whose text matches, oldest first
+	Search(room string, match func(text string) bool) []StoredMessage
+	// Returns the root of a message's thread followed by all replies to it
+	Thread(id int64) ([]StoredMessage, error)
+}
+
+// Per-user preferences as key value pairs
+type Preferences interface {
+	// Returns a copy of the stored preferences for the user
+	Get(user string) map[string]string
+	// Stores a single preference for the user
+	Set(user string, key string, value string) error
+	// Removes all preferences of the user
+	Delete(user string) error
+}
+
+// A storage backend holding the message history and user preferences
+type Storage interface {
+	History() History
+	Preferences() Preferences
+}
+
+// Keeps history and preferences in local files, see NewHistoryStore and
+// NewPreferencesStore
+type fileStorage struct {
+	history *HistoryStore
+	prefs   *PreferencesStore
+}
+
+func (s *fileStorage) History() History         { return s.history }
+func (s *fileStorage) Preferences() Preferences { return s.prefs }
+
+// Returns the backend chosen by STORAGE_BACKEND: "file" (default) keeps
+// data in the files named by HISTORY_PATH and PREFERENCES_PATH, "postgres"
+// in the database at POSTGRES_URL.
+func Open() Storage {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", BackendFile:
+		return &fileStorage{history: NewHistoryStore(), prefs: NewPreferencesStore()}
+	case BackendPostgres:
+		pg, err := OpenPostgres(os.Getenv("POSTGRES_URL"))
+		if err != nil {
+			log.Fatal("Failed to open Postgres storage: ", err)
+		}
+		return pg
+	default:
+		log.Fatalf("Unknown STORAGE_BACKEND %q, expected %q or %q", backend, BackendFile, BackendPostgres)
+		return nil
+	}
+}
@@ -0,0 +1,219 @@
+package telnet
+
+import (
+	"errors"
+	"fmt"
+	"group-ssh-chat/auth"
+	"group-ssh-chat/chat"
+	"group-ssh-chat/graceful"
+	"group-ssh-chat/proxyproto"
+	"io"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Telnet protocol bytes used during option negotiation
+const (
+	iac  = 255
+	will = 251
+	wont = 252
+	do   = 253
+	dont = 254
+	sb   = 250
+	se   = 240
+
+	optEcho            = 1
+	optSuppressGoAhead = 3
+)
+
+// Usernames telnet clients may pick for themselves
+var validUsername = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+// Names the SSH guest provider hands out, which telnet clients may not take
+var sshGuestName = regexp.MustCompile(`^` + auth.GuestPrefix + `[0-9]{4}$`)
+
+// Knows the users that authenticate over SSH
+type UserDirectory interface {
+	IsKnownUser(user string) bool
+}
+
+// Attaches a byte stream to the chat as the given user
+type TerminalServer interface {
+	ServeTerminal(user string, remoteAddr string, rwc io.ReadWriteCloser)
+}
+
+// A plaintext, unauthenticated telnet frontend for LAN and demo use
+type Server struct {
+	listenAddress string
+	hub           *chat.Hub
+	chat          TerminalServer
+	users         UserDirectory
+}
+
+// Returns a new telnet server, or nil when TELNET_LISTEN_ADDRESS is not set.
+// Because telnet is unauthenticated the operator must also set
+// TELNET_ALLOW_UNAUTHENTICATED=true to acknowledge the risk.
+func New(hub *chat.Hub, chat TerminalServer, users UserDirectory) *Server {
+	listenAddress := os.Getenv("TELNET_LISTEN_ADDRESS")
+	if listenAddress == "" {
+		return nil
+	}
+	if os.Getenv("TELNET_ALLOW_UNAUTHENTICATED") != "true" {
+		log.Fatal("TELNET_LISTEN_ADDRESS is set but TELNET_ALLOW_UNAUTHENTICATED=true was not given, refusing to start an unauthenticated listener")
+	}
+
+	return &Server{
+		listenAddress: listenAddress,
+		hub:           hub,
+		chat:          chat,
+		users:         users,
+	}
+}
+
+// Accepts telnet connections until the listener fails
+func (ts *Server) ListenAndServe() error {
+	listener, err := graceful.Listen("telnet", "tcp", ts.listenAddress)
+	if err != nil {
+		return err
+	}
+	listener = proxyproto.FromEnv(listener)
+	log.Println("Telnet listener (unauthenticated) is serving on", ts.listenAddress)
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+		if err != nil {
+			log.Printf("failed to accept telnet connection: %q", err)
+			continue
+		}
+		go ts.handleConnection(conn)
+	}
+}
+
+// Negotiates character mode, prompts for a username and joins the chat
+func (ts *Server) handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	// Ask the client to let the server echo and to send characters as they
+	// are typed, so the terminal line editor works as it does over SSH.
+	if _, err := conn.Write([]byte{iac, will, optEcho, iac, will, optSuppressGoAhead, iac, do, optSuppressGoAhead}); err != nil {
+		return
+	}
+	stream := &telnetConn{Conn: conn}
+
+	user, err := ts.promptUsername(stream)
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			log.Println("Telnet login error:", err)
+		}
+		return
+	}
+
+	log.Printf("Telnet client %s connected as %s", conn.RemoteAddr(), user)
+	ts.hub.SetGuest(user, true)
+	ts.chat.ServeTerminal(user, conn.RemoteAddr().String(), stream)
+}
+
+// Asks for a username until the client picks a valid one that is not in use.
+// Telnet users are unauthenticated, so they always join as guests under the
+// guest prefix and may not take a name SSH users own.
+func (ts *Server) promptUsername(stream io.ReadWriter) (string, error) {
+	t := term.NewTerminal(stream, "Username: ")
+	fmt.Fprintln(t, "Warning: this telnet connection is unencrypted and unauthenticated.")
+
+	for {
+		name, err := t.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		if !validUsername.MatchString(name) {
+			fmt.Fprintln(t, "Usernames may only contain letters, digits, '_' and '-' (max 32).")
+			continue
+		}
+		if !strings.HasPrefix(strings.ToLower(name), auth.GuestPrefix) {
+			name = auth.GuestPrefix + name
+		}
+		switch {
+		case sshGuestName.MatchString(strings.ToLower(name)) || ts.users.IsKnownUser(name) || ts.hub.IsKnownUser(name):
+			fmt.Fprintln(t, "That username is reserved.")
+		case ts.hub.IsOnline(name):
+			fmt.Fprintln(t, "That username is already in use.")
+		default:
+			return name, nil
+		}
+	}
+}
+
+// Wraps a connection and strips telnet command sequences from its input
+type telnetConn struct {
+	net.Conn
+	state int
+}
+
+const (
+	stateData = iota
+	stateIAC
+	stateOption
+	stateSub
+	stateSubIAC
+)
+
+// Reads data from the client with IAC sequences removed
+func (tc *telnetConn) Read(p []byte) (int, error) {
+	for {
+		n, err := tc.Conn.Read(p)
+		if n == 0 {
+			return 0, err
+		}
+
+		out := 0
+		for _, c := range p[:n] {
+			switch tc.state {
+			case stateData:
+				if c == iac {
+					tc.state = stateIAC
+				} else {
+					p[out] = c
+					out++
+				}
+			case stateIAC:
+				switch c {
+				case iac:
+					p[out] = c
+					out++
+					tc.state = stateData
+				case will, wont, do, dont:
+					tc.state = stateOption
+				case sb:
+					tc.state = stateSub
+				default:
+					tc.state = stateData
+				}
+			case stateOption:
+				tc.state = stateData
+			case stateSub:
+				if c == iac {
+					tc.state = stateSubIAC
+				}
+			case stateSubIAC:
+				if c == se {
+					tc.state = stateData
+				} else {
+					tc.state = stateSub
+				}
+			}
+		}
+
+		// Only negotiation bytes were received, keep reading.
+		if out > 0 || err != nil {
+			return out, err
+		}
+	}
+}
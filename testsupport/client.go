@@ -0,0 +1,211 @@
+package testsupport
+
+import (
+	"group-ssh-chat/ui"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// How long Expect waits for output before failing the test
+var ExpectTimeout = 5 * time.Second
+
+// An SSH client in a chat session. Its output is read continuously; Expect
+// walks through it in order, so a scenario reads the same way every run.
+type Client struct {
+	t       testing.TB
+	user    string
+	conn    *ssh.Client
+	session *ssh.Session
+	stdin   io.WriteCloser
+
+	mu      sync.Mutex
+	output  string
+	offset  int
+	changed chan struct{}
+	ended   bool
+}
+
+// Connects as a configured user and opens a chat session on a wide, plain
+// terminal, so lines are neither wrapped nor colored. The client is closed
+// when the test ends.
+func (srv *Server) Connect(t testing.TB, user string) *Client {
+	t.Helper()
+	signer, ok := srv.keys[user]
+	if !ok {
+		t.Fatalf("%s is not a configured user", user)
+	}
+	conn := srv.dial(t, user, signer)
+	c := &Client{t: t, user: user, conn: conn, changed: make(chan struct{})}
+	t.Cleanup(func() { c.Close() })
+
+	var err error
+	if c.session, err = conn.NewSession(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.session.RequestPty("dumb", 50, 1000, ssh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+	if c.stdin, err = c.session.StdinPipe(); err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := c.session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+	go c.read(stdout)
+	return c
+}
+
+// Runs a one-shot command the way `ssh host "post #lobby hi"` does and
+// returns its output. The error is set when the command exits with a
+// failure. A user without a configured key connects with a fresh one, which
+// only the guest provider admits.
+func (srv *Server) Exec(t testing.TB, user string, command string) (string, error) {
+	t.Helper()
+	signer, ok := srv.keys[user]
+	if !ok {
+		signer = newKey(t)
+	}
+	conn := srv.dial(t, user, signer)
+	defer conn.Close()
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := session.CombinedOutput(command)
+	return strings.ReplaceAll(string(output), "\r", ""), err
+}
+
+// Opens an SSH connection as user, failing the test when it is refused
+func (srv *Server) dial(t testing.TB, user string, signer ssh.Signer) *ssh.Client {
+	t.Helper()
+	conn, err := ssh.Dial("tcp", srv.Addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.FixedHostKey(srv.hostKey),
+		Timeout:         ExpectTimeout,
+	})
+	if err != nil {
+		t.Fatalf("%s failed to connect: %v", user, err)
+	}
+	return conn
+}
+
+// Collects the session output without terminal control sequences
+func (c *Client) read(stdout io.Reader) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := stdout.Read(buf)
+		c.mu.Lock()
+		c.output += strings.ReplaceAll(ui.StripANSI(string(buf[:n])), "\r", "")
+		if err != nil {
+			c.ended = true
+		}
+		close(c.changed)
+		c.changed = make(chan struct{})
+		c.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Types a line and presses Enter
+func (c *Client) Send(line string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.stdin, line+"\r"); err != nil {
+		c.t.Fatalf("%s failed to send %q: %v", c.user, line, err)
+	}
+}
+
+// Waits for output matching the regular expression after what earlier
+// expectations matched, and returns the match and its submatches. Fails the
+// test when it does not arrive within ExpectTimeout.
+func (c *Client) Expect(pattern string) []string {
+	c.t.Helper()
+	return c.ExpectWithout(pattern, "")
+}
+
+// Like Expect, and also fails the test when the forbidden regular
+// expression matches the output before the expected one, e.g. to check a
+// message was not delivered before a later one
+func (c *Client) ExpectWithout(pattern string, forbidden string) []string {
+	c.t.Helper()
+	re := regexp.MustCompile(pattern)
+	var not *regexp.Regexp
+	if forbidden != "" {
+		not = regexp.MustCompile(forbidden)
+	}
+
+	deadline := time.After(ExpectTimeout)
+	for {
+		c.mu.Lock()
+		unread := c.output[c.offset:]
+		loc := re.FindStringSubmatchIndex(unread)
+		if loc != nil {
+			if not != nil && not.MatchString(unread[:loc[1]]) {
+				c.mu.Unlock()
+				c.t.Fatalf("%s got %q before %q:\n%s", c.user, forbidden, pattern, unread[:loc[1]])
+			}
+			match := submatches(unread, loc)
+			c.offset += loc[1]
+			c.mu.Unlock()
+			return match
+		}
+		changed, ended := c.changed, c.ended
+		c.mu.Unlock()
+		if ended {
+			c.t.Fatalf("%s: session ended while waiting for %q, unread output:\n%s", c.user, pattern, unread)
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			c.t.Fatalf("%s: timed out waiting for %q, unread output:\n%s", c.user, pattern, unread)
+		}
+	}
+}
+
+// Returns the output the session has not been expected to match yet
+func (c *Client) Unread() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.output[c.offset:]
+}
+
+// Waits for the server to end the session, e.g. after a kick
+func (c *Client) ExpectClosed() {
+	c.t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- c.session.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(ExpectTimeout):
+		c.t.Fatalf("%s: the session is still open", c.user)
+	}
+}
+
+// Disconnects the client
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Returns the substrings of s located by a FindStringSubmatchIndex result
+func submatches(s string, loc []int) []string {
+	match := make([]string, len(loc)/2)
+	for i := range match {
+		if loc[2*i] >= 0 {
+			match[i] = s[loc[2*i]:loc[2*i+1]]
+		}
+	}
+	return match
+}
@@ -0,0 +1,203 @@
+package testsupport
+
+import (
+	"group-ssh-chat/totp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChatInRoom(t *testing.T) {
+	srv := Start(t, Config{Users: []string{"alice", "bob"}})
+	alice := srv.Connect(t, "alice")
+	alice.Expect(`Welcome alice! You are in #lobby`)
+	bob := srv.Connect(t, "bob")
+	bob.Expect(`Welcome bob!`)
+	alice.Expect(`bob joined #lobby`)
+
+	alice.Send("hello bob")
+	bob.Expect(`\[\d+\] alice: hello bob`)
+	alice.Expect(`\[\d+\] alice: hello bob`)
+}
+
+func TestJoinRoom(t *testing.T) {
+	srv := Start(t, Config{Users: []string{"alice", "bob"}})
+	alice := srv.Connect(t, "alice")
+	alice.Expect(`Welcome alice!`)
+	bob := srv.Connect(t, "bob")
+	bob.Expect(`Welcome bob!`)
+
+	alice.Send("/join dev")
+	alice.Expect(`You joined #dev`)
+	bob.Send("not for alice")
+	bob.Expect(`bob: not for alice`)
+	bob.Send("/join dev")
+	alice.Expect(`bob joined #dev`)
+	bob.Send("in dev")
+	alice.ExpectWithout(`bob: in dev`, `not for alice`)
+}
+
+func TestWhisper(t *testing.T) {
+	srv := Start(t, Config{Users: []string{"alice", "bob", "carol"}})
+	alice := srv.Connect(t, "alice")
+	alice.Expect(`Welcome alice!`)
+	bob := srv.Connect(t, "bob")
+	bob.Expect(`Welcome bob!`)
+	carol := srv.Connect(t, "carol")
+	carol.Expect(`Welcome carol!`)
+
+	alice.Send("/whisper bob the secret")
+	bob.Expect(`\[alice -> bob\] the secret`)
+	alice.Expect(`\[alice -> bob\] the secret`)
+	alice.Send("in public")
+	carol.ExpectWithout(`alice: in public`, `the secret`)
+}
+
+func TestGuestExecPost(t *testing.T) {
+	srv := Start(t, Config{
+		Users: []string{"alice"},
+		Env:   map[string]string{"AUTH_PROVIDERS": "authorized_keys,guest"},
+	})
+	alice := srv.Connect(t, "alice")
+	alice.Expect(`Welcome alice!`)
+
+	output, err := srv.Exec(t, "visitor", "post #lobby from a guest")
+	if err == nil {
+		t.Fatalf("guest post succeeded with output %q", output)
+	}
+	if !strings.Contains(output, "Guests can only read") {
+		t.Errorf("got %q, want the guest read-only error", output)
+	}
+
+	output, err = srv.Exec(t, "alice", "post #lobby from alice")
+	if err != nil {
+		t.Fatalf("post failed: %v: %s", err, output)
+	}
+	alice.ExpectWithout(`alice: from alice`, `from a guest`)
+}
+
+func TestFunCommandsFollowPostingRules(t *testing.T) {
+	srv := Start(t, Config{
+		Users: []string{"alice", "bob"},
+		Env:   map[string]string{"BANNED_WORDS": "darn"},
+	})
+	alice := srv.Connect(t, "alice")
+	alice.Expect(`Welcome alice!`)
+	bob := srv.Connect(t, "bob")
+	bob.Expect(`Welcome bob!`)
+
+	bob.Send("/choose tea|darn")
+	bob.Expect(`contains a banned word \(darn\)`)
+	bob.Send("/roll 2d6")
+	alice.ExpectWithout(`bob rolled 2d6`, `darn`)
+}
+
+func TestPoll(t *testing.T) {
+	srv := Start(t, Config{Users: []string{"alice", "bob"}})
+	alice := srv.Connect(t, "alice")
+	alice.Expect(`Welcome alice!`)
+	bob := srv.Connect(t, "bob")
+	bob.Expect(`Welcome bob!`)
+
+	alice.Send(`/poll "Lunch?" pizza ` + strings.Repeat("x", 81))
+	alice.Expect(`Poll options are limited to 80 characters`)
+	alice.Send(`/poll "Lunch?" pizza sushi`)
+	bob.Expect(`alice started a poll: Lunch\?`)
+
+	// Votes are only confirmed to the voter
+	bob.Send("/vote 2")
+	bob.Expect(`You voted \(1 in total\)`)
+	alice.Send("/poll close")
+	alice.ExpectWithout(`Poll closed by alice`, `voted`)
+	bob.Expect(`sushi\s+#+ 1`)
+}
+
+func TestReadOnlyRoom(t *testing.T) {
+	srv := Start(t, Config{Users: []string{"alice", "bob"}})
+	alice := srv.Connect(t, "alice")
+	alice.Expect(`Welcome alice!`)
+	bob := srv.Connect(t, "bob")
+	bob.Expect(`Welcome bob!`)
+
+	alice.Send("/join news")
+	alice.Expect(`You joined #news`)
+	alice.Send("/readonly on")
+	alice.Expect(`#news is now read-only`)
+	alice.Send("release is out")
+	id := alice.Expect(`\[(\d+)\] alice: release is out`)[1]
+	bob.Send("/join news")
+	alice.Expect(`bob joined #news`)
+
+	for _, command := range []string{
+		"posting anyway",
+		"/reply " + id + " posting anyway",
+		"/roll",
+		"/flip",
+		"/choose a|b",
+		`/poll "Posting anyway?" yes no`,
+		"/react " + id + " +1",
+	} {
+		bob.Send(command)
+		bob.Expect(`#news is read-only and only its ops can post here`)
+	}
+	alice.Send("/users")
+	alice.ExpectWithout(`#news \(2\)`, `posting anyway|rolled|flipped|to choose|started a poll|reacted`)
+}
+
+func TestDisconnect(t *testing.T) {
+	srv := Start(t, Config{Users: []string{"alice", "bob"}, Admins: []string{"alice"}})
+	alice := srv.Connect(t, "alice")
+	alice.Expect(`Welcome alice!`)
+	bob := srv.Connect(t, "bob")
+	bob.Expect(`Welcome bob!`)
+	alice.Expect(`bob joined #lobby`)
+
+	bob.Close()
+	alice.Expect(`bob left #lobby`)
+
+	bob = srv.Connect(t, "bob")
+	bob.Expect(`Welcome bob!`)
+	if n := srv.Hub.Disconnect("bob", "testing"); n != 1 {
+		t.Fatalf("Disconnect closed %d sessions, want 1", n)
+	}
+	bob.ExpectClosed()
+	alice.Expect(`bob left #lobby`)
+}
+
+func TestTwoFactorSetup(t *testing.T) {
+	srv := Start(t, Config{Users: []string{"alice"}})
+	alice := srv.Connect(t, "alice")
+	alice.Expect(`Welcome alice!`)
+
+	alice.Send("/2fa confirm 123456")
+	alice.Expect(`Nothing to confirm, run /2fa setup first`)
+
+	alice.Send("/2fa setup")
+	secret := alice.Expect(`Secret: ([A-Z2-7]+)`)[1]
+	alice.Send("/2fa status")
+	alice.Expect(`awaiting confirmation`)
+
+	alice.Send("/2fa confirm 000000")
+	alice.Expect(`Invalid code`)
+	alice.Send("/2fa confirm " + code(t, secret))
+	alice.Expect(`Two-factor authentication is now on`)
+	alice.Send("/2fa setup")
+	alice.Expect(`already on, disable it first`)
+
+	alice.Send("/2fa disable 000000")
+	alice.Expect(`Invalid code`)
+	alice.Send("/2fa disable " + code(t, secret))
+	alice.Expect(`Two-factor authentication is now off`)
+	alice.Send("/2fa status")
+	alice.Expect(`Two-factor authentication is off`)
+}
+
+// Returns the current TOTP code for the secret
+func code(t *testing.T, secret string) string {
+	t.Helper()
+	c, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
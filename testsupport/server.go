@@ -0,0 +1,139 @@
+// Package testsupport runs the chat server in-process for end-to-end tests
+// and drives it with real SSH clients.
+//
+//	srv := testsupport.Start(t, testsupport.Config{Users: []string{"alice", "bob"}})
+//	alice := srv.Connect(t, "alice")
+//	bob := srv.Connect(t, "bob")
+//	alice.Send("hello")
+//	bob.Expect(`alice: hello`)
+//
+// The server is configured through the environment like the real one, so
+// tests using it cannot run in parallel.
+package testsupport
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"group-ssh-chat/audit"
+	"group-ssh-chat/auth"
+	"group-ssh-chat/chat"
+	"group-ssh-chat/connlimit"
+	"group-ssh-chat/securitypolicy"
+	"group-ssh-chat/sshserver"
+	"group-ssh-chat/stats"
+	"group-ssh-chat/storage"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Settings cleared before starting a server, so the environment running the
+// tests does not leak into them. File backed stores without a path keep
+// their data in memory.
+var isolatedSettings = []string{
+	"ADMIN_USERS", "AUDIT_LOG_MESSAGES", "AUDIT_LOG_PATH", "AUTH_BLOCK_DURATION",
+	"AUTH_FAILURE_WINDOW", "AUTH_MAX_FAILURES", "AUTH_PROVIDERS", "AUTH_TARPIT_DELAY",
+	"BANNED_WORDS", "CONNECTION_COOLDOWN", "EDIT_WINDOW", "GUEST_POSTING",
+	"GUEST_SLOWMODE", "HISTORY_PATH", "HOST_SSH_KEYS_DIR", "HOST_SSH_KEY_PASSPHRASE",
+	"INBOX_PATH", "LDAP_URL", "LINKED_IDENTITIES_PATH", "LISTEN_FDS", "LISTEN_PID",
+	"MAX_MESSAGE_LENGTH", "MAX_SESSIONS_PER_USER", "MOTD", "OPEN_REGISTRATION",
+	"PREFERENCES_PATH", "PRESENCE_BATCH_WINDOW", "PROXY_PROTOCOL", "REGISTERED_KEYS_PATH",
+	"REGISTRATION_REQUIRES_APPROVAL", "REMINDERS_PATH", "RESUME_GRACE", "ROOMS_PATH",
+	"STRICT_USERNAMES", "TOTP_SECRETS_PATH", "WRITE_TIMEOUT",
+}
+
+// What the test server starts with
+type Config struct {
+	// Users with a key in authorized_keys
+	Users []string
+	// Users listed in ADMIN_USERS
+	Admins []string
+	// Further settings, applied after the defaults
+	Env map[string]string
+}
+
+// A chat server listening on an ephemeral port of the loopback interface
+type Server struct {
+	// Address SSH clients connect to
+	Addr string
+	// The server's hub, for inspecting its state
+	Hub *chat.Hub
+
+	hostKey ssh.PublicKey
+	keys    map[string]ssh.Signer
+}
+
+// Starts a server with a fresh host key and a key for every user in a
+// temporary directory. It is stopped when the test ends.
+func Start(t testing.TB, cfg Config) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	for _, key := range isolatedSettings {
+		t.Setenv(key, "")
+	}
+
+	srv := &Server{keys: map[string]ssh.Signer{}}
+	var authorizedKeys strings.Builder
+	for _, user := range cfg.Users {
+		signer := newKey(t)
+		srv.keys[user] = signer
+		authorizedKeys.WriteString(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))) + " " + user + "\n")
+	}
+	authorizedKeysPath := filepath.Join(dir, "authorized_keys")
+	if err := os.WriteFile(authorizedKeysPath, []byte(authorizedKeys.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("AUTHORIZED_KEYS_PATH", authorizedKeysPath)
+	t.Setenv("HOST_SSH_PRIVATE_KEY_PATH", filepath.Join(dir, "host_key"))
+	t.Setenv("SSH_LISTEN_ADDRESSES", "127.0.0.1:0")
+	t.Setenv("ADMIN_USERS", strings.Join(cfg.Admins, ","))
+	for key, value := range cfg.Env {
+		t.Setenv(key, value)
+	}
+
+	auditLog := audit.New()
+	totpSecrets := storage.NewSecretStore()
+	registeredKeys := storage.NewKeyStore()
+	sshAuth := auth.New(totpSecrets, registeredKeys)
+	policy := securitypolicy.New()
+	srv.Hub = chat.New(storage.NewPreferencesStore(), storage.NewHistoryStore(), storage.NewRoomStore(), storage.NewReminderStore(), storage.NewInboxStore(), totpSecrets, registeredKeys, policy, auditLog, stats.New())
+	sshServer := sshserver.New(sshAuth, srv.Hub, connlimit.New(), policy, auditLog)
+	srv.hostKey = sshAuth.HostSSHPrivateKeys[0].PublicKey()
+	srv.Addr = sshServer.Addrs()[0].String()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sshServer.AcceptConnections()
+	}()
+	t.Cleanup(func() {
+		sshServer.Close()
+		<-done
+		srv.Hub.Close()
+		auditLog.Close()
+	})
+	return srv
+}
+
+// Returns a fresh ed25519 key
+func newKey(t testing.TB) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// Returns the private key of a configured user
+func (srv *Server) Key(user string) ssh.Signer {
+	return srv.keys[user]
+}
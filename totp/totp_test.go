@@ -0,0 +1,93 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Secret of the RFC 6238 test vectors, "12345678901234567890" in base32
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238(t *testing.T) {
+	// The SHA1 vectors of RFC 6238 appendix B. The RFC lists 8 digit codes,
+	// of which the last 6 are the 6 digit code.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / int64(period.Seconds())
+	codeAt := func(offset time.Duration) string {
+		code, err := Code(rfcSecret, now.Add(offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		valid    bool
+	}{
+		{"current step", codeAt(0), step, true},
+		{"surrounding spaces", " " + codeAt(0) + "\n", step, true},
+		{"previous step", codeAt(-period), step - 1, true},
+		{"next step", codeAt(period), step + 1, true},
+		{"two steps behind", codeAt(-2 * period), 0, false},
+		{"two steps ahead", codeAt(2 * period), 0, false},
+		{"wrong code", "000000", 0, false},
+		{"too short", codeAt(0)[1:], 0, false},
+		{"empty", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, valid := Validate(rfcSecret, tt.code, now)
+			if valid != tt.valid || got != tt.wantStep {
+				t.Errorf("Validate(%q) = %d, %v, want %d, %v", tt.code, got, valid, tt.wantStep, tt.valid)
+			}
+		})
+	}
+}
+
+func TestValidateLowercaseSecret(t *testing.T) {
+	now := time.Now()
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, valid := Validate(strings.ToLower(secret), code, now); !valid {
+		t.Errorf("code for %q rejected with the lowercase secret", secret)
+	}
+}
+
+func TestValidateInvalidSecret(t *testing.T) {
+	if _, valid := Validate("not base32!", "123456", time.Now()); valid {
+		t.Error("code accepted for an invalid secret")
+	}
+}
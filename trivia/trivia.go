@@ -0,0 +1,339 @@
+package trivia
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"group-ssh-chat/chat"
+	"group-ssh-chat/commands"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	// Name the bot talks under
+	botName = "trivia"
+
+	// Pause between a question being resolved and the next one
+	questionPause = 3 * time.Second
+
+	// Number of players shown in the all-time leaderboard
+	leaderboardSize = 10
+)
+
+// A question with its answer and any other accepted spellings of it
+type Question struct {
+	Question string   `json:"question"`
+	Answer   string   `json:"answer"`
+	Aliases  []string `json:"aliases,omitempty"`
+}
+
+// A trivia bot running games in rooms. Players answer by chatting normally
+// and the first correct answer scores a point.
+type Bot struct {
+	hub       *chat.Hub
+	questions []Question
+	rounds    int
+	roundTime time.Duration
+
+	mu     sync.Mutex
+	games  map[string]*game
+	totals map[string]int
+}
+
+// A running game in a room
+type game struct {
+	room      string
+	questions []Question
+	round     int
+	current   *Question
+	answers   map[string]bool
+	scores    map[string]int
+	timer     *time.Timer
+}
+
+// Returns a bot asking the questions in the JSON file at
+// TRIVIA_QUESTIONS_PATH, or nil when the variable is not set. TRIVIA_ROUNDS
+// (questions per game, default 5) and TRIVIA_ROUND_TIME (time to answer,
+// default 30s) tune it. The bot registers /trivia and starts listening to
+// the rooms of the hub.
+func New(hub *chat.Hub) *Bot {
+	path := os.Getenv("TRIVIA_QUESTIONS_PATH")
+	if path == "" {
+		return nil
+	}
+	questions, err := loadQuestions(path)
+	if err != nil {
+		log.Printf("Trivia bot disabled: %v", err)
+		return nil
+	}
+
+	b := &Bot{
+		hub:       hub,
+		questions: questions,
+		rounds:    5,
+		roundTime: 30 * time.Second,
+		games:     map[string]*game{},
+		totals:    map[string]int{},
+	}
+	if n, err := strconv.Atoi(os.Getenv("TRIVIA_ROUNDS")); err == nil && n > 0 {
+		b.rounds = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("TRIVIA_ROUND_TIME")); err == nil && d > 0 {
+		b.roundTime = d
+	}
+
+	hub.RegisterCommand(commands.Command{
+		Name:        "trivia",
+		Usage:       "/trivia start|stop|scores",
+		Description: "Play trivia in the current room",
+		Category:    commands.Fun,
+		Handler:     b.handleCommand,
+	})
+	hub.AddBot(b)
+	return b
+}
+
+// Reads the question file, skipping questions without an answer
+func loadQuestions(path string) ([]Question, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var all []Question
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("invalid question file %s: %w", path, err)
+	}
+	var questions []Question
+	for _, q := range all {
+		if strings.TrimSpace(q.Question) != "" && normalize(q.Answer) != "" {
+			questions = append(questions, q)
+		}
+	}
+	if len(questions) == 0 {
+		return nil, fmt.Errorf("no questions in %s", path)
+	}
+	return questions, nil
+}
+
+// Handles /trivia start|stop|scores
+func (b *Bot) handleCommand(ctx *commands.CommandContext, args []string) error {
+	if len(args) != 1 {
+		return errors.New("Usage: /trivia start|stop|scores")
+	}
+	room := ctx.Room
+	switch args[0] {
+	case "start":
+		return b.start(ctx.User, room)
+	case "stop":
+		return b.stop(ctx.User, room)
+	case "scores":
+		return ctx.Reply(b.leaderboard())
+	default:
+		return errors.New("Usage: /trivia start|stop|scores")
+	}
+}
+
+// Starts a game in the room
+func (b *Bot) start(sender string, room string) error {
+	b.mu.Lock()
+	if _, ok := b.games[room]; ok {
+		b.mu.Unlock()
+		return fmt.Errorf("A trivia game is already running in #%s", room)
+	}
+	g := &game{
+		room:      room,
+		questions: b.pick(),
+		scores:    map[string]int{},
+	}
+	b.games[room] = g
+	b.mu.Unlock()
+
+	b.hub.Notice(room, fmt.Sprintf("%s started a game of trivia with %d questions. Answer by chatting, you have %s per question.", sender, len(g.questions), b.roundTime))
+	b.ask(g)
+	return nil
+}
+
+// Stops the game in the room and posts its results
+func (b *Bot) stop(sender string, room string) error {
+	b.mu.Lock()
+	g, ok := b.games[room]
+	if !ok {
+		b.mu.Unlock()
+		return fmt.Errorf("There is no trivia game running in #%s", room)
+	}
+	text := b.finishLocked(g)
+	b.mu.Unlock()
+
+	b.hub.Notice(room, sender+" stopped the trivia game. "+text)
+	return nil
+}
+
+// Returns the questions for a game in random order
+func (b *Bot) pick() []Question {
+	order := rand.Perm(len(b.questions))
+	if len(order) > b.rounds {
+		order = order[:b.rounds]
+	}
+	questions := make([]Question, len(order))
+	for i, n := range order {
+		questions[i] = b.questions[n]
+	}
+	return questions
+}
+
+// Asks the next question of the game, or ends it when none are left
+func (b *Bot) ask(g *game) {
+	b.mu.Lock()
+	if b.games[g.room] != g {
+		b.mu.Unlock()
+		return
+	}
+	if g.round == len(g.questions) {
+		text := b.finishLocked(g)
+		b.mu.Unlock()
+		b.hub.Notice(g.room, "Trivia is over! "+text)
+		return
+	}
+	q := &g.questions[g.round]
+	g.round++
+	g.current = q
+	g.answers = answersOf(q)
+	round := g.round
+	g.timer = time.AfterFunc(b.roundTime, func() { b.timeUp(g, round) })
+	b.mu.Unlock()
+
+	b.say(g.room, fmt.Sprintf("Question %d/%d: %s", round, len(g.questions), q.Question))
+}
+
+// Reveals the answer when nobody got the question in time
+func (b *Bot) timeUp(g *game, round int) {
+	b.mu.Lock()
+	if b.games[g.room] != g || g.round != round || g.current == nil {
+		b.mu.Unlock()
+		return
+	}
+	answer := g.current.Answer
+	g.current = nil
+	b.mu.Unlock()
+
+	b.say(g.room, "Time's up! The answer was: "+answer)
+	time.AfterFunc(questionPause, func() { b.ask(g) })
+}
+
+// Checks chat messages for the answer to the current question of the room
+func (b *Bot) OnMessage(msg chat.Message) {
+	if msg.From == botName {
+		return
+	}
+
+	b.mu.Lock()
+	g, ok := b.games[msg.Room]
+	if !ok || g.current == nil || !g.answers[normalize(msg.Text)] {
+		b.mu.Unlock()
+		return
+	}
+	answer := g.current.Answer
+	g.current = nil
+	g.timer.Stop()
+	g.scores[msg.From]++
+	score := g.scores[msg.From]
+	b.mu.Unlock()
+
+	b.say(msg.Room, fmt.Sprintf("%s got it! The answer was: %s (%s)", msg.From, answer, points(score)))
+	time.AfterFunc(questionPause, func() { b.ask(g) })
+}
+
+// Ends the game, adding its scores to the totals, and returns its results.
+// Must be called with mu held.
+func (b *Bot) finishLocked(g *game) string {
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	delete(b.games, g.room)
+	for user, score := range g.scores {
+		b.totals[user] += score
+	}
+	if len(g.scores) == 0 {
+		return "Nobody scored."
+	}
+	return "Final scores:" + ranking(g.scores, len(g.scores))
+}
+
+// Returns the all-time leaderboard
+func (b *Bot) leaderboard() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.totals) == 0 {
+		return "Nobody has scored at trivia yet"
+	}
+	return "Trivia leaderboard:" + ranking(b.totals, leaderboardSize)
+}
+
+// Posts a chat message as the bot
+func (b *Bot) say(room string, text string) {
+	if err := b.hub.Say(botName, room, text); err != nil {
+		log.Printf("Trivia bot failed to post in #%s: %v", room, err)
+	}
+}
+
+// Returns the normalized answers accepted for the question
+func answersOf(q *Question) map[string]bool {
+	answers := map[string]bool{normalize(q.Answer): true}
+	for _, alias := range q.Aliases {
+		if a := normalize(alias); a != "" {
+			answers[a] = true
+		}
+	}
+	return answers
+}
+
+// Lowercases text and drops punctuation and extra whitespace so answers
+// match however they are typed
+func normalize(text string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) {
+			sb.WriteRune(r)
+		}
+	}
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+// Renders the best n scores, highest first
+func ranking(scores map[string]int, n int) string {
+	users := make([]string, 0, len(scores))
+	for user := range scores {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if scores[users[i]] != scores[users[j]] {
+			return scores[users[i]] > scores[users[j]]
+		}
+		return users[i] < users[j]
+	})
+	if len(users) > n {
+		users = users[:n]
+	}
+
+	var sb strings.Builder
+	for i, user := range users {
+		fmt.Fprintf(&sb, "\n  %2d. %s %s", i+1, user, points(scores[user]))
+	}
+	return sb.String()
+}
+
+// Returns "1 point" or "n points"
+func points(n int) string {
+	if n == 1 {
+		return "1 point"
+	}
+	return fmt.Sprintf("%d points", n)
+}
@@ -0,0 +1,48 @@
+package ui
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// Characters from light to dark used to draw images on terminals without
+// color or Unicode support
+const asciiRamp = " .:-=+*#%@"
+
+// Draws an image with one terminal cell per pixel column and two pixel rows
+// per line. Color terminals get upper half blocks with 24-bit colors for the
+// upper and lower pixel, others a grayscale ramp of ASCII characters.
+func RenderImage(img image.Image, caps Capabilities) []string {
+	bounds := img.Bounds()
+	var lines []string
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 2 {
+		var sb strings.Builder
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			top := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			bottom := top
+			if y+1 < bounds.Max.Y {
+				bottom = color.NRGBAModel.Convert(img.At(x, y+1)).(color.NRGBA)
+			}
+			if caps.Color && caps.Unicode {
+				fmt.Fprintf(&sb, "\033[38;2;%d;%d;%dm\033[48;2;%d;%d;%dm▀", top.R, top.G, top.B, bottom.R, bottom.G, bottom.B)
+				continue
+			}
+			level := (luminance(top) + luminance(bottom)) / 2
+			sb.WriteByte(asciiRamp[level*(len(asciiRamp)-1)/255])
+		}
+		if caps.Color && caps.Unicode {
+			sb.WriteString(ansiReset)
+		}
+		lines = append(lines, sb.String())
+	}
+	return lines
+}
+
+// Returns the perceived brightness of a color from 0 to 255, treating
+// transparency as black
+func luminance(c color.NRGBA) int {
+	l := (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000
+	return l * int(c.A) / 255
+}
@@ -0,0 +1,58 @@
+package ui
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Matches http and https URLs up to the next whitespace, quote or control
+// character
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"'\x00-\x1f\x7f]+`)
+
+// Returns the URLs in text in order of appearance
+func FindURLs(text string) []string {
+	matches := urlPattern.FindAllString(text, -1)
+	for i, m := range matches {
+		matches[i] = trimURL(m)
+	}
+	return matches
+}
+
+// Drops punctuation that ends a sentence right after a URL. A closing
+// parenthesis is kept when the URL opened it, as in Wikipedia links.
+func trimURL(u string) string {
+	for len(u) > 0 {
+		last := u[len(u)-1]
+		switch {
+		case strings.IndexByte(".,;:!?]}", last) >= 0:
+		case last == ')' && strings.Count(u, "(") < strings.Count(u, ")"):
+		default:
+			return u
+		}
+		u = u[:len(u)-1]
+	}
+	return u
+}
+
+// Wraps text in an OSC 8 escape sequence that makes it a clickable link to
+// url in terminals that support hyperlinks
+func Hyperlink(url string, text string) string {
+	return "\033]8;;" + url + "\033\\" + text + "\033]8;;\033\\"
+}
+
+// Turns the URLs in a line of wrapped text into hyperlinks. A URL too long
+// for one line is cut by wrapping, so a fragment that starts one of the
+// message's full urls links to that URL.
+func Linkify(line string, urls []string) string {
+	return urlPattern.ReplaceAllStringFunc(line, func(m string) string {
+		fragment := trimURL(m)
+		target := fragment
+		for _, u := range urls {
+			if strings.HasPrefix(u, fragment) {
+				target = u
+				break
+			}
+		}
+		return Hyperlink(target, fragment) + m[len(fragment):]
+	})
+}
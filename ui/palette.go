@@ -0,0 +1,82 @@
+package ui
+
+import "sort"
+
+const ansiReset = "\033[0m"
+
+// The escape codes used to style each kind of output. An empty code means
+// the text is written unstyled.
+type Palette struct {
+	Name      string
+	Timestamp string
+	Username  string
+	Whisper   string
+	System    string
+	Mention   string
+	Code      string
+}
+
+// Name of the theme used when a user has not picked one
+const DefaultTheme = "default"
+
+// Theme without any escape codes, used for terminals that cannot render color
+const MonochromeTheme = "monochrome"
+
+var themes = map[string]Palette{
+	DefaultTheme: {
+		Name:      DefaultTheme,
+		Timestamp: "\033[2m",
+		Username:  "\033[1;36m",
+		Whisper:   "\033[35m",
+		System:    "\033[33m",
+		Mention:   "\033[7m",
+		Code:      "\033[48;5;236m",
+	},
+	"solarized": {
+		Name:      "solarized",
+		Timestamp: "\033[38;5;245m",
+		Username:  "\033[1;38;5;33m",
+		Whisper:   "\033[38;5;125m",
+		System:    "\033[38;5;136m",
+		Mention:   "\033[38;5;230;48;5;64m",
+		Code:      "\033[38;5;245;48;5;235m",
+	},
+	MonochromeTheme: {
+		Name: MonochromeTheme,
+	},
+	"high-contrast": {
+		Name:      "high-contrast",
+		Timestamp: "\033[1;37m",
+		Username:  "\033[1;93m",
+		Whisper:   "\033[1;95m",
+		System:    "\033[1;96m",
+		Mention:   "\033[1;30;103m",
+		Code:      "\033[97;40m",
+	},
+}
+
+// Returns the palette for a theme, falling back to the default theme
+func Theme(name string) Palette {
+	if p, ok := themes[name]; ok {
+		return p
+	}
+	return themes[DefaultTheme]
+}
+
+// Returns the names of all available themes in alphabetical order
+func ThemeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Styles text with the given escape code and resets it afterwards
+func (p Palette) Paint(code string, text string) string {
+	if code == "" {
+		return text
+	}
+	return code + text + ansiReset
+}
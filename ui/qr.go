@@ -0,0 +1,43 @@
+package ui
+
+import (
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// Modules of blank border around the code, required by scanners
+const qrQuietZone = 2
+
+// Renders text as a QR code using half block characters, two modules per
+// line. Light modules are drawn filled so the code scans on dark terminals.
+func QRCode(text string) ([]string, error) {
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		return nil, err
+	}
+
+	light := func(x, y int) bool {
+		return !code.Black(x, y)
+	}
+
+	var lines []string
+	for y := -qrQuietZone; y < code.Size+qrQuietZone; y += 2 {
+		var sb strings.Builder
+		for x := -qrQuietZone; x < code.Size+qrQuietZone; x++ {
+			top, bottom := light(x, y), light(x, y+1)
+			switch {
+			case top && bottom:
+				sb.WriteString("█")
+			case top:
+				sb.WriteString("▀")
+			case bottom:
+				sb.WriteString("▄")
+			default:
+				sb.WriteString(" ")
+			}
+		}
+		lines = append(lines, sb.String())
+	}
+	return lines, nil
+}
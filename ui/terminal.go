@@ -0,0 +1,86 @@
+package ui
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// What a client's terminal can render, derived from the terminal type sent
+// in its pty-req
+type Capabilities struct {
+	// Escape codes for colors and text attributes
+	Color bool
+	// UTF-8 output, including box drawing characters and emoji
+	Unicode bool
+	// Clickable links using OSC 8 escape sequences
+	Hyperlinks bool
+}
+
+// Capabilities assumed for clients that send no terminal type (WebSocket,
+// telnet) or one that is not known to be limited
+var DefaultCapabilities = Capabilities{Color: true, Unicode: true}
+
+// Terminal types, or prefixes of them, of hardware terminals and their
+// emulations that predate color and UTF-8
+var legacyTerminals = []string{"dumb", "vt52", "vt100", "vt102", "vt220"}
+
+// Terminal types, or prefixes of them, of emulators known to render OSC 8
+// hyperlinks. Others might print the escape sequences as garbage.
+var hyperlinkTerminals = []string{"xterm", "alacritty", "foot", "wezterm", "contour", "vte", "gnome", "konsole", "iterm"}
+
+// Returns the capabilities of a terminal type such as "xterm-256color"
+func DetectCapabilities(termType string) Capabilities {
+	termType = strings.ToLower(termType)
+	for _, legacy := range legacyTerminals {
+		if strings.HasPrefix(termType, legacy) {
+			return Capabilities{}
+		}
+	}
+	caps := DefaultCapabilities
+	for _, t := range hyperlinkTerminals {
+		if strings.HasPrefix(termType, t) {
+			caps.Hyperlinks = true
+		}
+	}
+	return caps
+}
+
+// Matches CSI sequences (colors, cursor movement) and OSC sequences
+// (titles, hyperlinks) as well as any other two byte escape
+var ansiPattern = regexp.MustCompile(`\x1b(\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[@-Z\\-_])`)
+
+// Removes terminal escape sequences from s
+func StripANSI(s string) string {
+	if !strings.Contains(s, "\x1b") {
+		return s
+	}
+	return ansiPattern.ReplaceAllString(s, "")
+}
+
+// ASCII replacements for the characters used to draw the UI. They take up
+// the same single cell so wrapped and boxed output keeps its alignment.
+var asciiGlyphs = map[rune]rune{
+	'…': '.', '―': '-', '─': '-', '│': '|', '┌': '+', '┐': '+', '└': '+',
+	'┘': '+', '↳': '>', '•': '*', '█': '#', '▀': '"', '▄': ',',
+}
+
+// Rewrites s for terminals without Unicode support. UI glyphs become their
+// ASCII look-alikes and any other non-ASCII character is replaced by as many
+// question marks as it is wide.
+func ASCIIFallback(s string) string {
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for _, r := range s {
+		switch {
+		case r < 0x80:
+			sb.WriteRune(r)
+		case asciiGlyphs[r] != 0:
+			sb.WriteRune(asciiGlyphs[r])
+		default:
+			sb.WriteString(strings.Repeat("?", runewidth.RuneWidth(r)))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,326 @@
+package web
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"group-ssh-chat/audit"
+	"group-ssh-chat/chat"
+	"group-ssh-chat/graceful"
+	"group-ssh-chat/securitypolicy"
+	"group-ssh-chat/stats"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// Name of the cookie holding the token once the dashboard was opened with it
+const tokenCookie = "dashboard_token"
+
+// How often message throughput is sampled and how many samples are kept,
+// i.e. the graph covers the last 15 minutes
+const (
+	throughputInterval = 10 * time.Second
+	throughputSamples  = 90
+)
+
+// Number of audit events shown
+const recentEvents = 50
+
+// Used for ban requests without a duration
+const defaultBanDuration = 24 * time.Hour
+
+// Serves an admin dashboard over HTTP showing live sessions, rooms, message
+// throughput and recent audit events, with actions to kick, ban and
+// broadcast. The Go profiler is served under /debug/pprof/. Every request
+// must carry DASHBOARD_TOKEN.
+type Dashboard struct {
+	listenAddress string
+	token         string
+	hub           *chat.Hub
+	policy        *securitypolicy.Policy
+	auditLog      *audit.Logger
+	collector     *stats.Collector
+
+	mu         sync.Mutex
+	throughput []throughputSample
+}
+
+// Messages sent during the interval ending at Time
+type throughputSample struct {
+	Time     time.Time `json:"time"`
+	Messages int       `json:"messages"`
+}
+
+// Returns a dashboard listening on DASHBOARD_LISTEN_ADDRESS, or nil when the
+// variable is not set
+func New(hub *chat.Hub, policy *securitypolicy.Policy, auditLog *audit.Logger, collector *stats.Collector) *Dashboard {
+	listenAddress := os.Getenv("DASHBOARD_LISTEN_ADDRESS")
+	if listenAddress == "" {
+		return nil
+	}
+	token := os.Getenv("DASHBOARD_TOKEN")
+	if token == "" {
+		log.Fatal("DASHBOARD_TOKEN must be set when DASHBOARD_LISTEN_ADDRESS is")
+	}
+
+	return &Dashboard{
+		listenAddress: listenAddress,
+		token:         token,
+		hub:           hub,
+		policy:        policy,
+		auditLog:      auditLog,
+		collector:     collector,
+	}
+}
+
+// Serves the dashboard until the listener fails
+func (d *Dashboard) ListenAndServe() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", d.handleIndex)
+	mux.HandleFunc("/api/overview", d.authorized(d.handleOverview))
+	mux.HandleFunc("/api/kick", d.authorized(d.action(d.kick)))
+	mux.HandleFunc("/api/ban", d.authorized(d.action(d.ban)))
+	mux.HandleFunc("/api/broadcast", d.authorized(d.action(d.broadcast)))
+	mux.HandleFunc("/debug/pprof/", d.authorized(pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", d.authorized(pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", d.authorized(pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", d.authorized(pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", d.authorized(pprof.Trace))
+
+	listener, err := graceful.Listen("dashboard", "tcp", d.listenAddress)
+	if err != nil {
+		return err
+	}
+	go d.sampleThroughput()
+	log.Println("Admin dashboard is serving on", d.listenAddress)
+	return http.Serve(listener, mux)
+}
+
+// Records how many messages were sent in each interval
+func (d *Dashboard) sampleThroughput() {
+	last := d.collector.Snapshot().TotalMessages
+	ticker := time.NewTicker(throughputInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		total := d.collector.Snapshot().TotalMessages
+
+		d.mu.Lock()
+		d.throughput = append(d.throughput, throughputSample{Time: now.UTC(), Messages: total - last})
+		if len(d.throughput) > throughputSamples {
+			d.throughput = d.throughput[len(d.throughput)-throughputSamples:]
+		}
+		d.mu.Unlock()
+		last = total
+	}
+}
+
+// Serves the dashboard page. Opening it as /?token=<token> stores the token
+// in a cookie so it does not stay in the address bar.
+func (d *Dashboard) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		if !d.validToken(token) {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     tokenCookie,
+			Value:    token,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if !d.isAuthorized(r) {
+		http.Error(w, "Open /?token=<DASHBOARD_TOKEN> to sign in", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardHTML)
+}
+
+// Rejects requests without the token in the cookie or a bearer header
+func (d *Dashboard) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !d.isAuthorized(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Reports whether the request carries the dashboard token
+func (d *Dashboard) isAuthorized(r *http.Request) bool {
+	if cookie, err := r.Cookie(tokenCookie); err == nil && d.validToken(cookie.Value) {
+		return true
+	}
+	return d.validToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+}
+
+func (d *Dashboard) validToken(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(d.token)) == 1
+}
+
+// Everything the dashboard page shows
+type overview struct {
+	Stats      overviewStats          `json:"stats"`
+	Sessions   []chat.SessionInfo     `json:"sessions"`
+	Rooms      []chat.RoomInfo        `json:"rooms"`
+	Blocked    []securitypolicy.Block `json:"blocked"`
+	Throughput []throughputSample     `json:"throughput"`
+	Events     []audit.Event          `json:"events"`
+}
+
+type overviewStats struct {
+	UptimeSeconds int64 `json:"uptime_seconds"`
+	OnlineUsers   int   `json:"online_users"`
+	Sessions      int   `json:"sessions"`
+	PeakUsers     int   `json:"peak_users"`
+	MessagesToday int   `json:"messages_today"`
+	TotalMessages int   `json:"total_messages"`
+}
+
+// Writes the current state of the server as JSON
+func (d *Dashboard) handleOverview(w http.ResponseWriter, r *http.Request) {
+	s := d.collector.Snapshot()
+	events := d.auditLog.Recent(recentEvents)
+
+	d.mu.Lock()
+	throughput := append([]throughputSample(nil), d.throughput...)
+	d.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, overview{
+		Stats: overviewStats{
+			UptimeSeconds: int64(s.Uptime.Seconds()),
+			OnlineUsers:   s.OnlineUsers,
+			Sessions:      s.Sessions,
+			PeakUsers:     s.PeakUsers,
+			MessagesToday: s.MessagesToday,
+			TotalMessages: s.TotalMessages,
+		},
+		Sessions:   d.hub.Sessions(),
+		Rooms:      d.hub.Rooms(),
+		Blocked:    d.policy.Blocked(),
+		Throughput: throughput,
+		Events:     events,
+	})
+}
+
+// The body of kick, ban and broadcast requests
+type actionRequest struct {
+	User     string `json:"user"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"`
+	Text     string `json:"text"`
+}
+
+// Wraps an action handler taking a JSON POST body. Requiring JSON keeps
+// plain HTML forms on other sites from triggering actions.
+func (d *Dashboard) action(run func(req actionRequest) (string, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			http.Error(w, "Expected a JSON body", http.StatusUnsupportedMediaType)
+			return
+		}
+		var req actionRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		result, err := run(req)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		log.Printf("Admin dashboard: %s", result)
+		writeJSON(w, http.StatusOK, map[string]string{"result": result})
+	}
+}
+
+// Disconnects all sessions of a user
+func (d *Dashboard) kick(req actionRequest) (string, error) {
+	if req.User == "" {
+		return "", errors.New("user is required")
+	}
+	n := d.hub.Disconnect(req.User, req.Reason)
+	if n == 0 {
+		return "", fmt.Errorf("%s is not online", req.User)
+	}
+	return fmt.Sprintf("closed %d sessions of %s", n, req.User), nil
+}
+
+// Blocks the addresses a user is connected from and disconnects them. The
+// ban is by IP, so it also keeps out anyone else behind the same address.
+func (d *Dashboard) ban(req actionRequest) (string, error) {
+	if req.User == "" {
+		return "", errors.New("user is required")
+	}
+	duration := defaultBanDuration
+	if req.Duration != "" {
+		var err error
+		if duration, err = time.ParseDuration(req.Duration); err != nil || duration <= 0 {
+			return "", fmt.Errorf("invalid duration %q", req.Duration)
+		}
+	}
+
+	ips := map[string]bool{}
+	for _, s := range d.hub.Sessions() {
+		if s.User != req.User {
+			continue
+		}
+		if host, _, err := net.SplitHostPort(s.RemoteAddr); err == nil {
+			ips[host] = true
+		}
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("%s is not online", req.User)
+	}
+	for ip := range ips {
+		d.policy.Block(ip, duration)
+	}
+	reason := "Banned"
+	if req.Reason != "" {
+		reason += ": " + req.Reason
+	}
+	d.hub.Disconnect(req.User, reason)
+	return fmt.Sprintf("banned %s (%d addresses) for %s", req.User, len(ips), duration), nil
+}
+
+// Sends an announcement to every session
+func (d *Dashboard) broadcast(req actionRequest) (string, error) {
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		return "", errors.New("text is required")
+	}
+	d.hub.Announce(text)
+	return fmt.Sprintf("announced %q", text), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Dashboard failed to write a response: %v", err)
+	}
+}
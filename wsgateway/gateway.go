@@ -0,0 +1,130 @@
+package wsgateway
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"group-ssh-chat/graceful"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// Attaches a byte stream from the gateway to the chat as the given user
+type TerminalServer interface {
+	ServeTerminal(user string, remoteAddr string, rwc io.ReadWriteCloser)
+}
+
+// A WebSocket frontend for browser terminals such as xterm.js
+type Gateway struct {
+	listenAddress string
+	tlsCertPath   string
+	tlsKeyPath    string
+	tokens        map[string]string
+	chat          TerminalServer
+}
+
+// Returns a new gateway, or nil when WEBSOCKET_LISTEN_ADDRESS is not set
+func New(chat TerminalServer) *Gateway {
+	listenAddress := os.Getenv("WEBSOCKET_LISTEN_ADDRESS")
+	if listenAddress == "" {
+		return nil
+	}
+
+	gw := &Gateway{
+		listenAddress: listenAddress,
+		tlsCertPath:   os.Getenv("WEBSOCKET_TLS_CERT_PATH"),
+		tlsKeyPath:    os.Getenv("WEBSOCKET_TLS_KEY_PATH"),
+		tokens:        map[string]string{},
+		chat:          chat,
+	}
+	gw.initTokens()
+
+	return gw
+}
+
+// Serves the gateway over wss:// (or ws:// when no certificate is configured)
+func (gw *Gateway) ListenAndServe() error {
+	mux := http.NewServeMux()
+	mux.Handle("/ws", websocket.Server{
+		Handshake: gw.handshake,
+		Handler:   gw.handleConnection,
+	})
+	svr := &http.Server{Handler: mux}
+	listener, err := graceful.Listen("websocket", "tcp", gw.listenAddress)
+	if err != nil {
+		return err
+	}
+
+	if gw.tlsCertPath == "" || gw.tlsKeyPath == "" {
+		log.Println("WebSocket gateway is serving without TLS on", gw.listenAddress)
+		return svr.Serve(listener)
+	}
+	log.Println("WebSocket gateway is serving wss:// on", gw.listenAddress)
+	return svr.ServeTLS(listener, gw.tlsCertPath, gw.tlsKeyPath)
+}
+
+// Rejects the upgrade unless the request carries a known token
+func (gw *Gateway) handshake(config *websocket.Config, req *http.Request) error {
+	if _, ok := gw.userForRequest(req); !ok {
+		return fmt.Errorf("invalid token from %s", req.RemoteAddr)
+	}
+	return nil
+}
+
+// Serves a single browser terminal connection
+func (gw *Gateway) handleConnection(ws *websocket.Conn) {
+	defer ws.Close()
+
+	user, ok := gw.userForRequest(ws.Request())
+	if !ok {
+		return
+	}
+	log.Printf("WebSocket client connected as %s", user)
+	gw.chat.ServeTerminal(user, ws.Request().RemoteAddr, ws)
+}
+
+// Looks up the user for the token sent in the query string or bearer header.
+// Browsers cannot set headers on WebSocket requests, hence the query fallback.
+func (gw *Gateway) userForRequest(req *http.Request) (string, bool) {
+	token := req.URL.Query().Get("token")
+	if token == "" {
+		token = strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	}
+	if token == "" {
+		return "", false
+	}
+
+	for t, user := range gw.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return user, true
+		}
+	}
+	return "", false
+}
+
+// Reads "<token> <username>" lines from the tokens file
+func (gw *Gateway) initTokens() {
+	tokensBytes, err := os.ReadFile(os.Getenv("WEBSOCKET_TOKENS_PATH"))
+	if err != nil {
+		log.Fatalf("Failed to load websocket tokens, err: %v", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(tokensBytes))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			log.Fatalf("Invalid websocket token line: %q", line)
+		}
+		gw.tokens[fields[0]] = fields[1]
+	}
+}